@@ -0,0 +1,90 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"goapi/config"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+//DocumentTermsBucketName is the bucket of the terms index of the documents, the other storages search their own indexes
+const DocumentTermsBucketName = "document_terms"
+
+//the root buckets of the bolt file, the buckets of the documents, the revisions, the contents and the terms have a bucket per tenant
+var boltBucketNames = []string{DocumentCollectionName, DocumentRevisionCollectionName, DocumentContentBucketName, DocumentOutboxCollectionName, DocumentTermsBucketName}
+
+//openTimeout is how long the file lock is waited for, another process using the file holds it
+const openTimeout = 2 * time.Second
+
+// BoltDataBaseHandler holds the bolt file of the documents once opened
+type BoltDataBaseHandler struct {
+	db   *bolt.DB
+	lock sync.RWMutex
+}
+
+var instanceBoltHandler *BoltDataBaseHandler
+var onceBoltHandler sync.Once
+
+// GetDB returns the opened bolt file, nil until it is opened
+func (h *BoltDataBaseHandler) GetDB() *bolt.DB {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return h.db
+}
+
+// Open opens the bolt file of the configuration and creates its root buckets, the file and its directory are created if needed
+func (h *BoltDataBaseHandler) Open(storageConfig *config.StorageConfig) error {
+	if len(storageConfig.Path) == 0 {
+		return errors.New("the path of the storage must be defined")
+	}
+	if err := os.MkdirAll(filepath.Dir(storageConfig.Path), 0700); err != nil {
+		return fmt.Errorf("Cannot create the directory of %s [err=%w]", storageConfig.Path, err)
+	}
+	db, err := bolt.Open(storageConfig.Path, 0600, &bolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return fmt.Errorf("Cannot open %s [err=%w]", storageConfig.Path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range boltBucketNames {
+			if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return fmt.Errorf("Cannot create the buckets of %s [err=%w]", storageConfig.Path, err)
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.db = db
+	log.Infof("Storage opened in %s", storageConfig.Path)
+	return nil
+}
+
+func (h *BoltDataBaseHandler) Close() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.db == nil {
+		return
+	}
+	if err := h.db.Close(); err != nil {
+		log.Error("Cannot close the storage")
+	}
+	h.db = nil
+}
+
+func GetBoltDatabaseHandler() *BoltDataBaseHandler {
+	onceBoltHandler.Do(func() {
+		instanceBoltHandler = &BoltDataBaseHandler{}
+	})
+	return instanceBoltHandler
+}
@@ -45,7 +45,7 @@ func (h *MongoDataBaseHandler) TryOrRetryCreateConnection(config *config.Databas
 		db, session, err := connectToMongo(config)
 		if err == nil {
 			log.Info("Successfull connection")
-			datastore := &MongoDatastore{db, session}
+			datastore := &MongoDatastore{db, session, config.TenantIsolation}
 			datastore.createDatabaseForApp()
 			h.lock.Lock()
 			defer h.lock.Unlock()
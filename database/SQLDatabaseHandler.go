@@ -0,0 +1,136 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"goapi/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/lib/pq"
+	log "github.com/sirupsen/logrus"
+	_ "modernc.org/sqlite"
+)
+
+// The drivers of the sql storage, as registered in database/sql
+const (
+	SQLDriverSqlite   = "sqlite"
+	SQLDriverPostgres = "postgres"
+)
+
+// SQLDataBaseHandler holds the pool of connections to the sql database once opened and migrated
+type SQLDataBaseHandler struct {
+	db *sql.DB
+	//driver is the driver of the open database, the full-text search has a statement per driver
+	driver string
+	lock   sync.RWMutex
+}
+
+var instanceSQLHandler *SQLDataBaseHandler
+var onceSQLHandler sync.Once
+
+// GetDB returns the pool of connections, nil until the database is opened
+func (h *SQLDataBaseHandler) GetDB() *sql.DB {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return h.db
+}
+
+// Driver returns the driver of the database, empty until the database is opened
+func (h *SQLDataBaseHandler) Driver() string {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return h.driver
+}
+
+//sqliteFile returns the file of a sqlite data source, empty for an in memory database
+func sqliteFile(dsn string) string {
+	file := strings.TrimPrefix(strings.SplitN(dsn, "?", 2)[0], "file:")
+	if file == ":memory:" {
+		return ""
+	}
+	return file
+}
+
+// Open opens the pool of connections of the configuration and migrates the schema to the version of the configuration.
+// It fails when the schema is migrated down, the repositories need the latest version.
+func (h *SQLDataBaseHandler) Open(dbConfig *config.DatabaseConfig) error {
+	sqlConfig := &dbConfig.SQL
+	switch sqlConfig.Driver {
+	case SQLDriverSqlite:
+		if file := sqliteFile(sqlConfig.DSN); len(file) > 0 {
+			if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+				return fmt.Errorf("Cannot create the directory of %s [err=%w]", file, err)
+			}
+		}
+	case SQLDriverPostgres:
+	default:
+		return fmt.Errorf("unknown sql driver %s", sqlConfig.Driver)
+	}
+
+	db, err := sql.Open(sqlConfig.Driver, sqlConfig.DSN)
+	if err != nil {
+		return err
+	}
+	if sqlConfig.Driver == SQLDriverSqlite {
+		//sqlite has a single writer, the writes wait for the connection instead of failing on a locked database
+		db.SetMaxOpenConns(1)
+		db.SetMaxIdleConns(1)
+	} else {
+		db.SetMaxOpenConns(int(dbConfig.MaxPoolSize))
+		db.SetMaxIdleConns(sqlConfig.MaxIdleConns)
+		db.SetConnMaxLifetime(time.Duration(sqlConfig.ConnMaxLifetimeMinutes) * time.Minute)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return err
+	}
+
+	version, err := Migrate(db, sqlConfig.Driver, sqlConfig.SchemaVersion)
+	if err != nil {
+		db.Close()
+		return err
+	}
+	latest, err := LatestSchemaVersion(sqlConfig.Driver)
+	if err != nil {
+		db.Close()
+		return err
+	}
+	if version < latest {
+		db.Close()
+		return fmt.Errorf("the schema has been migrated down to version %d, this release needs version %d", version, latest)
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.db = db
+	h.driver = sqlConfig.Driver
+	log.Infof("Sql database %s opened with schema version %d", sqlConfig.Driver, version)
+	return nil
+}
+
+func (h *SQLDataBaseHandler) Close() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.db == nil {
+		return
+	}
+	if err := h.db.Close(); err != nil {
+		log.Error("Cannot close the sql database")
+	}
+	h.db = nil
+}
+
+func GetSQLDatabaseHandler() *SQLDataBaseHandler {
+	onceSQLHandler.Do(func() {
+		instanceSQLHandler = &SQLDataBaseHandler{}
+	})
+	return instanceSQLHandler
+}
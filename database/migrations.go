@@ -0,0 +1,172 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// The migrations of each driver are in migrations/<driver>, as <version>_<name>.up.sql applying a version of the schema
+// and <version>_<name>.down.sql reverting it. The versions applied are the rows of the table schema_migrations.
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+var migrationFilePattern = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+//migrationsLockID is the postgres advisory lock held while migrating, so that the instances starting together migrate one at a time
+const migrationsLockID = 7240516
+
+type migration struct {
+	version int64
+	name    string
+	up      string
+	down    string
+}
+
+//loadMigrations returns the migrations of the driver sorted by version, each of them with its up and down statements
+func loadMigrations(driver string) ([]migration, error) {
+	dir := path.Join("migrations", driver)
+	files, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, fmt.Errorf("no migrations for driver %s", driver)
+	}
+
+	byVersion := make(map[int64]*migration)
+	for _, file := range files {
+		parts := migrationFilePattern.FindStringSubmatch(file.Name())
+		if parts == nil {
+			return nil, fmt.Errorf("invalid migration file name %s", file.Name())
+		}
+		version, _ := strconv.ParseInt(parts[1], 10, 64)
+		statements, err := fs.ReadFile(migrationFiles, path.Join(dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if byVersion[version] == nil {
+			byVersion[version] = &migration{version: version, name: parts[2]}
+		}
+		if parts[3] == "up" {
+			byVersion[version].up = string(statements)
+		} else {
+			byVersion[version].down = string(statements)
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if len(m.up) == 0 || len(m.down) == 0 {
+			return nil, fmt.Errorf("migration %d_%s must have an up and a down file", m.version, m.name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+	return migrations, nil
+}
+
+// LatestSchemaVersion returns the version of the schema the repositories of the driver need
+func LatestSchemaVersion(driver string) (int64, error) {
+	migrations, err := loadMigrations(driver)
+	if err != nil || len(migrations) == 0 {
+		return 0, err
+	}
+	return migrations[len(migrations)-1].version, nil
+}
+
+// Migrate applies the up or the down migrations of the driver until the schema has the version, the latest one when 0,
+// and returns the version of the schema. Each migration is applied in its own transaction.
+func Migrate(db *sql.DB, driver string, version int64) (int64, error) {
+	migrations, err := loadMigrations(driver)
+	if err != nil {
+		return 0, err
+	}
+	var latest int64
+	if len(migrations) > 0 {
+		latest = migrations[len(migrations)-1].version
+	}
+	if version == 0 {
+		version = latest
+	}
+	if version < 0 || version > latest {
+		return 0, fmt.Errorf("unknown schema version %d, the latest is %d", version, latest)
+	}
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	if driver == SQLDriverPostgres {
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationsLockID); err != nil {
+			return 0, err
+		}
+		defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationsLockID)
+	}
+
+	if _, err := conn.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS schema_migrations (version BIGINT PRIMARY KEY, applied_at TEXT NOT NULL)"); err != nil {
+		return 0, err
+	}
+	var current int64
+	if err := conn.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&current); err != nil {
+		return 0, err
+	}
+	//an older release cannot use the schema of a newer one
+	if current > latest {
+		return current, fmt.Errorf("the schema has version %d, newer than the latest version %d of this release", current, latest)
+	}
+
+	for _, m := range migrations {
+		if m.version > current && m.version <= version {
+			err = migrateStep(ctx, conn, m.up, "INSERT INTO schema_migrations (version, applied_at) VALUES ($1, $2)", m.version, time.Now().UTC().Format(time.RFC3339))
+			if err != nil {
+				return current, fmt.Errorf("Cannot apply migration %d_%s [err=%w]", m.version, m.name, err)
+			}
+			current = m.version
+			log.Infof("Schema migrated up to version %d (%s)", m.version, m.name)
+		}
+	}
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.version <= current && m.version > version {
+			err = migrateStep(ctx, conn, m.down, "DELETE FROM schema_migrations WHERE version = $1", m.version)
+			if err != nil {
+				return current, fmt.Errorf("Cannot revert migration %d_%s [err=%w]", m.version, m.name, err)
+			}
+			current = 0
+			if i > 0 {
+				current = migrations[i-1].version
+			}
+			log.Infof("Schema migrated down from version %d (%s)", m.version, m.name)
+		}
+	}
+	return version, nil
+}
+
+//migrateStep runs the statements of a migration and records its version in the same transaction
+func migrateStep(ctx context.Context, conn *sql.Conn, statements string, record string, args ...interface{}) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, statements); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
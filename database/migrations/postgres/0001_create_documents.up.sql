@@ -0,0 +1,32 @@
+-- the documents of all the tenants, the columns are the ones the listings filter and sort on, data is the whole document in json.
+-- The texts are compared byte by byte as in Go, so that the pages follow the order of their cursors whatever the locale.
+CREATE TABLE document (
+	tenant TEXT COLLATE "C" NOT NULL,
+	id TEXT COLLATE "C" NOT NULL,
+	name TEXT COLLATE "C" NOT NULL,
+	created_at TEXT COLLATE "C" NOT NULL,
+	created_by TEXT COLLATE "C" NOT NULL,
+	updated_at TEXT COLLATE "C" NOT NULL,
+	updated_by TEXT COLLATE "C" NOT NULL,
+	deleted_at TEXT COLLATE "C",
+	version BIGINT NOT NULL,
+	data TEXT NOT NULL,
+	PRIMARY KEY (tenant, id)
+);
+CREATE INDEX document_name ON document (tenant, name, id);
+CREATE INDEX document_created_at ON document (tenant, created_at, id);
+CREATE INDEX document_updated_at ON document (tenant, updated_at, id);
+CREATE INDEX document_deleted_at ON document (tenant, deleted_at);
+
+CREATE TABLE document_revisions (
+	tenant TEXT COLLATE "C" NOT NULL,
+	document_id TEXT COLLATE "C" NOT NULL,
+	revision BIGINT NOT NULL,
+	data TEXT NOT NULL,
+	PRIMARY KEY (tenant, document_id, revision)
+);
+
+CREATE TABLE document_outbox (
+	id BIGSERIAL PRIMARY KEY,
+	event TEXT NOT NULL
+);
@@ -0,0 +1,8 @@
+-- the contents of the documents, metadata is the metadata of the content in json
+CREATE TABLE document_contents (
+	tenant TEXT COLLATE "C" NOT NULL,
+	document_id TEXT COLLATE "C" NOT NULL,
+	metadata TEXT NOT NULL,
+	data BYTEA NOT NULL,
+	PRIMARY KEY (tenant, document_id)
+);
@@ -0,0 +1,6 @@
+-- the lease of the relay publishing the outbox, a single instance publishes at a time. expires_at is in unix milliseconds
+CREATE TABLE document_outbox_lease (
+	name TEXT COLLATE "C" NOT NULL PRIMARY KEY,
+	holder TEXT NOT NULL,
+	expires_at BIGINT NOT NULL
+);
@@ -0,0 +1,4 @@
+DROP INDEX document_search;
+ALTER TABLE document DROP COLUMN search;
+DROP TABLE document_readers;
+DROP TABLE document_labels;
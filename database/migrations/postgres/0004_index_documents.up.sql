@@ -0,0 +1,34 @@
+-- the labels and the readers of the documents, the listings filter on them. A document without ACL has no readers and can be read by anyone.
+CREATE TABLE document_labels (
+	tenant TEXT COLLATE "C" NOT NULL,
+	document_id TEXT COLLATE "C" NOT NULL,
+	label_key TEXT COLLATE "C" NOT NULL,
+	label_value TEXT COLLATE "C" NOT NULL,
+	PRIMARY KEY (tenant, document_id, label_key)
+);
+CREATE INDEX document_labels_pair ON document_labels (tenant, label_key, label_value);
+
+CREATE TABLE document_readers (
+	tenant TEXT COLLATE "C" NOT NULL,
+	document_id TEXT COLLATE "C" NOT NULL,
+	principal TEXT COLLATE "C" NOT NULL,
+	PRIMARY KEY (tenant, document_id, principal)
+);
+CREATE INDEX document_readers_principal ON document_readers (tenant, principal);
+
+-- the functions are given empty values when the documents have no labels or no ACL
+INSERT INTO document_labels (tenant, document_id, label_key, label_value)
+	SELECT document.tenant, document.id, labels.key, labels.value FROM document,
+	jsonb_each_text(CASE WHEN jsonb_typeof(document.data::jsonb -> 'labels') = 'object' THEN document.data::jsonb -> 'labels' ELSE '{}' END) AS labels;
+INSERT INTO document_readers (tenant, document_id, principal)
+	SELECT tenant, id, 'user:' || (data::jsonb -> 'acl' ->> 'owner') FROM document WHERE jsonb_typeof(data::jsonb -> 'acl') = 'object'
+	UNION SELECT document.tenant, document.id, principals.value FROM document,
+	jsonb_array_elements_text(CASE WHEN jsonb_typeof(document.data::jsonb -> 'acl' -> 'readers') = 'array' THEN document.data::jsonb -> 'acl' -> 'readers' ELSE '[]' END) AS principals
+	UNION SELECT document.tenant, document.id, principals.value FROM document,
+	jsonb_array_elements_text(CASE WHEN jsonb_typeof(document.data::jsonb -> 'acl' -> 'writers') = 'array' THEN document.data::jsonb -> 'acl' -> 'writers' ELSE '[]' END) AS principals;
+
+-- the names and descriptions searched, computed on each write of a document. The name weighs more than the description.
+ALTER TABLE document ADD COLUMN search tsvector GENERATED ALWAYS AS (
+	setweight(to_tsvector('simple', name), 'A') || setweight(to_tsvector('simple', coalesce(data::jsonb ->> 'description', '')), 'B')
+) STORED;
+CREATE INDEX document_search ON document USING GIN (search);
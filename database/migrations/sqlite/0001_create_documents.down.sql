@@ -0,0 +1,3 @@
+DROP TABLE document_outbox;
+DROP TABLE document_revisions;
+DROP TABLE document;
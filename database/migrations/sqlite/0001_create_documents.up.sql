@@ -0,0 +1,31 @@
+-- the documents of all the tenants, the columns are the ones the listings filter and sort on, data is the whole document in json
+CREATE TABLE document (
+	tenant TEXT NOT NULL,
+	id TEXT NOT NULL,
+	name TEXT NOT NULL,
+	created_at TEXT NOT NULL,
+	created_by TEXT NOT NULL,
+	updated_at TEXT NOT NULL,
+	updated_by TEXT NOT NULL,
+	deleted_at TEXT,
+	version BIGINT NOT NULL,
+	data TEXT NOT NULL,
+	PRIMARY KEY (tenant, id)
+);
+CREATE INDEX document_name ON document (tenant, name, id);
+CREATE INDEX document_created_at ON document (tenant, created_at, id);
+CREATE INDEX document_updated_at ON document (tenant, updated_at, id);
+CREATE INDEX document_deleted_at ON document (tenant, deleted_at);
+
+CREATE TABLE document_revisions (
+	tenant TEXT NOT NULL,
+	document_id TEXT NOT NULL,
+	revision BIGINT NOT NULL,
+	data TEXT NOT NULL,
+	PRIMARY KEY (tenant, document_id, revision)
+);
+
+CREATE TABLE document_outbox (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	event TEXT NOT NULL
+);
@@ -0,0 +1,8 @@
+-- the contents of the documents, metadata is the metadata of the content in json
+CREATE TABLE document_contents (
+	tenant TEXT NOT NULL,
+	document_id TEXT NOT NULL,
+	metadata TEXT NOT NULL,
+	data BLOB NOT NULL,
+	PRIMARY KEY (tenant, document_id)
+);
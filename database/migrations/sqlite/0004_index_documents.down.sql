@@ -0,0 +1,7 @@
+DROP TRIGGER document_search_delete;
+DROP TRIGGER document_search_update;
+DROP TRIGGER document_search_insert;
+DROP TABLE document_search;
+DROP TABLE document_search_rows;
+DROP TABLE document_readers;
+DROP TABLE document_labels;
@@ -0,0 +1,59 @@
+-- the labels and the readers of the documents, the listings filter on them. A document without ACL has no readers and can be read by anyone.
+CREATE TABLE document_labels (
+	tenant TEXT NOT NULL,
+	document_id TEXT NOT NULL,
+	label_key TEXT NOT NULL,
+	label_value TEXT NOT NULL,
+	PRIMARY KEY (tenant, document_id, label_key)
+);
+CREATE INDEX document_labels_pair ON document_labels (tenant, label_key, label_value);
+
+CREATE TABLE document_readers (
+	tenant TEXT NOT NULL,
+	document_id TEXT NOT NULL,
+	principal TEXT NOT NULL,
+	PRIMARY KEY (tenant, document_id, principal)
+);
+CREATE INDEX document_readers_principal ON document_readers (tenant, principal);
+
+INSERT INTO document_labels (tenant, document_id, label_key, label_value)
+	SELECT document.tenant, document.id, labels.key, labels.value FROM document, json_each(document.data, '$.labels') AS labels
+	WHERE json_type(document.data, '$.labels') = 'object';
+INSERT INTO document_readers (tenant, document_id, principal)
+	SELECT tenant, id, 'user:' || json_extract(data, '$.acl.owner') FROM document WHERE json_type(data, '$.acl') = 'object'
+	UNION SELECT document.tenant, document.id, principals.value FROM document, json_each(document.data, '$.acl.readers') AS principals
+	WHERE json_type(document.data, '$.acl.readers') = 'array'
+	UNION SELECT document.tenant, document.id, principals.value FROM document, json_each(document.data, '$.acl.writers') AS principals
+	WHERE json_type(document.data, '$.acl.writers') = 'array';
+
+-- the names and descriptions searched, kept by the triggers of the documents. The rows of the full-text index are the ones of
+-- document_search_rows, whose ids are not changed by a vacuum like the rowids of the documents.
+CREATE TABLE document_search_rows (
+	row_id INTEGER PRIMARY KEY,
+	tenant TEXT NOT NULL,
+	document_id TEXT NOT NULL,
+	UNIQUE (tenant, document_id)
+);
+CREATE VIRTUAL TABLE document_search USING fts5(name, description);
+
+INSERT INTO document_search_rows (tenant, document_id) SELECT tenant, id FROM document;
+INSERT INTO document_search (rowid, name, description)
+	SELECT document_search_rows.row_id, document.name, coalesce(json_extract(document.data, '$.description'), '')
+	FROM document JOIN document_search_rows ON document_search_rows.tenant = document.tenant AND document_search_rows.document_id = document.id;
+
+CREATE TRIGGER document_search_insert AFTER INSERT ON document BEGIN
+	INSERT INTO document_search_rows (tenant, document_id) VALUES (new.tenant, new.id);
+	INSERT INTO document_search (rowid, name, description)
+		SELECT row_id, new.name, coalesce(json_extract(new.data, '$.description'), '') FROM document_search_rows
+		WHERE tenant = new.tenant AND document_id = new.id;
+END;
+CREATE TRIGGER document_search_update AFTER UPDATE ON document BEGIN
+	DELETE FROM document_search WHERE rowid = (SELECT row_id FROM document_search_rows WHERE tenant = old.tenant AND document_id = old.id);
+	INSERT INTO document_search (rowid, name, description)
+		SELECT row_id, new.name, coalesce(json_extract(new.data, '$.description'), '') FROM document_search_rows
+		WHERE tenant = new.tenant AND document_id = new.id;
+END;
+CREATE TRIGGER document_search_delete AFTER DELETE ON document BEGIN
+	DELETE FROM document_search WHERE rowid = (SELECT row_id FROM document_search_rows WHERE tenant = old.tenant AND document_id = old.id);
+	DELETE FROM document_search_rows WHERE tenant = old.tenant AND document_id = old.id;
+END;
@@ -0,0 +1,129 @@
+package database
+
+import (
+	"database/sql"
+	"goapi/config"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//openSqlite opens a sqlite file in a temporary directory removed after the test, without migrating it
+func openSqlite(t *testing.T) *sql.DB {
+	db, err := sql.Open(SQLDriverSqlite, filepath.Join(t.TempDir(), "goapi.sqlite"))
+	assert.Nil(t, err)
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+//tableExists tells if the table, or the virtual table, is in the schema of the sqlite database
+func tableExists(t *testing.T, db *sql.DB, table string) bool {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&count)
+	assert.Nil(t, err)
+	return count > 0
+}
+
+//countRows returns the count selected by the query
+func countRows(t *testing.T, db *sql.DB, query string, args ...interface{}) int {
+	var result int
+	err := db.QueryRow(query, args...).Scan(&result)
+	assert.Nil(t, err)
+	return result
+}
+
+func TestLoadMigrations(t *testing.T) {
+	//the drivers have the same versions of the schema
+	sqlite, err := loadMigrations(SQLDriverSqlite)
+	assert.Nil(t, err)
+	postgres, err := loadMigrations(SQLDriverPostgres)
+	assert.Nil(t, err)
+	assert.Equal(t, len(sqlite), len(postgres))
+	for i := range sqlite {
+		assert.Equal(t, int64(i+1), sqlite[i].version)
+		assert.Equal(t, sqlite[i].version, postgres[i].version)
+		assert.Equal(t, sqlite[i].name, postgres[i].name)
+	}
+
+	latest, err := LatestSchemaVersion(SQLDriverSqlite)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4), latest)
+
+	_, err = loadMigrations("oracle")
+	assert.NotNil(t, err)
+}
+
+func TestMigrate(t *testing.T) {
+	db := openSqlite(t)
+
+	version, err := Migrate(db, SQLDriverSqlite, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4), version)
+	for _, table := range []string{"document", "document_revisions", "document_outbox", "document_contents", "document_outbox_lease",
+		"document_labels", "document_readers", "document_search", "document_search_rows"} {
+		assert.True(t, tableExists(t, db, table), table)
+	}
+	assert.Equal(t, 4, countRows(t, db, "SELECT COUNT(*) FROM schema_migrations"))
+
+	//migrating again does nothing
+	version, err = Migrate(db, SQLDriverSqlite, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4), version)
+
+	_, err = Migrate(db, SQLDriverSqlite, 5)
+	assert.NotNil(t, err)
+}
+
+func TestMigrateDownAndUp(t *testing.T) {
+	db := openSqlite(t)
+	_, err := Migrate(db, SQLDriverSqlite, 0)
+	assert.Nil(t, err)
+
+	//the tables of the versions after 1 are dropped, the documents are kept
+	version, err := Migrate(db, SQLDriverSqlite, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), version)
+	for _, table := range []string{"document_contents", "document_outbox_lease", "document_labels", "document_readers", "document_search", "document_search_rows"} {
+		assert.False(t, tableExists(t, db, table), table)
+	}
+	assert.True(t, tableExists(t, db, "document"))
+	assert.Equal(t, 1, countRows(t, db, "SELECT COUNT(*) FROM schema_migrations"))
+
+	//a document written by the version 1 is indexed by the up migrations
+	_, err = db.Exec("INSERT INTO document (tenant, id, name, created_at, created_by, updated_at, updated_by, version, data)"+
+		" VALUES ('default', 'toto', 'annual report', '', '', '', '', 1, ?)",
+		`{"id":"toto","name":"annual report","description":"the figures","labels":{"team":"finance"},`+
+			`"acl":{"owner":"alice","readers":["group:finance"],"writers":[]}}`)
+	assert.Nil(t, err)
+
+	version, err = Migrate(db, SQLDriverSqlite, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4), version)
+	assert.Equal(t, 4, countRows(t, db, "SELECT COUNT(*) FROM schema_migrations"))
+	assert.Equal(t, 1, countRows(t, db, "SELECT COUNT(*) FROM document_labels WHERE document_id = 'toto' AND label_key = 'team' AND label_value = 'finance'"))
+	assert.Equal(t, 2, countRows(t, db, "SELECT COUNT(*) FROM document_readers WHERE document_id = 'toto' AND principal IN ('user:alice', 'group:finance')"))
+	assert.Equal(t, 1, countRows(t, db, "SELECT COUNT(*) FROM document_search WHERE document_search MATCH 'figures'"))
+
+	//the triggers keep the search of the documents written afterwards
+	_, err = db.Exec("UPDATE document SET name = 'minutes', data = '{\"id\":\"toto\",\"name\":\"minutes\"}' WHERE id = 'toto'")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, countRows(t, db, "SELECT COUNT(*) FROM document_search WHERE document_search MATCH 'figures'"))
+	assert.Equal(t, 1, countRows(t, db, "SELECT COUNT(*) FROM document_search WHERE document_search MATCH 'minutes'"))
+}
+
+func TestSQLDataBaseHandler_OpenMigratedDown(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "goapi.sqlite")
+	handler := &SQLDataBaseHandler{}
+
+	//the repositories need the latest schema
+	err := handler.Open(&config.DatabaseConfig{SQL: config.SQLDatabaseConfig{Driver: SQLDriverSqlite, DSN: dsn, SchemaVersion: 1}})
+	assert.NotNil(t, err)
+	assert.Nil(t, handler.GetDB())
+
+	err = handler.Open(&config.DatabaseConfig{SQL: config.SQLDatabaseConfig{Driver: SQLDriverSqlite, DSN: dsn}})
+	assert.Nil(t, err)
+	defer handler.Close()
+	assert.Equal(t, SQLDriverSqlite, handler.Driver())
+}
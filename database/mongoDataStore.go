@@ -2,6 +2,7 @@ package database
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -11,10 +12,31 @@ import (
 )
 
 const DocumentCollectionName = "document"
+const DocumentRevisionCollectionName = "document_revisions"
+const DocumentContentBucketName = "document_contents"
+const DocumentOutboxCollectionName = "document_outbox"
+const DocumentOutboxLeaseCollectionName = "document_outbox_lease"
+const RateLimitCollectionName = "rate_limits"
+
+// The isolations of the documents of the tenants
+const (
+	//TenantIsolationField keeps the documents of all the tenants in the same collections, with their tenant in a field
+	TenantIsolationField = "field"
+	//TenantIsolationCollection gives each tenant its own collections
+	TenantIsolationCollection = "collection"
+)
+
+//the unique indexes of the collections shared by the tenants before the tenant field, they are replaced by indexes with the tenant
+const (
+	sharedIDIndexName       = "id_1"
+	sharedRevisionIndexName = "documentId_1_revision_-1"
+)
 
 type MongoDatastore struct {
 	Database *mongo.Database
 	Session  *mongo.Client
+	//TenantIsolation is how the documents of the tenants are kept apart
+	TenantIsolation string
 }
 
 func (ds *MongoDatastore) Close() {
@@ -25,25 +47,133 @@ func (ds *MongoDatastore) Close() {
 }
 
 func (ds *MongoDatastore) createDatabaseForApp() {
+	tenantField := ds.TenantIsolation != TenantIsolationCollection
+	if err := ds.EnsureDocumentIndexes(DocumentCollectionName, DocumentRevisionCollectionName, tenantField); err != nil {
+		log.Errorf("Cannot create indexes [err=%s]", err)
+	} else if tenantField {
+		//the ids are only unique per tenant, the former indexes are dropped once the indexes with the tenant exist
+		//so that the ids are never left without a unique index
+		ds.dropSharedIndexes()
+	}
+	if err := ds.ensureRateLimitIndexes(); err != nil {
+		log.Errorf("Cannot create indexes [err=%s]", err)
+	}
+}
+
+//dropSharedIndexes drops the unique indexes of the collections shared by the tenants that have no tenant
+func (ds *MongoDatastore) dropSharedIndexes() {
+	//create context
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := ds.Database.Collection(DocumentCollectionName).Indexes().DropOne(ctx, sharedIDIndexName); err == nil {
+		log.Infof("Index %s dropped on %s, replaced by an index with the tenant", sharedIDIndexName, DocumentCollectionName)
+	}
+	if _, err := ds.Database.Collection(DocumentRevisionCollectionName).Indexes().DropOne(ctx, sharedRevisionIndexName); err == nil {
+		log.Infof("Index %s dropped on %s, replaced by an index with the tenant", sharedRevisionIndexName, DocumentRevisionCollectionName)
+	}
+}
+
+//ensureRateLimitIndexes removes the buckets of the rate limits once they are full again
+func (ds *MongoDatastore) ensureRateLimitIndexes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	modExpire := mongo.IndexModel{
+		Keys:    bson.D{{Key: "expireAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}
+	if _, err := ds.Database.Collection(RateLimitCollectionName).Indexes().CreateOne(ctx, modExpire); err != nil {
+		return fmt.Errorf("Cannot create index on %s [err=%w]", RateLimitCollectionName, err)
+	}
+	return nil
+}
+
+// EnsureDocumentIndexes creates the indexes of a collection of documents and of its collection of revisions.
+// With tenantField, the documents of all the tenants are in the collections, and the unique indexes are per tenant.
+func (ds *MongoDatastore) EnsureDocumentIndexes(documentCollectionName string, revisionCollectionName string, tenantField bool) error {
+	//the keys of an index, prefixed by the tenant when the collection is shared by the tenants
+	keys := func(fields ...string) bson.D {
+		var keys bson.D
+		if tenantField {
+			keys = append(keys, bson.E{Key: "tenant", Value: 1})
+		}
+		for _, field := range fields {
+			keys = append(keys, bson.E{Key: field, Value: 1})
+		}
+		return keys
+	}
+
 	//create model index
 	mod := mongo.IndexModel{
-		Keys: bson.M{
-			"id": 1,
-		},
+		Keys: keys("id"),
 		// create UniqueIndex option
 		Options: options.Index().SetUnique(true),
 	}
 
+	//index for the listings sorted by name
+	modName := mongo.IndexModel{
+		Keys: keys("name", "id"),
+	}
+
+	//index for the full text search, the text is not stemmed so that it is searched as the in memory repository does
+	modText := mongo.IndexModel{
+		Keys: bson.D{{Key: "name", Value: "text"}, {Key: "description", Value: "text"}},
+		Options: options.Index().
+			SetName("text_search").
+			SetWeights(bson.M{"name": 2, "description": 1}).
+			SetDefaultLanguage("none"),
+	}
+
+	//index for the trash listings and the purge, only trashed documents have a deletedAt
+	modTrash := mongo.IndexModel{
+		Keys:    bson.D{{Key: "deletedAt", Value: 1}},
+		Options: options.Index().SetSparse(true),
+	}
+
+	//indexes for the listings sorted or filtered by creation or modification time
+	modCreatedAt := mongo.IndexModel{
+		Keys: keys("createdAt", "id"),
+	}
+	modUpdatedAt := mongo.IndexModel{
+		Keys: keys("updatedAt", "id"),
+	}
+
+	//multikey indexes for the label selectors, on the label pairs and keys written with the documents
+	modLabelPairs := mongo.IndexModel{
+		Keys: keys("labelPairs"),
+	}
+	modLabelKeys := mongo.IndexModel{
+		Keys: keys("labelKeys"),
+	}
+
+	//multikey index for the listings of a caller, on the readers written with the ACL of the documents
+	modACLReaders := mongo.IndexModel{
+		Keys: keys("aclReaders"),
+	}
+
 	//create collection
-	collection := ds.Database.Collection(DocumentCollectionName)
+	collection := ds.Database.Collection(documentCollectionName)
 
 	//create context
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	//create index
-	_, err := collection.Indexes().CreateOne(ctx, mod)
+	//create indexes
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{mod, modName, modText, modTrash, modCreatedAt, modUpdatedAt, modLabelPairs, modLabelKeys, modACLReaders})
+	if err != nil {
+		return fmt.Errorf("Cannot create index on %s [err=%w]", documentCollectionName, err)
+	}
+
+	//a revision number is unique for a document
+	revisionKeys := append(keys("documentId"), bson.E{Key: "revision", Value: -1})
+	modRevision := mongo.IndexModel{
+		Keys:    revisionKeys,
+		Options: options.Index().SetUnique(true),
+	}
+	_, err = ds.Database.Collection(revisionCollectionName).Indexes().CreateOne(ctx, modRevision)
 	if err != nil {
-		log.Errorf("Cannot create index on %s", DocumentCollectionName)
+		return fmt.Errorf("Cannot create index on %s [err=%w]", revisionCollectionName, err)
 	}
+	return nil
 }
@@ -0,0 +1,52 @@
+package database
+
+import (
+	"fmt"
+	"goapi/config"
+)
+
+// The types of storage of the documents
+const (
+	//StorageMemory keeps the documents in memory, they are lost on restart
+	StorageMemory = "memory"
+	//StorageMongo keeps the documents in mongo
+	StorageMongo = "mongo"
+	//StorageBolt keeps the documents in a bolt file on the local disk, for a single instance
+	StorageBolt = "bolt"
+	//StorageSQL keeps the documents in a sql database, sqlite or postgres
+	StorageSQL = "sql"
+)
+
+// StorageTypeOf returns the type of storage of the configuration, the empty type is memory
+func StorageTypeOf(storageConfig *config.StorageConfig) string {
+	if len(storageConfig.Type) == 0 {
+		return StorageMemory
+	}
+	return storageConfig.Type
+}
+
+// ValidStorageType tells if the type of storage of the configuration is known
+func ValidStorageType(storageConfig *config.StorageConfig) bool {
+	switch StorageTypeOf(storageConfig) {
+	case StorageMemory, StorageMongo, StorageBolt, StorageSQL:
+		return true
+	}
+	return false
+}
+
+// ApplyFormerStorageSetting sets the type of storage from storageInMemory (STORAGE_MEMORY), that storage.type replaced:
+// true is the memory storage and false the mongo one. It fails when storage.type is set to another type.
+func ApplyFormerStorageSetting(configuration *config.Config) error {
+	if configuration.StorageInMemory == nil {
+		return nil
+	}
+	storageType := StorageMongo
+	if *configuration.StorageInMemory {
+		storageType = StorageMemory
+	}
+	if len(configuration.StorageConfig.Type) > 0 && configuration.StorageConfig.Type != storageType {
+		return fmt.Errorf("storageInMemory=%t contradicts storage.type=%s, remove storageInMemory", *configuration.StorageInMemory, configuration.StorageConfig.Type)
+	}
+	configuration.StorageConfig.Type = storageType
+	return nil
+}
@@ -62,8 +62,9 @@ func TestApiDocument(t *testing.T) {
 
 			bodyBytes := getBodyResponseFrom(resp)
 
-			var documents []models.Document
-			json.Unmarshal(bodyBytes, &documents)
+			var page models.DocumentPage
+			json.Unmarshal(bodyBytes, &page)
+			documents := page.Documents
 			So(documents, ShouldBeEmpty)
 
 		})
@@ -84,7 +85,7 @@ func TestApiDocument(t *testing.T) {
 				bodyBytes := getBodyResponseFrom(resp)
 				var document models.Document
 				json.Unmarshal(bodyBytes, &document)
-				So(document, ShouldResemble, models.Document{ID: "toto", Name: "nametoto"})
+				So(document, ShouldResemble, models.Document{ID: "toto", Name: "nametoto", Version: 1})
 
 			})
 
@@ -105,7 +106,7 @@ func TestApiDocument(t *testing.T) {
 				bodyBytes := getBodyResponseFrom(resp)
 				var document models.Document
 				json.Unmarshal(bodyBytes, &document)
-				So(document, ShouldResemble, models.Document{ID: "toto", Name: "nametoto2"})
+				So(document, ShouldResemble, models.Document{ID: "toto", Name: "nametoto2", Version: 2})
 			})
 
 		})
@@ -117,10 +118,11 @@ func TestApiDocument(t *testing.T) {
 
 			bodyBytes := getBodyResponseFrom(resp)
 
-			var documents []models.Document
-			json.Unmarshal(bodyBytes, &documents)
+			var page models.DocumentPage
+			json.Unmarshal(bodyBytes, &page)
+			documents := page.Documents
 			So(len(documents), ShouldEqual, 1)
-			So(documents[0], ShouldResemble, models.Document{ID: "toto", Name: "nametoto2"})
+			So(documents[0], ShouldResemble, models.Document{ID: "toto", Name: "nametoto2", Version: 2})
 
 		})
 
@@ -135,8 +137,9 @@ func TestApiDocument(t *testing.T) {
 
 				bodyBytes := getBodyResponseFrom(resp)
 
-				var documents []models.Document
-				json.Unmarshal(bodyBytes, &documents)
+				var page models.DocumentPage
+				json.Unmarshal(bodyBytes, &page)
+				documents := page.Documents
 				So(documents, ShouldBeEmpty)
 			})
 
@@ -0,0 +1,225 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"goapi/models"
+	documentsv1 "goapi/proto/documents/v1"
+	"goapi/repositories/repodocuments"
+	"goapi/resources/problems"
+	"goapi/services/servicedocuments"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// documentsServer serves the documents as the REST endpoints /documents, with the same errors
+type documentsServer struct {
+	documentsv1.UnimplementedDocumentServiceServer
+	documentService servicedocuments.DocumentService
+	//stopping is done when the server stops, it ends the streams of the changes
+	stopping context.Context
+}
+
+//timestamp returns the timestamp of a time, nil for the zero time
+func timestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+//timeOf returns the time of a timestamp, the zero time when there is none
+func timeOf(ts *timestamppb.Timestamp) time.Time {
+	if ts == nil {
+		return time.Time{}
+	}
+	return ts.AsTime()
+}
+
+func toDocument(document models.Document) *documentsv1.Document {
+	answer := &documentsv1.Document{
+		Id:          document.ID,
+		Name:        document.Name,
+		Description: document.Description,
+		Labels:      document.Labels,
+		Version:     document.Version,
+		CreatedAt:   timestamp(document.CreatedAt),
+		CreatedBy:   document.CreatedBy,
+		UpdatedAt:   timestamp(document.UpdatedAt),
+		UpdatedBy:   document.UpdatedBy,
+	}
+	if document.ACL != nil {
+		answer.Acl = &documentsv1.DocumentACL{Owner: document.ACL.Owner, Readers: document.ACL.Readers, Writers: document.ACL.Writers}
+	}
+	if document.DeletedAt != nil {
+		answer.DeletedAt = timestamppb.New(*document.DeletedAt)
+	}
+	return answer
+}
+
+//fromDocument returns the document to write, the fields managed by the server are ignored
+func fromDocument(document *documentsv1.Document) models.Document {
+	written := models.Document{ID: document.GetId(), Name: document.GetName(), Description: document.GetDescription()}
+	if len(document.GetLabels()) > 0 {
+		written.Labels = models.Labels(document.GetLabels())
+	}
+	return written
+}
+
+func preconditionOf(precondition *documentsv1.Precondition) repodocuments.Precondition {
+	return repodocuments.Precondition{
+		IfMatch:      precondition.GetIfMatch(),
+		MustExist:    precondition.GetMustExist(),
+		MustNotExist: precondition.GetMustNotExist(),
+	}
+}
+
+//queryOf returns the query of the documents to list, as the query parameters of GET /documents
+func queryOf(request *documentsv1.ListDocumentsRequest) (repodocuments.DocumentQuery, error) {
+	var query repodocuments.DocumentQuery
+	if request.GetLimit() < 0 {
+		return query, errors.New("limit must be positive")
+	}
+	sort, err := repodocuments.ParseDocumentSort(request.GetSort())
+	if err != nil {
+		return query, fmt.Errorf("sort must be one of %s, prefixed by - for a descending order", strings.Join(repodocuments.SortFields(), ", "))
+	}
+	query.Sort = sort
+	query.Filter.Selector, err = repodocuments.ParseLabelSelector(request.GetSelector())
+	if err != nil {
+		return query, err
+	}
+	query.Filter.CreatedBy = request.GetCreatedBy()
+	query.Filter.UpdatedBy = request.GetUpdatedBy()
+	query.Filter.CreatedAfter = timeOf(request.GetCreatedAfter())
+	query.Filter.CreatedBefore = timeOf(request.GetCreatedBefore())
+	query.Filter.UpdatedAfter = timeOf(request.GetUpdatedAfter())
+	query.Filter.UpdatedBefore = timeOf(request.GetUpdatedBefore())
+	return query, nil
+}
+
+func (s *documentsServer) Get(ctx context.Context, request *documentsv1.GetDocumentRequest) (*documentsv1.Document, error) {
+	id := request.GetId()
+	document, err := s.documentService.Get(ctx, id)
+	if errors.Is(err, repodocuments.ErrNotFound) {
+		return nil, problems.NotFound("document id %s not found", id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get document id %s [err=%w]", id, err)
+	}
+	return toDocument(document), nil
+}
+
+// List streams the documents page by page, the pages are as large as the default pages of GET /documents
+func (s *documentsServer) List(request *documentsv1.ListDocumentsRequest, stream documentsv1.DocumentService_ListServer) error {
+	query, err := queryOf(request)
+	if err != nil {
+		return problems.Validation("Validation failed [err=%s]", err)
+	}
+
+	remaining := int(request.GetLimit())
+	for {
+		query.Limit = repodocuments.DefaultPageLimit
+		if remaining > 0 && remaining < query.Limit {
+			query.Limit = remaining
+		}
+		page, err := s.documentService.List(stream.Context(), query)
+		if err != nil {
+			return fmt.Errorf("Cannot get documents [err=%w]", err)
+		}
+		for _, document := range page.Documents {
+			if err := stream.Send(toDocument(document)); err != nil {
+				return err
+			}
+		}
+		if remaining > 0 {
+			remaining -= len(page.Documents)
+			if remaining <= 0 {
+				return nil
+			}
+		}
+		if len(page.NextCursor) == 0 {
+			return nil
+		}
+		query.Cursor = page.NextCursor
+	}
+}
+
+func (s *documentsServer) CreateOrUpdate(ctx context.Context, request *documentsv1.CreateOrUpdateDocumentRequest) (*documentsv1.CreateOrUpdateDocumentResponse, error) {
+	if request.GetDocument() == nil {
+		return nil, problems.Validation("Validation failed [err=document must be defined]")
+	}
+	id := request.GetDocument().GetId()
+	if len(id) == 0 {
+		return nil, problems.Validation("Validation failed [err=id must be defined]")
+	}
+
+	document, updated, err := s.documentService.CreateOrUpdate(ctx, fromDocument(request.GetDocument()), preconditionOf(request.GetPrecondition()))
+	if errors.Is(err, repodocuments.ErrPreconditionFailed) {
+		return nil, fmt.Errorf("document id %s has been modified [err=%w]", id, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Cannot create or update document [err=%w]", err)
+	}
+	return &documentsv1.CreateOrUpdateDocumentResponse{Document: toDocument(document), Created: !updated}, nil
+}
+
+func (s *documentsServer) Delete(ctx context.Context, request *documentsv1.DeleteDocumentRequest) (*documentsv1.DeleteDocumentResponse, error) {
+	id := request.GetId()
+	if len(id) == 0 {
+		return nil, problems.Validation("Validation failed [err=id must be defined]")
+	}
+
+	found, err := s.documentService.Delete(ctx, id, preconditionOf(request.GetPrecondition()))
+	if errors.Is(err, repodocuments.ErrPreconditionFailed) {
+		return nil, fmt.Errorf("document id %s has been modified [err=%w]", id, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Cannot delete documents [err=%w]", err)
+	}
+	if !found {
+		return nil, problems.NotFound("document id %s not found", id)
+	}
+	return &documentsv1.DeleteDocumentResponse{}, nil
+}
+
+// WatchChanges streams the changes until the client cancels the call or the server stops
+func (s *documentsServer) WatchChanges(request *documentsv1.WatchChangesRequest, stream documentsv1.DocumentService_WatchChangesServer) error {
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	changes, err := s.documentService.WatchChanges(ctx, request.GetLastEventId(), request.GetPrefix())
+	if errors.Is(err, repodocuments.ErrUnknownEvent) {
+		return fmt.Errorf("Cannot resume the changes [err=%w]", err)
+	}
+	if err != nil {
+		return fmt.Errorf("Cannot watch the changes [err=%w]", err)
+	}
+
+	for {
+		select {
+		case change, open := <-changes:
+			if !open {
+				return nil
+			}
+			sent := &documentsv1.DocumentChange{
+				EventId:    change.EventID,
+				Type:       change.Type,
+				DocumentId: change.DocumentID,
+				Timestamp:  timestamp(change.Timestamp),
+			}
+			if change.Document != nil {
+				sent.Document = toDocument(*change.Document)
+			}
+			if err := stream.Send(sent); err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return nil
+		case <-s.stopping.Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package grpcserver
+
+import (
+	"context"
+	"goapi/emails"
+	documentsv1 "goapi/proto/documents/v1"
+)
+
+// EmailSender posts the emails to send, like the POST /emails endpoint
+type EmailSender interface {
+	Send(emailMessage emails.EmailMessage) error
+}
+
+// emailsServer posts the emails as the REST endpoint /emails
+type emailsServer struct {
+	documentsv1.UnimplementedEmailServiceServer
+	emailSender EmailSender
+}
+
+func (s *emailsServer) Send(_ context.Context, request *documentsv1.SendEmailRequest) (*documentsv1.SendEmailResponse, error) {
+	emailMessage := emails.EmailMessage{
+		From:        request.GetFrom(),
+		To:          request.GetTo(),
+		CC:          request.GetCc(),
+		BCC:         request.GetBcc(),
+		Subject:     request.GetSubject(),
+		TextContent: request.GetTextBody(),
+		HtmlContent: request.GetHtmlBody(),
+		Attachments: make(map[string][]byte),
+	}
+	for name, content := range request.GetAttachments() {
+		emailMessage.Attachments[name] = content
+	}
+	if err := s.emailSender.Send(emailMessage); err != nil {
+		return nil, err
+	}
+	return &documentsv1.SendEmailResponse{}, nil
+}
@@ -0,0 +1,278 @@
+package grpcserver
+
+import (
+	"context"
+	"fmt"
+	"goapi/config"
+	documentsv1 "goapi/proto/documents/v1"
+	"goapi/repositories/reporatelimits"
+	"goapi/resources/auth"
+	"goapi/resources/documents"
+	"goapi/resources/emails"
+	"goapi/resources/problems"
+	"goapi/resources/ratelimit"
+	"goapi/resources/tenants"
+	"goapi/services/servicedocuments"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+// StopTimeout is how long the calls in progress are waited for when the server stops, they are cancelled after
+const StopTimeout = 5 * time.Second
+
+//publicServices are the services called without credentials, the probes and the descriptions of the services
+var publicServices = []string{"/grpc.health.v1.Health/", "/grpc.reflection.v1alpha.ServerReflection/"}
+
+//methodScopes are the scopes of the methods, the same as the scopes of their REST endpoints.
+//A method missing from them is refused, only the methods of publicServices are called without scope.
+var methodScopes = map[string][]string{
+	"/goapi.documents.v1.DocumentService/Get":            {documents.ReadScope},
+	"/goapi.documents.v1.DocumentService/List":           {documents.ReadScope},
+	"/goapi.documents.v1.DocumentService/WatchChanges":   {documents.ReadScope},
+	"/goapi.documents.v1.DocumentService/CreateOrUpdate": {documents.WriteScope},
+	"/goapi.documents.v1.DocumentService/Delete":         {documents.WriteScope},
+	"/goapi.documents.v1.EmailService/Send":              {emails.SendScope},
+}
+
+//route is a route of the REST API
+type route struct {
+	method string
+	path   string
+}
+
+//methodRoutes are the REST endpoints of the methods, a call takes its token from the bucket of its client on the route of the endpoint
+var methodRoutes = map[string]route{
+	"/goapi.documents.v1.DocumentService/Get":            {http.MethodGet, "/documents/:id"},
+	"/goapi.documents.v1.DocumentService/List":           {http.MethodGet, "/documents"},
+	"/goapi.documents.v1.DocumentService/WatchChanges":   {http.MethodGet, "/documents/changes"},
+	"/goapi.documents.v1.DocumentService/CreateOrUpdate": {http.MethodPut, "/documents/:id"},
+	"/goapi.documents.v1.DocumentService/Delete":         {http.MethodDelete, "/documents/:id"},
+	"/goapi.documents.v1.EmailService/Send":              {http.MethodPost, "/emails"},
+}
+
+// Server serves the documents and the emails over gRPC, with the authentication, the authorization and the tenants of the REST API
+type Server struct {
+	configuration *config.Config
+	authenticator *auth.Authenticator
+	limiter       *ratelimit.Limiter
+	server        *grpc.Server
+	health        *health.Server
+	//stopping is cancelled when the server stops, so that the streams of the changes do not hold the graceful stop
+	stopping context.Context
+	stop     context.CancelFunc
+}
+
+// NewServer returns the server of the services, it is started with Start.
+// The calls are limited with the buckets of the rate limits of the REST API, in the same repository.
+func NewServer(configuration *config.Config, documentService servicedocuments.DocumentService, emailSender EmailSender,
+	rateLimitRepository reporatelimits.RateLimitRepository) (*Server, error) {
+	authenticator, err := auth.NewAuthenticator(&configuration.AuthConfig)
+	if err != nil {
+		return nil, err
+	}
+	limiter, err := ratelimit.NewLimiter(&configuration.RateLimitConfig, rateLimitRepository)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{configuration: configuration, authenticator: authenticator, limiter: limiter, health: health.NewServer()}
+	s.stopping, s.stop = context.WithCancel(context.Background())
+	s.server = grpc.NewServer(grpc.UnaryInterceptor(s.unaryInterceptor), grpc.StreamInterceptor(s.streamInterceptor))
+
+	documentsv1.RegisterDocumentServiceServer(s.server, &documentsServer{documentService: documentService, stopping: s.stopping})
+	documentsv1.RegisterEmailServiceServer(s.server, &emailsServer{emailSender: emailSender})
+	healthpb.RegisterHealthServer(s.server, s.health)
+	if configuration.GRPCConfig.Reflection {
+		reflection.Register(s.server)
+	}
+	return s, nil
+}
+
+// Start listens on the port of the configuration and serves the calls in the background
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", ":"+s.configuration.GRPCConfig.Port)
+	if err != nil {
+		return fmt.Errorf("Cannot listen on port %s [err=%w]", s.configuration.GRPCConfig.Port, err)
+	}
+	s.serve(listener)
+	log.Infof("gRPC server listening on port %s", s.configuration.GRPCConfig.Port)
+	return nil
+}
+
+//serve serves the calls of the listener in the background
+func (s *Server) serve(listener net.Listener) {
+	for service := range s.server.GetServiceInfo() {
+		s.health.SetServingStatus(service, healthpb.HealthCheckResponse_SERVING)
+	}
+	s.health.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	go func() {
+		if err := s.server.Serve(listener); err != nil {
+			log.Errorf("gRPC server stopped [err=%s]", err)
+		}
+	}()
+}
+
+// Stop tells the probes the server is not serving anymore, ends the streams of the changes and waits for the calls in progress,
+// at most StopTimeout
+func (s *Server) Stop() {
+	s.health.Shutdown()
+	s.stop()
+	stopped := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(StopTimeout):
+		log.Warn("gRPC calls still in progress after the timeout, cancelling them")
+		s.server.Stop()
+	}
+}
+
+//public tells if the method is called without credentials
+func public(fullMethod string) bool {
+	for _, prefix := range publicServices {
+		if strings.HasPrefix(fullMethod, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+//peerIP returns the IP of the client of the call, the address of the connection
+func peerIP(ctx context.Context) string {
+	caller, found := peer.FromContext(ctx)
+	if !found || caller.Addr == nil {
+		return ""
+	}
+	address := caller.Addr.String()
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+	return address
+}
+
+//headerOf returns the function reading a header of the metadata of the call, the metadata keys are lower case
+func headerOf(ctx context.Context) func(name string) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	return func(name string) string {
+		if values := md.Get(name); len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+}
+
+//callerContext authenticates the caller and checks its scopes, then returns the context of the service calls with its tenant,
+//its actor and its groups, as the middlewares of the REST API do
+func (s *Server) callerContext(ctx context.Context, fullMethod string) (context.Context, error) {
+	//the calls of each IP are limited before the authentication, the ones failing to authenticate too
+	ip := peerIP(ctx)
+	if err := s.limiter.TakeIP(ctx, ip); err != nil {
+		return nil, err
+	}
+	if public(fullMethod) {
+		return ctx, nil
+	}
+	scopes, known := methodScopes[fullMethod]
+	if !known {
+		return nil, status.Errorf(codes.PermissionDenied, "Authorization failed [err=the method %s has no scopes]", fullMethod)
+	}
+	header := headerOf(ctx)
+
+	var principal *auth.Principal
+	if s.authenticator != nil {
+		authenticated, err := s.authenticator.Authenticate(header)
+		if err != nil {
+			return nil, status.Errorf(codes.Unauthenticated, "Authentication failed [err=%s]", err)
+		}
+		principal = authenticated
+	}
+
+	//the calls are counted by principal, in the buckets of the REST endpoints
+	if route, found := methodRoutes[fullMethod]; found {
+		if err := s.limiter.Take(ctx, route.method, route.path, s.limiter.ClientKey(principal, ip)); err != nil {
+			return nil, err
+		}
+	}
+
+	authorization := &s.configuration.AuthConfig.Authorization
+	if principal != nil && authorization.Enabled {
+		if missing := principal.MissingScopes(scopes...); len(missing) > 0 {
+			err := &problems.MissingScopesError{Scopes: missing}
+			if !authorization.DryRun {
+				return nil, err
+			}
+			log.Warnf("%s of %s would be denied in dry run [err=%s]", fullMethod, principal.Subject, err)
+		}
+	}
+
+	var binding *tenants.Binding
+	if principal != nil {
+		binding = principal.Binding()
+	}
+	tenancy := &s.configuration.TenancyConfig
+	tenant, err := tenants.Resolve(tenancy, binding, header(tenants.HeaderOf(tenancy)))
+	if err != nil {
+		return nil, err
+	}
+	ctx = servicedocuments.WithTenant(ctx, tenant)
+
+	//the actor of an authenticated call is its principal, the calls without principal are made by the anonymous actor
+	if principal != nil {
+		ctx = servicedocuments.WithGroups(servicedocuments.WithActor(ctx, principal.Subject), principal.Groups)
+		return servicedocuments.WithAdmin(ctx, principal.HasScope(auth.AdminScope)), nil
+	}
+	return servicedocuments.WithGroups(servicedocuments.WithActor(ctx, servicedocuments.AnonymousActor), nil), nil
+}
+
+//answer returns the status answering the error of a method, the unexpected errors are logged
+func answer(ctx context.Context, fullMethod string, err error) error {
+	if err == nil {
+		return nil
+	}
+	answered := statusOf(err)
+	if answered.Code() == codes.Internal || answered.Code() == codes.Unavailable {
+		servicedocuments.LoggerFrom(ctx).Errorf("%s failed [err=%s]", fullMethod, err)
+	}
+	return answered.Err()
+}
+
+func (s *Server) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	callerCtx, err := s.callerContext(ctx, info.FullMethod)
+	if err != nil {
+		return nil, answer(ctx, info.FullMethod, err)
+	}
+	resp, err := handler(callerCtx, req)
+	return resp, answer(callerCtx, info.FullMethod, err)
+}
+
+//callerStream is a stream whose context is the context of the caller
+type callerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *callerStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *Server) streamInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	callerCtx, err := s.callerContext(stream.Context(), info.FullMethod)
+	if err != nil {
+		return answer(stream.Context(), info.FullMethod, err)
+	}
+	return answer(callerCtx, info.FullMethod, handler(srv, &callerStream{ServerStream: stream, ctx: callerCtx}))
+}
@@ -0,0 +1,377 @@
+package grpcserver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"goapi/config"
+	"goapi/emails"
+	"goapi/models"
+	documentsv1 "goapi/proto/documents/v1"
+	"goapi/repositories/repodocuments"
+	"goapi/repositories/reporatelimits"
+	"goapi/services/servicedocuments"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+/*
+	Mock of the calls of the services, the other methods of the service are not called
+*/
+type DocumentServiceMock struct {
+	mock.Mock
+	servicedocuments.DocumentService
+}
+
+//the calls are made with the tenant and the actor of their context
+func (s *DocumentServiceMock) Get(ctx context.Context, id string) (models.Document, error) {
+	args := s.Called(servicedocuments.TenantFrom(ctx), servicedocuments.ActorFrom(ctx), id)
+	return args.Get(0).(models.Document), args.Error(1)
+}
+
+func (s *DocumentServiceMock) List(ctx context.Context, query repodocuments.DocumentQuery) (models.DocumentPage, error) {
+	args := s.Called(query)
+	return args.Get(0).(models.DocumentPage), args.Error(1)
+}
+
+func (s *DocumentServiceMock) CreateOrUpdate(ctx context.Context, document models.Document, precondition repodocuments.Precondition) (models.Document, bool, error) {
+	args := s.Called(servicedocuments.ActorFrom(ctx), document, precondition)
+	return args.Get(0).(models.Document), args.Get(1).(bool), args.Error(2)
+}
+
+func (s *DocumentServiceMock) Delete(ctx context.Context, id string, precondition repodocuments.Precondition) (bool, error) {
+	args := s.Called(id, precondition)
+	return args.Get(0).(bool), args.Error(1)
+}
+
+func (s *DocumentServiceMock) WatchChanges(ctx context.Context, lastEventID string, prefix string) (<-chan models.DocumentChange, error) {
+	args := s.Called(lastEventID, prefix)
+	changes, _ := args.Get(0).(chan models.DocumentChange)
+	return changes, args.Error(1)
+}
+
+type EmailSenderMock struct {
+	mock.Mock
+}
+
+func (s *EmailSenderMock) Send(emailMessage emails.EmailMessage) error {
+	args := s.Called(emailMessage)
+	return args.Error(0)
+}
+
+var document = models.Document{
+	ID: "toto", Name: "nameOfToto", Description: "descOfToto", Labels: models.Labels{"team": "payments"}, Version: 2,
+	CreatedAt: time.Date(2021, 10, 1, 8, 0, 0, 0, time.UTC), CreatedBy: "alice", UpdatedAt: time.Date(2021, 10, 2, 8, 0, 0, 0, time.UTC), UpdatedBy: "bob",
+}
+
+//the API keys of the clients with the scopes of their role
+var apiKeys = map[string]string{"reader": "r34d3r-k3y", "editor": "3d1t0r-k3y"}
+
+//the tenants of the API keys, the default tenant for the others
+var apiKeyTenants = map[string]string{"reader": "payments"}
+
+func configuration() *config.Config {
+	configuration := &config.Config{
+		AuthConfig: config.AuthConfig{
+			Enabled: true,
+			Authorization: config.AuthorizationConfig{
+				Enabled: true,
+				Roles:   map[string][]string{"reader": {"documents:read"}, "editor": {"documents:read", "documents:write", "emails:send"}},
+			},
+		},
+		TenancyConfig: config.TenancyConfig{Header: "X-Tenant"},
+		GRPCConfig:    config.GRPCConfig{Enabled: true, Reflection: true},
+	}
+	for role, key := range apiKeys {
+		hash := sha256.Sum256([]byte(key))
+		configuration.AuthConfig.APIKeys = append(configuration.AuthConfig.APIKeys,
+			config.APIKeyConfig{Name: role + "-client", Hash: hex.EncodeToString(hash[:]), Roles: []string{role}, Tenant: apiKeyTenants[role]})
+	}
+	return configuration
+}
+
+//startServer serves the services in memory and returns a connection to them
+func startServer(t *testing.T, configuration *config.Config, documentService servicedocuments.DocumentService, emailSender EmailSender) (*Server, *grpc.ClientConn) {
+	server, err := NewServer(configuration, documentService, emailSender, &reporatelimits.InMemoryRateLimitRepo{})
+	require.Nil(t, err)
+	listener := bufconn.Listen(1024 * 1024)
+	server.serve(listener)
+
+	connection, err := grpc.Dial("bufnet", grpc.WithInsecure(),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }))
+	require.Nil(t, err)
+	t.Cleanup(func() {
+		_ = connection.Close()
+		server.Stop()
+	})
+	return server, connection
+}
+
+//as returns the context of the calls of the client with the role, with the metadata
+func as(role string, kv ...string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), append([]string{"x-api-key", apiKeys[role]}, kv...)...)
+}
+
+func TestGet(t *testing.T) {
+	documentService := new(DocumentServiceMock)
+	_, connection := startServer(t, configuration(), documentService, new(EmailSenderMock))
+	client := documentsv1.NewDocumentServiceClient(connection)
+	documentService.On("Get", "payments", "reader-client", "toto").Return(document, nil)
+	documentService.On("Get", "payments", "reader-client", "titi").Return(models.Document{}, repodocuments.ErrNotFound)
+
+	//the call is made for the tenant of the API key by its client, the headers cannot change the actor
+	received, err := client.Get(as("reader", "x-tenant", "payments", "x-user", "mallory"), &documentsv1.GetDocumentRequest{Id: "toto"})
+	require.Nil(t, err)
+	assert.Equal(t, "nameOfToto", received.Name)
+	assert.Equal(t, map[string]string{"team": "payments"}, received.Labels)
+	assert.True(t, document.UpdatedAt.Equal(received.UpdatedAt.AsTime()))
+	assert.Nil(t, received.DeletedAt)
+
+	_, err = client.Get(as("reader"), &documentsv1.GetDocumentRequest{Id: "titi"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Equal(t, "document id titi not found", status.Convert(err).Message())
+}
+
+func TestList(t *testing.T) {
+	documentService := new(DocumentServiceMock)
+	_, connection := startServer(t, configuration(), documentService, new(EmailSenderMock))
+	client := documentsv1.NewDocumentServiceClient(connection)
+	selector, err := repodocuments.ParseLabelSelector("team=payments")
+	require.Nil(t, err)
+	query := repodocuments.DocumentQuery{
+		Limit: 3, Sort: repodocuments.DocumentSort{Field: "name", Descending: true},
+		Filter: repodocuments.DocumentFilter{Selector: selector, CreatedAfter: time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	documentService.On("List", query).Return(models.DocumentPage{Documents: []models.Document{{ID: "a"}, {ID: "b"}}, NextCursor: "next"}, nil)
+	query.Limit, query.Cursor = 1, "next"
+	documentService.On("List", query).Return(models.DocumentPage{Documents: []models.Document{{ID: "c"}}, NextCursor: "last"}, nil)
+
+	//the pages are streamed until the limit
+	stream, err := client.List(as("reader"), &documentsv1.ListDocumentsRequest{
+		Limit: 3, Sort: "-name", Selector: "team=payments", CreatedAfter: timestamppb.New(time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC)),
+	})
+	require.Nil(t, err)
+	var ids []string
+	for {
+		received, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.Nil(t, err)
+		ids = append(ids, received.Id)
+	}
+	assert.Equal(t, []string{"a", "b", "c"}, ids)
+
+	//the filters are checked as the query parameters of GET /documents
+	stream, err = client.List(as("reader"), &documentsv1.ListDocumentsRequest{Selector: "team in (payments"})
+	require.Nil(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestWrites(t *testing.T) {
+	documentService := new(DocumentServiceMock)
+	emailSender := new(EmailSenderMock)
+	_, connection := startServer(t, configuration(), documentService, emailSender)
+	client := documentsv1.NewDocumentServiceClient(connection)
+	toUpsert := models.Document{ID: "toto", Name: "nameOfToto", Labels: models.Labels{"team": "payments"}}
+	documentService.On("CreateOrUpdate", "editor-client", toUpsert, repodocuments.Precondition{MustNotExist: true}).Return(document, false, nil)
+	documentService.On("Delete", "toto", repodocuments.Precondition{IfMatch: 1}).Return(false, repodocuments.ErrPreconditionFailed)
+	documentService.On("Delete", "titi", repodocuments.Precondition{}).Return(false, nil)
+
+	//the fields managed by the server are ignored
+	response, err := client.CreateOrUpdate(as("editor"), &documentsv1.CreateOrUpdateDocumentRequest{
+		Document:     &documentsv1.Document{Id: "toto", Name: "nameOfToto", Labels: map[string]string{"team": "payments"}, Version: 7, CreatedBy: "mallory"},
+		Precondition: &documentsv1.Precondition{MustNotExist: true},
+	})
+	require.Nil(t, err)
+	assert.True(t, response.Created)
+	assert.Equal(t, int64(2), response.Document.Version)
+
+	_, err = client.Delete(as("editor"), &documentsv1.DeleteDocumentRequest{Id: "toto", Precondition: &documentsv1.Precondition{IfMatch: 1}})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	assert.Equal(t, "document id toto has been modified [err=precondition failed]", status.Convert(err).Message())
+	_, err = client.Delete(as("editor"), &documentsv1.DeleteDocumentRequest{Id: "titi"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = client.CreateOrUpdate(as("editor"), &documentsv1.CreateOrUpdateDocumentRequest{Document: &documentsv1.Document{}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Equal(t, "Validation failed [err=id must be defined]", status.Convert(err).Message())
+
+	//an email is posted as with POST /emails
+	emailSender.On("Send", emails.EmailMessage{From: "a@b.c", To: []string{"d@e.f"}, Subject: "hello", Attachments: map[string][]byte{"file.txt": []byte("content")}}).Return(nil)
+	_, err = documentsv1.NewEmailServiceClient(connection).Send(as("editor"), &documentsv1.SendEmailRequest{
+		From: "a@b.c", To: []string{"d@e.f"}, Subject: "hello", Attachments: map[string][]byte{"file.txt": []byte("content")},
+	})
+	assert.Nil(t, err)
+	documentService.AssertExpectations(t)
+	emailSender.AssertExpectations(t)
+}
+
+func TestRateLimits(t *testing.T) {
+	documentService := new(DocumentServiceMock)
+	emailSender := new(EmailSenderMock)
+	limited := configuration()
+	limited.RateLimitConfig = config.RateLimitConfig{
+		Enabled: true,
+		Default: config.RouteRateLimitConfig{RequestsPerMinute: 600, Burst: 2},
+		Routes:  []config.RouteRateLimitConfig{{Method: "POST", Path: "/emails", RequestsPerMinute: 10, Burst: 5}},
+	}
+	_, connection := startServer(t, limited, documentService, emailSender)
+	emailSender.On("Send", mock.Anything).Return(nil)
+	documentService.On("Get", "default", "editor-client", "toto").Return(document, nil)
+
+	//the emails take the tokens of POST /emails, the 6th one of the burst is refused
+	emailClient := documentsv1.NewEmailServiceClient(connection)
+	for i := 0; i < 5; i++ {
+		_, err := emailClient.Send(as("editor"), &documentsv1.SendEmailRequest{From: "a@b.c", To: []string{"d@e.f"}})
+		require.Nil(t, err)
+	}
+	_, err := emailClient.Send(as("editor"), &documentsv1.SendEmailRequest{From: "a@b.c", To: []string{"d@e.f"}})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, "Rate limit of 10 requests per minute exceeded, retry after 6 seconds", status.Convert(err).Message())
+	emailSender.AssertNumberOfCalls(t, "Send", 5)
+
+	//the documents take the tokens of the routes without their own limit
+	client := documentsv1.NewDocumentServiceClient(connection)
+	for i := 0; i < 2; i++ {
+		_, err = client.Get(as("editor"), &documentsv1.GetDocumentRequest{Id: "toto"})
+		require.Nil(t, err)
+	}
+	_, err = client.Get(as("editor"), &documentsv1.GetDocumentRequest{Id: "toto"})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	documentService.AssertNumberOfCalls(t, "Get", 2)
+}
+
+func TestStatuses(t *testing.T) {
+	for _, test := range []struct {
+		err          error
+		expectedCode codes.Code
+	}{
+		{repodocuments.ErrNotFound, codes.NotFound},
+		{repodocuments.ErrUnknownEvent, codes.OutOfRange},
+		{repodocuments.ErrRolledBack, codes.Aborted},
+		{repodocuments.ErrNoDatastore, codes.Unavailable},
+		{servicedocuments.ErrRevisionDeleted, codes.FailedPrecondition},
+		{errors.New("unexpected"), codes.Internal},
+	} {
+		assert.Equal(t, test.expectedCode, statusOf(test.err).Code(), test.err.Error())
+	}
+
+	//the invalid fields are in the details
+	answered := statusOf(&servicedocuments.ValidationError{Fields: []models.FieldError{{Field: "name", Message: "name is required"}}})
+	assert.Equal(t, codes.InvalidArgument, answered.Code())
+	require.Len(t, answered.Details(), 1)
+	badRequest := answered.Details()[0].(*errdetails.BadRequest)
+	assert.Equal(t, "name", badRequest.FieldViolations[0].Field)
+	assert.Equal(t, "name is required", badRequest.FieldViolations[0].Description)
+}
+
+func TestAuthentication(t *testing.T) {
+	documentService := new(DocumentServiceMock)
+	_, connection := startServer(t, configuration(), documentService, new(EmailSenderMock))
+	client := documentsv1.NewDocumentServiceClient(connection)
+
+	_, err := client.Get(context.Background(), &documentsv1.GetDocumentRequest{Id: "toto"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	assert.Equal(t, "Authentication failed [err=a Bearer token or an API key in the header X-API-Key is required]", status.Convert(err).Message())
+
+	//the scopes are the ones of the REST endpoints
+	_, err = client.Delete(as("reader"), &documentsv1.DeleteDocumentRequest{Id: "toto"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	assert.Equal(t, "Authorization failed [err=missing scopes documents:write]", status.Convert(err).Message())
+	documentService.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything)
+
+	//the tenant is checked as with the REST API, a client cannot use another tenant than the one of its API key
+	_, err = client.Get(as("reader", "x-tenant", "billing"), &documentsv1.GetDocumentRequest{Id: "toto"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	assert.Equal(t, "Tenant forbidden [err=the principal cannot use the tenant billing]", status.Convert(err).Message())
+	_, err = client.Get(as("editor", "x-tenant", "payments"), &documentsv1.GetDocumentRequest{Id: "toto"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	//the health is checked without credentials
+	health, err := healthpb.NewHealthClient(connection).Check(context.Background(), &healthpb.HealthCheckRequest{Service: "goapi.documents.v1.DocumentService"})
+	require.Nil(t, err)
+	assert.Equal(t, healthpb.HealthCheckResponse_SERVING, health.Status)
+}
+
+func TestUnknownMethods(t *testing.T) {
+	for _, configuration := range []*config.Config{configuration(), {GRPCConfig: config.GRPCConfig{Enabled: true}}} {
+		server, err := NewServer(configuration, new(DocumentServiceMock), new(EmailSenderMock), &reporatelimits.InMemoryRateLimitRepo{})
+		require.Nil(t, err)
+
+		//a method without scopes is refused, even without authorization
+		_, err = server.callerContext(as("editor"), "/goapi.documents.v1.DocumentService/Purge")
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+		assert.Equal(t, "Authorization failed [err=the method /goapi.documents.v1.DocumentService/Purge has no scopes]", status.Convert(err).Message())
+
+		//the health and the reflection need no scope
+		_, err = server.callerContext(context.Background(), "/grpc.health.v1.Health/Check")
+		assert.Nil(t, err)
+		_, err = server.callerContext(context.Background(), "/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo")
+		assert.Nil(t, err)
+	}
+}
+
+func TestAnonymousCalls(t *testing.T) {
+	documentService := new(DocumentServiceMock)
+	_, connection := startServer(t, &config.Config{GRPCConfig: config.GRPCConfig{Enabled: true}}, documentService, new(EmailSenderMock))
+	client := documentsv1.NewDocumentServiceClient(connection)
+	documentService.On("Get", "default", servicedocuments.AnonymousActor, "toto").Return(document, nil)
+
+	//without authentication, the metadata cannot tell the actor of the call
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-user", "alice", "x-groups", "legal")
+	_, err := client.Get(ctx, &documentsv1.GetDocumentRequest{Id: "toto"})
+	require.Nil(t, err)
+	documentService.AssertExpectations(t)
+}
+
+func TestWatchChangesStopsWithTheServer(t *testing.T) {
+	documentService := new(DocumentServiceMock)
+	server, connection := startServer(t, configuration(), documentService, new(EmailSenderMock))
+	client := documentsv1.NewDocumentServiceClient(connection)
+	changes := make(chan models.DocumentChange, 1)
+	changes <- models.DocumentChange{EventID: "1", Type: models.ChangeCreated, DocumentID: "toto", Document: &document, Timestamp: document.CreatedAt}
+	documentService.On("WatchChanges", "", "to").Return(changes, nil)
+	documentService.On("WatchChanges", "expired", "").Return(nil, repodocuments.ErrUnknownEvent)
+
+	watching, err := client.WatchChanges(as("reader"), &documentsv1.WatchChangesRequest{Prefix: "to"})
+	require.Nil(t, err)
+	change, err := watching.Recv()
+	require.Nil(t, err)
+	assert.Equal(t, "1", change.EventId)
+	assert.Equal(t, "nameOfToto", change.Document.Name)
+
+	expired, err := client.WatchChanges(as("reader"), &documentsv1.WatchChangesRequest{LastEventId: "expired"})
+	require.Nil(t, err)
+	_, err = expired.Recv()
+	assert.Equal(t, codes.OutOfRange, status.Code(err))
+
+	//the stop does not wait for the stream, and the probes are told
+	stopped := make(chan struct{})
+	go func() {
+		server.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(StopTimeout / 2):
+		t.Fatal("the server did not stop")
+	}
+	_, err = watching.Recv()
+	assert.Equal(t, io.EOF, err)
+}
@@ -0,0 +1,57 @@
+package grpcserver
+
+import (
+	"errors"
+	"goapi/resources/problems"
+	"goapi/services/servicedocuments"
+	"net/http"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+//the codes of the statuses the REST endpoints answer for the same errors
+var statusCodes = map[int]codes.Code{
+	http.StatusBadRequest:           codes.InvalidArgument,
+	http.StatusUnauthorized:         codes.Unauthenticated,
+	http.StatusForbidden:            codes.PermissionDenied,
+	http.StatusNotFound:             codes.NotFound,
+	http.StatusConflict:             codes.Aborted,
+	http.StatusGone:                 codes.OutOfRange,
+	http.StatusPreconditionFailed:   codes.FailedPrecondition,
+	http.StatusUnsupportedMediaType: codes.InvalidArgument,
+	http.StatusUnprocessableEntity:  codes.FailedPrecondition,
+	http.StatusFailedDependency:     codes.Aborted,
+	http.StatusTooManyRequests:      codes.ResourceExhausted,
+	http.StatusServiceUnavailable:   codes.Unavailable,
+}
+
+// codeOf returns the code answering the error, mapped from the status of the REST endpoints, Internal for an unexpected error
+func codeOf(err error) codes.Code {
+	if code, found := statusCodes[problems.StatusOf(err)]; found {
+		return code
+	}
+	return codes.Internal
+}
+
+// statusOf returns the status answering the error, the invalid fields of a document are in its BadRequest details
+func statusOf(err error) *status.Status {
+	if answered, isStatus := status.FromError(err); isStatus {
+		return answered
+	}
+	answer := status.New(codeOf(err), err.Error())
+
+	var invalid *servicedocuments.ValidationError
+	if !errors.As(err, &invalid) {
+		return answer
+	}
+	badRequest := &errdetails.BadRequest{}
+	for _, field := range invalid.Fields {
+		badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{Field: field.Field, Description: field.Message})
+	}
+	if withDetails, err := answer.WithDetails(badRequest); err == nil {
+		return withDetails
+	}
+	return answer
+}
@@ -0,0 +1,61 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"goapi/config"
+	"goapi/models"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+)
+
+type DocumentEventsKafkaProducer struct {
+	kafkaWriter *kafka.Writer
+}
+
+func NewDocumentEventsKafkaProducer(configuration *config.KafkaServerConfig) *DocumentEventsKafkaProducer {
+	topic := configuration.DocumentsTopic
+	if len(topic) == 0 {
+		topic = documentsTopic
+	}
+	//the events of a document are keyed by its id, so they go to the same partition and keep their order
+	kafkaWriter := &kafka.Writer{
+		Addr:         kafka.TCP(configuration.Uri),
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+	}
+	return &DocumentEventsKafkaProducer{kafkaWriter}
+}
+
+func (p *DocumentEventsKafkaProducer) Close() {
+	if err := p.kafkaWriter.Close(); err != nil {
+		logrus.Error("failed to close writer:", err)
+	}
+}
+
+// PublishEvents writes the events in order, it returns once all of them are acknowledged
+func (p *DocumentEventsKafkaProducer) PublishEvents(events []models.DocumentEvent) error {
+	messages := make([]kafka.Message, 0, len(events))
+	for _, event := range events {
+		value, err := json.Marshal(event)
+		if err != nil {
+			logrus.Error("Cannot encode struct to bytes")
+			return err
+		}
+		messages = append(messages, kafka.Message{
+			Key:     []byte(event.DocumentID),
+			Value:   value,
+			Headers: []kafka.Header{{Key: "type", Value: []byte(event.Type)}},
+		})
+	}
+
+	err := p.kafkaWriter.WriteMessages(context.Background(), messages...)
+	if err != nil {
+		logrus.Errorf("[DocumentEventsKafkaProducer]%s", err)
+		return err
+	}
+	logrus.Debugf("%d document events written", len(messages))
+	return nil
+}
@@ -1,3 +1,5 @@
 package kafka
 
 const emailTopic string = "emails"
+
+const documentsTopic string = "documents"
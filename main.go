@@ -3,13 +3,22 @@ package main
 import (
 	"bytes"
 	"context"
+	"expvar"
 	"goapi/config"
 	"goapi/database"
 	_ "goapi/docs/apis"
+	"goapi/grpcserver"
 	"goapi/kafka"
+	"goapi/repositories/repocontents"
 	"goapi/repositories/repodocuments"
+	"goapi/repositories/reporatelimits"
+	"goapi/resources/auth"
 	"goapi/resources/documents"
 	"goapi/resources/emails"
+	"goapi/resources/graphql"
+	"goapi/resources/problems"
+	"goapi/resources/ratelimit"
+	"goapi/resources/tenants"
 	"goapi/services/servicedocuments"
 	"io/ioutil"
 	"net/http"
@@ -27,22 +36,73 @@ import (
 	"gopkg.in/yaml.v2"
 )
 
-func configureRouter(configuration *config.Config) *gin.Engine {
-	router := gin.Default()
-	configCors := cors.DefaultConfig()
-	configCors.AllowOrigins = []string{"*"}
-	configCors.AllowHeaders = []string{"*"}
-	configCors.AllowCredentials = true
-	router.Use(cors.New(configCors))
+func configureRouter(configuration *config.Config, documentService servicedocuments.DocumentService, contentService servicedocuments.ContentService,
+	emailResource *emails.ResourceEmails, rateLimitRepository reporatelimits.RateLimitRepository) *gin.Engine {
+	//the access log tells the tenant of each request
+	router := gin.New()
+	router.Use(gin.LoggerWithFormatter(tenants.LogFormatter), gin.Recovery())
+	//without allowed origins, the browsers only let the pages of the API itself call it
+	if allowOrigins := configuration.CORSConfig.AllowOrigins; len(allowOrigins) > 0 {
+		configCors := cors.DefaultConfig()
+		configCors.AllowHeaders = []string{"*"}
+		configCors.AllowOrigins = allowOrigins
+		configCors.AllowCredentials = true
+		for _, origin := range allowOrigins {
+			//the browsers never send the credentials to any origin
+			if origin == "*" {
+				configCors.AllowOrigins, configCors.AllowAllOrigins, configCors.AllowCredentials = nil, true, false
+			}
+		}
+		router.Use(cors.New(configCors))
+	}
+	router.Use(problems.Handler())
+	//the requests of each IP are limited before the authentication, the ones failing to authenticate too
+	ipRateLimit, err := ratelimit.IPMiddleware(&configuration.RateLimitConfig, rateLimitRepository)
+	if err != nil {
+		log.Fatalf("Cannot configure the rate limits [err=%s]", err)
+	}
+	router.Use(ipRateLimit)
+	//the authentication comes before the tenants, the tenant can be told by a claim of the token
+	authentication, err := auth.Middleware(&configuration.AuthConfig)
+	if err != nil {
+		log.Fatalf("Cannot configure the authentication [err=%s]", err)
+	}
+	router.Use(authentication)
+	//the requests are counted by principal, so after the authentication
+	rateLimit, err := ratelimit.Middleware(&configuration.RateLimitConfig, rateLimitRepository)
+	if err != nil {
+		log.Fatalf("Cannot configure the rate limits [err=%s]", err)
+	}
+	router.Use(rateLimit)
+	router.Use(tenants.Middleware(&configuration.TenancyConfig))
 
 	//register document resource endpoints
-	documents.RegisterHandlers(router, servicedocuments.NewDocumentServiceImpl(repodocuments.CreateDocumentRepository(configuration)))
+	documents.RegisterHandlers(router, &configuration.CORSConfig, documentService)
+	documents.RegisterContentHandlers(router, contentService)
 	//register Email resource
-	emails.RegisterHandlers(router, configuration)
+	emails.RegisterHandlers(router, emailResource)
+	//register the GraphQL endpoint, over the same services and the same rate limits
+	limiter, err := ratelimit.NewLimiter(&configuration.RateLimitConfig, rateLimitRepository)
+	if err != nil {
+		log.Fatalf("Cannot configure the rate limits [err=%s]", err)
+	}
+	graphql.RegisterHandlers(router, &configuration.GraphQLConfig, documentService, emailResource, limiter)
 
 	// @title Swagger REST API Documentation
 	// @version 1.0
+	// @securityDefinitions.apikey BearerAuth
+	// @in header
+	// @name Authorization
+	// @securityDefinitions.apikey ApiKeyAuth
+	// @in header
+	// @name X-API-Key
 	router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
+	//metrics
+	router.GET("/debug/vars", auth.RequireScopes("admin:metrics"), gin.WrapH(expvar.Handler()))
+	//health, for the probes
+	router.GET("/health", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "UP"})
+	})
 	return router
 }
 
@@ -53,6 +113,11 @@ func retrieveConfig() (*config.Config, error) {
 		MONGO_SERVER_HOST     string
 		MONGO_SERVER_PORT     string
 		STORAGE_MEMORY        string
+		STORAGE_TYPE          string
+		STORAGE_PATH          string
+		SQL_DRIVER            string
+		SQL_DSN               string
+		SQL_SCHEMA_VERSION    string
 		EMAIL_CONSUMERS       string
 		KAFKA_SERVER_HOST     string
 		KAFKA_SERVER_PORT     string
@@ -61,10 +126,32 @@ func retrieveConfig() (*config.Config, error) {
 		EMAIL_SERVER_USERNAME string
 		EMAIL_SERVER_PASSWORD string
 		EMAIL_SERVER_STARTTLS string
+		DOCUMENT_EVENTS       string
+		TENANT_ISOLATION      string
+		TENANT_REQUIRED       string
+		AUTH_ENABLED          string
+		AUTH_JWT_SECRET       string
+		AUTH_JWKS_FILE        string
+		AUTH_JWT_ISSUER       string
+		AUTH_JWT_AUDIENCE     string
+		AUTHZ_ENABLED         string
+		AUTHZ_DRY_RUN         string
+		RATE_LIMIT_ENABLED    string
+		RATE_LIMIT_SHARED     string
+		GRAPHQL_ENABLED       string
+		GRAPHQL_PLAYGROUND    string
+		GRPC_ENABLED          string
+		GRPC_PORT             string
+		GRPC_REFLECTION       string
 	}{
 		MONGO_SERVER_HOST:     os.Getenv("MONGO_SERVER_HOST"),
 		MONGO_SERVER_PORT:     os.Getenv("MONGO_SERVER_PORT"),
 		STORAGE_MEMORY:        os.Getenv("STORAGE_MEMORY"),
+		STORAGE_TYPE:          os.Getenv("STORAGE_TYPE"),
+		STORAGE_PATH:          os.Getenv("STORAGE_PATH"),
+		SQL_DRIVER:            os.Getenv("SQL_DRIVER"),
+		SQL_DSN:               os.Getenv("SQL_DSN"),
+		SQL_SCHEMA_VERSION:    os.Getenv("SQL_SCHEMA_VERSION"),
 		EMAIL_CONSUMERS:       os.Getenv("EMAIL_CONSUMERS"),
 		KAFKA_SERVER_HOST:     os.Getenv("KAFKA_SERVER_HOST"),
 		KAFKA_SERVER_PORT:     os.Getenv("KAFKA_SERVER_PORT"),
@@ -73,6 +160,23 @@ func retrieveConfig() (*config.Config, error) {
 		EMAIL_SERVER_USERNAME: os.Getenv("EMAIL_SERVER_USERNAME"),
 		EMAIL_SERVER_PASSWORD: os.Getenv("EMAIL_SERVER_PASSWORD"),
 		EMAIL_SERVER_STARTTLS: os.Getenv("EMAIL_SERVER_STARTTLS"),
+		DOCUMENT_EVENTS:       os.Getenv("DOCUMENT_EVENTS"),
+		TENANT_ISOLATION:      os.Getenv("TENANT_ISOLATION"),
+		TENANT_REQUIRED:       os.Getenv("TENANT_REQUIRED"),
+		AUTH_ENABLED:          os.Getenv("AUTH_ENABLED"),
+		AUTH_JWT_SECRET:       os.Getenv("AUTH_JWT_SECRET"),
+		AUTH_JWKS_FILE:        os.Getenv("AUTH_JWKS_FILE"),
+		AUTH_JWT_ISSUER:       os.Getenv("AUTH_JWT_ISSUER"),
+		AUTH_JWT_AUDIENCE:     os.Getenv("AUTH_JWT_AUDIENCE"),
+		AUTHZ_ENABLED:         os.Getenv("AUTHZ_ENABLED"),
+		AUTHZ_DRY_RUN:         os.Getenv("AUTHZ_DRY_RUN"),
+		RATE_LIMIT_ENABLED:    os.Getenv("RATE_LIMIT_ENABLED"),
+		RATE_LIMIT_SHARED:     os.Getenv("RATE_LIMIT_SHARED"),
+		GRAPHQL_ENABLED:       os.Getenv("GRAPHQL_ENABLED"),
+		GRAPHQL_PLAYGROUND:    os.Getenv("GRAPHQL_PLAYGROUND"),
+		GRPC_ENABLED:          os.Getenv("GRPC_ENABLED"),
+		GRPC_PORT:             os.Getenv("GRPC_PORT"),
+		GRPC_REFLECTION:       os.Getenv("GRPC_REFLECTION"),
 	}
 
 	fileData, _ := ioutil.ReadFile("config.yml")
@@ -100,18 +204,62 @@ func main() {
 	if err != nil {
 		return
 	}
+	if err := database.ApplyFormerStorageSetting(configuration); err != nil {
+		log.Fatalf("Cannot configure the storage [err=%s]", err)
+	}
+	if !database.ValidStorageType(&configuration.StorageConfig) {
+		log.Fatalf("Cannot configure the storage [err=unknown type %s]", configuration.StorageConfig.Type)
+	}
 
 	//configure the router
-	router := configureRouter(configuration)
+	documentRepository := repodocuments.CreateDocumentRepository(configuration)
+	documentService, err := servicedocuments.NewDocumentServiceImplWithConfig(documentRepository, &configuration.DocumentsConfig)
+	if err != nil {
+		log.Fatalf("Cannot configure the validation of the documents [err=%s]", err)
+	}
+	contentService := servicedocuments.NewContentServiceImpl(documentRepository, repocontents.CreateContentRepository(configuration))
+	//the contents of the purged documents are deleted with them
+	documentService.RegisterPurgeObserver(contentService)
+	emailResource := emails.NewResourceEmails(configuration)
+	//the REST and the gRPC APIs share the buckets of the rate limits
+	rateLimitRepository := reporatelimits.CreateRateLimitRepository(configuration)
+	router := configureRouter(configuration, documentService, contentService, emailResource, rateLimitRepository)
+
+	//the gRPC API serves the same services, on its own port
+	var grpcServer *grpcserver.Server
+	if configuration.GRPCConfig.Enabled {
+		grpcServer, err = grpcserver.NewServer(configuration, documentService, emailResource, rateLimitRepository)
+		if err != nil {
+			log.Fatalf("Cannot configure the gRPC server [err=%s]", err)
+		}
+	}
+
+	//the events of the documents are recorded in the outbox from the first write
+	var relay *servicedocuments.OutboxRelay
+	var eventsProducer *kafka.DocumentEventsKafkaProducer
+	if configuration.DocumentsConfig.PublishEvents {
+		eventsProducer = kafka.NewDocumentEventsKafkaProducer(&configuration.KafkaConfig)
+		relay = servicedocuments.NewOutboxRelay(documentRepository, eventsProducer, &configuration.DocumentsConfig)
+	}
 
 	//start everything
 	startEveryThing(configuration)
+	documentService.StartPurge()
+	if relay != nil {
+		relay.Start()
+	}
 
 	srv := &http.Server{
 		Addr:    ":" + configuration.ServerConfig.Port,
 		Handler: router,
 	}
 
+	if grpcServer != nil {
+		if err := grpcServer.Start(); err != nil {
+			log.Fatalf("Cannot start the gRPC server [err=%s]", err)
+		}
+	}
+
 	// Initializing the server in a goroutine so that
 	// it won't block the graceful shutdown handling below
 	go func() {
@@ -127,6 +275,16 @@ func main() {
 	<-quit
 	log.Info("Shutting down server...")
 
+	//no more gRPC calls, the streams of the changes are ended
+	if grpcServer != nil {
+		grpcServer.Stop()
+	}
+
+	documentService.StopPurge()
+	if relay != nil {
+		relay.Stop()
+		eventsProducer.Close()
+	}
 	stopEveryThing(configuration)
 
 	// The context is used to inform the server it has 5 seconds to finish
@@ -140,9 +298,19 @@ func main() {
 }
 
 func startEveryThing(configuration *config.Config) {
-	//Create a connection to DB if needed
-	if !configuration.StorageInMemory {
+	//Create a connection to DB or open the storage file if needed
+	switch database.StorageTypeOf(&configuration.StorageConfig) {
+	case database.StorageMongo:
 		database.GetMongoDatabaseHandler().TryOrRetryCreateConnection(&configuration.DbConfig)
+	case database.StorageBolt:
+		if err := database.GetBoltDatabaseHandler().Open(&configuration.StorageConfig); err != nil {
+			log.Fatalf("Cannot open the storage [err=%s]", err)
+		}
+	case database.StorageSQL:
+		//the schema is migrated before the first request
+		if err := database.GetSQLDatabaseHandler().Open(&configuration.DbConfig); err != nil {
+			log.Fatalf("Cannot open the sql database [err=%s]", err)
+		}
 	}
 	//start consumers
 	kafka.GetInstanceKafkaConsumers(configuration).StartConsumers(configuration.EmailConsumers, kafka.EmailConsumer)
@@ -151,8 +319,13 @@ func startEveryThing(configuration *config.Config) {
 func stopEveryThing(configuration *config.Config) {
 	//stop consumers
 	kafka.GetInstanceKafkaConsumers(configuration).StopConsumers(configuration.EmailConsumers, kafka.EmailConsumer)
-	//stop DB client
-	if !configuration.StorageInMemory {
+	//stop DB client, or close the storage file
+	switch database.StorageTypeOf(&configuration.StorageConfig) {
+	case database.StorageMongo:
 		database.GetMongoDatabaseHandler().Close()
+	case database.StorageBolt:
+		database.GetBoltDatabaseHandler().Close()
+	case database.StorageSQL:
+		database.GetSQLDatabaseHandler().Close()
 	}
 }
@@ -1,7 +1,28 @@
 package models
 
+import "time"
+
 type Document struct {
-	ID          string `json:"id"`
-	Name        string `json:"name,omitempty"`
-	Description string `json:"description"`
+	ID          string `json:"id" xml:"id" yaml:"id"`
+	Name        string `json:"name,omitempty" xml:"name,omitempty" yaml:"name,omitempty"`
+	Description string `json:"description" xml:"description" yaml:"description"`
+	//Labels group the documents, they are selected with a label selector like team=payments,env!=prod
+	Labels Labels `json:"labels,omitempty" bson:"labels" xml:"labels,omitempty" yaml:"labels,omitempty"`
+	//Version is managed by the server, it is incremented on each write of the document
+	Version int64 `json:"version" bson:"version" xml:"version" yaml:"version"`
+	//DeletedAt is set when the document is moved to the trash, a trashed document is only listed in the trash
+	DeletedAt *time.Time `json:"deletedAt,omitempty" bson:"deletedAt,omitempty" xml:"deletedAt,omitempty" yaml:"deletedAt,omitempty"`
+	//the audit fields are managed by the server, the values sent by the clients are ignored
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt" xml:"createdAt" yaml:"createdAt"`
+	CreatedBy string    `json:"createdBy" bson:"createdBy" xml:"createdBy" yaml:"createdBy"`
+	UpdatedAt time.Time `json:"updatedAt" bson:"updatedAt" xml:"updatedAt" yaml:"updatedAt"`
+	UpdatedBy string    `json:"updatedBy" bson:"updatedBy" xml:"updatedBy" yaml:"updatedBy"`
+	//ACL is managed by the server and changed on its own endpoint, a document without ACL can be read and written by anyone
+	//and only an admin can give it an ACL
+	ACL *DocumentACL `json:"acl,omitempty" bson:"acl,omitempty" xml:"acl,omitempty" yaml:"acl,omitempty"`
+}
+
+//Trashed tells if the document is in the trash
+func (d Document) Trashed() bool {
+	return d.DeletedAt != nil
 }
@@ -0,0 +1,9 @@
+package models
+
+// DocumentACL tells who can read and write a document. The readers and the writers are principals like user:alice or group:finance,
+// a writer can also read the document. Only the owner or an admin can change the ACL.
+type DocumentACL struct {
+	Owner   string   `json:"owner" bson:"owner" xml:"owner" yaml:"owner"`
+	Readers []string `json:"readers" bson:"readers" xml:"readers>reader" yaml:"readers"`
+	Writers []string `json:"writers" bson:"writers" xml:"writers>writer" yaml:"writers"`
+}
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+const (
+	ChangeCreated = "created"
+	ChangeUpdated = "updated"
+	ChangeDeleted = "deleted"
+)
+
+// DocumentChange is an event of the change feed of the documents
+type DocumentChange struct {
+	//EventID identifies the event in the feed, the feed can be resumed after it
+	EventID    string `json:"eventId"`
+	Type       string `json:"type"`
+	DocumentID string `json:"documentId"`
+	//Document is the document after the change, absent for a deletion
+	Document  *Document `json:"document,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+	//ACL is the ACL of the document, of the deleted one for a deletion, it filters the feed of each actor and is never sent
+	ACL *DocumentACL `json:"-"`
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// ContentMetadata describes the binary content attached to a document
+type ContentMetadata struct {
+	DocumentID  string `json:"documentId" bson:"documentId" xml:"documentId" yaml:"documentId"`
+	ContentType string `json:"contentType" bson:"contentType" xml:"contentType" yaml:"contentType"`
+	Size        int64  `json:"size" bson:"size" xml:"size" yaml:"size"`
+	//SHA256 is the hex encoded checksum of the content
+	SHA256     string    `json:"sha256" bson:"sha256" xml:"sha256" yaml:"sha256"`
+	UploadedAt time.Time `json:"uploadedAt" bson:"uploadedAt" xml:"uploadedAt" yaml:"uploadedAt"`
+}
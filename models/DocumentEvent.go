@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+const (
+	EventDocumentCreated = "DocumentCreated"
+	EventDocumentUpdated = "DocumentUpdated"
+	EventDocumentDeleted = "DocumentDeleted"
+)
+
+// DocumentEvent is the domain event published for each write of a document
+type DocumentEvent struct {
+	ID         string `json:"id" bson:"-"`
+	Type       string `json:"type" bson:"type"`
+	DocumentID string `json:"documentId" bson:"documentId"`
+	//Tenant is the tenant of the document
+	Tenant string `json:"tenant" bson:"tenant"`
+	//Version is the version of the document after the write, it orders the events of a document
+	Version int64 `json:"version" bson:"version"`
+	//Document is the document after the write, absent for a deletion
+	Document   *Document `json:"document,omitempty" bson:"document,omitempty"`
+	OccurredAt time.Time `json:"occurredAt" bson:"occurredAt"`
+}
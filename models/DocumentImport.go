@@ -0,0 +1,32 @@
+package models
+
+const (
+	ImportModeUpsert = "upsert"
+	ImportModeSkip   = "skip"
+)
+
+// DocumentImport is the outcome of an import of documents
+type DocumentImport struct {
+	// DryRun tells that the documents were only validated, nothing was written
+	DryRun bool `json:"dryRun" xml:"dryRun" yaml:"dryRun"`
+	// Lines is the number of documents read, the header of a CSV file and the blank lines are not counted
+	Lines int `json:"lines" xml:"lines" yaml:"lines"`
+	// Valid is the number of documents that passed the validation
+	Valid   int `json:"valid" xml:"valid" yaml:"valid"`
+	Created int `json:"created" xml:"created" yaml:"created"`
+	Updated int `json:"updated" xml:"updated" yaml:"updated"`
+	// Skipped is the number of documents that already existed, in the skip mode
+	Skipped int `json:"skipped" xml:"skipped" yaml:"skipped"`
+	Failed  int `json:"failed" xml:"failed" yaml:"failed"`
+	// Errors are the failed lines, only the first ones are listed when there are too many
+	Errors []ImportError `json:"errors" xml:"errors>error" yaml:"errors"`
+	// Error is why the import stopped before the end of the upload, the documents counted before were written
+	Error string `json:"error,omitempty" xml:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// ImportError is a line of an import that failed
+type ImportError struct {
+	Line  int    `json:"line" xml:"line" yaml:"line"`
+	ID    string `json:"id,omitempty" xml:"id,omitempty" yaml:"id,omitempty"`
+	Error string `json:"error" xml:"error" yaml:"error"`
+}
@@ -0,0 +1,25 @@
+package models
+
+const (
+	OperationUpsert = "upsert"
+	OperationDelete = "delete"
+)
+
+// DocumentOperation is one operation of a bulk request
+type DocumentOperation struct {
+	// Op is upsert or delete
+	Op string `json:"op" xml:"op" yaml:"op"`
+	ID string `json:"id" xml:"id" yaml:"id"`
+	// Document is the content of the document to upsert
+	Document Document `json:"document" xml:"document" yaml:"document"`
+	// IfMatch is the version the document must have, as in the If-Match header
+	IfMatch int64 `json:"ifMatch,omitempty" xml:"ifMatch,omitempty" yaml:"ifMatch,omitempty"`
+}
+
+// DocumentOperationResult is the result of an operation of a bulk request
+type DocumentOperationResult struct {
+	ID       string    `json:"id" xml:"id" yaml:"id"`
+	Status   int       `json:"status" xml:"status" yaml:"status"`
+	Error    string    `json:"error,omitempty" xml:"error,omitempty" yaml:"error,omitempty"`
+	Document *Document `json:"document,omitempty" xml:"document,omitempty" yaml:"document,omitempty"`
+}
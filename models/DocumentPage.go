@@ -0,0 +1,8 @@
+package models
+
+// DocumentPage is one page of a documents listing
+type DocumentPage struct {
+	Documents  []Document `json:"documents" xml:"documents>document" yaml:"documents"`
+	NextCursor string     `json:"nextCursor,omitempty" xml:"nextCursor,omitempty" yaml:"nextCursor,omitempty"`
+	Next       string     `json:"next,omitempty" xml:"next,omitempty" yaml:"next,omitempty"`
+}
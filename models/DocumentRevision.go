@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// DocumentRevision is the immutable state of a document after one of its writes
+type DocumentRevision struct {
+	DocumentID string    `json:"documentId" bson:"documentId" xml:"documentId" yaml:"documentId"`
+	Revision   int64     `json:"revision" bson:"revision" xml:"revision" yaml:"revision"`
+	Timestamp  time.Time `json:"timestamp" bson:"timestamp" xml:"timestamp" yaml:"timestamp"`
+	// Deleted tells the write was a deletion, then there is no document
+	Deleted  bool      `json:"deleted,omitempty" bson:"deleted" xml:"deleted,omitempty" yaml:"deleted,omitempty"`
+	Document *Document `json:"document,omitempty" bson:"document,omitempty" xml:"document,omitempty" yaml:"document,omitempty"`
+}
@@ -0,0 +1,77 @@
+package models
+
+import (
+	"encoding/xml"
+	"sort"
+)
+
+// Labels are the labels of a document by key. In XML they are written like <labels><label key="team">payments</label></labels>
+type Labels map[string]string
+
+// Highlights are the snippets of a search hit by field. In XML they are written like <highlights><highlight field="name">...</highlight></highlights>
+type Highlights map[string]string
+
+func (l Labels) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return marshalEntries(e, start, l, "label", "key")
+}
+
+func (l *Labels) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	entries, err := unmarshalEntries(d, "label", "key")
+	*l = entries
+	return err
+}
+
+func (h Highlights) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	return marshalEntries(e, start, h, "highlight", "field")
+}
+
+func (h *Highlights) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	entries, err := unmarshalEntries(d, "highlight", "field")
+	*h = entries
+	return err
+}
+
+//marshalEntries writes each entry as an element with its key in an attribute, sorted by key
+func marshalEntries(e *xml.Encoder, start xml.StartElement, entries map[string]string, element string, attribute string) error {
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		entry := xml.StartElement{Name: xml.Name{Local: element}, Attr: []xml.Attr{{Name: xml.Name{Local: attribute}, Value: key}}}
+		if err := e.EncodeElement(entries[key], entry); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+//unmarshalEntries reads the entries written by marshalEntries, the other elements are ignored
+func unmarshalEntries(d *xml.Decoder, element string, attribute string) (map[string]string, error) {
+	entries := make(map[string]string)
+	for {
+		token, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			var value string
+			if err := d.DecodeElement(&value, &t); err != nil {
+				return nil, err
+			}
+			for _, attr := range t.Attr {
+				if t.Name.Local == element && attr.Name.Local == attribute {
+					entries[attr.Value] = value
+				}
+			}
+		case xml.EndElement:
+			return entries, nil
+		}
+	}
+}
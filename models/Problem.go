@@ -0,0 +1,22 @@
+package models
+
+// Problem is the body of an error response (RFC 7807)
+type Problem struct {
+	// Type is a URI reference identifying the kind of problem
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// Instance is the request that failed
+	Instance string `json:"instance,omitempty"`
+	// Errors lists the invalid fields of a document
+	Errors []FieldError `json:"errors,omitempty"`
+	// MissingScopes lists the scopes the principal needs to be authorized
+	MissingScopes []string `json:"missingScopes,omitempty"`
+}
+
+// FieldError is a field of a document breaking a validation rule
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
@@ -0,0 +1,16 @@
+package models
+
+// SearchHit is a document matching a search, with its relevance
+type SearchHit struct {
+	Document Document `json:"document" xml:"document" yaml:"document"`
+	Score    float64  `json:"score" xml:"score" yaml:"score"`
+	// Highlights are the snippets of the fields matching the search, the matching terms are in <em> tags
+	Highlights Highlights `json:"highlights,omitempty" xml:"highlights,omitempty" yaml:"highlights,omitempty"`
+}
+
+// SearchPage is one page of the hits of a search, the most relevant first
+type SearchPage struct {
+	Hits       []SearchHit `json:"hits" xml:"hits>hit" yaml:"hits"`
+	NextCursor string      `json:"nextCursor,omitempty" xml:"nextCursor,omitempty" yaml:"nextCursor,omitempty"`
+	Next       string      `json:"next,omitempty" xml:"next,omitempty" yaml:"next,omitempty"`
+}
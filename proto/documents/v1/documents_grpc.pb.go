@@ -0,0 +1,402 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             v3.5.1-go
+// source: proto/documents/v1/documents.proto
+
+package documentsv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// DocumentServiceClient is the client API for DocumentService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type DocumentServiceClient interface {
+	// Get returns the document of the id, NOT_FOUND when it does not exist
+	Get(ctx context.Context, in *GetDocumentRequest, opts ...grpc.CallOption) (*Document, error)
+	// List streams the documents matching the filters, in the order of sort
+	List(ctx context.Context, in *ListDocumentsRequest, opts ...grpc.CallOption) (DocumentService_ListClient, error)
+	// CreateOrUpdate creates or replaces the document of its id, if it meets the precondition
+	CreateOrUpdate(ctx context.Context, in *CreateOrUpdateDocumentRequest, opts ...grpc.CallOption) (*CreateOrUpdateDocumentResponse, error)
+	// Delete moves the document of the id to the trash, if it meets the precondition, NOT_FOUND when it does not exist
+	Delete(ctx context.Context, in *DeleteDocumentRequest, opts ...grpc.CallOption) (*DeleteDocumentResponse, error)
+	// WatchChanges streams the creations, updates and deletions of the documents until the client cancels it
+	WatchChanges(ctx context.Context, in *WatchChangesRequest, opts ...grpc.CallOption) (DocumentService_WatchChangesClient, error)
+}
+
+type documentServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewDocumentServiceClient(cc grpc.ClientConnInterface) DocumentServiceClient {
+	return &documentServiceClient{cc}
+}
+
+func (c *documentServiceClient) Get(ctx context.Context, in *GetDocumentRequest, opts ...grpc.CallOption) (*Document, error) {
+	out := new(Document)
+	err := c.cc.Invoke(ctx, "/goapi.documents.v1.DocumentService/Get", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *documentServiceClient) List(ctx context.Context, in *ListDocumentsRequest, opts ...grpc.CallOption) (DocumentService_ListClient, error) {
+	stream, err := c.cc.NewStream(ctx, &DocumentService_ServiceDesc.Streams[0], "/goapi.documents.v1.DocumentService/List", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &documentServiceListClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type DocumentService_ListClient interface {
+	Recv() (*Document, error)
+	grpc.ClientStream
+}
+
+type documentServiceListClient struct {
+	grpc.ClientStream
+}
+
+func (x *documentServiceListClient) Recv() (*Document, error) {
+	m := new(Document)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *documentServiceClient) CreateOrUpdate(ctx context.Context, in *CreateOrUpdateDocumentRequest, opts ...grpc.CallOption) (*CreateOrUpdateDocumentResponse, error) {
+	out := new(CreateOrUpdateDocumentResponse)
+	err := c.cc.Invoke(ctx, "/goapi.documents.v1.DocumentService/CreateOrUpdate", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *documentServiceClient) Delete(ctx context.Context, in *DeleteDocumentRequest, opts ...grpc.CallOption) (*DeleteDocumentResponse, error) {
+	out := new(DeleteDocumentResponse)
+	err := c.cc.Invoke(ctx, "/goapi.documents.v1.DocumentService/Delete", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *documentServiceClient) WatchChanges(ctx context.Context, in *WatchChangesRequest, opts ...grpc.CallOption) (DocumentService_WatchChangesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &DocumentService_ServiceDesc.Streams[1], "/goapi.documents.v1.DocumentService/WatchChanges", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &documentServiceWatchChangesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type DocumentService_WatchChangesClient interface {
+	Recv() (*DocumentChange, error)
+	grpc.ClientStream
+}
+
+type documentServiceWatchChangesClient struct {
+	grpc.ClientStream
+}
+
+func (x *documentServiceWatchChangesClient) Recv() (*DocumentChange, error) {
+	m := new(DocumentChange)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// DocumentServiceServer is the server API for DocumentService service.
+// All implementations must embed UnimplementedDocumentServiceServer
+// for forward compatibility
+type DocumentServiceServer interface {
+	// Get returns the document of the id, NOT_FOUND when it does not exist
+	Get(context.Context, *GetDocumentRequest) (*Document, error)
+	// List streams the documents matching the filters, in the order of sort
+	List(*ListDocumentsRequest, DocumentService_ListServer) error
+	// CreateOrUpdate creates or replaces the document of its id, if it meets the precondition
+	CreateOrUpdate(context.Context, *CreateOrUpdateDocumentRequest) (*CreateOrUpdateDocumentResponse, error)
+	// Delete moves the document of the id to the trash, if it meets the precondition, NOT_FOUND when it does not exist
+	Delete(context.Context, *DeleteDocumentRequest) (*DeleteDocumentResponse, error)
+	// WatchChanges streams the creations, updates and deletions of the documents until the client cancels it
+	WatchChanges(*WatchChangesRequest, DocumentService_WatchChangesServer) error
+	mustEmbedUnimplementedDocumentServiceServer()
+}
+
+// UnimplementedDocumentServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedDocumentServiceServer struct {
+}
+
+func (UnimplementedDocumentServiceServer) Get(context.Context, *GetDocumentRequest) (*Document, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Get not implemented")
+}
+func (UnimplementedDocumentServiceServer) List(*ListDocumentsRequest, DocumentService_ListServer) error {
+	return status.Errorf(codes.Unimplemented, "method List not implemented")
+}
+func (UnimplementedDocumentServiceServer) CreateOrUpdate(context.Context, *CreateOrUpdateDocumentRequest) (*CreateOrUpdateDocumentResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CreateOrUpdate not implemented")
+}
+func (UnimplementedDocumentServiceServer) Delete(context.Context, *DeleteDocumentRequest) (*DeleteDocumentResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Delete not implemented")
+}
+func (UnimplementedDocumentServiceServer) WatchChanges(*WatchChangesRequest, DocumentService_WatchChangesServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchChanges not implemented")
+}
+func (UnimplementedDocumentServiceServer) mustEmbedUnimplementedDocumentServiceServer() {}
+
+// UnsafeDocumentServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to DocumentServiceServer will
+// result in compilation errors.
+type UnsafeDocumentServiceServer interface {
+	mustEmbedUnimplementedDocumentServiceServer()
+}
+
+func RegisterDocumentServiceServer(s grpc.ServiceRegistrar, srv DocumentServiceServer) {
+	s.RegisterService(&DocumentService_ServiceDesc, srv)
+}
+
+func _DocumentService_Get_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetDocumentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DocumentServiceServer).Get(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/goapi.documents.v1.DocumentService/Get",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DocumentServiceServer).Get(ctx, req.(*GetDocumentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DocumentService_List_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListDocumentsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DocumentServiceServer).List(m, &documentServiceListServer{stream})
+}
+
+type DocumentService_ListServer interface {
+	Send(*Document) error
+	grpc.ServerStream
+}
+
+type documentServiceListServer struct {
+	grpc.ServerStream
+}
+
+func (x *documentServiceListServer) Send(m *Document) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _DocumentService_CreateOrUpdate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CreateOrUpdateDocumentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DocumentServiceServer).CreateOrUpdate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/goapi.documents.v1.DocumentService/CreateOrUpdate",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DocumentServiceServer).CreateOrUpdate(ctx, req.(*CreateOrUpdateDocumentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DocumentService_Delete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteDocumentRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DocumentServiceServer).Delete(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/goapi.documents.v1.DocumentService/Delete",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DocumentServiceServer).Delete(ctx, req.(*DeleteDocumentRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DocumentService_WatchChanges_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchChangesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(DocumentServiceServer).WatchChanges(m, &documentServiceWatchChangesServer{stream})
+}
+
+type DocumentService_WatchChangesServer interface {
+	Send(*DocumentChange) error
+	grpc.ServerStream
+}
+
+type documentServiceWatchChangesServer struct {
+	grpc.ServerStream
+}
+
+func (x *documentServiceWatchChangesServer) Send(m *DocumentChange) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// DocumentService_ServiceDesc is the grpc.ServiceDesc for DocumentService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var DocumentService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "goapi.documents.v1.DocumentService",
+	HandlerType: (*DocumentServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Get",
+			Handler:    _DocumentService_Get_Handler,
+		},
+		{
+			MethodName: "CreateOrUpdate",
+			Handler:    _DocumentService_CreateOrUpdate_Handler,
+		},
+		{
+			MethodName: "Delete",
+			Handler:    _DocumentService_Delete_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "List",
+			Handler:       _DocumentService_List_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "WatchChanges",
+			Handler:       _DocumentService_WatchChanges_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "proto/documents/v1/documents.proto",
+}
+
+// EmailServiceClient is the client API for EmailService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type EmailServiceClient interface {
+	// Send posts an email to send, as POST /emails
+	Send(ctx context.Context, in *SendEmailRequest, opts ...grpc.CallOption) (*SendEmailResponse, error)
+}
+
+type emailServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewEmailServiceClient(cc grpc.ClientConnInterface) EmailServiceClient {
+	return &emailServiceClient{cc}
+}
+
+func (c *emailServiceClient) Send(ctx context.Context, in *SendEmailRequest, opts ...grpc.CallOption) (*SendEmailResponse, error) {
+	out := new(SendEmailResponse)
+	err := c.cc.Invoke(ctx, "/goapi.documents.v1.EmailService/Send", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// EmailServiceServer is the server API for EmailService service.
+// All implementations must embed UnimplementedEmailServiceServer
+// for forward compatibility
+type EmailServiceServer interface {
+	// Send posts an email to send, as POST /emails
+	Send(context.Context, *SendEmailRequest) (*SendEmailResponse, error)
+	mustEmbedUnimplementedEmailServiceServer()
+}
+
+// UnimplementedEmailServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedEmailServiceServer struct {
+}
+
+func (UnimplementedEmailServiceServer) Send(context.Context, *SendEmailRequest) (*SendEmailResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Send not implemented")
+}
+func (UnimplementedEmailServiceServer) mustEmbedUnimplementedEmailServiceServer() {}
+
+// UnsafeEmailServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to EmailServiceServer will
+// result in compilation errors.
+type UnsafeEmailServiceServer interface {
+	mustEmbedUnimplementedEmailServiceServer()
+}
+
+func RegisterEmailServiceServer(s grpc.ServiceRegistrar, srv EmailServiceServer) {
+	s.RegisterService(&EmailService_ServiceDesc, srv)
+}
+
+func _EmailService_Send_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SendEmailRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EmailServiceServer).Send(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/goapi.documents.v1.EmailService/Send",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EmailServiceServer).Send(ctx, req.(*SendEmailRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// EmailService_ServiceDesc is the grpc.ServiceDesc for EmailService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var EmailService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "goapi.documents.v1.EmailService",
+	HandlerType: (*EmailServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Send",
+			Handler:    _EmailService_Send_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "proto/documents/v1/documents.proto",
+}
@@ -0,0 +1,145 @@
+package repocontents
+
+import (
+	"bytes"
+	"encoding/json"
+	"goapi/database"
+	"goapi/models"
+	"goapi/repositories/repodocuments"
+	"io"
+	"io/ioutil"
+
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+//the keys of the bucket of a content, the content of a document has its own bucket in the bucket of its tenant
+var (
+	boltMetadataKey = []byte("metadata")
+	boltDataKey     = []byte("data")
+)
+
+// boltContentRepo keeps the contents in the bolt file of the documents, a content is read in memory to be served
+type boltContentRepo struct {
+	handler *database.BoltDataBaseHandler
+	tenant  string
+}
+
+func NewBoltContentRepo(databaseHandler *database.BoltDataBaseHandler) *boltContentRepo {
+	return &boltContentRepo{handler: databaseHandler}
+}
+
+func (r *boltContentRepo) ForTenant(tenant string) ContentRepository {
+	if len(tenant) == 0 || tenant == repodocuments.DefaultTenant {
+		return &boltContentRepo{handler: r.handler}
+	}
+	return &boltContentRepo{handler: r.handler, tenant: tenant}
+}
+
+//tenantBucket is the name of the bucket of the contents of the tenant
+func (r *boltContentRepo) tenantBucket() []byte {
+	if len(r.tenant) == 0 {
+		return []byte(repodocuments.DefaultTenant)
+	}
+	return []byte(r.tenant)
+}
+
+//logger logs with the tenant of the repository
+func (r *boltContentRepo) logger() *log.Entry {
+	return log.WithField("tenant", string(r.tenantBucket()))
+}
+
+func (r *boltContentRepo) Put(documentID string, contentType string, content io.Reader) (models.ContentMetadata, error) {
+	db := r.handler.GetDB()
+	if db == nil {
+		r.logger().Error("data store not available")
+		return models.ContentMetadata{}, repodocuments.ErrNoDatastore
+	}
+
+	digest := newDigestReader(content)
+	data, err := ioutil.ReadAll(digest)
+	if err != nil {
+		return models.ContentMetadata{}, err
+	}
+	metadata := digest.metadata(documentID, contentType)
+	value, err := json.Marshal(metadata)
+	if err != nil {
+		return models.ContentMetadata{}, err
+	}
+
+	//the metadata and the data are replaced in the same transaction
+	err = db.Update(func(tx *bolt.Tx) error {
+		contents, err := tx.Bucket([]byte(database.DocumentContentBucketName)).CreateBucketIfNotExists(r.tenantBucket())
+		if err != nil {
+			return err
+		}
+		stored, err := contents.CreateBucketIfNotExists([]byte(documentID))
+		if err != nil {
+			return err
+		}
+		if err := stored.Put(boltMetadataKey, value); err != nil {
+			return err
+		}
+		return stored.Put(boltDataKey, data)
+	})
+	if err != nil {
+		r.logger().Error(err)
+		return models.ContentMetadata{}, err
+	}
+	return metadata, nil
+}
+
+func (r *boltContentRepo) Open(documentID string) (models.ContentMetadata, io.ReadSeekCloser, bool, error) {
+	db := r.handler.GetDB()
+	if db == nil {
+		r.logger().Error("data store not available")
+		return models.ContentMetadata{}, nil, false, repodocuments.ErrNoDatastore
+	}
+
+	var metadata models.ContentMetadata
+	var data []byte
+	err := db.View(func(tx *bolt.Tx) error {
+		contents := tx.Bucket([]byte(database.DocumentContentBucketName)).Bucket(r.tenantBucket())
+		if contents == nil || contents.Bucket([]byte(documentID)) == nil {
+			return nil
+		}
+		stored := contents.Bucket([]byte(documentID))
+		if err := json.Unmarshal(stored.Get(boltMetadataKey), &metadata); err != nil {
+			return err
+		}
+		//the data is only valid in the transaction
+		data = append([]byte{}, stored.Get(boltDataKey)...)
+		return nil
+	})
+	if err != nil {
+		r.logger().Error(err)
+		return models.ContentMetadata{}, nil, false, err
+	}
+	if data == nil {
+		return models.ContentMetadata{}, nil, false, nil
+	}
+	return metadata, bytesContent{bytes.NewReader(data)}, true, nil
+}
+
+func (r *boltContentRepo) Delete(documentID string) (bool, error) {
+	db := r.handler.GetDB()
+	if db == nil {
+		r.logger().Error("data store not available")
+		return false, repodocuments.ErrNoDatastore
+	}
+
+	found := false
+	err := db.Update(func(tx *bolt.Tx) error {
+		contents := tx.Bucket([]byte(database.DocumentContentBucketName)).Bucket(r.tenantBucket())
+		if contents == nil || contents.Bucket([]byte(documentID)) == nil {
+			return nil
+		}
+		found = true
+		return contents.DeleteBucket([]byte(documentID))
+	})
+	if err != nil {
+		r.logger().Error(err)
+		return false, err
+	}
+	return found, nil
+}
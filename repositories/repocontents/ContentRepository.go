@@ -0,0 +1,49 @@
+package repocontents
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"goapi/models"
+	"hash"
+	"io"
+	"time"
+)
+
+type ContentRepository interface {
+	// ForTenant returns the repository of the contents of the documents of the tenant, the repository itself is the one of the default tenant
+	ForTenant(tenant string) ContentRepository
+	// Put stores the content of a document, the previous content is replaced once the new one is fully written
+	Put(documentID string, contentType string, content io.Reader) (models.ContentMetadata, error)
+	// Open returns the metadata and a reader on the content of a document, found is false if the document has no content
+	Open(documentID string) (models.ContentMetadata, io.ReadSeekCloser, bool, error)
+	Delete(documentID string) (bool, error)
+}
+
+//digestReader computes the size and the checksum of a content while it is read
+type digestReader struct {
+	reader io.Reader
+	hash   hash.Hash
+	size   int64
+}
+
+func newDigestReader(reader io.Reader) *digestReader {
+	return &digestReader{reader: reader, hash: sha256.New()}
+}
+
+func (r *digestReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.hash.Write(p[:n])
+	r.size += int64(n)
+	return n, err
+}
+
+//metadata returns the metadata of the content once fully read
+func (r *digestReader) metadata(documentID string, contentType string) models.ContentMetadata {
+	return models.ContentMetadata{
+		DocumentID:  documentID,
+		ContentType: contentType,
+		Size:        r.size,
+		SHA256:      hex.EncodeToString(r.hash.Sum(nil)),
+		UploadedAt:  time.Now().UTC(),
+	}
+}
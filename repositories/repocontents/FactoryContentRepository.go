@@ -0,0 +1,19 @@
+package repocontents
+
+import (
+	"goapi/config"
+	"goapi/database"
+)
+
+func CreateContentRepository(config *config.Config) ContentRepository {
+	switch database.StorageTypeOf(&config.StorageConfig) {
+	case database.StorageMongo:
+		return NewMongoDbContentRepo(database.GetMongoDatabaseHandler())
+	case database.StorageBolt:
+		return NewBoltContentRepo(database.GetBoltDatabaseHandler())
+	case database.StorageSQL:
+		return NewSQLContentRepo(database.GetSQLDatabaseHandler())
+	default:
+		return &InMemoryContentRepo{}
+	}
+}
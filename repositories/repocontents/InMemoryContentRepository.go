@@ -0,0 +1,93 @@
+package repocontents
+
+import (
+	"bytes"
+	"goapi/models"
+	"goapi/repositories/repodocuments"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+type InMemoryContentRepo struct {
+	contentsById map[string]storedContent
+	lock         sync.RWMutex
+
+	//the repository of another tenant has its own contents, and is kept by the repository of the default tenant
+	tenants     map[string]*InMemoryContentRepo
+	root        *InMemoryContentRepo
+	tenantsLock sync.Mutex
+}
+
+func (r *InMemoryContentRepo) ForTenant(tenant string) ContentRepository {
+	root := r
+	if r.root != nil {
+		root = r.root
+	}
+	if len(tenant) == 0 || tenant == repodocuments.DefaultTenant {
+		return root
+	}
+
+	root.tenantsLock.Lock()
+	defer root.tenantsLock.Unlock()
+	if root.tenants == nil {
+		root.tenants = make(map[string]*InMemoryContentRepo)
+	}
+	repo, found := root.tenants[tenant]
+	if !found {
+		repo = &InMemoryContentRepo{root: root}
+		root.tenants[tenant] = repo
+	}
+	return repo
+}
+
+type storedContent struct {
+	metadata models.ContentMetadata
+	data     []byte
+}
+
+//bytesContent reads a content kept in memory, the stored bytes are never modified so there is nothing to close
+type bytesContent struct {
+	*bytes.Reader
+}
+
+func (c bytesContent) Close() error {
+	return nil
+}
+
+func (r *InMemoryContentRepo) Put(documentID string, contentType string, content io.Reader) (models.ContentMetadata, error) {
+	digest := newDigestReader(content)
+	data, err := ioutil.ReadAll(digest)
+	if err != nil {
+		return models.ContentMetadata{}, err
+	}
+	metadata := digest.metadata(documentID, contentType)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.contentsById == nil {
+		r.contentsById = make(map[string]storedContent)
+	}
+	r.contentsById[documentID] = storedContent{metadata: metadata, data: data}
+	return metadata, nil
+}
+
+func (r *InMemoryContentRepo) Open(documentID string) (models.ContentMetadata, io.ReadSeekCloser, bool, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	stored, found := r.contentsById[documentID]
+	if !found {
+		return models.ContentMetadata{}, nil, false, nil
+	}
+	return stored.metadata, bytesContent{bytes.NewReader(stored.data)}, true, nil
+}
+
+func (r *InMemoryContentRepo) Delete(documentID string) (bool, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	_, found := r.contentsById[documentID]
+	delete(r.contentsById, documentID)
+	return found, nil
+}
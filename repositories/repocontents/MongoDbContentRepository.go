@@ -0,0 +1,284 @@
+package repocontents
+
+import (
+	"context"
+	"errors"
+	"goapi/database"
+	"goapi/models"
+	"goapi/repositories/repodocuments"
+	"io"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type mongoDbContentRepo struct {
+	store *database.MongoDatastore
+
+	//the repository of another tenant uses the datastore of the repository of the default tenant
+	tenant string
+	root   *mongoDbContentRepo
+}
+
+//interface ObserverDatabase implementation
+func (r *mongoDbContentRepo) SetDataStore(dataStore *database.MongoDatastore) {
+	r.store = dataStore
+}
+
+func NewMongoDbContentRepo(databaseHandler *database.MongoDataBaseHandler) *mongoDbContentRepo {
+	repo := &mongoDbContentRepo{}
+	repo.store = databaseHandler.GetDataStore()
+	if repo.store == nil {
+		databaseHandler.RegisterAsObserver(repo)
+	}
+	return repo
+}
+
+func (r *mongoDbContentRepo) ForTenant(tenant string) ContentRepository {
+	root := r
+	if r.root != nil {
+		root = r.root
+	}
+	if len(tenant) == 0 || tenant == repodocuments.DefaultTenant {
+		return root
+	}
+	return &mongoDbContentRepo{tenant: tenant, root: root}
+}
+
+//datastore is the datastore of the repository of the default tenant, nil until the database is available
+func (r *mongoDbContentRepo) datastore() *database.MongoDatastore {
+	if r.root != nil {
+		return r.root.store
+	}
+	return r.store
+}
+
+//logger logs with the tenant of the repository
+func (r *mongoDbContentRepo) logger() *log.Entry {
+	if len(r.tenant) == 0 {
+		return log.WithField("tenant", repodocuments.DefaultTenant)
+	}
+	return log.WithField("tenant", r.tenant)
+}
+
+//tenantField tells if the bucket is shared by the tenants, then the files have their tenant in their metadata
+func (r *mongoDbContentRepo) tenantField() bool {
+	return r.datastore().TenantIsolation != database.TenantIsolationCollection
+}
+
+//tenantValue is the tenant of the files of the shared bucket, the files of the default tenant have none
+func (r *mongoDbContentRepo) tenantValue() interface{} {
+	if len(r.tenant) == 0 || !r.tenantField() {
+		return nil
+	}
+	return r.tenant
+}
+
+//scoped adds the tenant to the filter of the files when the bucket is shared by the tenants
+func (r *mongoDbContentRepo) scoped(filter bson.M) bson.M {
+	if r.tenantField() {
+		filter["metadata.tenant"] = r.tenantValue()
+	}
+	return filter
+}
+
+//the metadata of a gridfs file, with its tenant when the bucket is shared by the tenants
+type gridfsMetadata struct {
+	models.ContentMetadata `bson:",inline"`
+	Tenant                 interface{} `bson:"tenant,omitempty"`
+}
+
+//the gridfs file of a content, its name is the document id
+type gridfsFile struct {
+	ID       primitive.ObjectID     `bson:"_id"`
+	Metadata models.ContentMetadata `bson:"metadata"`
+}
+
+//bucket is the bucket of the contents of the tenant, each tenant has its own bucket when it has its own collections
+func (r *mongoDbContentRepo) bucket() (*gridfs.Bucket, error) {
+	store := r.datastore()
+	if store == nil {
+		r.logger().Error("data store not available")
+		return nil, repodocuments.ErrNoDatastore
+	}
+	name := database.DocumentContentBucketName
+	if len(r.tenant) > 0 && store.TenantIsolation == database.TenantIsolationCollection {
+		name = "tenant_" + r.tenant + "_" + name
+	}
+	return gridfs.NewBucket(store.Database, options.GridFSBucket().SetName(name))
+}
+
+func (r *mongoDbContentRepo) Put(documentID string, contentType string, content io.Reader) (models.ContentMetadata, error) {
+	bucket, err := r.bucket()
+	if err != nil {
+		return models.ContentMetadata{}, err
+	}
+
+	//the content is streamed to gridfs, its size and checksum are computed on the way
+	digest := newDigestReader(content)
+	//the tenant is set from the start, so that an upload in progress is never seen by another tenant
+	uploadOptions := options.GridFSUpload()
+	if tenant := r.tenantValue(); tenant != nil {
+		uploadOptions.SetMetadata(bson.M{"tenant": tenant})
+	}
+	fileID, err := bucket.UploadFromStream(documentID, digest, uploadOptions)
+	if err != nil {
+		r.logger().Error(err)
+		return models.ContentMetadata{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	//the checksum is only known once uploaded, a file without metadata is an upload in progress and is never read
+	metadata := digest.metadata(documentID, contentType)
+	_, err = bucket.GetFilesCollection().UpdateOne(ctx, bson.M{"_id": fileID}, bson.M{"$set": bson.M{"metadata": gridfsMetadata{ContentMetadata: metadata, Tenant: r.tenantValue()}}})
+	if err != nil {
+		r.logger().Error(err)
+		if err := bucket.Delete(fileID); err != nil {
+			r.logger().Error("Cannot delete the uploaded content", err)
+		}
+		return models.ContentMetadata{}, err
+	}
+
+	//drop the previous contents, the ones uploaded concurrently after this one are kept
+	if _, err := r.deleteFiles(ctx, bucket, r.scoped(bson.M{"filename": documentID, "_id": bson.M{"$lt": fileID}})); err != nil {
+		r.logger().Error("Cannot delete the previous contents", err)
+	}
+	return metadata, nil
+}
+
+func (r *mongoDbContentRepo) Open(documentID string) (models.ContentMetadata, io.ReadSeekCloser, bool, error) {
+	bucket, err := r.bucket()
+	if err != nil {
+		return models.ContentMetadata{}, nil, false, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	//the last complete upload
+	var file gridfsFile
+	filter := r.scoped(bson.M{"filename": documentID, "metadata.sha256": bson.M{"$exists": true}})
+	findOptions := options.FindOne().SetSort(bson.D{primitive.E{Key: "_id", Value: -1}})
+	err = bucket.GetFilesCollection().FindOne(ctx, filter, findOptions).Decode(&file)
+	if err == mongo.ErrNoDocuments {
+		return models.ContentMetadata{}, nil, false, nil
+	}
+	if err != nil {
+		r.logger().Error(err)
+		return models.ContentMetadata{}, nil, false, err
+	}
+	return file.Metadata, &gridfsReadSeeker{bucket: bucket, fileID: file.ID, size: file.Metadata.Size}, true, nil
+}
+
+func (r *mongoDbContentRepo) Delete(documentID string) (bool, error) {
+	bucket, err := r.bucket()
+	if err != nil {
+		return false, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	deleted, err := r.deleteFiles(ctx, bucket, r.scoped(bson.M{"filename": documentID}))
+	return deleted > 0, err
+}
+
+//deleteFiles deletes the gridfs files matching the filter with their chunks, and returns how many were deleted
+func (r *mongoDbContentRepo) deleteFiles(ctx context.Context, bucket *gridfs.Bucket, filter bson.M) (int, error) {
+	cur, err := bucket.GetFilesCollection().Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		r.logger().Error(err)
+		return 0, err
+	}
+	var files []gridfsFile
+	if err := cur.All(ctx, &files); err != nil {
+		r.logger().Error(err)
+		return 0, err
+	}
+
+	deleted := 0
+	for _, file := range files {
+		err := bucket.Delete(file.ID)
+		if err == gridfs.ErrFileNotFound {
+			continue
+		}
+		if err != nil {
+			r.logger().Error(err)
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+//gridfsReadSeeker reads a gridfs file from any position.
+//A download stream can only go forward, so it is opened again to go back.
+type gridfsReadSeeker struct {
+	bucket         *gridfs.Bucket
+	fileID         interface{}
+	size           int64
+	stream         *gridfs.DownloadStream
+	streamPosition int64
+	position       int64
+}
+
+func (s *gridfsReadSeeker) Read(p []byte) (int, error) {
+	if s.position >= s.size {
+		return 0, io.EOF
+	}
+	if s.stream == nil || s.streamPosition > s.position {
+		if err := s.Close(); err != nil {
+			return 0, err
+		}
+		stream, err := s.bucket.OpenDownloadStream(s.fileID)
+		if err != nil {
+			return 0, err
+		}
+		s.stream = stream
+		s.streamPosition = 0
+	}
+	if s.streamPosition < s.position {
+		skipped, err := s.stream.Skip(s.position - s.streamPosition)
+		s.streamPosition += skipped
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := s.stream.Read(p)
+	s.streamPosition += int64(n)
+	s.position += int64(n)
+	return n, err
+}
+
+func (s *gridfsReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	position := offset
+	switch whence {
+	case io.SeekCurrent:
+		position += s.position
+	case io.SeekEnd:
+		position += s.size
+	}
+	if position < 0 {
+		return 0, errors.New("negative position")
+	}
+	//the stream is moved on the next read
+	s.position = position
+	return position, nil
+}
+
+func (s *gridfsReadSeeker) Close() error {
+	if s.stream == nil {
+		return nil
+	}
+	err := s.stream.Close()
+	s.stream = nil
+	return err
+}
@@ -0,0 +1,115 @@
+package repocontents
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"goapi/database"
+	"goapi/models"
+	"goapi/repositories/repodocuments"
+	"io"
+	"io/ioutil"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// sqlContentRepo keeps the contents in the sql database of the documents, a content is read in memory to be served
+type sqlContentRepo struct {
+	handler *database.SQLDataBaseHandler
+	tenant  string
+}
+
+func NewSQLContentRepo(databaseHandler *database.SQLDataBaseHandler) *sqlContentRepo {
+	return &sqlContentRepo{handler: databaseHandler}
+}
+
+func (r *sqlContentRepo) ForTenant(tenant string) ContentRepository {
+	if len(tenant) == 0 || tenant == repodocuments.DefaultTenant {
+		return &sqlContentRepo{handler: r.handler}
+	}
+	return &sqlContentRepo{handler: r.handler, tenant: tenant}
+}
+
+//tenantName is the value of the tenant column of the contents of the tenant
+func (r *sqlContentRepo) tenantName() string {
+	if len(r.tenant) == 0 {
+		return repodocuments.DefaultTenant
+	}
+	return r.tenant
+}
+
+//logger logs with the tenant of the repository
+func (r *sqlContentRepo) logger() *log.Entry {
+	return log.WithField("tenant", r.tenantName())
+}
+
+func (r *sqlContentRepo) Put(documentID string, contentType string, content io.Reader) (models.ContentMetadata, error) {
+	db := r.handler.GetDB()
+	if db == nil {
+		r.logger().Error("data store not available")
+		return models.ContentMetadata{}, repodocuments.ErrNoDatastore
+	}
+
+	digest := newDigestReader(content)
+	data, err := ioutil.ReadAll(digest)
+	if err != nil {
+		return models.ContentMetadata{}, err
+	}
+	metadata := digest.metadata(documentID, contentType)
+	value, err := json.Marshal(metadata)
+	if err != nil {
+		return models.ContentMetadata{}, err
+	}
+
+	//the metadata and the data are replaced in the same statement
+	_, err = db.Exec(`INSERT INTO document_contents (tenant, document_id, metadata, data) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (tenant, document_id) DO UPDATE SET metadata = excluded.metadata, data = excluded.data`,
+		r.tenantName(), documentID, string(value), data)
+	if err != nil {
+		r.logger().Error(err)
+		return models.ContentMetadata{}, err
+	}
+	return metadata, nil
+}
+
+func (r *sqlContentRepo) Open(documentID string) (models.ContentMetadata, io.ReadSeekCloser, bool, error) {
+	db := r.handler.GetDB()
+	if db == nil {
+		r.logger().Error("data store not available")
+		return models.ContentMetadata{}, nil, false, repodocuments.ErrNoDatastore
+	}
+
+	var value string
+	var data []byte
+	err := db.QueryRow("SELECT metadata, data FROM document_contents WHERE tenant = $1 AND document_id = $2",
+		r.tenantName(), documentID).Scan(&value, &data)
+	if err == sql.ErrNoRows {
+		return models.ContentMetadata{}, nil, false, nil
+	}
+	if err != nil {
+		r.logger().Error(err)
+		return models.ContentMetadata{}, nil, false, err
+	}
+	var metadata models.ContentMetadata
+	if err := json.Unmarshal([]byte(value), &metadata); err != nil {
+		r.logger().Error(err)
+		return models.ContentMetadata{}, nil, false, err
+	}
+	return metadata, bytesContent{bytes.NewReader(data)}, true, nil
+}
+
+func (r *sqlContentRepo) Delete(documentID string) (bool, error) {
+	db := r.handler.GetDB()
+	if db == nil {
+		r.logger().Error("data store not available")
+		return false, repodocuments.ErrNoDatastore
+	}
+
+	result, err := db.Exec("DELETE FROM document_contents WHERE tenant = $1 AND document_id = $2", r.tenantName(), documentID)
+	if err != nil {
+		r.logger().Error(err)
+		return false, err
+	}
+	removed, _ := result.RowsAffected()
+	return removed > 0, nil
+}
@@ -0,0 +1,79 @@
+package repodocuments
+
+import (
+	"goapi/models"
+	"sort"
+	"strings"
+)
+
+// The prefixes of the principals the documents are shared with
+const (
+	UserPrincipalPrefix  = "user:"
+	GroupPrincipalPrefix = "group:"
+)
+
+// UserPrincipal returns the principal of a user
+func UserPrincipal(user string) string {
+	return UserPrincipalPrefix + user
+}
+
+// GroupPrincipal returns the principal of a group
+func GroupPrincipal(group string) string {
+	return GroupPrincipalPrefix + group
+}
+
+// ValidPrincipal tells if the value is a user or a group principal, like user:alice or group:finance
+func ValidPrincipal(value string) bool {
+	for _, prefix := range []string{UserPrincipalPrefix, GroupPrincipalPrefix} {
+		if strings.HasPrefix(value, prefix) {
+			name := strings.TrimPrefix(value, prefix)
+			return len(name) > 0 && len(name) <= 256 && strings.TrimSpace(name) == name
+		}
+	}
+	return false
+}
+
+//contains tells if one of the principals is in the list
+func contains(list []string, principals []string) bool {
+	for _, principal := range principals {
+		for _, entry := range list {
+			if entry == principal {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// IsOwner tells if one of the principals owns a document with the ACL, nobody owns a document without ACL
+func IsOwner(acl *models.DocumentACL, principals []string) bool {
+	return acl != nil && contains([]string{UserPrincipal(acl.Owner)}, principals)
+}
+
+// CanWrite tells if one of the principals can write a document with the ACL, anyone can write a document without ACL
+func CanWrite(acl *models.DocumentACL, principals []string) bool {
+	return acl == nil || IsOwner(acl, principals) || contains(acl.Writers, principals)
+}
+
+// CanRead tells if one of the principals can read a document with the ACL
+func CanRead(acl *models.DocumentACL, principals []string) bool {
+	return CanWrite(acl, principals) || contains(acl.Readers, principals)
+}
+
+//aclReaders returns the sorted principals that can read a document with the ACL, nil when anyone can.
+//They are what the listings are filtered on.
+func aclReaders(acl *models.DocumentACL) []string {
+	if acl == nil {
+		return nil
+	}
+	unique := map[string]bool{UserPrincipal(acl.Owner): true}
+	for _, principal := range append(append([]string{}, acl.Readers...), acl.Writers...) {
+		unique[principal] = true
+	}
+	readers := make([]string, 0, len(unique))
+	for principal := range unique {
+		readers = append(readers, principal)
+	}
+	sort.Strings(readers)
+	return readers
+}
@@ -0,0 +1,32 @@
+package repodocuments
+
+import (
+	"goapi/models"
+)
+
+var ErrRolledBack = NewError(ErrConflict, "rolled back because another operation failed")
+
+// Operation is one write of a batch: the upsert of the document, or the deletion of the document id
+type Operation struct {
+	Delete       bool
+	Document     models.Document
+	Precondition Precondition
+}
+
+// OperationResult is the outcome of an operation of a batch
+type OperationResult struct {
+	// Document is the document stored by an upsert, with its new version
+	Document models.Document
+	// Existed tells if the document existed before the operation
+	Existed bool
+	Err     error
+}
+
+//rollBack marks all the operations as rolled back, except the failed one
+func rollBack(results []OperationResult, failed int) {
+	for i := range results {
+		if i != failed {
+			results[i] = OperationResult{Err: ErrRolledBack}
+		}
+	}
+}
@@ -0,0 +1,915 @@
+package repodocuments
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"goapi/database"
+	"goapi/models"
+	"sort"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+//exportBatchSize is how many documents an export reads per transaction, so that a slow export does not hold a transaction
+const exportBatchSize = DefaultPageLimit
+
+// boltDocumentRepo keeps the documents in a bolt file. Each tenant has its own bucket of documents, keyed by id so that
+// they are read sorted by id, and its own bucket of revisions, with a bucket per document keyed by revision number.
+// Bolt serializes the writes and reads from consistent snapshots, so the repository is safe for concurrent use.
+type boltDocumentRepo struct {
+	handler *database.BoltDataBaseHandler
+	//once enabled, each write records its event in the outbox in its transaction
+	outboxEnabled bool
+	//the file is opened by a single process, so the lease of its relay is kept in memory
+	relayLease localLease
+
+	//the repository of another tenant uses the file and the outbox of the repository of the default tenant
+	tenant string
+	root   *boltDocumentRepo
+}
+
+func NewBoltDocumentRepo(databaseHandler *database.BoltDataBaseHandler) *boltDocumentRepo {
+	return &boltDocumentRepo{handler: databaseHandler}
+}
+
+//rootRepo is the repository of the default tenant, that keeps the state of the outbox
+func (r *boltDocumentRepo) rootRepo() *boltDocumentRepo {
+	if r.root != nil {
+		return r.root
+	}
+	return r
+}
+
+func (r *boltDocumentRepo) ForTenant(tenant string) DocumentRepository {
+	root := r.rootRepo()
+	if tenantOrDefault(tenant) == DefaultTenant {
+		return root
+	}
+	return &boltDocumentRepo{handler: root.handler, tenant: tenant, root: root}
+}
+
+//logger logs with the tenant of the repository
+func (r *boltDocumentRepo) logger() *log.Entry {
+	return log.WithField("tenant", tenantOrDefault(r.tenant))
+}
+
+//read runs f in a read transaction, the bucket is nil when the tenant has no bucket yet
+func (r *boltDocumentRepo) read(bucketName string, f func(bucket *bolt.Bucket) error) error {
+	db := r.handler.GetDB()
+	if db == nil {
+		r.logger().Error("data store not available")
+		return ErrNoDatastore
+	}
+	err := db.View(func(tx *bolt.Tx) error {
+		return f(tx.Bucket([]byte(bucketName)).Bucket([]byte(tenantOrDefault(r.tenant))))
+	})
+	if err != nil {
+		r.logger().Error(err)
+	}
+	return err
+}
+
+//write runs f in a write transaction on the documents of the tenant, the transaction is rolled back when f or a write fails
+func (r *boltDocumentRepo) write(f func(view *boltView) error) error {
+	db := r.handler.GetDB()
+	if db == nil {
+		r.logger().Error("data store not available")
+		return ErrNoDatastore
+	}
+	return db.Update(func(tx *bolt.Tx) error {
+		documents, err := tx.Bucket([]byte(database.DocumentCollectionName)).CreateBucketIfNotExists([]byte(tenantOrDefault(r.tenant)))
+		if err != nil {
+			return err
+		}
+		view := &boltView{repo: r, tx: tx, documents: documents}
+		if err := f(view); err != nil {
+			return err
+		}
+		return view.err
+	})
+}
+
+//decodeDocument decodes a stored document, the value is only valid in its transaction so it is copied
+func decodeDocument(value []byte) (models.Document, error) {
+	var document models.Document
+	err := json.Unmarshal(value, &document)
+	return document, err
+}
+
+//forEachDocument calls f on the stored documents sorted by id, trashed or not, and stops at the first error
+func forEachDocument(bucket *bolt.Bucket, f func(document models.Document) error) error {
+	if bucket == nil {
+		return nil
+	}
+	return bucket.ForEach(func(_, value []byte) error {
+		document, err := decodeDocument(value)
+		if err != nil {
+			return err
+		}
+		return f(document)
+	})
+}
+
+//seekDocuments calls f on the stored documents after the cursor, sorted by id in descending order when asked,
+//until f returns false. The cursor of the bucket is moved to the cursor of the listing instead of reading the bucket from its start.
+func seekDocuments(bucket *bolt.Bucket, cursor *documentCursor, descending bool, f func(document models.Document) bool) error {
+	if bucket == nil {
+		return nil
+	}
+	c := bucket.Cursor()
+	var key, value []byte
+	next := c.Next
+	switch {
+	case descending:
+		next = c.Prev
+		if cursor == nil {
+			key, value = c.Last()
+		} else if key, value = c.Seek([]byte(cursor.ID)); key == nil {
+			//all the ids are before the cursor
+			key, value = c.Last()
+		} else {
+			key, value = c.Prev()
+		}
+	case cursor == nil:
+		key, value = c.First()
+	default:
+		key, value = c.Seek([]byte(cursor.ID))
+		if bytes.Equal(key, []byte(cursor.ID)) {
+			key, value = c.Next()
+		}
+	}
+	for ; key != nil; key, value = next() {
+		document, err := decodeDocument(value)
+		if err != nil {
+			return err
+		}
+		if !f(document) {
+			return nil
+		}
+	}
+	return nil
+}
+
+func (r *boltDocumentRepo) Tenants() ([]string, error) {
+	db := r.handler.GetDB()
+	if db == nil {
+		r.logger().Error("data store not available")
+		return nil, ErrNoDatastore
+	}
+
+	var tenants []string
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(database.DocumentCollectionName)).ForEach(func(name, _ []byte) error {
+			tenants = append(tenants, string(name))
+			return nil
+		})
+	})
+	if err != nil {
+		r.logger().Error(err)
+		return nil, err
+	}
+	return sortedTenants(tenants), nil
+}
+
+func (r *boltDocumentRepo) GetById(id string) (models.Document, error) {
+	var document models.Document
+	found := false
+	err := r.read(database.DocumentCollectionName, func(bucket *bolt.Bucket) error {
+		if bucket == nil {
+			return nil
+		}
+		value := bucket.Get([]byte(id))
+		if value == nil {
+			return nil
+		}
+		var err error
+		document, err = decodeDocument(value)
+		found = err == nil && !document.Trashed()
+		return err
+	})
+	if err != nil {
+		return models.Document{}, err
+	}
+	if !found {
+		return models.Document{}, ErrNotFound
+	}
+	return document, nil
+}
+
+// GetAll reads the documents in the order of the keys of the bucket, which is the order of the ids
+func (r *boltDocumentRepo) GetAll(filter DocumentFilter) ([]models.Document, error) {
+	values := make([]models.Document, 0)
+	err := r.read(database.DocumentCollectionName, func(bucket *bolt.Bucket) error {
+		return forEachDocument(bucket, func(document models.Document) error {
+			if !document.Trashed() && filter.matches(document) {
+				values = append(values, document)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// Export reads the documents by batches sorted by id, f is called between the transactions
+func (r *boltDocumentRepo) Export(filter DocumentFilter, f func(document models.Document) error) error {
+	var from []byte
+	for {
+		batch := make([]models.Document, 0, exportBatchSize)
+		//last is the key of the last document read when the batch is full, nil once all the documents are read
+		var last []byte
+		err := r.read(database.DocumentCollectionName, func(bucket *bolt.Bucket) error {
+			if bucket == nil {
+				return nil
+			}
+			cursor := bucket.Cursor()
+			key, value := cursor.First()
+			if from != nil {
+				key, value = cursor.Seek(from)
+				if bytes.Equal(key, from) {
+					key, value = cursor.Next()
+				}
+			}
+			for ; key != nil; key, value = cursor.Next() {
+				document, err := decodeDocument(value)
+				if err != nil {
+					return err
+				}
+				if !document.Trashed() && filter.matches(document) {
+					batch = append(batch, document)
+				}
+				if len(batch) == exportBatchSize {
+					last = append([]byte(nil), key...)
+					return nil
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, document := range batch {
+			if err := f(document); err != nil {
+				return err
+			}
+		}
+		if last == nil {
+			return nil
+		}
+		from = last
+	}
+}
+
+func (r *boltDocumentRepo) List(query DocumentQuery) (models.DocumentPage, error) {
+	cursor, err := decodeCursor(query)
+	if err != nil {
+		return models.DocumentPage{}, err
+	}
+
+	//keep only the documents after the cursor, from the trash or not
+	values := make([]models.Document, 0)
+	keep := func(document models.Document) bool {
+		return document.Trashed() == query.Trashed && query.Filter.matches(document)
+	}
+	if query.Sort.field() == "id" {
+		//the keys are the ids, the documents are read from the cursor in the order of the listing until the page is full
+		err = r.read(database.DocumentCollectionName, func(bucket *bolt.Bucket) error {
+			return seekDocuments(bucket, cursor, query.Sort.Descending, func(document models.Document) bool {
+				if keep(document) {
+					values = append(values, document)
+				}
+				//one more document than the limit tells there is a next page
+				return len(values) <= query.limit()
+			})
+		})
+		if err != nil {
+			return models.DocumentPage{}, err
+		}
+		return newPage(query, values), nil
+	}
+
+	err = r.read(database.DocumentCollectionName, func(bucket *bolt.Bucket) error {
+		return forEachDocument(bucket, func(document models.Document) error {
+			if keep(document) && (cursor == nil || cursor.after(query.Sort, document)) {
+				values = append(values, document)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return models.DocumentPage{}, err
+	}
+	sort.Slice(values, func(i, j int) bool {
+		return query.Sort.before(values[i], values[j])
+	})
+
+	//one more document than the limit tells there is a next page
+	if len(values) > query.limit()+1 {
+		values = values[:query.limit()+1]
+	}
+	return newPage(query, values), nil
+}
+
+// Search reads the terms index of the tenant, that is updated in the transaction of each write
+func (r *boltDocumentRepo) Search(query SearchQuery) (models.SearchPage, error) {
+	after, err := decodeSearchCursor(query)
+	if err != nil {
+		return models.SearchPage{}, err
+	}
+	db := r.handler.GetDB()
+	if db == nil {
+		r.logger().Error("data store not available")
+		return models.SearchPage{}, ErrNoDatastore
+	}
+	if err := r.indexTerms(db); err != nil {
+		r.logger().Error(err)
+		return models.SearchPage{}, err
+	}
+
+	//keep only the hits after the cursor
+	hits := make([]models.SearchHit, 0)
+	err = db.View(func(tx *bolt.Tx) error {
+		documents := tx.Bucket([]byte(database.DocumentCollectionName)).Bucket([]byte(tenantOrDefault(r.tenant)))
+		index := tx.Bucket([]byte(database.DocumentTermsBucketName)).Bucket([]byte(tenantOrDefault(r.tenant)))
+		if documents == nil || index == nil {
+			return nil
+		}
+		for id, score := range termScores(index, SearchTerms(query.Text)) {
+			value := documents.Get([]byte(id))
+			if value == nil {
+				continue
+			}
+			document, err := decodeDocument(value)
+			if err != nil {
+				return err
+			}
+			if query.ReadableBy != nil && !CanRead(document.ACL, query.ReadableBy) {
+				continue
+			}
+			hit := models.SearchHit{Document: document, Score: score}
+			if after == nil || hitBefore(*after, hit) {
+				hits = append(hits, hit)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		r.logger().Error(err)
+		return models.SearchPage{}, err
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		return hitBefore(hits[i], hits[j])
+	})
+
+	//one more hit than the limit tells there is a next page
+	if len(hits) > query.limit()+1 {
+		hits = hits[:query.limit()+1]
+	}
+	return newSearchPage(query, hits), nil
+}
+
+//indexTerms builds the terms index of the tenant from its documents when the tenant has none,
+//the files written before the index was kept are indexed by their first search
+func (r *boltDocumentRepo) indexTerms(db *bolt.DB) error {
+	missing := false
+	err := db.View(func(tx *bolt.Tx) error {
+		tenant := []byte(tenantOrDefault(r.tenant))
+		missing = tx.Bucket([]byte(database.DocumentCollectionName)).Bucket(tenant) != nil &&
+			tx.Bucket([]byte(database.DocumentTermsBucketName)).Bucket(tenant) == nil
+		return nil
+	})
+	if err != nil || !missing {
+		return err
+	}
+	return r.write(func(view *boltView) error {
+		view.termsIndex()
+		return nil
+	})
+}
+
+func (r *boltDocumentRepo) CreateOrUpdate(documentToCreate models.Document, precondition Precondition) (models.Document, bool, error) {
+	var document models.Document
+	var found bool
+	err := r.write(func(view *boltView) error {
+		var err error
+		document, found, err = createOrUpdateIn(view, documentToCreate, precondition)
+		return err
+	})
+	if err != nil {
+		return models.Document{}, found, err
+	}
+	return document, found, nil
+}
+
+func (r *boltDocumentRepo) SetACL(id string, acl models.DocumentACL, precondition Precondition) (models.Document, error) {
+	var storedDocument models.Document
+	err := r.write(func(view *boltView) error {
+		var found bool
+		storedDocument, found = view.load(id)
+		found = found && !storedDocument.Trashed()
+		if err := precondition.check(storedDocument, found); err != nil {
+			return err
+		}
+		if !found {
+			return ErrNotFound
+		}
+		storedDocument.ACL = &acl
+		storedDocument.Version++
+		view.store(storedDocument)
+		view.record(newDocumentEvent(storedDocument, true))
+		return nil
+	})
+	if err != nil {
+		return models.Document{}, err
+	}
+	return storedDocument, nil
+}
+
+func (r *boltDocumentRepo) Delete(idToDelete string, precondition Precondition) (bool, error) {
+	var found bool
+	err := r.write(func(view *boltView) error {
+		var err error
+		found, err = deleteIn(view, idToDelete, precondition)
+		return err
+	})
+	return found, err
+}
+
+func (r *boltDocumentRepo) Restore(id string) (models.Document, error) {
+	var storedDocument models.Document
+	err := r.write(func(view *boltView) error {
+		var found bool
+		storedDocument, found = view.load(id)
+		if !found || !storedDocument.Trashed() {
+			return ErrNotFound
+		}
+		storedDocument.DeletedAt = nil
+		storedDocument.Version++
+		view.store(storedDocument)
+		view.record(newDocumentEvent(storedDocument, false))
+		return nil
+	})
+	if err != nil {
+		return models.Document{}, err
+	}
+	return storedDocument, nil
+}
+
+func (r *boltDocumentRepo) Purge(deletedBefore time.Time) ([]string, error) {
+	purged := make([]string, 0)
+	err := r.write(func(view *boltView) error {
+		//the keys cannot be deleted while the bucket is iterated
+		err := forEachDocument(view.documents, func(document models.Document) error {
+			if document.Trashed() && document.DeletedAt.Before(deletedBefore) {
+				purged = append(purged, document.ID)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, id := range purged {
+			view.remove(id)
+		}
+		//the revisions of the purged documents can't be restored anymore
+		tenantRevisions := view.tx.Bucket([]byte(database.DocumentRevisionCollectionName)).Bucket([]byte(tenantOrDefault(r.tenant)))
+		if tenantRevisions == nil {
+			return nil
+		}
+		for _, id := range purged {
+			if err := tenantRevisions.DeleteBucket([]byte(id)); err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		r.logger().Error(err)
+		return nil, err
+	}
+	return purged, nil
+}
+
+func (r *boltDocumentRepo) ApplyBatch(operations []Operation, atomic bool) ([]OperationResult, error) {
+	results := make([]OperationResult, len(operations))
+	if !atomic {
+		for i, operation := range operations {
+			err := r.write(func(view *boltView) error {
+				results[i] = applyIn(view, operation)
+				return results[i].Err
+			})
+			if results[i].Err == nil && err != nil {
+				results[i] = OperationResult{Err: err}
+			}
+		}
+		return results, nil
+	}
+
+	//the whole batch is one transaction, on staged changes that are only written if all operations succeed
+	err := r.write(func(view *boltView) error {
+		staged := newStagedView(view)
+		for i, operation := range operations {
+			results[i] = applyIn(staged, operation)
+			if results[i].Err != nil {
+				rollBack(results, i)
+				return nil
+			}
+		}
+		staged.commit()
+		return nil
+	})
+	if err != nil {
+		r.logger().Error(err)
+		return nil, err
+	}
+	return results, nil
+}
+
+func (r *boltDocumentRepo) EnableOutbox() {
+	r.rootRepo().outboxEnabled = true
+}
+
+//eventKey is the key of an event of the outbox, the sequence of the bucket so that the events are in the order of the writes
+func eventKey(sequence uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, sequence)
+	return key
+}
+
+func (r *boltDocumentRepo) PendingEvents(limit int) ([]models.DocumentEvent, error) {
+	db := r.handler.GetDB()
+	if db == nil {
+		r.logger().Error("data store not available")
+		return nil, ErrNoDatastore
+	}
+
+	events := make([]models.DocumentEvent, 0)
+	err := db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket([]byte(database.DocumentOutboxCollectionName)).Cursor()
+		for key, value := cursor.First(); key != nil && len(events) < limit; key, value = cursor.Next() {
+			var event models.DocumentEvent
+			if err := json.Unmarshal(value, &event); err != nil {
+				return err
+			}
+			event.ID = strconv.FormatUint(binary.BigEndian.Uint64(key), 10)
+			events = append(events, event)
+		}
+		return nil
+	})
+	if err != nil {
+		r.logger().Error(err)
+		return nil, err
+	}
+	return events, nil
+}
+
+func (r *boltDocumentRepo) RemoveEvents(ids []string) error {
+	db := r.handler.GetDB()
+	if db == nil {
+		r.logger().Error("data store not available")
+		return ErrNoDatastore
+	}
+
+	keys := make([][]byte, 0, len(ids))
+	for _, id := range ids {
+		sequence, err := strconv.ParseUint(id, 10, 64)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, eventKey(sequence))
+	}
+	err := db.Update(func(tx *bolt.Tx) error {
+		outbox := tx.Bucket([]byte(database.DocumentOutboxCollectionName))
+		for _, key := range keys {
+			if err := outbox.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		r.logger().Error(err)
+	}
+	return err
+}
+
+func (r *boltDocumentRepo) AcquireRelayLease(holder string, duration time.Duration) (bool, error) {
+	return r.rootRepo().relayLease.acquire(holder, duration), nil
+}
+
+func (r *boltDocumentRepo) ReleaseRelayLease(holder string) error {
+	r.rootRepo().relayLease.release(holder)
+	return nil
+}
+
+//recordEvent writes the event in the outbox, in the transaction of the write
+func (r *boltDocumentRepo) recordEvent(tx *bolt.Tx, event models.DocumentEvent) error {
+	if !r.rootRepo().outboxEnabled {
+		return nil
+	}
+	outbox := tx.Bucket([]byte(database.DocumentOutboxCollectionName))
+	sequence, err := outbox.NextSequence()
+	if err != nil {
+		return err
+	}
+	event.Tenant = tenantOrDefault(r.tenant)
+	event.ID = strconv.FormatUint(sequence, 10)
+	value, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return outbox.Put(eventKey(sequence), value)
+}
+
+//revisionKey is the key of a revision in the bucket of its document, big endian so that the revisions are sorted by number
+func revisionKey(revision int64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(revision))
+	return key
+}
+
+func (r *boltDocumentRepo) AddRevision(revision models.DocumentRevision, maxRevisions int) (models.DocumentRevision, error) {
+	db := r.handler.GetDB()
+	if db == nil {
+		r.logger().Error("data store not available")
+		return models.DocumentRevision{}, ErrNoDatastore
+	}
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		tenantRevisions, err := tx.Bucket([]byte(database.DocumentRevisionCollectionName)).CreateBucketIfNotExists([]byte(tenantOrDefault(r.tenant)))
+		if err != nil {
+			return err
+		}
+		revisions, err := tenantRevisions.CreateBucketIfNotExists([]byte(revision.DocumentID))
+		if err != nil {
+			return err
+		}
+
+		revision.Revision = 1
+		if last, _ := revisions.Cursor().Last(); last != nil {
+			revision.Revision = int64(binary.BigEndian.Uint64(last)) + 1
+		}
+		value, err := json.Marshal(revision)
+		if err != nil {
+			return err
+		}
+		if err := revisions.Put(revisionKey(revision.Revision), value); err != nil {
+			return err
+		}
+
+		//drop the oldest revisions, the keys cannot be deleted while the bucket is iterated
+		if maxRevisions <= 0 {
+			return nil
+		}
+		var dropped [][]byte
+		cursor := revisions.Cursor()
+		for key, _ := cursor.First(); key != nil && int64(binary.BigEndian.Uint64(key)) <= revision.Revision-int64(maxRevisions); key, _ = cursor.Next() {
+			dropped = append(dropped, append([]byte(nil), key...))
+		}
+		for _, key := range dropped {
+			if err := revisions.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		r.logger().Error(err)
+		return models.DocumentRevision{}, err
+	}
+	return revision, nil
+}
+
+func (r *boltDocumentRepo) GetRevisions(id string) ([]models.DocumentRevision, error) {
+	results := make([]models.DocumentRevision, 0)
+	err := r.read(database.DocumentRevisionCollectionName, func(tenantRevisions *bolt.Bucket) error {
+		if tenantRevisions == nil || tenantRevisions.Bucket([]byte(id)) == nil {
+			return nil
+		}
+		return tenantRevisions.Bucket([]byte(id)).ForEach(func(_, value []byte) error {
+			var revision models.DocumentRevision
+			if err := json.Unmarshal(value, &revision); err != nil {
+				return err
+			}
+			results = append(results, revision)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (r *boltDocumentRepo) GetRevision(id string, revision int64) (models.DocumentRevision, bool, error) {
+	var stored models.DocumentRevision
+	found := false
+	err := r.read(database.DocumentRevisionCollectionName, func(tenantRevisions *bolt.Bucket) error {
+		if tenantRevisions == nil || tenantRevisions.Bucket([]byte(id)) == nil {
+			return nil
+		}
+		value := tenantRevisions.Bucket([]byte(id)).Get(revisionKey(revision))
+		if value == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(value, &stored)
+	})
+	if err != nil {
+		return models.DocumentRevision{}, false, err
+	}
+	return stored, found, nil
+}
+
+//boltView reads and stores the documents of the tenant in a write transaction.
+//The views have no error to return, so the first error is kept and fails the transaction.
+type boltView struct {
+	repo      *boltDocumentRepo
+	tx        *bolt.Tx
+	documents *bolt.Bucket
+	//index is the terms index of the tenant, read on the first write of a document
+	index *bolt.Bucket
+	err   error
+}
+
+func (v *boltView) fail(err error) {
+	if v.err == nil && err != nil {
+		v.err = err
+	}
+}
+
+func (v *boltView) load(id string) (models.Document, bool) {
+	value := v.documents.Get([]byte(id))
+	if value == nil {
+		return models.Document{}, false
+	}
+	document, err := decodeDocument(value)
+	if err != nil {
+		v.fail(err)
+		return models.Document{}, false
+	}
+	return document, true
+}
+
+func (v *boltView) store(document models.Document) {
+	index := v.termsIndex()
+	previous, found := v.load(document.ID)
+	value, err := json.Marshal(document)
+	if err != nil {
+		v.fail(err)
+		return
+	}
+	v.fail(v.documents.Put([]byte(document.ID), value))
+
+	//trashed documents are not searched
+	if index == nil {
+		return
+	}
+	if found && !previous.Trashed() {
+		v.fail(removeTerms(index, previous))
+	}
+	if !document.Trashed() {
+		v.fail(addTerms(index, document))
+	}
+}
+
+func (v *boltView) remove(id string) {
+	index := v.termsIndex()
+	previous, found := v.load(id)
+	v.fail(v.documents.Delete([]byte(id)))
+	if index != nil && found && !previous.Trashed() {
+		v.fail(removeTerms(index, previous))
+	}
+}
+
+//termsIndex returns the terms index of the tenant, built from the stored documents when the tenant has none yet.
+//It must be read before the documents are written, so that a new index does not index a write twice.
+func (v *boltView) termsIndex() *bolt.Bucket {
+	if v.index != nil {
+		return v.index
+	}
+	root := v.tx.Bucket([]byte(database.DocumentTermsBucketName))
+	tenant := []byte(tenantOrDefault(v.repo.tenant))
+	if index := root.Bucket(tenant); index != nil {
+		v.index = index
+		return index
+	}
+	index, err := root.CreateBucket(tenant)
+	if err != nil {
+		v.fail(err)
+		return nil
+	}
+	v.fail(forEachDocument(v.documents, func(document models.Document) error {
+		if document.Trashed() {
+			return nil
+		}
+		return addTerms(index, document)
+	}))
+	v.index = index
+	return index
+}
+
+func (v *boltView) record(event models.DocumentEvent) {
+	v.fail(v.repo.recordEvent(v.tx, event))
+}
+
+func (v *boltView) logger() *log.Entry {
+	return v.repo.logger()
+}
+
+//the terms index of a tenant keeps the number of the indexed documents, and a bucket per term
+//with the weighted frequency of the term in each document
+var (
+	indexedCountKey = []byte("count")
+	termsKey        = []byte("terms")
+)
+
+func encodeCount(count int) []byte {
+	return revisionKey(int64(count))
+}
+
+func decodeCount(value []byte) int {
+	if value == nil {
+		return 0
+	}
+	return int(binary.BigEndian.Uint64(value))
+}
+
+//addTerms indexes the terms of a document, that must not be indexed yet
+func addTerms(index *bolt.Bucket, document models.Document) error {
+	terms, err := index.CreateBucketIfNotExists(termsKey)
+	if err != nil {
+		return err
+	}
+	for term, frequency := range documentTerms(document) {
+		documents, err := terms.CreateBucketIfNotExists([]byte(term))
+		if err != nil {
+			return err
+		}
+		if err := documents.Put([]byte(document.ID), encodeCount(frequency)); err != nil {
+			return err
+		}
+	}
+	return index.Put(indexedCountKey, encodeCount(decodeCount(index.Get(indexedCountKey))+1))
+}
+
+//removeTerms removes the terms of a document as it was indexed, the buckets of the terms left without documents are dropped
+func removeTerms(index *bolt.Bucket, document models.Document) error {
+	terms := index.Bucket(termsKey)
+	if terms == nil {
+		return nil
+	}
+	for term := range documentTerms(document) {
+		documents := terms.Bucket([]byte(term))
+		if documents == nil {
+			continue
+		}
+		if err := documents.Delete([]byte(document.ID)); err != nil {
+			return err
+		}
+		if key, _ := documents.Cursor().First(); key == nil {
+			if err := terms.DeleteBucket([]byte(term)); err != nil {
+				return err
+			}
+		}
+	}
+	count := decodeCount(index.Get(indexedCountKey))
+	if count > 0 {
+		count--
+	}
+	return index.Put(indexedCountKey, encodeCount(count))
+}
+
+//termScores returns the score of each document having at least one of the terms, the way termsIndex.scores does
+func termScores(index *bolt.Bucket, terms []string) map[string]float64 {
+	scores := make(map[string]float64)
+	buckets := index.Bucket(termsKey)
+	if buckets == nil {
+		return scores
+	}
+	indexed := decodeCount(index.Get(indexedCountKey))
+	seen := make(map[string]bool)
+	for _, term := range terms {
+		documents := buckets.Bucket([]byte(term))
+		if seen[term] || documents == nil {
+			continue
+		}
+		seen[term] = true
+		frequencies := make(map[string]int)
+		_ = documents.ForEach(func(id, frequency []byte) error {
+			frequencies[string(id)] = decodeCount(frequency)
+			return nil
+		})
+		rarity := termRarity(indexed, len(frequencies))
+		for id, frequency := range frequencies {
+			scores[id] += float64(frequency) * rarity
+		}
+	}
+	return scores
+}
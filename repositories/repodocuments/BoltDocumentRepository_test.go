@@ -0,0 +1,306 @@
+package repodocuments
+
+import (
+	"goapi/config"
+	"goapi/database"
+	"goapi/models"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//newBoltRepo opens a bolt file in a temporary directory removed after the test
+func newBoltRepo(t *testing.T) *boltDocumentRepo {
+	handler := &database.BoltDataBaseHandler{}
+	err := handler.Open(&config.StorageConfig{Path: filepath.Join(t.TempDir(), "goapi.db")})
+	assert.Nil(t, err)
+	t.Cleanup(handler.Close)
+	return NewBoltDocumentRepo(handler)
+}
+
+func TestBoltDocumentRepo_CRUD(t *testing.T) {
+	repo := newBoltRepo(t)
+
+	created, found, err := repo.CreateOrUpdate(models.Document{ID: "toto", Name: "first"}, Precondition{})
+	assert.Nil(t, err)
+	assert.False(t, found)
+	assert.Equal(t, int64(1), created.Version)
+
+	updated, found, err := repo.CreateOrUpdate(models.Document{ID: "toto", Name: "second"}, Precondition{})
+	assert.Nil(t, err)
+	assert.True(t, found)
+	assert.Equal(t, int64(2), updated.Version)
+
+	stored, err := repo.GetById("toto")
+	assert.Nil(t, err)
+	assert.Equal(t, "second", stored.Name)
+	_, err = repo.GetById("titi")
+	assert.Equal(t, ErrNotFound, err)
+
+	//a deleted document is in the trash until it is restored
+	deleted, err := repo.Delete("toto", Precondition{})
+	assert.Nil(t, err)
+	assert.True(t, deleted)
+	_, err = repo.GetById("toto")
+	assert.Equal(t, ErrNotFound, err)
+	all, err := repo.GetAll(DocumentFilter{})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(all))
+	trash, err := repo.List(DocumentQuery{Trashed: true})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(trash.Documents))
+
+	restored, err := repo.Restore("toto")
+	assert.Nil(t, err)
+	assert.Nil(t, restored.DeletedAt)
+	_, err = repo.Restore("toto")
+	assert.Equal(t, ErrNotFound, err)
+	stored, err = repo.GetById("toto")
+	assert.Nil(t, err)
+	assert.Equal(t, "second", stored.Name)
+}
+
+func TestBoltDocumentRepo_Tenants(t *testing.T) {
+	repo := newBoltRepo(t)
+	acme := repo.ForTenant("acme")
+
+	_, _, _ = repo.CreateOrUpdate(models.Document{ID: "toto", Name: "default"}, Precondition{})
+	_, _, _ = acme.CreateOrUpdate(models.Document{ID: "toto", Name: "acme"}, Precondition{})
+
+	//each tenant only sees its own documents
+	stored, err := repo.GetById("toto")
+	assert.Nil(t, err)
+	assert.Equal(t, "default", stored.Name)
+	stored, err = acme.GetById("toto")
+	assert.Nil(t, err)
+	assert.Equal(t, "acme", stored.Name)
+	_, err = repo.ForTenant("other").GetById("toto")
+	assert.Equal(t, ErrNotFound, err)
+	assert.Equal(t, repo, repo.ForTenant(DefaultTenant))
+
+	//reading a tenant does not create it
+	tenants, err := repo.Tenants()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"acme", DefaultTenant}, tenants)
+}
+
+func TestBoltDocumentRepo_List(t *testing.T) {
+	repo := newBoltRepo(t)
+	for _, id := range []string{"c", "a", "e", "b", "d"} {
+		_, _, _ = repo.CreateOrUpdate(models.Document{ID: id, Name: "name " + id}, Precondition{})
+	}
+	_, _ = repo.Delete("d", Precondition{})
+
+	//the pages follow each other until the last one, in both orders
+	for _, test := range []struct {
+		sort     DocumentSort
+		expected []string
+	}{
+		{DocumentSort{Field: "id"}, []string{"a", "b", "c", "e"}},
+		{DocumentSort{Field: "id", Descending: true}, []string{"e", "c", "b", "a"}},
+		{DocumentSort{Field: "name", Descending: true}, []string{"e", "c", "b", "a"}},
+	} {
+		ids := make([]string, 0)
+		query := DocumentQuery{Limit: 2, Sort: test.sort}
+		for {
+			page, err := repo.List(query)
+			assert.Nil(t, err)
+			assert.LessOrEqual(t, len(page.Documents), 2)
+			for _, document := range page.Documents {
+				ids = append(ids, document.ID)
+			}
+			if len(page.NextCursor) == 0 {
+				break
+			}
+			query.Cursor = page.NextCursor
+		}
+		assert.Equal(t, test.expected, ids, test.sort.String())
+	}
+
+	_, err := repo.List(DocumentQuery{Cursor: "invalid"})
+	assert.Equal(t, ErrInvalidCursor, err)
+}
+
+func TestBoltDocumentRepo_Filters(t *testing.T) {
+	repo := newBoltRepo(t)
+	alice := []string{UserPrincipal("alice")}
+	_, _, _ = repo.CreateOrUpdate(models.Document{ID: "toto", Labels: map[string]string{"team": "finance"},
+		ACL: &models.DocumentACL{Owner: "alice"}}, Precondition{})
+	_, _, _ = repo.CreateOrUpdate(models.Document{ID: "titi", Labels: map[string]string{"team": "legal"},
+		ACL: &models.DocumentACL{Owner: "bob", Readers: []string{UserPrincipal("alice")}}}, Precondition{})
+	_, _, _ = repo.CreateOrUpdate(models.Document{ID: "tata", Labels: map[string]string{"team": "finance"},
+		ACL: &models.DocumentACL{Owner: "bob"}}, Precondition{})
+
+	selector, err := ParseLabelSelector("team=finance")
+	assert.Nil(t, err)
+	page, err := repo.List(DocumentQuery{Filter: DocumentFilter{Selector: selector}})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"tata", "toto"}, idsOf(page.Documents))
+
+	//the ACL keeps the documents alice owns or reads
+	page, err = repo.List(DocumentQuery{Filter: DocumentFilter{ReadableBy: alice}})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"titi", "toto"}, idsOf(page.Documents))
+	page, err = repo.List(DocumentQuery{Filter: DocumentFilter{Selector: selector, ReadableBy: alice}})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"toto"}, idsOf(page.Documents))
+
+	acl, err := repo.SetACL("tata", models.DocumentACL{Owner: "bob", Readers: []string{UserPrincipal("alice")}}, Precondition{})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{UserPrincipal("alice")}, acl.ACL.Readers)
+	all, err := repo.GetAll(DocumentFilter{ReadableBy: alice})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"tata", "titi", "toto"}, idsOf(all))
+	_, err = repo.SetACL("tutu", models.DocumentACL{Owner: "bob"}, Precondition{})
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestBoltDocumentRepo_Search(t *testing.T) {
+	repo := newBoltRepo(t)
+	_, _, _ = repo.CreateOrUpdate(models.Document{ID: "toto", Name: "annual report", Description: "the report of the year"}, Precondition{})
+	_, _, _ = repo.CreateOrUpdate(models.Document{ID: "titi", Name: "budget", Description: "annual budget",
+		ACL: &models.DocumentACL{Owner: "bob"}}, Precondition{})
+	_, _, _ = repo.CreateOrUpdate(models.Document{ID: "tata", Name: "report"}, Precondition{})
+
+	//the terms of the name weigh more than the ones of the description
+	page, err := repo.Search(SearchQuery{Text: "Annual"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"toto", "titi"}, hitIdsOf(page.Hits))
+	assert.Greater(t, page.Hits[0].Score, page.Hits[1].Score)
+
+	page, err = repo.Search(SearchQuery{Text: "annual", ReadableBy: []string{UserPrincipal("alice")}})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"toto"}, hitIdsOf(page.Hits))
+
+	//the index follows the updates and the trash
+	_, _, _ = repo.CreateOrUpdate(models.Document{ID: "toto", Name: "minutes"}, Precondition{})
+	_, _ = repo.Delete("tata", Precondition{})
+	page, err = repo.Search(SearchQuery{Text: "report"})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(page.Hits))
+	page, err = repo.Search(SearchQuery{Text: "minutes"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"toto"}, hitIdsOf(page.Hits))
+	_, _ = repo.Restore("tata")
+	page, err = repo.Search(SearchQuery{Text: "report"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"tata"}, hitIdsOf(page.Hits))
+
+	//the hits are paged
+	page, err = repo.Search(SearchQuery{Text: "annual minutes report", Limit: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(page.Hits))
+	next, err := repo.Search(SearchQuery{Text: "annual minutes report", Limit: 2, Cursor: page.NextCursor})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(next.Hits))
+	assert.Empty(t, next.NextCursor)
+}
+
+func TestBoltDocumentRepo_Preconditions(t *testing.T) {
+	repo := newBoltRepo(t)
+
+	_, _, err := repo.CreateOrUpdate(models.Document{ID: "toto"}, Precondition{MustExist: true})
+	assert.Equal(t, ErrPreconditionFailed, err)
+	_, _, err = repo.CreateOrUpdate(models.Document{ID: "toto"}, Precondition{MustNotExist: true})
+	assert.Nil(t, err)
+	_, _, err = repo.CreateOrUpdate(models.Document{ID: "toto"}, Precondition{MustNotExist: true})
+	assert.Equal(t, ErrPreconditionFailed, err)
+
+	_, _, err = repo.CreateOrUpdate(models.Document{ID: "toto", Name: "stale"}, Precondition{IfMatch: 2})
+	assert.Equal(t, ErrPreconditionFailed, err)
+	updated, _, err := repo.CreateOrUpdate(models.Document{ID: "toto", Name: "fresh"}, Precondition{IfMatch: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), updated.Version)
+
+	_, err = repo.Delete("toto", Precondition{IfMatch: 1})
+	assert.Equal(t, ErrPreconditionFailed, err)
+	deleted, err := repo.Delete("toto", Precondition{IfMatch: 2})
+	assert.Nil(t, err)
+	assert.True(t, deleted)
+}
+
+func TestBoltDocumentRepo_AtomicBatch(t *testing.T) {
+	repo := newBoltRepo(t)
+	_, _, _ = repo.CreateOrUpdate(models.Document{ID: "toto", Name: "first"}, Precondition{})
+
+	//the failed operation rolls back the ones before it
+	results, err := repo.ApplyBatch([]Operation{
+		{Document: models.Document{ID: "titi", Name: "new"}},
+		{Document: models.Document{ID: "toto", Name: "second"}},
+		{Document: models.Document{ID: "toto", Name: "stale"}, Precondition: Precondition{IfMatch: 1}},
+	}, true)
+	assert.Nil(t, err)
+	assert.Equal(t, ErrRolledBack, results[0].Err)
+	assert.Equal(t, ErrRolledBack, results[1].Err)
+	assert.Equal(t, ErrPreconditionFailed, results[2].Err)
+	_, err = repo.GetById("titi")
+	assert.Equal(t, ErrNotFound, err)
+	stored, _ := repo.GetById("toto")
+	assert.Equal(t, "first", stored.Name)
+	page, _ := repo.Search(SearchQuery{Text: "new second"})
+	assert.Equal(t, 0, len(page.Hits))
+
+	results, err = repo.ApplyBatch([]Operation{
+		{Document: models.Document{ID: "titi", Name: "new"}},
+		{Delete: true, Document: models.Document{ID: "toto"}},
+	}, true)
+	assert.Nil(t, err)
+	assert.Nil(t, results[0].Err)
+	assert.Nil(t, results[1].Err)
+	_, err = repo.GetById("titi")
+	assert.Nil(t, err)
+	_, err = repo.GetById("toto")
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestBoltDocumentRepo_Purge(t *testing.T) {
+	repo := newBoltRepo(t)
+	_, _, _ = repo.CreateOrUpdate(models.Document{ID: "toto", Name: "annual report"}, Precondition{})
+	_, _, _ = repo.CreateOrUpdate(models.Document{ID: "titi", Name: "annual budget"}, Precondition{})
+	_, _ = repo.AddRevision(models.DocumentRevision{DocumentID: "toto"}, 0)
+	_, _ = repo.AddRevision(models.DocumentRevision{DocumentID: "titi"}, 0)
+	_, _ = repo.Delete("toto", Precondition{})
+	_, _ = repo.Delete("titi", Precondition{})
+
+	//only the documents trashed before the time are purged, with their revisions
+	purged, err := repo.Purge(time.Now().Add(-time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(purged))
+	_, _ = repo.Restore("titi")
+	purged, err = repo.Purge(time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"toto"}, purged)
+
+	_, err = repo.Restore("toto")
+	assert.Equal(t, ErrNotFound, err)
+	revisions, err := repo.GetRevisions("toto")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(revisions))
+	revisions, err = repo.GetRevisions("titi")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(revisions))
+
+	//a new document with the id of a purged one is searched alone
+	_, _, _ = repo.CreateOrUpdate(models.Document{ID: "toto", Name: "minutes"}, Precondition{})
+	page, err := repo.Search(SearchQuery{Text: "annual minutes"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"titi", "toto"}, hitIdsOf(page.Hits))
+}
+
+func idsOf(documents []models.Document) []string {
+	ids := make([]string, 0, len(documents))
+	for _, document := range documents {
+		ids = append(ids, document.ID)
+	}
+	return ids
+}
+
+func hitIdsOf(hits []models.SearchHit) []string {
+	ids := make([]string, 0, len(hits))
+	for _, hit := range hits {
+		ids = append(ids, hit.Document.ID)
+	}
+	return ids
+}
@@ -0,0 +1,15 @@
+package repodocuments
+
+import (
+	"context"
+	"goapi/models"
+)
+
+var ErrUnknownEvent = NewError(ErrNotFound, "unknown or expired event id")
+
+// ChangeWatcher is implemented by the repositories that see the writes of all the instances sharing the storage
+type ChangeWatcher interface {
+	// Watch streams the changes after the event lastEventID, or only the new ones if empty.
+	// The channel is closed when the context is done or when the stream fails.
+	Watch(ctx context.Context, lastEventID string) (<-chan models.DocumentChange, error)
+}
@@ -0,0 +1,223 @@
+package repodocuments
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"goapi/models"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultPageLimit is the number of documents returned when the query has no limit
+	DefaultPageLimit = 100
+	// MaxPageLimit is the maximum number of documents a query can ask for
+	MaxPageLimit = 1000
+)
+
+var ErrInvalidSort = NewError(ErrValidation, "invalid sort")
+var ErrInvalidCursor = NewError(ErrValidation, "invalid cursor")
+
+//SortTimeLayout is the format of the times of the sort values, in UTC they sort as the times do
+const SortTimeLayout = "2006-01-02T15:04:05.000Z07:00"
+
+//the fields a listing can be sorted on, with the way to read them on a document
+var sortableFields = map[string]func(document models.Document) string{
+	"id":        func(document models.Document) string { return document.ID },
+	"name":      func(document models.Document) string { return document.Name },
+	"createdAt": func(document models.Document) string { return document.CreatedAt.UTC().Format(SortTimeLayout) },
+	"createdBy": func(document models.Document) string { return document.CreatedBy },
+	"updatedAt": func(document models.Document) string { return document.UpdatedAt.UTC().Format(SortTimeLayout) },
+	"updatedBy": func(document models.Document) string { return document.UpdatedBy },
+}
+
+//the sortable fields holding a time
+var sortableTimes = map[string]bool{"createdAt": true, "updatedAt": true}
+
+// SortFields returns the fields a listing can be sorted on
+func SortFields() []string {
+	return []string{"id", "name", "createdAt", "createdBy", "updatedAt", "updatedBy"}
+}
+
+// DocumentSort is the order of a documents listing. Ties are always broken by ascending id.
+type DocumentSort struct {
+	Field      string
+	Descending bool
+}
+
+// ParseDocumentSort parses a sort like "name" or "-name". An empty value means sort by id.
+func ParseDocumentSort(value string) (DocumentSort, error) {
+	if len(value) == 0 {
+		return DocumentSort{Field: "id"}, nil
+	}
+	sort := DocumentSort{Field: strings.TrimPrefix(value, "-"), Descending: strings.HasPrefix(value, "-")}
+	if _, found := sortableFields[sort.Field]; !found {
+		return DocumentSort{}, ErrInvalidSort
+	}
+	return sort, nil
+}
+
+func (s DocumentSort) String() string {
+	if s.Descending {
+		return "-" + s.field()
+	}
+	return s.field()
+}
+
+func (s DocumentSort) field() string {
+	if len(s.Field) == 0 {
+		return "id"
+	}
+	return s.Field
+}
+
+func (s DocumentSort) valueOf(document models.Document) string {
+	return sortableFields[s.field()](document)
+}
+
+//typedValue returns the value of the sort field read by valueOf, as it is stored
+func (s DocumentSort) typedValue(value string) (interface{}, error) {
+	if !sortableTimes[s.field()] {
+		return value, nil
+	}
+	return time.Parse(SortTimeLayout, value)
+}
+
+//before tells if document a comes before document b in the listing
+func (s DocumentSort) before(a, b models.Document) bool {
+	valueA, valueB := s.valueOf(a), s.valueOf(b)
+	if valueA != valueB {
+		if s.Descending {
+			return valueA > valueB
+		}
+		return valueA < valueB
+	}
+	return a.ID < b.ID
+}
+
+// DocumentFilter keeps the documents matching all its criteria, an empty criterion matches any document
+type DocumentFilter struct {
+	//ID keeps the document with the id
+	ID            string
+	CreatedBy     string
+	UpdatedBy     string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	UpdatedAfter  time.Time
+	UpdatedBefore time.Time
+	Selector      LabelSelector
+	//ReadableBy keeps the documents one of the principals can read, nil keeps all of them
+	ReadableBy []string
+}
+
+//matches tells if the document matches the filter, the bounds of the times are excluded
+func (f DocumentFilter) matches(document models.Document) bool {
+	switch {
+	case len(f.ID) > 0 && document.ID != f.ID:
+		return false
+	case len(f.CreatedBy) > 0 && document.CreatedBy != f.CreatedBy:
+		return false
+	case len(f.UpdatedBy) > 0 && document.UpdatedBy != f.UpdatedBy:
+		return false
+	case !f.CreatedAfter.IsZero() && !document.CreatedAt.After(f.CreatedAfter):
+		return false
+	case !f.CreatedBefore.IsZero() && !document.CreatedAt.Before(f.CreatedBefore):
+		return false
+	case !f.UpdatedAfter.IsZero() && !document.UpdatedAt.After(f.UpdatedAfter):
+		return false
+	case !f.UpdatedBefore.IsZero() && !document.UpdatedAt.Before(f.UpdatedBefore):
+		return false
+	case !f.Selector.Matches(document.Labels):
+		return false
+	case f.ReadableBy != nil && !CanRead(document.ACL, f.ReadableBy):
+		return false
+	}
+	return true
+}
+
+// DocumentQuery describes which page of documents to list
+type DocumentQuery struct {
+	Limit  int
+	Cursor string
+	Sort   DocumentSort
+	Filter DocumentFilter
+	// Trashed lists the documents of the trash instead of the other ones
+	Trashed bool
+}
+
+func (q DocumentQuery) limit() int {
+	if q.Limit <= 0 {
+		return DefaultPageLimit
+	}
+	if q.Limit > MaxPageLimit {
+		return MaxPageLimit
+	}
+	return q.Limit
+}
+
+//the cursor is the position of the last document of a page, it is opaque for the clients
+type documentCursor struct {
+	Sort  string `json:"s"`
+	ID    string `json:"i"`
+	Value string `json:"v,omitempty"`
+}
+
+func encodeCursor(sort DocumentSort, last models.Document) string {
+	cursor := documentCursor{Sort: sort.String(), ID: last.ID}
+	if sort.field() != "id" {
+		cursor.Value = sort.valueOf(last)
+	}
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+//decodeCursor returns the cursor of the query, nil if the query starts from the beginning
+func decodeCursor(query DocumentQuery) (*documentCursor, error) {
+	if len(query.Cursor) == 0 {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(query.Cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var cursor documentCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	//a cursor can only be used with the sort it was created for
+	if cursor.Sort != query.Sort.String() || len(cursor.ID) == 0 {
+		return nil, ErrInvalidCursor
+	}
+	if _, err := query.Sort.typedValue(cursor.Value); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
+
+//after tells if the document comes after the cursor position in the listing
+func (c *documentCursor) after(sort DocumentSort, document models.Document) bool {
+	if sort.field() == "id" {
+		if sort.Descending {
+			return document.ID < c.ID
+		}
+		return document.ID > c.ID
+	}
+	value := sort.valueOf(document)
+	if value != c.Value {
+		if sort.Descending {
+			return value < c.Value
+		}
+		return value > c.Value
+	}
+	return document.ID > c.ID
+}
+
+//newPage builds the page from the documents found after the cursor, sorted and with at most limit+1 documents
+func newPage(query DocumentQuery, documents []models.Document) models.DocumentPage {
+	page := models.DocumentPage{Documents: documents}
+	if len(documents) > query.limit() {
+		page.Documents = documents[:query.limit()]
+		page.NextCursor = encodeCursor(query.Sort, page.Documents[len(page.Documents)-1])
+	}
+	return page
+}
@@ -1,10 +1,38 @@
 package repodocuments
 
-import "goapi/models"
+import (
+	"goapi/models"
+	"time"
+)
 
 type DocumentRepository interface {
+	Outbox
+	Tenancy
+	// GetById returns the document out of the trash, ErrNotFound if there is none
 	GetById(id string) (models.Document, error)
-	GetAll() ([]models.Document, error)
-	CreateOrUpdate(document models.Document) (bool, error)
-	Delete(id string) (bool, error)
+	// GetAll returns the documents out of the trash matching the filter, sorted by id
+	GetAll(filter DocumentFilter) ([]models.Document, error)
+	// Export calls f with each document out of the trash matching the filter, one at a time without loading them all,
+	// and stops at the first error of f
+	Export(filter DocumentFilter, f func(document models.Document) error) error
+	List(query DocumentQuery) (models.DocumentPage, error)
+	// Search returns the documents whose name or description match the text, the most relevant first
+	Search(query SearchQuery) (models.SearchPage, error)
+	CreateOrUpdate(document models.Document, precondition Precondition) (models.Document, bool, error)
+	// SetACL replaces the ACL of the document out of the trash, ErrNotFound if there is none
+	SetACL(id string, acl models.DocumentACL, precondition Precondition) (models.Document, error)
+	// Delete moves the document to the trash
+	Delete(id string, precondition Precondition) (bool, error)
+	// Restore moves back the document from the trash, ErrNotFound if it is not in the trash
+	Restore(id string) (models.Document, error)
+	// Purge permanently removes the documents moved to the trash before deletedBefore, and returns their ids
+	Purge(deletedBefore time.Time) ([]string, error)
+	// ApplyBatch applies the operations in order. When atomic, either all operations are applied or none of them.
+	ApplyBatch(operations []Operation, atomic bool) ([]OperationResult, error)
+	// AddRevision stores the revision with the next revision number of its document,
+	// and only keeps the last maxRevisions revisions of the document (all of them if 0)
+	AddRevision(revision models.DocumentRevision, maxRevisions int) (models.DocumentRevision, error)
+	// GetRevisions returns the revisions of a document sorted by revision number
+	GetRevisions(id string) ([]models.DocumentRevision, error)
+	GetRevision(id string, revision int64) (models.DocumentRevision, bool, error)
 }
@@ -0,0 +1,154 @@
+package repodocuments
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"goapi/models"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+//the weight of a term found in the name compared to a term found in the description
+const nameWeight = 2
+
+// SearchQuery describes which page of the documents matching the text to return
+type SearchQuery struct {
+	Text   string
+	Limit  int
+	Cursor string
+	//ReadableBy keeps the documents one of the principals can read, nil keeps all of them
+	ReadableBy []string
+}
+
+func (q SearchQuery) limit() int {
+	return DocumentQuery{Limit: q.Limit}.limit()
+}
+
+// SearchTerms splits a text in lower case terms, the way documents are indexed
+func SearchTerms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+//the cursor of a search is the position of the last hit of a page, hits are sorted by descending score then by id
+type searchCursor struct {
+	Text  string `json:"t"`
+	ID    string `json:"i"`
+	Score string `json:"s"`
+}
+
+func encodeSearchCursor(text string, last models.SearchHit) string {
+	cursor := searchCursor{Text: text, ID: last.Document.ID, Score: strconv.FormatFloat(last.Score, 'g', -1, 64)}
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+//decodeSearchCursor returns the position of the cursor, nil if the query starts from the beginning
+func decodeSearchCursor(query SearchQuery) (*models.SearchHit, error) {
+	if len(query.Cursor) == 0 {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(query.Cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var cursor searchCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	score, err := strconv.ParseFloat(cursor.Score, 64)
+	//a cursor can only be used with the text it was created for
+	if err != nil || cursor.Text != query.Text || len(cursor.ID) == 0 {
+		return nil, ErrInvalidCursor
+	}
+	return &models.SearchHit{Document: models.Document{ID: cursor.ID}, Score: score}, nil
+}
+
+//hitBefore tells if hit a comes before hit b in the search results
+func hitBefore(a, b models.SearchHit) bool {
+	if a.Score != b.Score {
+		return a.Score > b.Score
+	}
+	return a.Document.ID < b.Document.ID
+}
+
+//newSearchPage builds the page from the hits found after the cursor, sorted and with at most limit+1 hits
+func newSearchPage(query SearchQuery, hits []models.SearchHit) models.SearchPage {
+	page := models.SearchPage{Hits: hits}
+	if len(hits) > query.limit() {
+		page.Hits = hits[:query.limit()]
+		page.NextCursor = encodeSearchCursor(query.Text, page.Hits[len(page.Hits)-1])
+	}
+	return page
+}
+
+//termsIndex is the inverted index of the in memory repository: for each term, the weighted frequency of the term in each document
+type termsIndex struct {
+	documentsByTerm map[string]map[string]int
+	termsByDocument map[string]map[string]int
+}
+
+//documentTerms returns the weighted frequency of each term of the document
+func documentTerms(document models.Document) map[string]int {
+	terms := make(map[string]int)
+	for _, term := range SearchTerms(document.Name) {
+		terms[term] += nameWeight
+	}
+	for _, term := range SearchTerms(document.Description) {
+		terms[term]++
+	}
+	return terms
+}
+
+func (i *termsIndex) add(document models.Document) {
+	if i.documentsByTerm == nil {
+		i.documentsByTerm = make(map[string]map[string]int)
+		i.termsByDocument = make(map[string]map[string]int)
+	}
+	i.remove(document.ID)
+	terms := documentTerms(document)
+	for term, frequency := range terms {
+		if i.documentsByTerm[term] == nil {
+			i.documentsByTerm[term] = make(map[string]int)
+		}
+		i.documentsByTerm[term][document.ID] = frequency
+	}
+	i.termsByDocument[document.ID] = terms
+}
+
+func (i *termsIndex) remove(id string) {
+	for term := range i.termsByDocument[id] {
+		delete(i.documentsByTerm[term], id)
+		if len(i.documentsByTerm[term]) == 0 {
+			delete(i.documentsByTerm, term)
+		}
+	}
+	delete(i.termsByDocument, id)
+}
+
+//scores returns the score of each document having at least one of the terms.
+//The score is the sum for each term of its frequency in the document weighted by its rarity among the documents.
+func (i *termsIndex) scores(terms []string) map[string]float64 {
+	scores := make(map[string]float64)
+	seen := make(map[string]bool)
+	for _, term := range terms {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+		documents := i.documentsByTerm[term]
+		rarity := termRarity(len(i.termsByDocument), len(documents))
+		for id, frequency := range documents {
+			scores[id] += float64(frequency) * rarity
+		}
+	}
+	return scores
+}
+
+//termRarity is the weight of a term found in matching documents among the indexed documents
+func termRarity(indexed int, matching int) float64 {
+	return math.Log(1 + float64(indexed)/float64(matching))
+}
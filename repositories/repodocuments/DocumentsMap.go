@@ -0,0 +1,145 @@
+package repodocuments
+
+import (
+	"goapi/models"
+	"sort"
+	"sync"
+)
+
+//documentsMap is the map of the documents of the in memory repository by id, with the methods of sync.Map.
+//It keeps the sorted ids, so that the listings by id seek their cursor instead of sorting the whole map,
+//and the documents each principal can read, so that the ACL filter doesn't scan the whole map.
+type documentsMap struct {
+	documents sync.Map
+
+	ids []string
+	//public has the documents without ACL, anyone can read them
+	public      map[string]bool
+	readers     map[string]map[string]bool
+	readersByID map[string][]string
+	lock        sync.RWMutex
+}
+
+func (m *documentsMap) Load(id interface{}) (interface{}, bool) {
+	return m.documents.Load(id)
+}
+
+func (m *documentsMap) Range(f func(id, document interface{}) bool) {
+	m.documents.Range(f)
+}
+
+func (m *documentsMap) Store(id, document interface{}) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, found := m.documents.Load(id); !found {
+		m.insertID(id.(string))
+	}
+	m.removeReaders(id.(string))
+	m.addReaders(id.(string), aclReaders(document.(models.Document).ACL))
+	m.documents.Store(id, document)
+}
+
+func (m *documentsMap) Delete(id interface{}) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, found := m.documents.Load(id); found {
+		m.removeID(id.(string))
+		m.removeReaders(id.(string))
+	}
+	m.documents.Delete(id)
+}
+
+func (m *documentsMap) insertID(id string) {
+	i := sort.SearchStrings(m.ids, id)
+	m.ids = append(m.ids, "")
+	copy(m.ids[i+1:], m.ids[i:])
+	m.ids[i] = id
+}
+
+func (m *documentsMap) removeID(id string) {
+	i := sort.SearchStrings(m.ids, id)
+	if i < len(m.ids) && m.ids[i] == id {
+		m.ids = append(m.ids[:i], m.ids[i+1:]...)
+	}
+}
+
+//addReaders indexes the principals that can read the document, nil when anyone can
+func (m *documentsMap) addReaders(id string, principals []string) {
+	if m.readersByID == nil {
+		m.public = make(map[string]bool)
+		m.readers = make(map[string]map[string]bool)
+		m.readersByID = make(map[string][]string)
+	}
+	if principals == nil {
+		m.public[id] = true
+		return
+	}
+	for _, principal := range principals {
+		addTo(m.readers, principal, id)
+	}
+	m.readersByID[id] = principals
+}
+
+func (m *documentsMap) removeReaders(id string) {
+	delete(m.public, id)
+	for _, principal := range m.readersByID[id] {
+		removeFrom(m.readers, principal, id)
+	}
+	delete(m.readersByID, id)
+}
+
+//readableBy returns the ids of the documents one of the principals can read, in no particular order
+func (m *documentsMap) readableBy(principals []string) []string {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	unique := make(map[string]bool, len(m.public))
+	for id := range m.public {
+		unique[id] = true
+	}
+	for _, principal := range principals {
+		for id := range m.readers[principal] {
+			unique[id] = true
+		}
+	}
+	ids := make([]string, 0, len(unique))
+	for id := range unique {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+//rangeIDs calls f on the ids in the order of the listing, from the cursor, until f returns false.
+//f must not write to the map.
+func (m *documentsMap) rangeIDs(cursor *documentCursor, descending bool, f func(id string) bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	rangeSorted(m.ids, cursor, descending, f)
+}
+
+//rangeSorted calls f on the sorted ids after the cursor, in descending order when asked, until f returns false
+func rangeSorted(ids []string, cursor *documentCursor, descending bool, f func(id string) bool) {
+	if !descending {
+		start := 0
+		if cursor != nil {
+			start = sort.Search(len(ids), func(i int) bool { return ids[i] > cursor.ID })
+		}
+		for _, id := range ids[start:] {
+			if !f(id) {
+				return
+			}
+		}
+		return
+	}
+	end := len(ids)
+	if cursor != nil {
+		end = sort.SearchStrings(ids, cursor.ID)
+	}
+	for i := end - 1; i >= 0; i-- {
+		if !f(ids[i]) {
+			return
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package repodocuments
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The kinds of the errors of the repositories, the other errors either wrap one of them or are unexpected
+var (
+	ErrNotFound    = errors.New("document not found")
+	ErrConflict    = errors.New("conflict")
+	ErrValidation  = errors.New("validation failed")
+	ErrUnavailable = errors.New("unavailable")
+	ErrForbidden   = errors.New("forbidden")
+)
+
+// ErrNoDatastore is returned when the datastore of a repository is not available
+var ErrNoDatastore = NewError(ErrUnavailable, "no datastore")
+
+// Error is an error of one of the kinds, errors.Is tells its kind
+type Error struct {
+	kind    error
+	message string
+}
+
+// NewError returns an error of the kind
+func NewError(kind error, format string, args ...interface{}) error {
+	return &Error{kind: kind, message: fmt.Sprintf(format, args...)}
+}
+
+func (e *Error) Error() string {
+	return e.message
+}
+
+func (e *Error) Unwrap() error {
+	return e.kind
+}
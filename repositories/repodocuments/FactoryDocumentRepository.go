@@ -6,9 +6,14 @@ import (
 )
 
 func CreateDocumentRepository(config *config.Config) DocumentRepository {
-	if config.StorageInMemory {
-		return &InMemoryDocumentRepo{}
-	} else {
+	switch database.StorageTypeOf(&config.StorageConfig) {
+	case database.StorageMongo:
 		return NewMongoDbDocumentRepo(database.GetMongoDatabaseHandler())
+	case database.StorageBolt:
+		return NewBoltDocumentRepo(database.GetBoltDatabaseHandler())
+	case database.StorageSQL:
+		return NewSQLDocumentRepo(database.GetSQLDatabaseHandler())
+	default:
+		return &InMemoryDocumentRepo{}
 	}
 }
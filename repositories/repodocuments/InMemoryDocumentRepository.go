@@ -3,56 +3,616 @@ package repodocuments
 import (
 	"goapi/models"
 	"sort"
+	"strconv"
 	"sync"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
 type InMemoryDocumentRepo struct {
-	DocumentsById sync.Map
+	DocumentsById documentsMap
+	//writes are serialized so that the precondition check and the write are atomic
+	writeLock sync.Mutex
+
+	revisionsById map[string][]models.DocumentRevision
+	revisionsLock sync.RWMutex
+
+	//inverted index of the names and descriptions, updated on each write
+	index     termsIndex
+	indexLock sync.RWMutex
+
+	//index of the labels of all the stored documents, trashed or not, so that selectors don't scan the whole map
+	labels     labelsIndex
+	labelsLock sync.RWMutex
+
+	//events of the writes not published yet, only recorded once the outbox is enabled.
+	//The outbox of the default tenant has the events of all the tenants, in the order of the writes.
+	outboxEnabled bool
+	outbox        []models.DocumentEvent
+	lastEventID   int64
+	outboxLock    sync.Mutex
+	relayLease    localLease
+
+	//the repository of another tenant has its own documents and revisions, and is kept by the repository of the default tenant
+	tenant      string
+	root        *InMemoryDocumentRepo
+	tenants     map[string]*InMemoryDocumentRepo
+	tenantsLock sync.Mutex
+}
+
+//rootRepo is the repository of the default tenant, that keeps the repositories of the other tenants and the outbox
+func (r *InMemoryDocumentRepo) rootRepo() *InMemoryDocumentRepo {
+	if r.root != nil {
+		return r.root
+	}
+	return r
+}
+
+func (r *InMemoryDocumentRepo) ForTenant(tenant string) DocumentRepository {
+	root := r.rootRepo()
+	if tenantOrDefault(tenant) == DefaultTenant {
+		return root
+	}
+
+	root.tenantsLock.Lock()
+	defer root.tenantsLock.Unlock()
+	if root.tenants == nil {
+		root.tenants = make(map[string]*InMemoryDocumentRepo)
+	}
+	repo, found := root.tenants[tenant]
+	if !found {
+		repo = &InMemoryDocumentRepo{tenant: tenant, root: root}
+		root.tenants[tenant] = repo
+	}
+	return repo
+}
+
+func (r *InMemoryDocumentRepo) Tenants() ([]string, error) {
+	root := r.rootRepo()
+	root.tenantsLock.Lock()
+	defer root.tenantsLock.Unlock()
+
+	tenants := make([]string, 0, len(root.tenants))
+	for tenant := range root.tenants {
+		tenants = append(tenants, tenant)
+	}
+	return sortedTenants(tenants), nil
+}
+
+//logger logs with the tenant of the repository
+func (r *InMemoryDocumentRepo) logger() *log.Entry {
+	return log.WithField("tenant", tenantOrDefault(r.tenant))
 }
 
 func (r *InMemoryDocumentRepo) GetById(id string) (models.Document, error) {
 	document, found := r.DocumentsById.Load(id)
-	if found {
+	if found && !document.(models.Document).Trashed() {
 		return document.(models.Document), nil
 	}
-	return models.Document{}, nil
+	return models.Document{}, ErrNotFound
 }
 
-func (r *InMemoryDocumentRepo) GetAll() ([]models.Document, error) {
-	ids := make([]string, 0)
-	//retrieve ids and sort them
-	r.DocumentsById.Range(func(id, value interface{}) bool {
-		ids = append(ids, id.(string))
+func (r *InMemoryDocumentRepo) GetAll(filter DocumentFilter) ([]models.Document, error) {
+	values := make([]models.Document, 0)
+	r.rangeByID(filter, nil, false, func(doc models.Document) bool {
+		if !doc.Trashed() && filter.matches(doc) {
+			values = append(values, doc)
+		}
 		return true
 	})
-	sort.Strings(ids)
+	return values, nil
+}
+
+// Export ranges over the stored documents, in no particular order
+func (r *InMemoryDocumentRepo) Export(filter DocumentFilter, f func(document models.Document) error) error {
+	var err error
+	r.rangeSelected(filter, func(doc models.Document) {
+		if err == nil && !doc.Trashed() && filter.matches(doc) {
+			err = f(doc)
+		}
+	})
+	return err
+}
+
+func (r *InMemoryDocumentRepo) List(query DocumentQuery) (models.DocumentPage, error) {
+	cursor, err := decodeCursor(query)
+	if err != nil {
+		return models.DocumentPage{}, err
+	}
+
+	//keep only the documents after the cursor, from the trash or not
+	values := make([]models.Document, 0)
+	keep := func(doc models.Document) bool {
+		return doc.Trashed() == query.Trashed && query.Filter.matches(doc)
+	}
+	if query.Sort.field() == "id" {
+		//the documents come in the order of the listing, one more document than the limit tells there is a next page
+		r.rangeByID(query.Filter, cursor, query.Sort.Descending, func(doc models.Document) bool {
+			if keep(doc) {
+				values = append(values, doc)
+			}
+			return len(values) <= query.limit()
+		})
+		return newPage(query, values), nil
+	}
+
+	r.rangeSelected(query.Filter, func(doc models.Document) {
+		if keep(doc) && (cursor == nil || cursor.after(query.Sort, doc)) {
+			values = append(values, doc)
+		}
+	})
+	sort.Slice(values, func(i, j int) bool {
+		return query.Sort.before(values[i], values[j])
+	})
+	if len(values) > query.limit()+1 {
+		values = values[:query.limit()+1]
+	}
+	return newPage(query, values), nil
+}
+
+//candidates returns the ids of the documents that may match the filter, from the most selective index.
+//It returns false when no index can be used, every document may match.
+func (r *InMemoryDocumentRepo) candidates(filter DocumentFilter) ([]string, bool) {
+	if len(filter.ID) > 0 {
+		return []string{filter.ID}, true
+	}
+
+	r.labelsLock.RLock()
+	ids, indexed := r.labels.candidates(filter.Selector)
+	r.labelsLock.RUnlock()
+
+	if filter.ReadableBy != nil {
+		if readable := r.DocumentsById.readableBy(filter.ReadableBy); !indexed || len(readable) < len(ids) {
+			ids, indexed = readable, true
+		}
+	}
+	return ids, indexed
+}
 
-	//fill values for sorted ids
-	values := make([]models.Document, 0, len(ids))
+//rangeSelected calls f on the documents that may match the filter, in no particular order
+func (r *InMemoryDocumentRepo) rangeSelected(filter DocumentFilter, f func(document models.Document)) {
+	ids, indexed := r.candidates(filter)
+	if !indexed {
+		r.DocumentsById.Range(func(_, value interface{}) bool {
+			f(value.(models.Document))
+			return true
+		})
+		return
+	}
 	for _, id := range ids {
-		doc, _ := r.DocumentsById.Load(id)
-		values = append(values, doc.(models.Document))
+		if document, found := r.DocumentsById.Load(id); found {
+			f(document.(models.Document))
+		}
 	}
+}
 
-	return values, nil
+//rangeByID calls f on the documents that may match the filter in the order of their ids, from the cursor, until f returns false
+func (r *InMemoryDocumentRepo) rangeByID(filter DocumentFilter, cursor *documentCursor, descending bool, f func(document models.Document) bool) {
+	call := func(id string) bool {
+		if document, found := r.DocumentsById.Load(id); found {
+			return f(document.(models.Document))
+		}
+		return true
+	}
+	ids, indexed := r.candidates(filter)
+	if !indexed {
+		r.DocumentsById.rangeIDs(cursor, descending, call)
+		return
+	}
+	sort.Strings(ids)
+	rangeSorted(ids, cursor, descending, call)
+}
+
+func (r *InMemoryDocumentRepo) Search(query SearchQuery) (models.SearchPage, error) {
+	after, err := decodeSearchCursor(query)
+	if err != nil {
+		return models.SearchPage{}, err
+	}
+
+	r.indexLock.RLock()
+	scores := r.index.scores(SearchTerms(query.Text))
+	r.indexLock.RUnlock()
+
+	//keep only the hits after the cursor
+	hits := make([]models.SearchHit, 0, len(scores))
+	for id, score := range scores {
+		document, found := r.DocumentsById.Load(id)
+		if !found || document.(models.Document).Trashed() {
+			continue
+		}
+		if query.ReadableBy != nil && !CanRead(document.(models.Document).ACL, query.ReadableBy) {
+			continue
+		}
+		hit := models.SearchHit{Document: document.(models.Document), Score: score}
+		if after == nil || hitBefore(*after, hit) {
+			hits = append(hits, hit)
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		return hitBefore(hits[i], hits[j])
+	})
+
+	//one more hit than the limit tells there is a next page
+	if len(hits) > query.limit()+1 {
+		hits = hits[:query.limit()+1]
+	}
+	return newSearchPage(query, hits), nil
+}
+
+func (r *InMemoryDocumentRepo) CreateOrUpdate(documentToCreate models.Document, precondition Precondition) (models.Document, bool, error) {
+	r.writeLock.Lock()
+	defer r.writeLock.Unlock()
+
+	return createOrUpdateIn(&mapView{r}, documentToCreate, precondition)
+}
+
+func (r *InMemoryDocumentRepo) SetACL(id string, acl models.DocumentACL, precondition Precondition) (models.Document, error) {
+	r.writeLock.Lock()
+	defer r.writeLock.Unlock()
+
+	view := &mapView{r}
+	storedDocument, found := view.load(id)
+	found = found && !storedDocument.Trashed()
+	if err := precondition.check(storedDocument, found); err != nil {
+		return models.Document{}, err
+	}
+	if !found {
+		return models.Document{}, ErrNotFound
+	}
+	storedDocument.ACL = &acl
+	storedDocument.Version++
+	view.store(storedDocument)
+	view.record(newDocumentEvent(storedDocument, true))
+	return storedDocument, nil
+}
+
+func (r *InMemoryDocumentRepo) Delete(idToDelete string, precondition Precondition) (bool, error) {
+	r.writeLock.Lock()
+	defer r.writeLock.Unlock()
+
+	return deleteIn(&mapView{r}, idToDelete, precondition)
+}
+
+func (r *InMemoryDocumentRepo) Restore(id string) (models.Document, error) {
+	r.writeLock.Lock()
+	defer r.writeLock.Unlock()
+
+	view := &mapView{r}
+	storedDocument, found := view.load(id)
+	if !found || !storedDocument.Trashed() {
+		return models.Document{}, ErrNotFound
+	}
+	storedDocument.DeletedAt = nil
+	storedDocument.Version++
+	view.store(storedDocument)
+	view.record(newDocumentEvent(storedDocument, false))
+	return storedDocument, nil
+}
+
+func (r *InMemoryDocumentRepo) Purge(deletedBefore time.Time) ([]string, error) {
+	r.writeLock.Lock()
+	defer r.writeLock.Unlock()
+
+	view := &mapView{r}
+	purged := make([]string, 0)
+	r.DocumentsById.Range(func(id, value interface{}) bool {
+		document := value.(models.Document)
+		if document.Trashed() && document.DeletedAt.Before(deletedBefore) {
+			view.remove(id.(string))
+			purged = append(purged, id.(string))
+		}
+		return true
+	})
+
+	//the revisions of the purged documents can't be restored anymore
+	r.revisionsLock.Lock()
+	defer r.revisionsLock.Unlock()
+	for _, id := range purged {
+		delete(r.revisionsById, id)
+	}
+	return purged, nil
 }
 
-func (r *InMemoryDocumentRepo) CreateOrUpdate(documentToCreate models.Document) (bool, error) {
-	_, found := r.DocumentsById.Load(documentToCreate.ID)
+func (r *InMemoryDocumentRepo) ApplyBatch(operations []Operation, atomic bool) ([]OperationResult, error) {
+	results := make([]OperationResult, len(operations))
+	if !atomic {
+		for i, operation := range operations {
+			results[i] = r.apply(operation)
+		}
+		return results, nil
+	}
+
+	//the whole batch is done under the lock, on staged changes that are only committed if all operations succeed
+	r.writeLock.Lock()
+	defer r.writeLock.Unlock()
+
+	staged := newStagedView(&mapView{r})
+	for i, operation := range operations {
+		results[i] = applyIn(staged, operation)
+		if results[i].Err != nil {
+			rollBack(results, i)
+			return results, nil
+		}
+	}
+	staged.commit()
+	return results, nil
+}
+
+func (r *InMemoryDocumentRepo) apply(operation Operation) OperationResult {
+	r.writeLock.Lock()
+	defer r.writeLock.Unlock()
+
+	return applyIn(&mapView{r}, operation)
+}
+
+func applyIn(view documentsView, operation Operation) OperationResult {
+	if operation.Delete {
+		found, err := deleteIn(view, operation.Document.ID, operation.Precondition)
+		if err == nil && !found {
+			err = ErrNotFound
+		}
+		return OperationResult{Existed: found, Err: err}
+	}
+	document, found, err := createOrUpdateIn(view, operation.Document, operation.Precondition)
+	return OperationResult{Document: document, Existed: found, Err: err}
+}
+
+//createOrUpdateIn writes the document, a trashed document is replaced as if it did not exist
+func createOrUpdateIn(view documentsView, documentToCreate models.Document, precondition Precondition) (models.Document, bool, error) {
+	storedDocument, found := view.load(documentToCreate.ID)
+	found = found && !storedDocument.Trashed()
 	if found {
-		log.Info("document " + documentToCreate.ID + " already exists")
+		view.logger().Info("document " + documentToCreate.ID + " already exists")
+	}
+	if err := precondition.check(storedDocument, found); err != nil {
+		return models.Document{}, found, err
+	}
+
+	//the creation and the ACL are kept from the stored document, even from the trash
+	if len(storedDocument.ID) > 0 {
+		documentToCreate.CreatedAt = storedDocument.CreatedAt
+		documentToCreate.CreatedBy = storedDocument.CreatedBy
+		documentToCreate.ACL = storedDocument.ACL
+	}
+	//the version goes on from the trashed document so that its ETag cannot match the new one
+	documentToCreate.Version = storedDocument.Version + 1
+	documentToCreate.DeletedAt = nil
+	view.store(documentToCreate)
+	view.record(newDocumentEvent(documentToCreate, found))
+	return documentToCreate, found, nil
+}
+
+//deleteIn moves the document to the trash
+func deleteIn(view documentsView, idToDelete string, precondition Precondition) (bool, error) {
+	storedDocument, found := view.load(idToDelete)
+	found = found && !storedDocument.Trashed()
+	if !found {
+		view.logger().Info("document " + idToDelete + " doesn't exists")
+	}
+	if err := precondition.check(storedDocument, found); err != nil {
+		return found, err
+	}
+	if !found {
+		return false, nil
+	}
+
+	deletedAt := time.Now().UTC()
+	storedDocument.DeletedAt = &deletedAt
+	storedDocument.Version++
+	view.store(storedDocument)
+	view.record(newDocumentEvent(storedDocument, true))
+	return true, nil
+}
+
+func (r *InMemoryDocumentRepo) EnableOutbox() {
+	r = r.rootRepo()
+	r.outboxLock.Lock()
+	defer r.outboxLock.Unlock()
+	r.outboxEnabled = true
+}
+
+func (r *InMemoryDocumentRepo) PendingEvents(limit int) ([]models.DocumentEvent, error) {
+	r = r.rootRepo()
+	r.outboxLock.Lock()
+	defer r.outboxLock.Unlock()
+
+	if len(r.outbox) < limit {
+		limit = len(r.outbox)
+	}
+	return append([]models.DocumentEvent{}, r.outbox[:limit]...), nil
+}
+
+func (r *InMemoryDocumentRepo) RemoveEvents(ids []string) error {
+	r = r.rootRepo()
+	r.outboxLock.Lock()
+	defer r.outboxLock.Unlock()
+
+	removed := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		removed[id] = true
+	}
+	pending := make([]models.DocumentEvent, 0, len(r.outbox))
+	for _, event := range r.outbox {
+		if !removed[event.ID] {
+			pending = append(pending, event)
+		}
+	}
+	r.outbox = pending
+	return nil
+}
+
+func (r *InMemoryDocumentRepo) AcquireRelayLease(holder string, duration time.Duration) (bool, error) {
+	return r.rootRepo().relayLease.acquire(holder, duration), nil
+}
+
+func (r *InMemoryDocumentRepo) ReleaseRelayLease(holder string) error {
+	r.rootRepo().relayLease.release(holder)
+	return nil
+}
+
+//recordEvent adds the event to the outbox, the writes are serialized so the events are in the order of the writes
+func (r *InMemoryDocumentRepo) recordEvent(event models.DocumentEvent) {
+	event.Tenant = tenantOrDefault(r.tenant)
+	r = r.rootRepo()
+	r.outboxLock.Lock()
+	defer r.outboxLock.Unlock()
+
+	if !r.outboxEnabled {
+		return
+	}
+	r.lastEventID++
+	event.ID = strconv.FormatInt(r.lastEventID, 10)
+	r.outbox = append(r.outbox, event)
+}
+
+func (r *InMemoryDocumentRepo) AddRevision(revision models.DocumentRevision, maxRevisions int) (models.DocumentRevision, error) {
+	r.revisionsLock.Lock()
+	defer r.revisionsLock.Unlock()
+
+	if r.revisionsById == nil {
+		r.revisionsById = make(map[string][]models.DocumentRevision)
+	}
+	revisions := r.revisionsById[revision.DocumentID]
+	revision.Revision = 1
+	if len(revisions) > 0 {
+		revision.Revision = revisions[len(revisions)-1].Revision + 1
 	}
-	r.DocumentsById.Store(documentToCreate.ID, documentToCreate)
-	return found, nil
+	revisions = append(revisions, revision)
+	if maxRevisions > 0 && len(revisions) > maxRevisions {
+		//copy so that the dropped revisions are not kept by the underlying array
+		revisions = append([]models.DocumentRevision(nil), revisions[len(revisions)-maxRevisions:]...)
+	}
+	r.revisionsById[revision.DocumentID] = revisions
+	return revision, nil
+}
+
+func (r *InMemoryDocumentRepo) GetRevisions(id string) ([]models.DocumentRevision, error) {
+	r.revisionsLock.RLock()
+	defer r.revisionsLock.RUnlock()
+
+	//the slice is only appended under the lock, return a copy
+	return append([]models.DocumentRevision{}, r.revisionsById[id]...), nil
+}
+
+func (r *InMemoryDocumentRepo) GetRevision(id string, revision int64) (models.DocumentRevision, bool, error) {
+	r.revisionsLock.RLock()
+	defer r.revisionsLock.RUnlock()
+
+	for _, stored := range r.revisionsById[id] {
+		if stored.Revision == revision {
+			return stored, true, nil
+		}
+	}
+	return models.DocumentRevision{}, false, nil
+}
+
+//documentsView is where the writes read and store documents: the map itself, or the staged changes of an atomic batch
+type documentsView interface {
+	load(id string) (models.Document, bool)
+	store(document models.Document)
+	remove(id string)
+	//record adds the event of a write to the outbox
+	record(event models.DocumentEvent)
+	//logger logs with the tenant of the documents
+	logger() *log.Entry
+}
+
+type mapView struct {
+	repo *InMemoryDocumentRepo
 }
 
-func (r *InMemoryDocumentRepo) Delete(idToDelete string) (bool, error) {
-	_, found := r.DocumentsById.Load(idToDelete)
+func (v *mapView) load(id string) (models.Document, bool) {
+	document, found := v.repo.DocumentsById.Load(id)
 	if !found {
-		log.Info("document " + idToDelete + " doesn't exists")
+		return models.Document{}, false
+	}
+	return document.(models.Document), true
+}
+
+func (v *mapView) store(document models.Document) {
+	v.repo.DocumentsById.Store(document.ID, document)
+
+	//trashed documents are not searched
+	v.repo.indexLock.Lock()
+	defer v.repo.indexLock.Unlock()
+	if document.Trashed() {
+		v.repo.index.remove(document.ID)
+	} else {
+		v.repo.index.add(document)
+	}
+
+	v.repo.labelsLock.Lock()
+	defer v.repo.labelsLock.Unlock()
+	v.repo.labels.add(document.ID, document.Labels)
+}
+
+func (v *mapView) remove(id string) {
+	v.repo.DocumentsById.Delete(id)
+
+	v.repo.indexLock.Lock()
+	defer v.repo.indexLock.Unlock()
+	v.repo.index.remove(id)
+
+	v.repo.labelsLock.Lock()
+	defer v.repo.labelsLock.Unlock()
+	v.repo.labels.remove(id)
+}
+
+func (v *mapView) record(event models.DocumentEvent) {
+	v.repo.recordEvent(event)
+}
+
+func (v *mapView) logger() *log.Entry {
+	return v.repo.logger()
+}
+
+//stagedView keeps the changes over a base view until they are committed, a nil document is a deletion
+type stagedView struct {
+	base    documentsView
+	changes map[string]*models.Document
+	events  []models.DocumentEvent
+}
+
+func newStagedView(base documentsView) *stagedView {
+	return &stagedView{base: base, changes: make(map[string]*models.Document)}
+}
+
+func (v *stagedView) load(id string) (models.Document, bool) {
+	if document, changed := v.changes[id]; changed {
+		if document == nil {
+			return models.Document{}, false
+		}
+		return *document, true
+	}
+	return v.base.load(id)
+}
+
+func (v *stagedView) store(document models.Document) {
+	v.changes[document.ID] = &document
+}
+
+func (v *stagedView) remove(id string) {
+	v.changes[id] = nil
+}
+
+func (v *stagedView) record(event models.DocumentEvent) {
+	v.events = append(v.events, event)
+}
+
+func (v *stagedView) logger() *log.Entry {
+	return v.base.logger()
+}
+
+func (v *stagedView) commit() {
+	for id, document := range v.changes {
+		if document == nil {
+			v.base.remove(id)
+		} else {
+			v.base.store(*document)
+		}
+	}
+	for _, event := range v.events {
+		v.base.record(event)
 	}
-	r.DocumentsById.Delete(idToDelete)
-	return found, nil
 }
@@ -0,0 +1,265 @@
+package repodocuments
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var ErrInvalidSelector = NewError(ErrValidation, "invalid selector")
+
+// LabelOperator is how a requirement of a selector compares the label of a document
+type LabelOperator string
+
+const (
+	LabelEquals       LabelOperator = "="
+	LabelNotEquals    LabelOperator = "!="
+	LabelIn           LabelOperator = "in"
+	LabelNotIn        LabelOperator = "notin"
+	LabelExists       LabelOperator = "exists"
+	LabelDoesNotExist LabelOperator = "!"
+)
+
+//the keys and the values of the labels, as Kubernetes allows them
+var labelKeyPattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._/-]*[A-Za-z0-9])?$`)
+var labelValuePattern = regexp.MustCompile(`^([A-Za-z0-9]([A-Za-z0-9._-]*[A-Za-z0-9])?)?$`)
+
+//a set-based requirement like "env in (dev,staging)"
+var setRequirementPattern = regexp.MustCompile(`^(\S+)\s+(in|notin)\s*\((.*)\)$`)
+
+// ValidLabelKey tells if the key of a label can be used in a selector
+func ValidLabelKey(key string) bool {
+	return len(key) <= 253 && labelKeyPattern.MatchString(key)
+}
+
+// ValidLabelValue tells if the value of a label can be used in a selector
+func ValidLabelValue(value string) bool {
+	return len(value) <= 63 && labelValuePattern.MatchString(value)
+}
+
+// LabelRequirement is a condition on one label of the documents
+type LabelRequirement struct {
+	Key      string
+	Operator LabelOperator
+	//Values has one value for = and !=, the set for in and notin
+	Values []string
+}
+
+// LabelSelector keeps the documents whose labels match all its requirements, an empty selector matches any document
+type LabelSelector []LabelRequirement
+
+// ParseLabelSelector parses a selector like "team=payments,env!=prod,tier in (front,back),!deprecated".
+// Like Kubernetes, a document without the label matches != and notin.
+func ParseLabelSelector(value string) (LabelSelector, error) {
+	var selector LabelSelector
+	for _, part := range splitRequirements(value) {
+		requirement, err := parseLabelRequirement(strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		selector = append(selector, requirement)
+	}
+	return selector, nil
+}
+
+//splitRequirements splits the selector on the commas that are not in a set of values
+func splitRequirements(value string) []string {
+	if len(strings.TrimSpace(value)) == 0 {
+		return nil
+	}
+	var parts []string
+	depth, start := 0, 0
+	for i, r := range value {
+		switch {
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+		case r == ',' && depth == 0:
+			parts = append(parts, value[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, value[start:])
+}
+
+func parseLabelRequirement(part string) (LabelRequirement, error) {
+	var requirement LabelRequirement
+	switch {
+	case strings.HasPrefix(part, "!") && !strings.Contains(part, "="):
+		requirement = LabelRequirement{Key: strings.TrimSpace(part[1:]), Operator: LabelDoesNotExist}
+	case setRequirementPattern.MatchString(part):
+		matches := setRequirementPattern.FindStringSubmatch(part)
+		requirement = LabelRequirement{Key: matches[1], Operator: LabelOperator(matches[2])}
+		for _, value := range strings.Split(matches[3], ",") {
+			requirement.Values = append(requirement.Values, strings.TrimSpace(value))
+		}
+	case strings.Contains(part, "!="):
+		key, value := splitRequirement(part, "!=")
+		requirement = LabelRequirement{Key: key, Operator: LabelNotEquals, Values: []string{value}}
+	case strings.Contains(part, "=="):
+		key, value := splitRequirement(part, "==")
+		requirement = LabelRequirement{Key: key, Operator: LabelEquals, Values: []string{value}}
+	case strings.Contains(part, "="):
+		key, value := splitRequirement(part, "=")
+		requirement = LabelRequirement{Key: key, Operator: LabelEquals, Values: []string{value}}
+	default:
+		requirement = LabelRequirement{Key: part, Operator: LabelExists}
+	}
+
+	if !ValidLabelKey(requirement.Key) {
+		return LabelRequirement{}, fmt.Errorf("%w: %q is not a label key", ErrInvalidSelector, requirement.Key)
+	}
+	for _, value := range requirement.Values {
+		if !ValidLabelValue(value) {
+			return LabelRequirement{}, fmt.Errorf("%w: %q is not a label value", ErrInvalidSelector, value)
+		}
+	}
+	return requirement, nil
+}
+
+func splitRequirement(part string, operator string) (string, string) {
+	index := strings.Index(part, operator)
+	return strings.TrimSpace(part[:index]), strings.TrimSpace(part[index+len(operator):])
+}
+
+func (r LabelRequirement) matches(labels map[string]string) bool {
+	value, found := labels[r.Key]
+	switch r.Operator {
+	case LabelExists:
+		return found
+	case LabelDoesNotExist:
+		return !found
+	case LabelEquals, LabelIn:
+		return found && r.has(value)
+	case LabelNotEquals, LabelNotIn:
+		return !found || !r.has(value)
+	}
+	return false
+}
+
+func (r LabelRequirement) has(value string) bool {
+	for _, candidate := range r.Values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches tells if the labels match all the requirements of the selector
+func (s LabelSelector) Matches(labels map[string]string) bool {
+	for _, requirement := range s {
+		if !requirement.matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+//labelPair is how a label is indexed, with its key and its value
+func labelPair(key string, value string) string {
+	return key + "=" + value
+}
+
+//labelPairs returns the indexed pairs of the labels, sorted
+func labelPairs(labels map[string]string) []string {
+	pairs := make([]string, 0, len(labels))
+	for key, value := range labels {
+		pairs = append(pairs, labelPair(key, value))
+	}
+	sort.Strings(pairs)
+	return pairs
+}
+
+//labelKeys returns the keys of the labels, sorted
+func labelKeys(labels map[string]string) []string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+//labelsIndex is the index of the labels of the in memory repository: the documents having each label, and each label key
+type labelsIndex struct {
+	documentsByPair map[string]map[string]bool
+	documentsByKey  map[string]map[string]bool
+	labelsByID      map[string]map[string]string
+}
+
+func (i *labelsIndex) add(id string, labels map[string]string) {
+	if i.labelsByID == nil {
+		i.documentsByPair = make(map[string]map[string]bool)
+		i.documentsByKey = make(map[string]map[string]bool)
+		i.labelsByID = make(map[string]map[string]string)
+	}
+	i.remove(id)
+	indexed := make(map[string]string, len(labels))
+	for key, value := range labels {
+		addTo(i.documentsByPair, labelPair(key, value), id)
+		addTo(i.documentsByKey, key, id)
+		indexed[key] = value
+	}
+	if len(indexed) > 0 {
+		i.labelsByID[id] = indexed
+	}
+}
+
+func (i *labelsIndex) remove(id string) {
+	for key, value := range i.labelsByID[id] {
+		removeFrom(i.documentsByPair, labelPair(key, value), id)
+		removeFrom(i.documentsByKey, key, id)
+	}
+	delete(i.labelsByID, id)
+}
+
+func addTo(index map[string]map[string]bool, entry string, id string) {
+	if index[entry] == nil {
+		index[entry] = make(map[string]bool)
+	}
+	index[entry][id] = true
+}
+
+func removeFrom(index map[string]map[string]bool, entry string, id string) {
+	delete(index[entry], id)
+	if len(index[entry]) == 0 {
+		delete(index, entry)
+	}
+}
+
+//candidates returns the ids of the documents that may match the selector, from its most selective requirement.
+//It returns false when no requirement can use the index, like a selector only made of != or notin.
+func (i *labelsIndex) candidates(selector LabelSelector) ([]string, bool) {
+	var best map[string]bool
+	indexed := false
+	for _, requirement := range selector {
+		var ids map[string]bool
+		switch requirement.Operator {
+		case LabelEquals, LabelIn:
+			ids = make(map[string]bool)
+			for _, value := range requirement.Values {
+				for id := range i.documentsByPair[labelPair(requirement.Key, value)] {
+					ids[id] = true
+				}
+			}
+		case LabelExists:
+			ids = i.documentsByKey[requirement.Key]
+		default:
+			continue
+		}
+		if !indexed || len(ids) < len(best) {
+			best, indexed = ids, true
+		}
+	}
+	if !indexed {
+		return nil, false
+	}
+	ids := make([]string, 0, len(best))
+	for id := range best {
+		ids = append(ids, id)
+	}
+	return ids, true
+}
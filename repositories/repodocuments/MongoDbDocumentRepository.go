@@ -5,9 +5,10 @@ import (
 	"errors"
 	"goapi/database"
 	"goapi/models"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/sirupsen/logrus"
 	log "github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -17,6 +18,14 @@ import (
 
 type mongoDbDocumentRepo struct {
 	store *database.MongoDatastore
+	//once enabled, each write is done in a transaction with its event in the outbox
+	outboxEnabled bool
+
+	//the repository of another tenant uses the datastore and the outbox of the repository of the default tenant
+	tenant string
+	root   *mongoDbDocumentRepo
+	//the tenants whose collections have their indexes, when each tenant has its own collections
+	indexedTenants sync.Map
 }
 
 //interface ObserverDatabase implementation
@@ -24,6 +33,127 @@ func (r *mongoDbDocumentRepo) SetDataStore(dataStore *database.MongoDatastore) {
 	r.store = dataStore
 }
 
+//rootRepo is the repository of the default tenant, that the database handler notifies
+func (r *mongoDbDocumentRepo) rootRepo() *mongoDbDocumentRepo {
+	if r.root != nil {
+		return r.root
+	}
+	return r
+}
+
+//datastore is nil until the database is available
+func (r *mongoDbDocumentRepo) datastore() *database.MongoDatastore {
+	return r.rootRepo().store
+}
+
+func (r *mongoDbDocumentRepo) ForTenant(tenant string) DocumentRepository {
+	root := r.rootRepo()
+	if tenantOrDefault(tenant) == DefaultTenant {
+		return root
+	}
+	return &mongoDbDocumentRepo{tenant: tenant, root: root}
+}
+
+//the collections of the tenants having their own collections are named tenant_<tenant>_<collection>
+const (
+	mongoTenantPrefix = "tenant_"
+	mongoTenantSuffix = "_" + database.DocumentCollectionName
+)
+
+func (r *mongoDbDocumentRepo) Tenants() ([]string, error) {
+	if r.datastore() == nil {
+		r.logger().Error("data store not available")
+		return nil, ErrNoDatastore
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var tenants []string
+	if r.tenantField() {
+		values, err := r.datastore().Database.Collection(database.DocumentCollectionName).Distinct(ctx, "tenant", bson.M{})
+		if err != nil {
+			r.logger().Error(err)
+			return nil, err
+		}
+		for _, value := range values {
+			//the documents of the default tenant have no tenant
+			if tenant, ok := value.(string); ok {
+				tenants = append(tenants, tenant)
+			}
+		}
+		return sortedTenants(tenants), nil
+	}
+
+	names, err := r.datastore().Database.ListCollectionNames(ctx, bson.M{"name": bson.M{"$regex": "^" + mongoTenantPrefix + ".+" + mongoTenantSuffix + "$"}})
+	if err != nil {
+		r.logger().Error(err)
+		return nil, err
+	}
+	for _, name := range names {
+		tenants = append(tenants, strings.TrimSuffix(strings.TrimPrefix(name, mongoTenantPrefix), mongoTenantSuffix))
+	}
+	return sortedTenants(tenants), nil
+}
+
+//logger logs with the tenant of the repository
+func (r *mongoDbDocumentRepo) logger() *log.Entry {
+	return log.WithField("tenant", tenantOrDefault(r.tenant))
+}
+
+//tenantField tells if the documents of all the tenants are in the same collections, with their tenant in a field
+func (r *mongoDbDocumentRepo) tenantField() bool {
+	return r.datastore().TenantIsolation != database.TenantIsolationCollection
+}
+
+//tenantValue is the value of the tenant field, the documents of the default tenant have none like the ones written before the tenants
+func (r *mongoDbDocumentRepo) tenantValue() interface{} {
+	if tenantOrDefault(r.tenant) == DefaultTenant {
+		return nil
+	}
+	return r.tenant
+}
+
+//scoped adds the tenant to the filter when the collections are shared by the tenants, so that no query sees another tenant
+func (r *mongoDbDocumentRepo) scoped(filter bson.M) bson.M {
+	if r.tenantField() {
+		filter["tenant"] = r.tenantValue()
+	}
+	return filter
+}
+
+//collection returns a collection of the tenant, the default tenant keeps the collections of the documents written before the tenants
+func (r *mongoDbDocumentRepo) collection(name string) *mongo.Collection {
+	if r.tenantField() || tenantOrDefault(r.tenant) == DefaultTenant {
+		return r.datastore().Database.Collection(name)
+	}
+	return r.datastore().Database.Collection(mongoTenantPrefix + r.tenant + "_" + name)
+}
+
+//documents returns the collection of the documents of the tenant, its indexes are created on its first use
+func (r *mongoDbDocumentRepo) documents() *mongo.Collection {
+	collection := r.collection(database.DocumentCollectionName)
+	if r.tenantField() || tenantOrDefault(r.tenant) == DefaultTenant {
+		return collection
+	}
+	root := r.rootRepo()
+	if _, indexed := root.indexedTenants.LoadOrStore(r.tenant, true); !indexed {
+		err := r.datastore().EnsureDocumentIndexes(collection.Name(), r.collection(database.DocumentRevisionCollectionName).Name(), false)
+		if err != nil {
+			//the indexes are created again on the next use
+			r.logger().Errorf("Cannot create the indexes of the tenant [err=%s]", err)
+			root.indexedTenants.Delete(r.tenant)
+		}
+	}
+	return collection
+}
+
+//revisions returns the collection of the revisions of the tenant
+func (r *mongoDbDocumentRepo) revisions() *mongo.Collection {
+	r.documents()
+	return r.collection(database.DocumentRevisionCollectionName)
+}
+
 func NewMongoDbDocumentRepo(databaseHandler *database.MongoDataBaseHandler) *mongoDbDocumentRepo {
 	repo := &mongoDbDocumentRepo{}
 	repo.store = databaseHandler.GetDataStore()
@@ -34,60 +164,64 @@ func NewMongoDbDocumentRepo(databaseHandler *database.MongoDataBaseHandler) *mon
 }
 
 func (r *mongoDbDocumentRepo) GetById(id string) (models.Document, error) {
-	if r.store == nil {
-		log.Error("data store not available")
-		return models.Document{}, errors.New("no datastore")
+	if r.datastore() == nil {
+		r.logger().Error("data store not available")
+		return models.Document{}, ErrNoDatastore
 	}
 
 	//create collection
-	collection := r.store.Database.Collection(database.DocumentCollectionName)
+	collection := r.documents()
 
-	//define filter
-	filter := bson.D{primitive.E{Key: "id", Value: id}}
+	//define filter, trashed documents are not found
+	filter := r.scoped(bson.M{"id": id, "deletedAt": nil})
 
 	var result models.Document
 	//search
 	err := collection.FindOne(context.Background(), filter).Decode(&result)
 	if err == mongo.ErrNoDocuments {
-		log.Info("record does not exist")
-		return models.Document{}, nil
+		r.logger().Info("record does not exist")
+		return models.Document{}, ErrNotFound
 	} else if err != nil {
-		log.Error(err)
+		r.logger().Error(err)
 		return models.Document{}, err
 	}
 	return result, nil
 }
 
-func (r *mongoDbDocumentRepo) GetAll() ([]models.Document, error) {
-	if r.store == nil {
-		log.Error("data store not available")
-		return nil, errors.New("no datastore")
+func (r *mongoDbDocumentRepo) GetAll(documentFilter DocumentFilter) ([]models.Document, error) {
+	if r.datastore() == nil {
+		r.logger().Error("data store not available")
+		return nil, ErrNoDatastore
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	collection := r.store.Database.Collection(database.DocumentCollectionName)
+	collection := r.documents()
 
 	findOptions := options.Find()
 	// Sort by `id` field ascending
 	findOptions.SetSort(bson.D{primitive.E{Key: "id", Value: 1}})
 
-	cur, err := collection.Find(ctx, bson.D{{}}, findOptions)
+	filter := bson.M{"deletedAt": nil}
+	addMongoAuditFilter(documentFilter, filter)
+	addMongoLabelFilter(documentFilter.Selector, filter)
+	addMongoACLFilter(documentFilter.ReadableBy, filter)
+	cur, err := collection.Find(ctx, r.scoped(filter), findOptions)
 
 	if err != nil {
-		log.Error(err)
+		r.logger().Error(err)
 		return nil, err
 	}
 	defer func(cursor *mongo.Cursor, ctxt *context.Context) {
 		err := cur.Close(ctx)
 		if err != nil {
-			log.Error("Cannot close context", err)
+			r.logger().Error("Cannot close context", err)
 		}
 	}(cur, &ctx)
 
 	if err := cur.Err(); err != nil {
-		log.Error(err)
+		r.logger().Error(err)
 		return nil, err
 	}
 
@@ -96,7 +230,7 @@ func (r *mongoDbDocumentRepo) GetAll() ([]models.Document, error) {
 		var result models.Document
 		err := cur.Decode(&result)
 		if err != nil {
-			log.Error(err)
+			r.logger().Error(err)
 		} else {
 			results = append(results, result)
 		}
@@ -104,63 +238,1049 @@ func (r *mongoDbDocumentRepo) GetAll() ([]models.Document, error) {
 	return results, nil
 }
 
-func (r *mongoDbDocumentRepo) CreateOrUpdate(document models.Document) (bool, error) {
-	if r.store == nil {
-		log.Error("data store not available")
-		return false, errors.New("no datastore")
+// Export reads the documents from a cursor sorted by id, the cursor has no timeout since an export lasts as long as the client reads it
+func (r *mongoDbDocumentRepo) Export(documentFilter DocumentFilter, f func(document models.Document) error) error {
+	if r.datastore() == nil {
+		r.logger().Error("data store not available")
+		return ErrNoDatastore
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	filter := bson.M{"deletedAt": nil}
+	addMongoAuditFilter(documentFilter, filter)
+	addMongoLabelFilter(documentFilter.Selector, filter)
+	addMongoACLFilter(documentFilter.ReadableBy, filter)
+	//the server would close the cursor after 10 minutes of a slow client, it is closed when the export ends instead
+	findOptions := options.Find().SetSort(bson.D{primitive.E{Key: "id", Value: 1}}).SetBatchSize(500).SetNoCursorTimeout(true)
+	cur, err := r.documents().Find(ctx, r.scoped(filter), findOptions)
+	if err != nil {
+		r.logger().Error(err)
+		return err
+	}
+	defer func() {
+		if err := cur.Close(ctx); err != nil {
+			r.logger().Error("Cannot close cursor", err)
+		}
+	}()
+
+	for cur.Next(ctx) {
+		var document models.Document
+		if err := cur.Decode(&document); err != nil {
+			r.logger().Error(err)
+			return err
+		}
+		if err := f(document); err != nil {
+			return err
+		}
+	}
+	return cur.Err()
+}
+
+//the mongo sort of a listing, ties are broken by id
+func mongoSort(sort DocumentSort) bson.D {
+	direction := 1
+	if sort.Descending {
+		direction = -1
+	}
+	if sort.field() == "id" {
+		return bson.D{primitive.E{Key: "id", Value: direction}}
+	}
+	return bson.D{primitive.E{Key: sort.field(), Value: direction}, primitive.E{Key: "id", Value: 1}}
+}
+
+//mongoTrashFilter matches the documents of the trash, or the other ones. A missing deletedAt matches nil.
+func mongoTrashFilter(trashed bool) interface{} {
+	if trashed {
+		return bson.M{"$ne": nil}
+	}
+	return nil
+}
+
+//the mongo filter for the documents after the cursor
+func mongoCursorFilter(sort DocumentSort, cursor *documentCursor) bson.M {
+	if cursor == nil {
+		return bson.M{}
+	}
+	operator := "$gt"
+	if sort.Descending {
+		operator = "$lt"
+	}
+	if sort.field() == "id" {
+		return bson.M{"id": bson.M{operator: cursor.ID}}
+	}
+	//the value was checked when the cursor was decoded
+	value, _ := sort.typedValue(cursor.Value)
+	return bson.M{"$or": bson.A{
+		bson.M{sort.field(): bson.M{operator: value}},
+		bson.M{sort.field(): value, "id": bson.M{"$gt": cursor.ID}},
+	}}
+}
+
+//addMongoAuditFilter adds the criteria of the filter on the id and the audit fields to the mongo filter
+func addMongoAuditFilter(documentFilter DocumentFilter, filter bson.M) {
+	//the cursor filter may already be on the id
+	if len(documentFilter.ID) > 0 {
+		addMongoConditions(filter, bson.M{"id": documentFilter.ID})
+	}
+	if len(documentFilter.CreatedBy) > 0 {
+		filter["createdBy"] = documentFilter.CreatedBy
+	}
+	if len(documentFilter.UpdatedBy) > 0 {
+		filter["updatedBy"] = documentFilter.UpdatedBy
+	}
+	addTimeRange := func(field string, after time.Time, before time.Time) {
+		bounds := bson.M{}
+		if !after.IsZero() {
+			bounds["$gt"] = after
+		}
+		if !before.IsZero() {
+			bounds["$lt"] = before
+		}
+		if len(bounds) > 0 {
+			filter[field] = bounds
+		}
+	}
+	addTimeRange("createdAt", documentFilter.CreatedAfter, documentFilter.CreatedBefore)
+	addTimeRange("updatedAt", documentFilter.UpdatedAfter, documentFilter.UpdatedBefore)
+}
+
+//the indexed fields the labels are queried on, written with the document
+const (
+	mongoLabelPairs = "labelPairs"
+	mongoLabelKeys  = "labelKeys"
+)
+
+//addMongoLabelFilter adds the requirements of the selector to the mongo filter, on the indexed label pairs and keys
+func addMongoLabelFilter(selector LabelSelector, filter bson.M) {
+	if len(selector) == 0 {
+		return
+	}
+	conditions := make(bson.A, 0, len(selector))
+	for _, requirement := range selector {
+		pairs := make(bson.A, 0, len(requirement.Values))
+		for _, value := range requirement.Values {
+			pairs = append(pairs, labelPair(requirement.Key, value))
+		}
+		switch requirement.Operator {
+		case LabelEquals:
+			conditions = append(conditions, bson.M{mongoLabelPairs: pairs[0]})
+		case LabelNotEquals:
+			conditions = append(conditions, bson.M{mongoLabelPairs: bson.M{"$ne": pairs[0]}})
+		case LabelIn:
+			conditions = append(conditions, bson.M{mongoLabelPairs: bson.M{"$in": pairs}})
+		case LabelNotIn:
+			conditions = append(conditions, bson.M{mongoLabelPairs: bson.M{"$nin": pairs}})
+		case LabelExists:
+			conditions = append(conditions, bson.M{mongoLabelKeys: requirement.Key})
+		case LabelDoesNotExist:
+			conditions = append(conditions, bson.M{mongoLabelKeys: bson.M{"$ne": requirement.Key}})
+		}
+	}
+	addMongoConditions(filter, conditions...)
+}
+
+//the indexed field the readers of a document are queried on, written with its ACL
+const mongoACLReaders = "aclReaders"
+
+//addMongoACLFilter keeps the documents one of the principals can read, on the indexed readers.
+//The documents without ACL have no readers and can be read by anyone.
+func addMongoACLFilter(principals []string, filter bson.M) {
+	if principals == nil {
+		return
+	}
+	addMongoConditions(filter, bson.M{"$or": bson.A{
+		bson.M{mongoACLReaders: nil},
+		bson.M{mongoACLReaders: bson.M{"$in": principals}},
+	}})
+}
+
+//addMongoConditions adds conditions that must all hold to the mongo filter, the cursor filter already uses $or
+func addMongoConditions(filter bson.M, conditions ...interface{}) {
+	and, _ := filter["$and"].(bson.A)
+	filter["$and"] = append(and, conditions...)
+}
+
+func (r *mongoDbDocumentRepo) List(query DocumentQuery) (models.DocumentPage, error) {
+	if r.datastore() == nil {
+		r.logger().Error("data store not available")
+		return models.DocumentPage{}, ErrNoDatastore
+	}
+
+	cursor, err := decodeCursor(query)
+	if err != nil {
+		return models.DocumentPage{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	collection := r.documents()
+
+	//one more document than the limit tells there is a next page
+	findOptions := options.Find()
+	findOptions.SetSort(mongoSort(query.Sort))
+	findOptions.SetLimit(int64(query.limit() + 1))
+
+	filter := mongoCursorFilter(query.Sort, cursor)
+	filter["deletedAt"] = mongoTrashFilter(query.Trashed)
+	addMongoAuditFilter(query.Filter, filter)
+	addMongoLabelFilter(query.Filter.Selector, filter)
+	addMongoACLFilter(query.Filter.ReadableBy, filter)
+	cur, err := collection.Find(ctx, r.scoped(filter), findOptions)
+	if err != nil {
+		r.logger().Error(err)
+		return models.DocumentPage{}, err
+	}
+	defer func() {
+		if err := cur.Close(ctx); err != nil {
+			r.logger().Error("Cannot close cursor", err)
+		}
+	}()
+
+	results := make([]models.Document, 0, query.limit()+1)
+	if err := cur.All(ctx, &results); err != nil {
+		r.logger().Error(err)
+		return models.DocumentPage{}, err
+	}
+	return newPage(query, results), nil
+}
+
+//a search hit as returned by the text search aggregation
+type mongoSearchHit struct {
+	models.Document `bson:",inline"`
+	Score           float64 `bson:"score"`
+}
+
+func (r *mongoDbDocumentRepo) Search(query SearchQuery) (models.SearchPage, error) {
+	if r.datastore() == nil {
+		r.logger().Error("data store not available")
+		return models.SearchPage{}, ErrNoDatastore
+	}
+
+	after, err := decodeSearchCursor(query)
+	if err != nil {
+		return models.SearchPage{}, err
+	}
+	//a text without terms matches nothing, mongo refuses an empty $search
+	terms := SearchTerms(query.Text)
+	if len(terms) == 0 {
+		return newSearchPage(query, nil), nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	collection := r.documents()
+
+	//search the terms as they are indexed in memory, so that both repositories understand the text the same way
+	match := bson.M{"$text": bson.M{"$search": strings.Join(terms, " ")}, "deletedAt": nil}
+	addMongoACLFilter(query.ReadableBy, match)
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: r.scoped(match)}},
+		{{Key: "$addFields", Value: bson.M{"score": bson.M{"$meta": "textScore"}}}},
+	}
+	//keep only the hits after the cursor
+	if after != nil {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.M{"$or": bson.A{
+			bson.M{"score": bson.M{"$lt": after.Score}},
+			bson.M{"score": after.Score, "id": bson.M{"$gt": after.Document.ID}},
+		}}}})
+	}
+	//one more hit than the limit tells there is a next page
+	pipeline = append(pipeline,
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "score", Value: -1}, {Key: "id", Value: 1}}}},
+		bson.D{{Key: "$limit", Value: query.limit() + 1}},
+	)
+
+	cur, err := collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		r.logger().Error(err)
+		return models.SearchPage{}, err
+	}
+	defer func() {
+		if err := cur.Close(ctx); err != nil {
+			r.logger().Error("Cannot close cursor", err)
+		}
+	}()
+
+	var results []mongoSearchHit
+	if err := cur.All(ctx, &results); err != nil {
+		r.logger().Error(err)
+		return models.SearchPage{}, err
+	}
+	hits := make([]models.SearchHit, 0, len(results))
+	for _, result := range results {
+		hits = append(hits, models.SearchHit{Document: result.Document, Score: result.Score})
+	}
+	return newSearchPage(query, hits), nil
+}
+
+//exists tells if a document with the id is stored out of the trash, used to tell apart a failed precondition from a missing document
+func (r *mongoDbDocumentRepo) exists(ctx context.Context, collection *mongo.Collection, id string) (bool, error) {
+	count, err := collection.CountDocuments(ctx, r.scoped(bson.M{"id": id, "deletedAt": nil}), options.Count().SetLimit(1))
+	if err != nil {
+		r.logger().Error(err)
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (r *mongoDbDocumentRepo) CreateOrUpdate(document models.Document, precondition Precondition) (models.Document, bool, error) {
+	if r.datastore() == nil {
+		r.logger().Error("data store not available")
+		return models.Document{}, false, ErrNoDatastore
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
 	//create collection
-	collection := r.store.Database.Collection(database.DocumentCollectionName)
+	collection := r.documents()
+
+	var stored models.Document
+	var found bool
+	write := func(ctx context.Context) error {
+		var err error
+		stored, found, err = r.createOrUpdate(ctx, collection, document, precondition)
+		return err
+	}
+	err := r.transaction(ctx, write)
+	if errors.Is(err, errCreatedConcurrently) {
+		//the document now exists, the write updates it
+		err = r.transaction(ctx, write)
+	}
+	return stored, found, err
+}
+
+//errCreatedConcurrently is returned when the upsert of a new document loses against a concurrent one on the unique index
+var errCreatedConcurrently = NewError(ErrConflict, "document created concurrently")
 
-	//insert or update data
-	filter := bson.M{"id": document.ID}
+//createOrUpdate writes the document, a trashed document is replaced as if it did not exist
+func (r *mongoDbDocumentRepo) createOrUpdate(ctx context.Context, collection *mongo.Collection, document models.Document, precondition Precondition) (models.Document, bool, error) {
+	//insert or update data, compare and swap on the version if asked. The upsert sets the tenant from the filter.
+	filter := r.scoped(bson.M{"id": document.ID})
+	switch {
+	case precondition.MustNotExist:
+		//only a trashed document can be replaced, otherwise the unique index on id rejects the upsert
+		filter["deletedAt"] = bson.M{"$ne": nil}
+	case precondition.requiresExistence():
+		filter["deletedAt"] = nil
+		if precondition.IfMatch > 0 {
+			filter["version"] = precondition.IfMatch
+		}
+	}
 
 	pByte, err := bson.Marshal(document)
 	if err != nil {
-		logrus.Errorf("can't marshal:%s", err)
+		r.logger().Errorf("can't marshal:%s", err)
 	}
 
 	var update bson.M
 	err = bson.Unmarshal(pByte, &update)
 	if err != nil {
-		logrus.Errorf("can't unmarshal:%s", err)
+		r.logger().Errorf("can't unmarshal:%s", err)
+	}
+	//the version is only incremented by the server, and the document leaves the trash
+	delete(update, "version")
+	delete(update, "deletedAt")
+	//the creation and the ACL are only set by the insert, an update or a document of the trash keeps them
+	delete(update, "createdAt")
+	delete(update, "createdBy")
+	delete(update, "acl")
+	onInsert := bson.M{"createdAt": document.CreatedAt, "createdBy": document.CreatedBy}
+	if document.ACL != nil {
+		onInsert["acl"] = document.ACL
+		onInsert[mongoACLReaders] = aclReaders(document.ACL)
 	}
+	//the labels are queried on their pairs and keys, the keys can contain dots that mongo paths cannot address
+	update[mongoLabelPairs] = labelPairs(document.Labels)
+	update[mongoLabelKeys] = labelKeys(document.Labels)
 
-	// NOTE: filter and ctx(Context) should be already defined
-	res, err := collection.UpdateOne(ctx, filter, bson.D{{Key: "$set", Value: update}})
+	//the previous state tells if the document was created or updated
+	updateOptions := options.FindOneAndUpdate().
+		SetUpsert(!precondition.requiresExistence()).
+		SetReturnDocument(options.Before)
+	var previous models.Document
+	err = collection.FindOneAndUpdate(ctx, filter, bson.D{
+		{Key: "$set", Value: update},
+		{Key: "$setOnInsert", Value: onInsert},
+		{Key: "$unset", Value: bson.M{"deletedAt": ""}},
+		{Key: "$inc", Value: bson.M{"version": 1}},
+	}, updateOptions).Decode(&previous)
 
+	if mongo.IsDuplicateKeyError(err) {
+		if precondition.MustNotExist {
+			return models.Document{}, true, ErrPreconditionFailed
+		}
+		return models.Document{}, false, errCreatedConcurrently
+	}
+	if err == mongo.ErrNoDocuments {
+		if precondition.requiresExistence() {
+			found, err := r.exists(ctx, collection, document.ID)
+			if err != nil {
+				return models.Document{}, false, err
+			}
+			return models.Document{}, found, ErrPreconditionFailed
+		}
+		document.Version = 1
+		document.DeletedAt = nil
+		return document, false, r.recordEvent(ctx, newDocumentEvent(document, false))
+	}
 	if err != nil {
-		logrus.Error(err.Error())
-		return false, err
+		r.logger().Error(err.Error())
+		return models.Document{}, false, err
 	}
 
-	if res.MatchedCount > 0 {
-		return true, err
+	document.Version = previous.Version + 1
+	document.DeletedAt = nil
+	document.CreatedAt = previous.CreatedAt
+	document.CreatedBy = previous.CreatedBy
+	document.ACL = previous.ACL
+	return document, !previous.Trashed(), r.recordEvent(ctx, newDocumentEvent(document, !previous.Trashed()))
+}
+
+func (r *mongoDbDocumentRepo) SetACL(id string, acl models.DocumentACL, precondition Precondition) (models.Document, error) {
+	if r.datastore() == nil {
+		r.logger().Error("data store not available")
+		return models.Document{}, ErrNoDatastore
 	}
 
-	_, err = collection.InsertOne(ctx, document)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	collection := r.documents()
+
+	filter := r.scoped(bson.M{"id": id, "deletedAt": nil})
+	if precondition.IfMatch > 0 {
+		filter["version"] = precondition.IfMatch
+	}
 
-	return false, err
+	var updated models.Document
+	err := r.transaction(ctx, func(ctx context.Context) error {
+		//the readers are written with the ACL, the listings are filtered on them
+		err := collection.FindOneAndUpdate(ctx, filter, bson.D{
+			{Key: "$set", Value: bson.M{"acl": acl, mongoACLReaders: aclReaders(&acl)}},
+			{Key: "$inc", Value: bson.M{"version": 1}},
+		}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&updated)
+		if err == mongo.ErrNoDocuments {
+			found, err := r.exists(ctx, collection, id)
+			switch {
+			case err != nil:
+				return err
+			case found || precondition.requiresExistence():
+				return ErrPreconditionFailed
+			default:
+				return ErrNotFound
+			}
+		}
+		if err != nil {
+			r.logger().Error(err)
+			return err
+		}
+		return r.recordEvent(ctx, newDocumentEvent(updated, true))
+	})
+	if err != nil {
+		return models.Document{}, err
+	}
+	return updated, nil
 }
 
-func (r *mongoDbDocumentRepo) Delete(id string) (bool, error) {
-	if r.store == nil {
-		log.Error("data store not available")
-		return false, errors.New("no datastore")
+func (r *mongoDbDocumentRepo) Delete(id string, precondition Precondition) (bool, error) {
+	if r.datastore() == nil {
+		r.logger().Error("data store not available")
+		return false, ErrNoDatastore
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	collection := r.store.Database.Collection(database.DocumentCollectionName)
+	collection := r.documents()
+
+	var found bool
+	err := r.transaction(ctx, func(ctx context.Context) error {
+		var err error
+		found, err = r.delete(ctx, collection, id, precondition)
+		return err
+	})
+	return found, err
+}
 
+//delete moves the document to the trash
+func (r *mongoDbDocumentRepo) delete(ctx context.Context, collection *mongo.Collection, id string, precondition Precondition) (bool, error) {
 	//Define filter query for fetching specific document from collection
-	filter := bson.D{primitive.E{Key: "id", Value: id}}
+	filter := r.scoped(bson.M{"id": id, "deletedAt": nil})
+	if precondition.IfMatch > 0 {
+		filter["version"] = precondition.IfMatch
+	}
+
+	var trashed models.Document
+	err := collection.FindOneAndUpdate(ctx, filter, bson.D{
+		{Key: "$set", Value: bson.M{"deletedAt": time.Now().UTC()}},
+		{Key: "$inc", Value: bson.M{"version": 1}},
+	}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&trashed)
+	if err == mongo.ErrNoDocuments {
+		if precondition.requiresExistence() {
+			found, err := r.exists(ctx, collection, id)
+			if err != nil {
+				return false, err
+			}
+			return found, ErrPreconditionFailed
+		}
+		return false, nil
+	}
+	if err != nil {
+		r.logger().Error(err)
+		return false, err
+	}
+	return true, r.recordEvent(ctx, newDocumentEvent(trashed, true))
+}
+
+func (r *mongoDbDocumentRepo) Restore(id string) (models.Document, error) {
+	if r.datastore() == nil {
+		r.logger().Error("data store not available")
+		return models.Document{}, ErrNoDatastore
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	collection := r.documents()
+
+	var restored models.Document
+	err := r.transaction(ctx, func(ctx context.Context) error {
+		err := collection.FindOneAndUpdate(ctx, r.scoped(bson.M{"id": id, "deletedAt": bson.M{"$ne": nil}}), bson.D{
+			{Key: "$unset", Value: bson.M{"deletedAt": ""}},
+			{Key: "$inc", Value: bson.M{"version": 1}},
+		}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&restored)
+		if err == mongo.ErrNoDocuments {
+			return ErrNotFound
+		}
+		if err != nil {
+			r.logger().Error(err)
+			return err
+		}
+		return r.recordEvent(ctx, newDocumentEvent(restored, false))
+	})
+	if err != nil {
+		return models.Document{}, err
+	}
+	return restored, nil
+}
+
+func (r *mongoDbDocumentRepo) Purge(deletedBefore time.Time) ([]string, error) {
+	if r.datastore() == nil {
+		r.logger().Error("data store not available")
+		return nil, ErrNoDatastore
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	collection := r.documents()
+
+	//remove the documents one by one, so that only the ids of the removed documents are returned
+	filter := r.scoped(bson.M{"deletedAt": bson.M{"$lt": deletedBefore}})
+	cur, err := collection.Find(ctx, filter, options.Find().SetProjection(bson.M{"id": 1}))
+	if err != nil {
+		r.logger().Error(err)
+		return nil, err
+	}
+	defer func() {
+		if err := cur.Close(ctx); err != nil {
+			r.logger().Error("Cannot close cursor", err)
+		}
+	}()
+
+	purged := make([]string, 0)
+	for cur.Next(ctx) {
+		var document models.Document
+		if err := cur.Decode(&document); err != nil {
+			r.logger().Error(err)
+			continue
+		}
+		removed, err := r.purgeOne(ctx, collection, document.ID, deletedBefore)
+		if err != nil {
+			r.logger().Error(err)
+			return purged, err
+		}
+		if removed {
+			purged = append(purged, document.ID)
+		}
+	}
+	return purged, cur.Err()
+}
 
-	result, err := collection.DeleteOne(ctx, filter)
-	return result.DeletedCount > 0, err
+//purgeOne removes a trashed document with its revisions, and tells whether the document was removed
+func (r *mongoDbDocumentRepo) purgeOne(ctx context.Context, collection *mongo.Collection, id string, deletedBefore time.Time) (bool, error) {
+	removed := false
+	err := r.transaction(ctx, func(ctx context.Context) error {
+		removed = false
+		result, err := collection.DeleteOne(ctx, r.scoped(bson.M{"id": id, "deletedAt": bson.M{"$lt": deletedBefore}}))
+		if err != nil || result.DeletedCount == 0 {
+			return err
+		}
+		removed = true
+		_, err = r.revisions().DeleteMany(ctx, r.scoped(bson.M{"documentId": id}))
+		return err
+	})
+	return removed, err
+}
+
+func (r *mongoDbDocumentRepo) apply(ctx context.Context, collection *mongo.Collection, operation Operation) OperationResult {
+	if operation.Delete {
+		found, err := r.delete(ctx, collection, operation.Document.ID, operation.Precondition)
+		if err == nil && !found {
+			err = ErrNotFound
+		}
+		return OperationResult{Existed: found, Err: err}
+	}
+	document, found, err := r.createOrUpdate(ctx, collection, operation.Document, operation.Precondition)
+	return OperationResult{Document: document, Existed: found, Err: err}
+}
+
+//errBatchFailed aborts the transaction of an atomic batch when an operation fails
+var errBatchFailed = errors.New("batch failed")
+
+func (r *mongoDbDocumentRepo) ApplyBatch(operations []Operation, atomic bool) ([]OperationResult, error) {
+	if r.datastore() == nil {
+		r.logger().Error("data store not available")
+		return nil, ErrNoDatastore
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	collection := r.documents()
+
+	results := make([]OperationResult, len(operations))
+	if !atomic {
+		for i, operation := range operations {
+			err := r.transaction(ctx, func(ctx context.Context) error {
+				results[i] = r.apply(ctx, collection, operation)
+				return results[i].Err
+			})
+			if results[i].Err == nil && err != nil {
+				results[i] = OperationResult{Err: err}
+			}
+		}
+		return results, nil
+	}
+
+	//an atomic batch is a transaction, it needs mongo to run as a replica set
+	session, err := r.datastore().Session.StartSession()
+	if err != nil {
+		r.logger().Error(err)
+		return nil, err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessionContext mongo.SessionContext) (interface{}, error) {
+		//the transaction may be retried, so start again from the first operation
+		for i, operation := range operations {
+			results[i] = r.apply(sessionContext, collection, operation)
+			switch results[i].Err {
+			case nil:
+			case ErrPreconditionFailed, ErrNotFound, errCreatedConcurrently:
+				rollBack(results, i)
+				return nil, errBatchFailed
+			default:
+				//keep the mongo error so that the transaction can be retried on transient errors
+				return nil, results[i].Err
+			}
+		}
+		return nil, nil
+	})
+	if err == errBatchFailed {
+		return results, nil
+	}
+	if err != nil {
+		r.logger().Error(err)
+		return nil, err
+	}
+	return results, nil
+}
+
+func (r *mongoDbDocumentRepo) EnableOutbox() {
+	r.rootRepo().outboxEnabled = true
+}
+
+//transaction runs the write in a transaction when the outbox is enabled, so that the event is written with the document.
+//A transaction needs mongo to run as a replica set.
+func (r *mongoDbDocumentRepo) transaction(ctx context.Context, write func(ctx context.Context) error) error {
+	if !r.rootRepo().outboxEnabled {
+		return write(ctx)
+	}
+	session, err := r.datastore().Session.StartSession()
+	if err != nil {
+		r.logger().Error(err)
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessionContext mongo.SessionContext) (interface{}, error) {
+		return nil, write(sessionContext)
+	})
+	return err
+}
+
+//an event of the outbox, the object id orders the events
+type mongoOutboxEvent struct {
+	ID                   primitive.ObjectID `bson:"_id"`
+	models.DocumentEvent `bson:",inline"`
+}
+
+//recordEvent writes the event in the outbox, in the transaction of the write when ctx is the context of a transaction
+func (r *mongoDbDocumentRepo) recordEvent(ctx context.Context, event models.DocumentEvent) error {
+	if !r.rootRepo().outboxEnabled {
+		return nil
+	}
+	collection := r.datastore().Database.Collection(database.DocumentOutboxCollectionName)
+	event.Tenant = tenantOrDefault(r.tenant)
+	if _, err := collection.InsertOne(ctx, mongoOutboxEvent{ID: primitive.NewObjectID(), DocumentEvent: event}); err != nil {
+		r.logger().Error(err)
+		return err
+	}
+	return nil
+}
+
+func (r *mongoDbDocumentRepo) PendingEvents(limit int) ([]models.DocumentEvent, error) {
+	if r.datastore() == nil {
+		r.logger().Error("data store not available")
+		return nil, ErrNoDatastore
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	collection := r.datastore().Database.Collection(database.DocumentOutboxCollectionName)
+
+	findOptions := options.Find().SetSort(bson.D{primitive.E{Key: "_id", Value: 1}}).SetLimit(int64(limit))
+	cur, err := collection.Find(ctx, bson.M{}, findOptions)
+	if err != nil {
+		r.logger().Error(err)
+		return nil, err
+	}
+	defer func() {
+		if err := cur.Close(ctx); err != nil {
+			r.logger().Error("Cannot close cursor", err)
+		}
+	}()
+
+	var stored []mongoOutboxEvent
+	if err := cur.All(ctx, &stored); err != nil {
+		r.logger().Error(err)
+		return nil, err
+	}
+	events := make([]models.DocumentEvent, 0, len(stored))
+	for _, event := range stored {
+		event.DocumentEvent.ID = event.ID.Hex()
+		events = append(events, event.DocumentEvent)
+	}
+	//the object ids of different instances are not strictly ordered, but the versions of a document are
+	orderByVersion(events)
+	return events, nil
+}
+
+func (r *mongoDbDocumentRepo) RemoveEvents(ids []string) error {
+	if r.datastore() == nil {
+		r.logger().Error("data store not available")
+		return ErrNoDatastore
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	collection := r.datastore().Database.Collection(database.DocumentOutboxCollectionName)
+
+	objectIDs := make(bson.A, 0, len(ids))
+	for _, id := range ids {
+		objectID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return err
+		}
+		objectIDs = append(objectIDs, objectID)
+	}
+	if _, err := collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": objectIDs}}); err != nil {
+		r.logger().Error(err)
+		return err
+	}
+	return nil
+}
+
+//AcquireRelayLease updates the lock document when it is expired or already held by holder, and inserts it when there is none.
+//When another holder has it, the filter does not match and the insert fails on its id.
+func (r *mongoDbDocumentRepo) AcquireRelayLease(holder string, duration time.Duration) (bool, error) {
+	if r.datastore() == nil {
+		r.logger().Error("data store not available")
+		return false, ErrNoDatastore
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	collection := r.datastore().Database.Collection(database.DocumentOutboxLeaseCollectionName)
+
+	now := time.Now().UTC()
+	filter := bson.M{"_id": relayLeaseName, "$or": bson.A{bson.M{"holder": holder}, bson.M{"expiresAt": bson.M{"$lt": now}}}}
+	update := bson.M{"$set": bson.M{"holder": holder, "expiresAt": now.Add(duration)}}
+	err := collection.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetUpsert(true)).Err()
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	//the upsert returns no document when it inserts the lock
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		r.logger().Error(err)
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *mongoDbDocumentRepo) ReleaseRelayLease(holder string) error {
+	if r.datastore() == nil {
+		r.logger().Error("data store not available")
+		return ErrNoDatastore
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	collection := r.datastore().Database.Collection(database.DocumentOutboxLeaseCollectionName)
+	if _, err := collection.DeleteOne(ctx, bson.M{"_id": relayLeaseName, "holder": holder}); err != nil {
+		r.logger().Error(err)
+		return err
+	}
+	return nil
+}
+
+//a revision of the collection of revisions, with its tenant when the collection is shared by the tenants
+type mongoRevision struct {
+	Tenant                  interface{} `bson:"tenant,omitempty"`
+	models.DocumentRevision `bson:",inline"`
+}
+
+//revisionTenant is the tenant written with the revisions, none for the default tenant or when the tenant has its own collections
+func (r *mongoDbDocumentRepo) revisionTenant() interface{} {
+	if r.tenantField() {
+		return r.tenantValue()
+	}
+	return nil
+}
+
+//lastRevision returns the last revision number of a document, 0 if it has no revision
+func (r *mongoDbDocumentRepo) lastRevision(ctx context.Context, collection *mongo.Collection, id string) (int64, error) {
+	var last models.DocumentRevision
+	findOptions := options.FindOne().SetSort(bson.D{primitive.E{Key: "revision", Value: -1}})
+	err := collection.FindOne(ctx, r.scoped(bson.M{"documentId": id}), findOptions).Decode(&last)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	return last.Revision, err
+}
+
+func (r *mongoDbDocumentRepo) AddRevision(revision models.DocumentRevision, maxRevisions int) (models.DocumentRevision, error) {
+	if r.datastore() == nil {
+		r.logger().Error("data store not available")
+		return models.DocumentRevision{}, ErrNoDatastore
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	collection := r.revisions()
+
+	//the unique index on documentId and revision rejects a revision number taken by a concurrent write, then try the next one
+	for attempt := 0; ; attempt++ {
+		last, err := r.lastRevision(ctx, collection, revision.DocumentID)
+		if err != nil {
+			r.logger().Error(err)
+			return models.DocumentRevision{}, err
+		}
+		revision.Revision = last + 1
+		_, err = collection.InsertOne(ctx, mongoRevision{Tenant: r.revisionTenant(), DocumentRevision: revision})
+		if err == nil {
+			break
+		}
+		if !mongo.IsDuplicateKeyError(err) || attempt >= 5 {
+			r.logger().Error(err)
+			return models.DocumentRevision{}, err
+		}
+	}
+
+	//drop the oldest revisions
+	if maxRevisions > 0 && revision.Revision > int64(maxRevisions) {
+		filter := r.scoped(bson.M{"documentId": revision.DocumentID, "revision": bson.M{"$lte": revision.Revision - int64(maxRevisions)}})
+		if _, err := collection.DeleteMany(ctx, filter); err != nil {
+			r.logger().Error("Cannot drop old revisions", err)
+		}
+	}
+	return revision, nil
+}
+
+func (r *mongoDbDocumentRepo) GetRevisions(id string) ([]models.DocumentRevision, error) {
+	if r.datastore() == nil {
+		r.logger().Error("data store not available")
+		return nil, ErrNoDatastore
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	collection := r.revisions()
+
+	findOptions := options.Find().SetSort(bson.D{primitive.E{Key: "revision", Value: 1}})
+	cur, err := collection.Find(ctx, r.scoped(bson.M{"documentId": id}), findOptions)
+	if err != nil {
+		r.logger().Error(err)
+		return nil, err
+	}
+	defer func() {
+		if err := cur.Close(ctx); err != nil {
+			r.logger().Error("Cannot close cursor", err)
+		}
+	}()
+
+	results := make([]models.DocumentRevision, 0)
+	if err := cur.All(ctx, &results); err != nil {
+		r.logger().Error(err)
+		return nil, err
+	}
+	return results, nil
+}
+
+func (r *mongoDbDocumentRepo) GetRevision(id string, revision int64) (models.DocumentRevision, bool, error) {
+	if r.datastore() == nil {
+		r.logger().Error("data store not available")
+		return models.DocumentRevision{}, false, ErrNoDatastore
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	collection := r.revisions()
+
+	var result models.DocumentRevision
+	err := collection.FindOne(ctx, r.scoped(bson.M{"documentId": id, "revision": revision})).Decode(&result)
+	if err == mongo.ErrNoDocuments {
+		return models.DocumentRevision{}, false, nil
+	}
+	if err != nil {
+		r.logger().Error(err)
+		return models.DocumentRevision{}, false, err
+	}
+	return result, true, nil
+}
+
+//a change stream event of the documents collection
+type mongoChangeEvent struct {
+	OperationType     string              `bson:"operationType"`
+	FullDocument      *models.Document    `bson:"fullDocument"`
+	ClusterTime       primitive.Timestamp `bson:"clusterTime"`
+	UpdateDescription struct {
+		UpdatedFields bson.M   `bson:"updatedFields"`
+		RemovedFields []string `bson:"removedFields"`
+	} `bson:"updateDescription"`
+}
+
+//the mongo errors telling a change stream cannot be resumed from a token
+var mongoResumeErrorCodes = map[int32]bool{
+	260: true, //InvalidResumeToken
+	280: true, //ChangeStreamFatalError
+	286: true, //ChangeStreamHistoryLost
+}
+
+//change returns the document change of a change stream event, false if the event is not a change of a document.
+//A move to the trash is a deletion, and a move back from the trash is a creation.
+func (e mongoChangeEvent) change() (models.DocumentChange, bool) {
+	if e.FullDocument == nil {
+		return models.DocumentChange{}, false
+	}
+	change := models.DocumentChange{
+		DocumentID: e.FullDocument.ID,
+		Document:   e.FullDocument,
+		Timestamp:  time.Unix(int64(e.ClusterTime.T), 0).UTC(),
+		//the document of the trash keeps its ACL, so a deletion is filtered like the other changes
+		ACL: e.FullDocument.ACL,
+	}
+	_, trashed := e.UpdateDescription.UpdatedFields["deletedAt"]
+	untrashed := false
+	for _, field := range e.UpdateDescription.RemovedFields {
+		untrashed = untrashed || field == "deletedAt"
+	}
+	switch {
+	case e.OperationType == "insert" || untrashed:
+		change.Type = models.ChangeCreated
+	case trashed:
+		change.Type = models.ChangeDeleted
+		change.Document = nil
+	case e.FullDocument.Trashed():
+		return models.DocumentChange{}, false
+	default:
+		change.Type = models.ChangeUpdated
+	}
+	return change, true
+}
+
+//Watch streams the changes with a mongo change stream, it needs mongo to run as a replica set.
+//The event id is the resume token of the change stream.
+func (r *mongoDbDocumentRepo) Watch(ctx context.Context, lastEventID string) (<-chan models.DocumentChange, error) {
+	if r.datastore() == nil {
+		r.logger().Error("data store not available")
+		return nil, ErrNoDatastore
+	}
+
+	collection := r.documents()
+
+	match := bson.M{"operationType": bson.M{"$in": bson.A{"insert", "update", "replace"}}}
+	if r.tenantField() {
+		match["fullDocument.tenant"] = r.tenantValue()
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+	}
+	streamOptions := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+	if len(lastEventID) > 0 {
+		streamOptions.SetResumeAfter(bson.M{"_data": lastEventID})
+	}
+	stream, err := collection.Watch(ctx, pipeline, streamOptions)
+	var commandError mongo.CommandError
+	if errors.As(err, &commandError) && mongoResumeErrorCodes[commandError.Code] {
+		return nil, ErrUnknownEvent
+	}
+	if err != nil {
+		r.logger().Error(err)
+		return nil, err
+	}
+
+	changes := make(chan models.DocumentChange)
+	go func() {
+		defer close(changes)
+		defer func() {
+			if err := stream.Close(context.Background()); err != nil {
+				r.logger().Error("Cannot close change stream", err)
+			}
+		}()
+		for stream.Next(ctx) {
+			var event mongoChangeEvent
+			if err := stream.Decode(&event); err != nil {
+				r.logger().Error(err)
+				continue
+			}
+			change, isChange := event.change()
+			if !isChange {
+				continue
+			}
+			change.EventID = stream.ResumeToken().Lookup("_data").StringValue()
+			select {
+			case changes <- change:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if err := stream.Err(); err != nil && ctx.Err() == nil {
+			r.logger().Error("Change stream failed", err)
+		}
+	}()
+	return changes, nil
 }
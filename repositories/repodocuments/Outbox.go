@@ -0,0 +1,93 @@
+package repodocuments
+
+import (
+	"goapi/models"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Outbox keeps the events of the writes until they are published.
+// Once enabled, the repository records the event of a write with the write itself, so that no event is lost.
+type Outbox interface {
+	EnableOutbox()
+	// PendingEvents returns the oldest events not published yet, in the order of the writes
+	PendingEvents(limit int) ([]models.DocumentEvent, error)
+	// RemoveEvents removes the published events from the outbox
+	RemoveEvents(ids []string) error
+	// AcquireRelayLease takes the lease of the relay for holder, or extends it when holder already has it.
+	// It returns false while another holder has it, only the holder of the lease publishes so that the events stay in order.
+	AcquireRelayLease(holder string, duration time.Duration) (bool, error)
+	// ReleaseRelayLease gives the lease of holder back, another relay can take it without waiting for its end
+	ReleaseRelayLease(holder string) error
+}
+
+//relayLeaseName is the name of the lease of the relay, the outbox of all the tenants has a single relay
+const relayLeaseName = "relay"
+
+//localLease is the lease of the relay of the repositories of a single process
+type localLease struct {
+	holder    string
+	expiresAt time.Time
+	lock      sync.Mutex
+}
+
+func (l *localLease) acquire(holder string, duration time.Duration) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	now := time.Now()
+	if l.holder != holder && now.Before(l.expiresAt) {
+		return false
+	}
+	l.holder, l.expiresAt = holder, now.Add(duration)
+	return true
+}
+
+func (l *localLease) release(holder string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.holder == holder {
+		l.holder, l.expiresAt = "", time.Time{}
+	}
+}
+
+//newDocumentEvent returns the event of a write, existed tells if the document existed before the write
+func newDocumentEvent(document models.Document, existed bool) models.DocumentEvent {
+	event := models.DocumentEvent{
+		Type:       models.EventDocumentCreated,
+		DocumentID: document.ID,
+		Version:    document.Version,
+		OccurredAt: time.Now().UTC(),
+	}
+	switch {
+	case document.Trashed():
+		event.Type = models.EventDocumentDeleted
+		return event
+	case existed:
+		event.Type = models.EventDocumentUpdated
+	}
+	event.Document = &document
+	return event
+}
+
+//orderByVersion sorts the events of each document by version, the events of a document keep the positions they take
+func orderByVersion(events []models.DocumentEvent) {
+	positions := make(map[string][]int)
+	for i, event := range events {
+		positions[event.DocumentID] = append(positions[event.DocumentID], i)
+	}
+	for _, indexes := range positions {
+		byVersion := make([]models.DocumentEvent, 0, len(indexes))
+		for _, i := range indexes {
+			byVersion = append(byVersion, events[i])
+		}
+		sort.Slice(byVersion, func(a, b int) bool {
+			return byVersion[a].Version < byVersion[b].Version
+		})
+		for k, i := range indexes {
+			events[i] = byVersion[k]
+		}
+	}
+}
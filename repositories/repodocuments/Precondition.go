@@ -0,0 +1,35 @@
+package repodocuments
+
+import (
+	"goapi/models"
+)
+
+var ErrPreconditionFailed = NewError(ErrConflict, "precondition failed")
+
+// Precondition restricts a write to a given state of the stored document
+type Precondition struct {
+	// IfMatch is the version the stored document must have, 0 means any version
+	IfMatch int64
+	// MustExist tells the document must already exist
+	MustExist bool
+	// MustNotExist tells the document must not exist yet (create only)
+	MustNotExist bool
+}
+
+// check tells if the precondition holds for the stored document, found is false if there is no stored document
+func (p Precondition) check(stored models.Document, found bool) error {
+	if found && p.MustNotExist {
+		return ErrPreconditionFailed
+	}
+	if !found && (p.MustExist || p.IfMatch > 0) {
+		return ErrPreconditionFailed
+	}
+	if found && p.IfMatch > 0 && stored.Version != p.IfMatch {
+		return ErrPreconditionFailed
+	}
+	return nil
+}
+
+func (p Precondition) requiresExistence() bool {
+	return p.MustExist || p.IfMatch > 0
+}
@@ -0,0 +1,998 @@
+package repodocuments
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"goapi/database"
+	"goapi/models"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//errConcurrentWrite tells a document has been written by another transaction since it was read, the write is tried again
+var errConcurrentWrite = errors.New("concurrent write")
+
+//maxWriteAttempts is how many times a write is tried when other transactions write the same documents
+const maxWriteAttempts = 5
+
+//sqlSortColumns are the columns of the sortable fields, they hold the values of the sort as DocumentSort reads them
+var sqlSortColumns = map[string]string{
+	"id":        "id",
+	"name":      "name",
+	"createdAt": "created_at",
+	"createdBy": "created_by",
+	"updatedAt": "updated_at",
+	"updatedBy": "updated_by",
+}
+
+// sqlDocumentRepo keeps the documents in a sql database, the same statements run on sqlite and postgres but the full-text search.
+// The documents are stored in json with the columns the listings filter and sort on, their labels and their readers are
+// written in their own tables with them so that the label selectors and the ACLs are filtered by the database too.
+type sqlDocumentRepo struct {
+	handler *database.SQLDataBaseHandler
+	//once enabled, each write records its event in the outbox in its transaction
+	outboxEnabled bool
+
+	//the repository of another tenant uses the database and the outbox of the repository of the default tenant
+	tenant string
+	root   *sqlDocumentRepo
+}
+
+func NewSQLDocumentRepo(databaseHandler *database.SQLDataBaseHandler) *sqlDocumentRepo {
+	return &sqlDocumentRepo{handler: databaseHandler}
+}
+
+//rootRepo is the repository of the default tenant, that keeps the state of the outbox
+func (r *sqlDocumentRepo) rootRepo() *sqlDocumentRepo {
+	if r.root != nil {
+		return r.root
+	}
+	return r
+}
+
+func (r *sqlDocumentRepo) ForTenant(tenant string) DocumentRepository {
+	root := r.rootRepo()
+	if tenantOrDefault(tenant) == DefaultTenant {
+		return root
+	}
+	return &sqlDocumentRepo{handler: root.handler, tenant: tenant, root: root}
+}
+
+//logger logs with the tenant of the repository
+func (r *sqlDocumentRepo) logger() *log.Entry {
+	return log.WithField("tenant", tenantOrDefault(r.tenant))
+}
+
+//database returns the pool of connections, ErrNoDatastore until the database is opened
+func (r *sqlDocumentRepo) database() (*sql.DB, error) {
+	db := r.handler.GetDB()
+	if db == nil {
+		r.logger().Error("data store not available")
+		return nil, ErrNoDatastore
+	}
+	return db, nil
+}
+
+func (r *sqlDocumentRepo) Tenants() ([]string, error) {
+	db, err := r.database()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT DISTINCT tenant FROM document")
+	if err != nil {
+		r.logger().Error(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tenants []string
+	for rows.Next() {
+		var tenant string
+		if err := rows.Scan(&tenant); err != nil {
+			r.logger().Error(err)
+			return nil, err
+		}
+		tenants = append(tenants, tenant)
+	}
+	if err := rows.Err(); err != nil {
+		r.logger().Error(err)
+		return nil, err
+	}
+	return sortedTenants(tenants), nil
+}
+
+//sqlCondition is a condition of a where clause with its arguments, written with ? that are numbered when the clause is built
+type sqlCondition struct {
+	text string
+	args []interface{}
+}
+
+//whereClause returns the where clause of the conditions with the numbered placeholders of postgres, that sqlite also reads
+func whereClause(conditions []sqlCondition) (string, []interface{}) {
+	text, args := joinConditions(conditions, " AND ")
+	return " WHERE " + text, args
+}
+
+//joinConditions joins the texts of the conditions with the separator, and numbers their placeholders in the order of their arguments
+func joinConditions(conditions []sqlCondition, separator string) (string, []interface{}) {
+	var args []interface{}
+	texts := make([]string, 0, len(conditions))
+	for _, condition := range conditions {
+		text := condition.text
+		for _, arg := range condition.args {
+			args = append(args, arg)
+			text = strings.Replace(text, "?", "$"+strconv.Itoa(len(args)), 1)
+		}
+		texts = append(texts, text)
+	}
+	return strings.Join(texts, separator), args
+}
+
+//sqlTime is the value of a time column, the times sort as their values
+func sqlTime(t time.Time) string {
+	return t.UTC().Format(SortTimeLayout)
+}
+
+//conditions returns the conditions of the documents of the tenant, from the trash or not, that may match the filter.
+//The time columns are to the millisecond, so their bounds are included and the documents are checked on the exact times.
+//The columns are qualified, the search joins the documents with its index.
+func (r *sqlDocumentRepo) conditions(trashed bool, filter DocumentFilter) []sqlCondition {
+	conditions := []sqlCondition{{text: "document.tenant = ?", args: []interface{}{tenantOrDefault(r.tenant)}}}
+	if trashed {
+		conditions = append(conditions, sqlCondition{text: "document.deleted_at IS NOT NULL"})
+	} else {
+		conditions = append(conditions, sqlCondition{text: "document.deleted_at IS NULL"})
+	}
+	if len(filter.ID) > 0 {
+		conditions = append(conditions, sqlCondition{text: "document.id = ?", args: []interface{}{filter.ID}})
+	}
+	if len(filter.CreatedBy) > 0 {
+		conditions = append(conditions, sqlCondition{text: "document.created_by = ?", args: []interface{}{filter.CreatedBy}})
+	}
+	if len(filter.UpdatedBy) > 0 {
+		conditions = append(conditions, sqlCondition{text: "document.updated_by = ?", args: []interface{}{filter.UpdatedBy}})
+	}
+	if !filter.CreatedAfter.IsZero() {
+		conditions = append(conditions, sqlCondition{text: "document.created_at >= ?", args: []interface{}{sqlTime(filter.CreatedAfter)}})
+	}
+	if !filter.CreatedBefore.IsZero() {
+		conditions = append(conditions, sqlCondition{text: "document.created_at <= ?", args: []interface{}{sqlTime(filter.CreatedBefore)}})
+	}
+	if !filter.UpdatedAfter.IsZero() {
+		conditions = append(conditions, sqlCondition{text: "document.updated_at >= ?", args: []interface{}{sqlTime(filter.UpdatedAfter)}})
+	}
+	if !filter.UpdatedBefore.IsZero() {
+		conditions = append(conditions, sqlCondition{text: "document.updated_at <= ?", args: []interface{}{sqlTime(filter.UpdatedBefore)}})
+	}
+	for _, requirement := range filter.Selector {
+		conditions = append(conditions, labelCondition(requirement))
+	}
+	if filter.ReadableBy != nil {
+		conditions = append(conditions, readableCondition(filter.ReadableBy))
+	}
+	return conditions
+}
+
+//placeholders returns the placeholders of a list of n values, like (?, ?)
+func placeholders(n int) string {
+	return "(" + strings.TrimSuffix(strings.Repeat("?, ", n), ", ") + ")"
+}
+
+//labelCondition is the condition of a requirement on the labels of the documents, like Kubernetes a document without
+//the label matches != and notin
+func labelCondition(requirement LabelRequirement) sqlCondition {
+	const labelOf = "SELECT 1 FROM document_labels WHERE document_labels.tenant = document.tenant AND document_labels.document_id = document.id" +
+		" AND document_labels.label_key = ?"
+	args := []interface{}{requirement.Key}
+	for _, value := range requirement.Values {
+		args = append(args, value)
+	}
+	switch requirement.Operator {
+	case LabelEquals, LabelIn:
+		return sqlCondition{text: "EXISTS (" + labelOf + " AND document_labels.label_value IN " + placeholders(len(requirement.Values)) + ")", args: args}
+	case LabelNotEquals, LabelNotIn:
+		return sqlCondition{text: "NOT EXISTS (" + labelOf + " AND document_labels.label_value IN " + placeholders(len(requirement.Values)) + ")", args: args}
+	case LabelDoesNotExist:
+		return sqlCondition{text: "NOT EXISTS (" + labelOf + ")", args: args}
+	default:
+		return sqlCondition{text: "EXISTS (" + labelOf + ")", args: args}
+	}
+}
+
+//readableCondition keeps the documents one of the principals can read, the documents without ACL have no readers and can be read by anyone
+func readableCondition(principals []string) sqlCondition {
+	const readersOf = "SELECT 1 FROM document_readers WHERE document_readers.tenant = document.tenant AND document_readers.document_id = document.id"
+	if len(principals) == 0 {
+		return sqlCondition{text: "NOT EXISTS (" + readersOf + ")"}
+	}
+	args := make([]interface{}, 0, len(principals))
+	for _, principal := range principals {
+		args = append(args, principal)
+	}
+	return sqlCondition{
+		text: "(NOT EXISTS (" + readersOf + ") OR EXISTS (" + readersOf + " AND document_readers.principal IN " + placeholders(len(principals)) + "))",
+		args: args,
+	}
+}
+
+//afterCondition is the condition of the documents after the cursor in the order of the sort
+func afterCondition(sort DocumentSort, cursor *documentCursor) sqlCondition {
+	if sort.field() == "id" {
+		if sort.Descending {
+			return sqlCondition{text: "id < ?", args: []interface{}{cursor.ID}}
+		}
+		return sqlCondition{text: "id > ?", args: []interface{}{cursor.ID}}
+	}
+	column := sqlSortColumns[sort.field()]
+	operator := ">"
+	if sort.Descending {
+		operator = "<"
+	}
+	return sqlCondition{
+		text: "(" + column + " " + operator + " ? OR (" + column + " = ? AND id > ?))",
+		args: []interface{}{cursor.Value, cursor.Value, cursor.ID},
+	}
+}
+
+//orderBy is the order of the sort, the ties are broken by ascending id
+func orderBy(sort DocumentSort) string {
+	direction := " ASC"
+	if sort.Descending {
+		direction = " DESC"
+	}
+	if sort.field() == "id" {
+		return " ORDER BY id" + direction
+	}
+	return " ORDER BY " + sqlSortColumns[sort.field()] + direction + ", id ASC"
+}
+
+//scan calls f on the documents matching the filter in the order of the sort, after the cursor if any, until f returns false.
+//The documents are read by chunks, f is called once the rows of a chunk are read so that it holds no connection.
+func (r *sqlDocumentRepo) scan(trashed bool, filter DocumentFilter, sort DocumentSort, cursor *documentCursor, chunk int,
+	f func(document models.Document) (bool, error)) error {
+	db, err := r.database()
+	if err != nil {
+		return err
+	}
+
+	for {
+		conditions := r.conditions(trashed, filter)
+		if cursor != nil {
+			conditions = append(conditions, afterCondition(sort, cursor))
+		}
+		where, args := whereClause(conditions)
+		documents, err := r.queryDocuments(db, "SELECT data FROM document"+where+orderBy(sort)+" LIMIT "+strconv.Itoa(chunk), args...)
+		if err != nil {
+			return err
+		}
+		for _, document := range documents {
+			if !filter.matches(document) {
+				continue
+			}
+			if next, err := f(document); err != nil || !next {
+				return err
+			}
+		}
+		if len(documents) < chunk {
+			return nil
+		}
+		last := documents[len(documents)-1]
+		cursor = &documentCursor{ID: last.ID, Value: sort.valueOf(last)}
+	}
+}
+
+//queryDocuments returns the documents of the query, which selects their data
+func (r *sqlDocumentRepo) queryDocuments(db *sql.DB, query string, args ...interface{}) ([]models.Document, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		r.logger().Error(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	documents := make([]models.Document, 0)
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			r.logger().Error(err)
+			return nil, err
+		}
+		var document models.Document
+		if err := json.Unmarshal([]byte(data), &document); err != nil {
+			r.logger().Error(err)
+			return nil, err
+		}
+		documents = append(documents, document)
+	}
+	if err := rows.Err(); err != nil {
+		r.logger().Error(err)
+		return nil, err
+	}
+	return documents, nil
+}
+
+func (r *sqlDocumentRepo) GetById(id string) (models.Document, error) {
+	db, err := r.database()
+	if err != nil {
+		return models.Document{}, err
+	}
+
+	documents, err := r.queryDocuments(db, "SELECT data FROM document WHERE tenant = $1 AND id = $2 AND deleted_at IS NULL", tenantOrDefault(r.tenant), id)
+	if err != nil {
+		return models.Document{}, err
+	}
+	if len(documents) == 0 {
+		return models.Document{}, ErrNotFound
+	}
+	return documents[0], nil
+}
+
+func (r *sqlDocumentRepo) GetAll(filter DocumentFilter) ([]models.Document, error) {
+	values := make([]models.Document, 0)
+	err := r.scan(false, filter, DocumentSort{Field: "id"}, nil, MaxPageLimit, func(document models.Document) (bool, error) {
+		values = append(values, document)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// Export reads the documents by chunks sorted by id
+func (r *sqlDocumentRepo) Export(filter DocumentFilter, f func(document models.Document) error) error {
+	return r.scan(false, filter, DocumentSort{Field: "id"}, nil, DefaultPageLimit, func(document models.Document) (bool, error) {
+		return true, f(document)
+	})
+}
+
+func (r *sqlDocumentRepo) List(query DocumentQuery) (models.DocumentPage, error) {
+	cursor, err := decodeCursor(query)
+	if err != nil {
+		return models.DocumentPage{}, err
+	}
+
+	//one more document than the limit tells there is a next page
+	values := make([]models.Document, 0)
+	err = r.scan(query.Trashed, query.Filter, query.Sort, cursor, query.limit()+1, func(document models.Document) (bool, error) {
+		values = append(values, document)
+		return len(values) <= query.limit(), nil
+	})
+	if err != nil {
+		return models.DocumentPage{}, err
+	}
+	return newPage(query, values), nil
+}
+
+// Search finds the documents with the full-text index of the database, FTS5 with sqlite and a tsvector with postgres.
+// The scores are the ones of the database, bm25 with sqlite and ts_rank with postgres, not the ones of the other repositories.
+func (r *sqlDocumentRepo) Search(query SearchQuery) (models.SearchPage, error) {
+	after, err := decodeSearchCursor(query)
+	if err != nil {
+		return models.SearchPage{}, err
+	}
+	db, err := r.database()
+	if err != nil {
+		return models.SearchPage{}, err
+	}
+	terms := SearchTerms(query.Text)
+	if len(terms) == 0 {
+		return newSearchPage(query, nil), nil
+	}
+
+	//the hits are matched and scored in a sub query, so that the cursor is checked on their scores
+	var hits sqlCondition
+	conditions := r.conditions(false, DocumentFilter{ReadableBy: query.ReadableBy})
+	if r.handler.Driver() == database.SQLDriverPostgres {
+		//the terms only have letters and numbers, the query matches the documents having any of them
+		tsquery := "'" + strings.Join(terms, "' | '") + "'"
+		conditions = append(conditions, sqlCondition{text: "document.search @@ to_tsquery('simple', ?)", args: []interface{}{tsquery}})
+		hits = sqlCondition{text: "SELECT document.data, document.id, ts_rank(document.search, to_tsquery('simple', ?))::float8 AS score FROM document",
+			args: []interface{}{tsquery}}
+	} else {
+		conditions = append(conditions, sqlCondition{text: "document_search MATCH ?", args: []interface{}{`"` + strings.Join(terms, `" OR "`) + `"`}})
+		hits = sqlCondition{text: "SELECT document.data, document.id, -bm25(document_search, ?, 1.0) AS score FROM document_search" +
+			" JOIN document_search_rows ON document_search_rows.row_id = document_search.rowid" +
+			" JOIN document ON document.tenant = document_search_rows.tenant AND document.id = document_search_rows.document_id",
+			args: []interface{}{float64(nameWeight)}}
+	}
+	statement := []sqlCondition{{text: "SELECT data, score FROM (" + hits.text, args: hits.args}}
+	for i, condition := range conditions {
+		keyword := " AND "
+		if i == 0 {
+			keyword = " WHERE "
+		}
+		statement = append(statement, sqlCondition{text: keyword + condition.text, args: condition.args})
+	}
+	statement = append(statement, sqlCondition{text: ") AS hits"})
+	if after != nil {
+		statement = append(statement, sqlCondition{text: " WHERE (score < ? OR (score = ? AND id > ?))", args: []interface{}{after.Score, after.Score, after.Document.ID}})
+	}
+	//one more hit than the limit tells there is a next page
+	statement = append(statement, sqlCondition{text: " ORDER BY score DESC, id ASC LIMIT " + strconv.Itoa(query.limit()+1)})
+
+	text, args := joinConditions(statement, "")
+	found, err := r.queryHits(db, text, args...)
+	if err != nil {
+		return models.SearchPage{}, err
+	}
+	return newSearchPage(query, found), nil
+}
+
+//queryHits returns the hits of the query, which selects the data of the documents and their scores
+func (r *sqlDocumentRepo) queryHits(db *sql.DB, query string, args ...interface{}) ([]models.SearchHit, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		r.logger().Error(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	hits := make([]models.SearchHit, 0)
+	for rows.Next() {
+		var data string
+		var score float64
+		if err := rows.Scan(&data, &score); err != nil {
+			r.logger().Error(err)
+			return nil, err
+		}
+		var document models.Document
+		if err := json.Unmarshal([]byte(data), &document); err != nil {
+			r.logger().Error(err)
+			return nil, err
+		}
+		hits = append(hits, models.SearchHit{Document: document, Score: score})
+	}
+	if err := rows.Err(); err != nil {
+		r.logger().Error(err)
+		return nil, err
+	}
+	return hits, nil
+}
+
+//write runs f in a transaction on the documents of the tenant, it is tried again when another transaction wrote the same documents
+func (r *sqlDocumentRepo) write(f func(view *sqlView) error) error {
+	db, err := r.database()
+	if err != nil {
+		return err
+	}
+	for attempt := 1; ; attempt++ {
+		err = r.writeOnce(db, f)
+		if err != errConcurrentWrite || attempt >= maxWriteAttempts {
+			return err
+		}
+		r.logger().Info("documents written by another transaction, trying again")
+	}
+}
+
+func (r *sqlDocumentRepo) writeOnce(db *sql.DB, f func(view *sqlView) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		r.logger().Error(err)
+		return err
+	}
+	view := &sqlView{repo: r, tx: tx, versions: make(map[string]int64)}
+	err = f(view)
+	//the error of a statement comes first, the error of f may come from a document the view could not read
+	if view.err != nil {
+		err = view.err
+	}
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			r.logger().Error("Cannot roll back the transaction", rollbackErr)
+		}
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		r.logger().Error(err)
+		return err
+	}
+	return nil
+}
+
+func (r *sqlDocumentRepo) CreateOrUpdate(documentToCreate models.Document, precondition Precondition) (models.Document, bool, error) {
+	var document models.Document
+	var found bool
+	err := r.write(func(view *sqlView) error {
+		var err error
+		document, found, err = createOrUpdateIn(view, documentToCreate, precondition)
+		return err
+	})
+	if err != nil {
+		return models.Document{}, found, err
+	}
+	return document, found, nil
+}
+
+func (r *sqlDocumentRepo) SetACL(id string, acl models.DocumentACL, precondition Precondition) (models.Document, error) {
+	var storedDocument models.Document
+	err := r.write(func(view *sqlView) error {
+		var found bool
+		storedDocument, found = view.load(id)
+		found = found && !storedDocument.Trashed()
+		if err := precondition.check(storedDocument, found); err != nil {
+			return err
+		}
+		if !found {
+			return ErrNotFound
+		}
+		storedDocument.ACL = &acl
+		storedDocument.Version++
+		view.store(storedDocument)
+		view.record(newDocumentEvent(storedDocument, true))
+		return nil
+	})
+	if err != nil {
+		return models.Document{}, err
+	}
+	return storedDocument, nil
+}
+
+func (r *sqlDocumentRepo) Delete(idToDelete string, precondition Precondition) (bool, error) {
+	var found bool
+	err := r.write(func(view *sqlView) error {
+		var err error
+		found, err = deleteIn(view, idToDelete, precondition)
+		return err
+	})
+	return found, err
+}
+
+func (r *sqlDocumentRepo) Restore(id string) (models.Document, error) {
+	var storedDocument models.Document
+	err := r.write(func(view *sqlView) error {
+		var found bool
+		storedDocument, found = view.load(id)
+		if !found || !storedDocument.Trashed() {
+			return ErrNotFound
+		}
+		storedDocument.DeletedAt = nil
+		storedDocument.Version++
+		view.store(storedDocument)
+		view.record(newDocumentEvent(storedDocument, false))
+		return nil
+	})
+	if err != nil {
+		return models.Document{}, err
+	}
+	return storedDocument, nil
+}
+
+func (r *sqlDocumentRepo) Purge(deletedBefore time.Time) ([]string, error) {
+	db, err := r.database()
+	if err != nil {
+		return nil, err
+	}
+
+	//the times of the column are to the millisecond, a document deleted in the same millisecond is purged the next time
+	rows, err := db.Query("SELECT id FROM document WHERE tenant = $1 AND deleted_at < $2", tenantOrDefault(r.tenant), sqlTime(deletedBefore))
+	if err != nil {
+		r.logger().Error(err)
+		return nil, err
+	}
+	var candidates []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			r.logger().Error(err)
+			return nil, err
+		}
+		candidates = append(candidates, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		r.logger().Error(err)
+		return nil, err
+	}
+
+	//remove the documents one by one, so that only the ids of the removed documents are returned.
+	//A document is removed with its revisions, its labels and its readers, in a transaction.
+	purged := make([]string, 0)
+	for _, id := range candidates {
+		removed, err := r.purgeOne(db, id, deletedBefore)
+		if err != nil {
+			return purged, err
+		}
+		if removed {
+			purged = append(purged, id)
+		}
+	}
+	return purged, nil
+}
+
+//purgeOne removes document id if it is still in the trash since before deletedBefore, it tells if it was removed
+func (r *sqlDocumentRepo) purgeOne(db *sql.DB, id string, deletedBefore time.Time) (bool, error) {
+	tenant := tenantOrDefault(r.tenant)
+	tx, err := db.Begin()
+	if err != nil {
+		r.logger().Error(err)
+		return false, err
+	}
+	removed, err := func() (bool, error) {
+		result, err := tx.Exec("DELETE FROM document WHERE tenant = $1 AND id = $2 AND deleted_at < $3", tenant, id, sqlTime(deletedBefore))
+		if err != nil {
+			return false, err
+		}
+		if removed, _ := result.RowsAffected(); removed == 0 {
+			return false, nil
+		}
+		for _, statement := range []string{
+			"DELETE FROM document_revisions WHERE tenant = $1 AND document_id = $2",
+			"DELETE FROM document_labels WHERE tenant = $1 AND document_id = $2",
+			"DELETE FROM document_readers WHERE tenant = $1 AND document_id = $2",
+		} {
+			if _, err := tx.Exec(statement, tenant, id); err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	}()
+	if err != nil {
+		r.logger().Error(err)
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			r.logger().Error("Cannot roll back the transaction", rollbackErr)
+		}
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		r.logger().Error(err)
+		return false, err
+	}
+	return removed, nil
+}
+
+func (r *sqlDocumentRepo) ApplyBatch(operations []Operation, atomic bool) ([]OperationResult, error) {
+	if _, err := r.database(); err != nil {
+		return nil, err
+	}
+
+	results := make([]OperationResult, len(operations))
+	if !atomic {
+		for i, operation := range operations {
+			err := r.write(func(view *sqlView) error {
+				results[i] = applyIn(view, operation)
+				return results[i].Err
+			})
+			if results[i].Err == nil && err != nil {
+				results[i] = OperationResult{Err: err}
+			}
+		}
+		return results, nil
+	}
+
+	//the whole batch is one transaction, on staged changes that are only written if all operations succeed
+	err := r.write(func(view *sqlView) error {
+		staged := newStagedView(view)
+		for i, operation := range operations {
+			results[i] = applyIn(staged, operation)
+			if results[i].Err != nil {
+				rollBack(results, i)
+				return nil
+			}
+		}
+		staged.commit()
+		return nil
+	})
+	if err != nil {
+		r.logger().Error(err)
+		return nil, err
+	}
+	return results, nil
+}
+
+func (r *sqlDocumentRepo) EnableOutbox() {
+	r.rootRepo().outboxEnabled = true
+}
+
+func (r *sqlDocumentRepo) PendingEvents(limit int) ([]models.DocumentEvent, error) {
+	db, err := r.database()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT id, event FROM document_outbox ORDER BY id LIMIT $1", limit)
+	if err != nil {
+		r.logger().Error(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]models.DocumentEvent, 0)
+	for rows.Next() {
+		var id int64
+		var data string
+		if err := rows.Scan(&id, &data); err != nil {
+			r.logger().Error(err)
+			return nil, err
+		}
+		var event models.DocumentEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			r.logger().Error(err)
+			return nil, err
+		}
+		event.ID = strconv.FormatInt(id, 10)
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		r.logger().Error(err)
+		return nil, err
+	}
+	//the ids are given when the events are inserted, not when their transactions commit, but the versions of a document are ordered
+	orderByVersion(events)
+	return events, nil
+}
+
+func (r *sqlDocumentRepo) RemoveEvents(ids []string) error {
+	db, err := r.database()
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		eventID, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			return err
+		}
+		if _, err := db.Exec("DELETE FROM document_outbox WHERE id = $1", eventID); err != nil {
+			r.logger().Error(err)
+			return err
+		}
+	}
+	return nil
+}
+
+//AcquireRelayLease takes the row of the lease when it is free, expired or already held by holder, in a single statement
+func (r *sqlDocumentRepo) AcquireRelayLease(holder string, duration time.Duration) (bool, error) {
+	db, err := r.database()
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now()
+	result, err := db.Exec(`INSERT INTO document_outbox_lease (name, holder, expires_at) VALUES ($1, $2, $3)
+		ON CONFLICT (name) DO UPDATE SET holder = excluded.holder, expires_at = excluded.expires_at
+		WHERE document_outbox_lease.holder = excluded.holder OR document_outbox_lease.expires_at < $4`,
+		relayLeaseName, holder, now.Add(duration).UnixMilli(), now.UnixMilli())
+	if err != nil {
+		r.logger().Error(err)
+		return false, err
+	}
+	taken, err := result.RowsAffected()
+	if err != nil {
+		r.logger().Error(err)
+		return false, err
+	}
+	return taken > 0, nil
+}
+
+func (r *sqlDocumentRepo) ReleaseRelayLease(holder string) error {
+	db, err := r.database()
+	if err != nil {
+		return err
+	}
+
+	if _, err := db.Exec("DELETE FROM document_outbox_lease WHERE name = $1 AND holder = $2", relayLeaseName, holder); err != nil {
+		r.logger().Error(err)
+		return err
+	}
+	return nil
+}
+
+//recordEvent writes the event in the outbox, in the transaction of the write
+func (r *sqlDocumentRepo) recordEvent(tx *sql.Tx, event models.DocumentEvent) error {
+	if !r.rootRepo().outboxEnabled {
+		return nil
+	}
+	event.Tenant = tenantOrDefault(r.tenant)
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO document_outbox (event) VALUES ($1)", string(data))
+	return err
+}
+
+func (r *sqlDocumentRepo) AddRevision(revision models.DocumentRevision, maxRevisions int) (models.DocumentRevision, error) {
+	db, err := r.database()
+	if err != nil {
+		return models.DocumentRevision{}, err
+	}
+	tenant := tenantOrDefault(r.tenant)
+
+	//the revision number taken by a concurrent write is not inserted, then try the next one
+	for attempt := 1; ; attempt++ {
+		var last int64
+		err := db.QueryRow("SELECT COALESCE(MAX(revision), 0) FROM document_revisions WHERE tenant = $1 AND document_id = $2", tenant, revision.DocumentID).Scan(&last)
+		if err != nil {
+			r.logger().Error(err)
+			return models.DocumentRevision{}, err
+		}
+		revision.Revision = last + 1
+		data, err := json.Marshal(revision)
+		if err != nil {
+			return models.DocumentRevision{}, err
+		}
+		result, err := db.Exec(`INSERT INTO document_revisions (tenant, document_id, revision, data) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (tenant, document_id, revision) DO NOTHING`, tenant, revision.DocumentID, revision.Revision, string(data))
+		if err != nil {
+			r.logger().Error(err)
+			return models.DocumentRevision{}, err
+		}
+		if inserted, _ := result.RowsAffected(); inserted > 0 {
+			break
+		}
+		if attempt >= maxWriteAttempts {
+			r.logger().Error("Cannot add the revision of document ", revision.DocumentID)
+			return models.DocumentRevision{}, errConcurrentWrite
+		}
+	}
+
+	//drop the oldest revisions
+	if maxRevisions > 0 && revision.Revision > int64(maxRevisions) {
+		_, err := db.Exec("DELETE FROM document_revisions WHERE tenant = $1 AND document_id = $2 AND revision <= $3",
+			tenant, revision.DocumentID, revision.Revision-int64(maxRevisions))
+		if err != nil {
+			r.logger().Error("Cannot drop old revisions", err)
+		}
+	}
+	return revision, nil
+}
+
+//queryRevisions returns the revisions of the query, which selects their data
+func (r *sqlDocumentRepo) queryRevisions(query string, args ...interface{}) ([]models.DocumentRevision, error) {
+	db, err := r.database()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		r.logger().Error(err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := make([]models.DocumentRevision, 0)
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			r.logger().Error(err)
+			return nil, err
+		}
+		var revision models.DocumentRevision
+		if err := json.Unmarshal([]byte(data), &revision); err != nil {
+			r.logger().Error(err)
+			return nil, err
+		}
+		revisions = append(revisions, revision)
+	}
+	if err := rows.Err(); err != nil {
+		r.logger().Error(err)
+		return nil, err
+	}
+	return revisions, nil
+}
+
+func (r *sqlDocumentRepo) GetRevisions(id string) ([]models.DocumentRevision, error) {
+	return r.queryRevisions("SELECT data FROM document_revisions WHERE tenant = $1 AND document_id = $2 ORDER BY revision",
+		tenantOrDefault(r.tenant), id)
+}
+
+func (r *sqlDocumentRepo) GetRevision(id string, revision int64) (models.DocumentRevision, bool, error) {
+	revisions, err := r.queryRevisions("SELECT data FROM document_revisions WHERE tenant = $1 AND document_id = $2 AND revision = $3",
+		tenantOrDefault(r.tenant), id, revision)
+	if err != nil || len(revisions) == 0 {
+		return models.DocumentRevision{}, false, err
+	}
+	return revisions[0], true, nil
+}
+
+//sqlView reads and stores the documents of the tenant in a transaction. A document is only stored if it still has
+//the version it was read with, otherwise the transaction fails with errConcurrentWrite and the write is tried again.
+//The views have no error to return, so the first error is kept and fails the transaction.
+type sqlView struct {
+	repo *sqlDocumentRepo
+	tx   *sql.Tx
+	//versions are the versions of the documents read, 0 for the documents that did not exist
+	versions map[string]int64
+	err      error
+}
+
+func (v *sqlView) fail(err error) {
+	if v.err == nil && err != nil {
+		v.err = err
+	}
+}
+
+func (v *sqlView) load(id string) (models.Document, bool) {
+	var data string
+	err := v.tx.QueryRow("SELECT data FROM document WHERE tenant = $1 AND id = $2", tenantOrDefault(v.repo.tenant), id).Scan(&data)
+	if err == sql.ErrNoRows {
+		v.versions[id] = 0
+		return models.Document{}, false
+	}
+	if err != nil {
+		v.fail(err)
+		return models.Document{}, false
+	}
+	var document models.Document
+	if err := json.Unmarshal([]byte(data), &document); err != nil {
+		v.fail(err)
+		return models.Document{}, false
+	}
+	v.versions[id] = document.Version
+	return document, true
+}
+
+func (v *sqlView) store(document models.Document) {
+	data, err := json.Marshal(document)
+	if err != nil {
+		v.fail(err)
+		return
+	}
+	var deletedAt interface{}
+	if document.DeletedAt != nil {
+		deletedAt = sqlTime(*document.DeletedAt)
+	}
+	result, err := v.tx.Exec(`INSERT INTO document (tenant, id, name, created_at, created_by, updated_at, updated_by, deleted_at, version, data)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (tenant, id) DO UPDATE SET name = excluded.name, created_at = excluded.created_at, created_by = excluded.created_by,
+			updated_at = excluded.updated_at, updated_by = excluded.updated_by, deleted_at = excluded.deleted_at,
+			version = excluded.version, data = excluded.data
+		WHERE document.version = $11`,
+		tenantOrDefault(v.repo.tenant), document.ID, document.Name, sqlTime(document.CreatedAt), document.CreatedBy,
+		sqlTime(document.UpdatedAt), document.UpdatedBy, deletedAt, document.Version, string(data), v.versions[document.ID])
+	if err != nil {
+		v.fail(err)
+		return
+	}
+	if stored, _ := result.RowsAffected(); stored == 0 {
+		v.fail(errConcurrentWrite)
+		return
+	}
+	v.versions[document.ID] = document.Version
+	v.storeIndexes(document)
+}
+
+//storeIndexes replaces the labels and the readers of the document, the listings filter on them
+func (v *sqlView) storeIndexes(document models.Document) {
+	tenant := tenantOrDefault(v.repo.tenant)
+	v.removeIndexes(document.ID)
+	for _, key := range labelKeys(document.Labels) {
+		_, err := v.tx.Exec("INSERT INTO document_labels (tenant, document_id, label_key, label_value) VALUES ($1, $2, $3, $4)",
+			tenant, document.ID, key, document.Labels[key])
+		v.fail(err)
+	}
+	for _, principal := range aclReaders(document.ACL) {
+		_, err := v.tx.Exec("INSERT INTO document_readers (tenant, document_id, principal) VALUES ($1, $2, $3)", tenant, document.ID, principal)
+		v.fail(err)
+	}
+}
+
+func (v *sqlView) removeIndexes(id string) {
+	tenant := tenantOrDefault(v.repo.tenant)
+	_, err := v.tx.Exec("DELETE FROM document_labels WHERE tenant = $1 AND document_id = $2", tenant, id)
+	v.fail(err)
+	_, err = v.tx.Exec("DELETE FROM document_readers WHERE tenant = $1 AND document_id = $2", tenant, id)
+	v.fail(err)
+}
+
+func (v *sqlView) remove(id string) {
+	_, err := v.tx.Exec("DELETE FROM document WHERE tenant = $1 AND id = $2", tenantOrDefault(v.repo.tenant), id)
+	v.fail(err)
+	v.removeIndexes(id)
+}
+
+func (v *sqlView) record(event models.DocumentEvent) {
+	v.fail(v.repo.recordEvent(v.tx, event))
+}
+
+func (v *sqlView) logger() *log.Entry {
+	return v.repo.logger()
+}
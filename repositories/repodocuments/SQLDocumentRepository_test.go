@@ -0,0 +1,199 @@
+package repodocuments
+
+import (
+	"goapi/config"
+	"goapi/database"
+	"goapi/models"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//newSQLRepo opens a sqlite file migrated to the latest schema, in a temporary directory removed after the test
+func newSQLRepo(t *testing.T) *sqlDocumentRepo {
+	handler := &database.SQLDataBaseHandler{}
+	err := handler.Open(&config.DatabaseConfig{SQL: config.SQLDatabaseConfig{
+		Driver: database.SQLDriverSqlite,
+		DSN:    filepath.Join(t.TempDir(), "goapi.sqlite"),
+	}})
+	assert.Nil(t, err)
+	t.Cleanup(handler.Close)
+	return NewSQLDocumentRepo(handler)
+}
+
+func TestSQLDocumentRepo_CRUD(t *testing.T) {
+	repo := newSQLRepo(t)
+
+	created, found, err := repo.CreateOrUpdate(models.Document{ID: "toto", Name: "first", Labels: map[string]string{"team": "finance"}}, Precondition{})
+	assert.Nil(t, err)
+	assert.False(t, found)
+	assert.Equal(t, int64(1), created.Version)
+
+	updated, found, err := repo.CreateOrUpdate(models.Document{ID: "toto", Name: "second"}, Precondition{})
+	assert.Nil(t, err)
+	assert.True(t, found)
+	assert.Equal(t, int64(2), updated.Version)
+
+	stored, err := repo.GetById("toto")
+	assert.Nil(t, err)
+	assert.Equal(t, "second", stored.Name)
+	assert.Nil(t, stored.Labels)
+	_, err = repo.GetById("titi")
+	assert.Equal(t, ErrNotFound, err)
+
+	//the tenants do not see each other's documents
+	_, err = repo.ForTenant("acme").GetById("toto")
+	assert.Equal(t, ErrNotFound, err)
+
+	//a deleted document is in the trash until it is restored
+	_, err = repo.Delete("toto", Precondition{IfMatch: 1})
+	assert.Equal(t, ErrPreconditionFailed, err)
+	deleted, err := repo.Delete("toto", Precondition{IfMatch: 2})
+	assert.Nil(t, err)
+	assert.True(t, deleted)
+	_, err = repo.GetById("toto")
+	assert.Equal(t, ErrNotFound, err)
+	trash, err := repo.List(DocumentQuery{Trashed: true})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"toto"}, idsOf(trash.Documents))
+	_, err = repo.Restore("toto")
+	assert.Nil(t, err)
+	stored, err = repo.GetById("toto")
+	assert.Nil(t, err)
+	assert.Equal(t, "second", stored.Name)
+}
+
+func TestSQLDocumentRepo_List(t *testing.T) {
+	repo := newSQLRepo(t)
+	for _, id := range []string{"c", "a", "e", "b", "d"} {
+		labels := map[string]string{"vowel": "no"}
+		if id == "a" || id == "e" {
+			labels["vowel"] = "yes"
+		}
+		_, _, _ = repo.CreateOrUpdate(models.Document{ID: id, Name: "name " + id, Labels: labels}, Precondition{})
+	}
+	_, _ = repo.Delete("d", Precondition{})
+
+	//the pages follow each other until the last one, in both orders
+	for _, test := range []struct {
+		sort     DocumentSort
+		expected []string
+	}{
+		{DocumentSort{Field: "id"}, []string{"a", "b", "c", "e"}},
+		{DocumentSort{Field: "id", Descending: true}, []string{"e", "c", "b", "a"}},
+		{DocumentSort{Field: "name", Descending: true}, []string{"e", "c", "b", "a"}},
+	} {
+		ids := make([]string, 0)
+		query := DocumentQuery{Limit: 2, Sort: test.sort}
+		for {
+			page, err := repo.List(query)
+			assert.Nil(t, err)
+			assert.LessOrEqual(t, len(page.Documents), 2)
+			ids = append(ids, idsOf(page.Documents)...)
+			if len(page.NextCursor) == 0 {
+				break
+			}
+			query.Cursor = page.NextCursor
+		}
+		assert.Equal(t, test.expected, ids, test.sort.String())
+	}
+
+	selector, err := ParseLabelSelector("vowel=yes")
+	assert.Nil(t, err)
+	page, err := repo.List(DocumentQuery{Filter: DocumentFilter{Selector: selector}})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "e"}, idsOf(page.Documents))
+}
+
+func TestSQLDocumentRepo_Search(t *testing.T) {
+	repo := newSQLRepo(t)
+	_, _, _ = repo.CreateOrUpdate(models.Document{ID: "toto", Name: "annual report", Description: "the report of the year"}, Precondition{})
+	_, _, _ = repo.CreateOrUpdate(models.Document{ID: "titi", Name: "budget", Description: "annual budget",
+		ACL: &models.DocumentACL{Owner: "bob"}}, Precondition{})
+	_, _, _ = repo.CreateOrUpdate(models.Document{ID: "tata", Name: "report"}, Precondition{})
+
+	page, err := repo.Search(SearchQuery{Text: "Annual"})
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{"toto", "titi"}, hitIdsOf(page.Hits))
+	page, err = repo.Search(SearchQuery{Text: "annual", ReadableBy: []string{UserPrincipal("alice")}})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"toto"}, hitIdsOf(page.Hits))
+	page, err = repo.Search(SearchQuery{Text: " ,; "})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(page.Hits))
+
+	//the trashed documents are not searched
+	_, _ = repo.Delete("tata", Precondition{})
+	page, err = repo.Search(SearchQuery{Text: "report"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"toto"}, hitIdsOf(page.Hits))
+
+	//the hits are paged
+	_, _ = repo.Restore("tata")
+	page, err = repo.Search(SearchQuery{Text: "annual report", Limit: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(page.Hits))
+	next, err := repo.Search(SearchQuery{Text: "annual report", Limit: 2, Cursor: page.NextCursor})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(next.Hits))
+	assert.Empty(t, next.NextCursor)
+	assert.ElementsMatch(t, []string{"toto", "titi", "tata"}, append(hitIdsOf(page.Hits), hitIdsOf(next.Hits)...))
+}
+
+func TestSQLDocumentRepo_AtomicBatch(t *testing.T) {
+	repo := newSQLRepo(t)
+	_, _, _ = repo.CreateOrUpdate(models.Document{ID: "toto", Name: "first"}, Precondition{})
+
+	//the failed operation rolls back the ones before it
+	results, err := repo.ApplyBatch([]Operation{
+		{Document: models.Document{ID: "titi", Name: "new"}},
+		{Document: models.Document{ID: "toto", Name: "second"}},
+		{Document: models.Document{ID: "toto", Name: "stale"}, Precondition: Precondition{IfMatch: 1}},
+	}, true)
+	assert.Nil(t, err)
+	assert.Equal(t, ErrRolledBack, results[0].Err)
+	assert.Equal(t, ErrRolledBack, results[1].Err)
+	assert.Equal(t, ErrPreconditionFailed, results[2].Err)
+	_, err = repo.GetById("titi")
+	assert.Equal(t, ErrNotFound, err)
+	stored, _ := repo.GetById("toto")
+	assert.Equal(t, "first", stored.Name)
+
+	results, err = repo.ApplyBatch([]Operation{
+		{Document: models.Document{ID: "titi", Name: "new"}},
+		{Delete: true, Document: models.Document{ID: "toto"}},
+	}, true)
+	assert.Nil(t, err)
+	assert.Nil(t, results[0].Err)
+	assert.Nil(t, results[1].Err)
+	_, err = repo.GetById("titi")
+	assert.Nil(t, err)
+	_, err = repo.GetById("toto")
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestSQLDocumentRepo_Purge(t *testing.T) {
+	repo := newSQLRepo(t)
+	_, _, _ = repo.CreateOrUpdate(models.Document{ID: "toto", Name: "annual report", Labels: map[string]string{"team": "finance"}}, Precondition{})
+	_, _ = repo.AddRevision(models.DocumentRevision{DocumentID: "toto"}, 0)
+	_, _ = repo.Delete("toto", Precondition{})
+
+	purged, err := repo.Purge(time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"toto"}, purged)
+	revisions, err := repo.GetRevisions("toto")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(revisions))
+
+	//a new document with the id of a purged one has none of its labels
+	_, _, _ = repo.CreateOrUpdate(models.Document{ID: "toto", Name: "minutes"}, Precondition{})
+	selector, _ := ParseLabelSelector("team=finance")
+	page, err := repo.List(DocumentQuery{Filter: DocumentFilter{Selector: selector}})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(page.Documents))
+	hits, err := repo.Search(SearchQuery{Text: "annual"})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(hits.Hits))
+}
@@ -0,0 +1,48 @@
+package repodocuments
+
+import (
+	"regexp"
+	"sort"
+)
+
+// DefaultTenant is the tenant of the requests telling no tenant, it owns the documents written before the tenants
+const DefaultTenant = "default"
+
+//a tenant is part of the collection names, so it is kept to the characters mongo accepts anywhere
+var tenantPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// ValidTenant tells if the tenant can be used to store documents
+func ValidTenant(tenant string) bool {
+	return tenantPattern.MatchString(tenant)
+}
+
+// Tenancy gives the repository of each tenant. A repository only sees the documents of its tenant,
+// the repository itself is the one of the default tenant.
+type Tenancy interface {
+	// ForTenant returns the repository of the documents of the tenant
+	ForTenant(tenant string) DocumentRepository
+	// Tenants returns the tenants having documents, sorted
+	Tenants() ([]string, error)
+}
+
+//tenantOrDefault is the tenant of a repository, the empty tenant is the default one
+func tenantOrDefault(tenant string) string {
+	if len(tenant) == 0 {
+		return DefaultTenant
+	}
+	return tenant
+}
+
+//sortedTenants returns the tenants sorted, with the default tenant that always exists
+func sortedTenants(tenants []string) []string {
+	found := map[string]bool{DefaultTenant: true}
+	sorted := []string{DefaultTenant}
+	for _, tenant := range tenants {
+		if !found[tenant] {
+			found[tenant] = true
+			sorted = append(sorted, tenant)
+		}
+	}
+	sort.Strings(sorted)
+	return sorted
+}
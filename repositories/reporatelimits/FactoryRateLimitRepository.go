@@ -0,0 +1,14 @@
+package reporatelimits
+
+import (
+	"goapi/config"
+	"goapi/database"
+)
+
+func CreateRateLimitRepository(config *config.Config) RateLimitRepository {
+	if database.StorageTypeOf(&config.StorageConfig) != database.StorageMongo || !config.RateLimitConfig.Shared {
+		return &InMemoryRateLimitRepo{}
+	} else {
+		return NewMongoDbRateLimitRepo(database.GetMongoDatabaseHandler())
+	}
+}
@@ -0,0 +1,49 @@
+package reporatelimits
+
+import (
+	"sync"
+	"time"
+)
+
+//sweepInterval is the number of requests between two sweeps of the idle buckets
+const sweepInterval = 1000
+
+//limitedBucket is a bucket with the limit it is filled with
+type limitedBucket struct {
+	bucket
+	limit Limit
+}
+
+// InMemoryRateLimitRepo keeps the buckets of the clients of the instance
+type InMemoryRateLimitRepo struct {
+	bucketsByKey map[string]limitedBucket
+	takes        int
+	lock         sync.Mutex
+}
+
+func (r *InMemoryRateLimitRepo) Take(key string, limit Limit) (Result, error) {
+	now := time.Now()
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.bucketsByKey == nil {
+		r.bucketsByKey = make(map[string]limitedBucket)
+	}
+
+	current, found := r.bucketsByKey[key]
+	if !found {
+		current = limitedBucket{newBucket(limit, now), limit}
+	}
+	updated, result := current.take(limit, now)
+	r.bucketsByKey[key] = limitedBucket{updated, limit}
+
+	//the full buckets are forgotten, so that the clients seen once are not kept forever
+	r.takes++
+	if r.takes%sweepInterval == 0 {
+		for key, stored := range r.bucketsByKey {
+			if stored.idle(stored.limit, now) {
+				delete(r.bucketsByKey, key)
+			}
+		}
+	}
+	return result, nil
+}
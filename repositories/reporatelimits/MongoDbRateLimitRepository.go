@@ -0,0 +1,96 @@
+package reporatelimits
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"goapi/database"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+//maxAttempts is how many times a take is tried again when the bucket is written by another instance in the meantime
+const maxAttempts = 5
+
+//storedBucket is a bucket of the collection, expireAt is when it is full again and can be removed
+type storedBucket struct {
+	Key       string    `bson:"_id"`
+	Tokens    float64   `bson:"tokens"`
+	UpdatedAt time.Time `bson:"updatedAt"`
+	Version   int64     `bson:"version"`
+	ExpireAt  time.Time `bson:"expireAt"`
+}
+
+type mongoDbRateLimitRepo struct {
+	store *database.MongoDatastore
+}
+
+//interface ObserverDatabase implementation
+func (r *mongoDbRateLimitRepo) SetDataStore(dataStore *database.MongoDatastore) {
+	r.store = dataStore
+}
+
+// NewMongoDbRateLimitRepo returns a repository keeping the buckets in mongo, shared by all the instances
+func NewMongoDbRateLimitRepo(databaseHandler *database.MongoDataBaseHandler) *mongoDbRateLimitRepo {
+	repo := &mongoDbRateLimitRepo{}
+	repo.store = databaseHandler.GetDataStore()
+	if repo.store == nil {
+		databaseHandler.RegisterAsObserver(repo)
+	}
+	return repo
+}
+
+// Take reads the bucket then writes it if nobody wrote it in the meantime, otherwise it is tried again.
+// The request is refused when the bucket is still written by other requests after maxAttempts, so that flooding a key cannot get more requests through.
+func (r *mongoDbRateLimitRepo) Take(key string, limit Limit) (Result, error) {
+	if r.store == nil {
+		return Result{}, ErrNoDatastore
+	}
+	collection := r.store.Database.Collection(database.RateLimitCollectionName)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		now := time.Now()
+		var stored storedBucket
+		err := collection.FindOne(ctx, bson.M{"_id": key}).Decode(&stored)
+		found := err == nil
+		current := bucket{Tokens: stored.Tokens, UpdatedAt: stored.UpdatedAt}
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			current = newBucket(limit, now)
+		case err != nil:
+			return Result{}, fmt.Errorf("Cannot read the bucket %s [err=%w]", key, err)
+		}
+
+		updated, result := current.take(limit, now)
+		expireAt := now.Add(result.ResetAfter)
+		if !found {
+			_, err = collection.InsertOne(ctx, storedBucket{Key: key, Tokens: updated.Tokens, UpdatedAt: updated.UpdatedAt, Version: 1, ExpireAt: expireAt})
+			if mongo.IsDuplicateKeyError(err) {
+				continue
+			}
+			if err != nil {
+				return Result{}, fmt.Errorf("Cannot write the bucket %s [err=%w]", key, err)
+			}
+			return result, nil
+		}
+
+		res, err := collection.UpdateOne(ctx,
+			bson.M{"_id": key, "version": stored.Version},
+			bson.M{
+				"$set": bson.M{"tokens": updated.Tokens, "updatedAt": updated.UpdatedAt, "expireAt": expireAt},
+				"$inc": bson.M{"version": 1},
+			})
+		if err != nil {
+			return Result{}, fmt.Errorf("Cannot write the bucket %s [err=%w]", key, err)
+		}
+		if res.MatchedCount == 1 {
+			return result, nil
+		}
+	}
+	//the bucket is written by too many requests at once, the request is refused rather than let through
+	return contended(limit), nil
+}
@@ -0,0 +1,96 @@
+package reporatelimits
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+// ErrNoDatastore is returned when the database keeping the buckets is not available
+var ErrNoDatastore = errors.New("no datastore")
+
+// Limit is the token bucket of a client on a route: the bucket is filled at RequestsPerMinute up to Burst tokens, each request takes one
+type Limit struct {
+	RequestsPerMinute int
+	Burst             int
+}
+
+// Result is the bucket of a client after one of its requests
+type Result struct {
+	//Allowed is false when the bucket was empty, the request must be refused
+	Allowed bool
+	//Remaining are the requests the client can still make at once
+	Remaining int
+	//ResetAfter is when the bucket is full again
+	ResetAfter time.Duration
+	//RetryAfter is when the next request can be made, 0 when the request is allowed
+	RetryAfter time.Duration
+}
+
+type RateLimitRepository interface {
+	// Take takes a token from the bucket of the key for a request, the bucket of a new key is full
+	Take(key string, limit Limit) (Result, error)
+}
+
+//bucket is the state of a token bucket, its tokens at the time of its last request
+type bucket struct {
+	Tokens    float64   `bson:"tokens"`
+	UpdatedAt time.Time `bson:"updatedAt"`
+}
+
+//burst is the size of the bucket of the limit
+func (l Limit) burst() float64 {
+	if l.Burst > 0 {
+		return float64(l.Burst)
+	}
+	return float64(l.RequestsPerMinute)
+}
+
+//perSecond is the rate the bucket of the limit is filled at
+func (l Limit) perSecond() float64 {
+	return float64(l.RequestsPerMinute) / 60
+}
+
+//seconds returns the duration of a number of seconds
+func seconds(s float64) time.Duration {
+	return time.Duration(math.Ceil(s * float64(time.Second)))
+}
+
+//newBucket is the bucket of a new key, full
+func newBucket(limit Limit, now time.Time) bucket {
+	return bucket{Tokens: limit.burst(), UpdatedAt: now}
+}
+
+//take fills the bucket for the time elapsed since its last request then takes a token, if there is one
+func (b bucket) take(limit Limit, now time.Time) (bucket, Result) {
+	elapsed := now.Sub(b.UpdatedAt).Seconds()
+	if elapsed < 0 {
+		//the clocks of the instances are not exactly the same
+		elapsed = 0
+	}
+	tokens := math.Min(limit.burst(), b.Tokens+elapsed*limit.perSecond())
+
+	result := Result{Allowed: tokens >= 1}
+	if result.Allowed {
+		tokens--
+	} else {
+		result.RetryAfter = seconds((1 - tokens) / limit.perSecond())
+	}
+	result.Remaining = int(math.Floor(tokens))
+	result.ResetAfter = seconds((limit.burst() - tokens) / limit.perSecond())
+	return bucket{Tokens: tokens, UpdatedAt: now}, result
+}
+
+//contended is the result refusing a request whose bucket is written by too many requests at once, it can be tried again once a token is added
+func contended(limit Limit) Result {
+	return Result{
+		Allowed:    false,
+		ResetAfter: seconds(limit.burst() / limit.perSecond()),
+		RetryAfter: seconds(1 / limit.perSecond()),
+	}
+}
+
+//idle tells if the bucket is full at the time, it is then the same as the bucket of a new key
+func (b bucket) idle(limit Limit, now time.Time) bool {
+	return b.Tokens+now.Sub(b.UpdatedAt).Seconds()*limit.perSecond() >= limit.burst()
+}
@@ -0,0 +1,298 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"goapi/config"
+	"goapi/resources/problems"
+	"goapi/resources/tenants"
+	"goapi/services/servicedocuments"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// DefaultAPIKeyHeader is the header of the API keys when the configuration has none
+const DefaultAPIKeyHeader = "X-API-Key"
+
+// PrincipalKey is the key of the authenticated Principal in the gin context
+const PrincipalKey = "principal"
+
+//the claims of the groups and the roles when the configuration has none
+const (
+	defaultGroupsClaim = "groups"
+	defaultRolesClaim  = "roles"
+)
+
+//the algorithms of the accepted tokens, "none" never is
+var validMethods = []string{"HS256", "HS384", "HS512", "RS256", "RS384", "RS512"}
+
+// Principal is who makes an authenticated request, the subject of its token or the client of its API key
+type Principal struct {
+	Subject string
+	Groups  []string
+	Roles   []string
+	//Scopes are the scopes granted to the token, from its scope or scp claim, and to the roles
+	Scopes []string
+	//Claims are the claims of the token, empty for an API key
+	Claims map[string]interface{}
+	//Tenant is the tenant of an API key, the tenant of a token is told by its claims
+	Tenant string
+}
+
+// Binding returns the tenant the principal is bound to, its requests cannot be made for another tenant
+func (p *Principal) Binding() *tenants.Binding {
+	return &tenants.Binding{Tenant: p.Tenant, Claims: p.Claims}
+}
+
+// PrincipalFrom returns the principal of the request, false when it is not authenticated
+func PrincipalFrom(c *gin.Context) (*Principal, bool) {
+	value, ok := c.Get(PrincipalKey)
+	if !ok {
+		return nil, false
+	}
+	principal, ok := value.(*Principal)
+	return principal, ok
+}
+
+//apiKey is a configured API key, by the hash of the key
+type apiKey struct {
+	hash      []byte
+	principal Principal
+}
+
+type authenticator struct {
+	configuration *config.AuthConfig
+	keys          map[string]verificationKey
+	apiKeys       []apiKey
+	apiKeyHeader  string
+	parser        *jwt.Parser
+}
+
+//newAuthenticator loads the keys of the configuration
+func newAuthenticator(configuration *config.AuthConfig) (*authenticator, error) {
+	keys, err := loadKeys(&configuration.JWT)
+	if err != nil {
+		return nil, err
+	}
+	a := &authenticator{
+		configuration: configuration,
+		keys:          keys,
+		apiKeyHeader:  configuration.APIKeyHeader,
+		parser:        jwt.NewParser(jwt.WithValidMethods(validMethods)),
+	}
+	if len(a.apiKeyHeader) == 0 {
+		a.apiKeyHeader = DefaultAPIKeyHeader
+	}
+	for _, keyConfig := range configuration.APIKeys {
+		hash, err := hex.DecodeString(keyConfig.Hash)
+		if err != nil || len(hash) != sha256.Size || len(keyConfig.Name) == 0 {
+			return nil, fmt.Errorf("the API key %q must have a name and the hex SHA-256 of the key as hash", keyConfig.Name)
+		}
+		a.apiKeys = append(a.apiKeys, apiKey{
+			hash:      hash,
+			principal: Principal{Subject: keyConfig.Name, Groups: keyConfig.Groups, Roles: keyConfig.Roles, Tenant: keyConfig.Tenant},
+		})
+	}
+	return a, nil
+}
+
+//public tells if the path is served without authentication, it is a public path or below one, so /health is public but not /healthz
+func (a *authenticator) public(path string) bool {
+	for _, prefix := range a.configuration.PublicPaths {
+		if len(prefix) == 0 {
+			continue
+		}
+		if path == prefix || strings.HasPrefix(path, strings.TrimSuffix(prefix, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+//key returns the key checking the signature of the token, by its kid and its algorithm
+func (a *authenticator) key(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, found := a.keys[kid]
+	if !found {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		if key.secret != nil {
+			return key.secret, nil
+		}
+	case *jwt.SigningMethodRSA:
+		if key.publicKey != nil {
+			return key.publicKey, nil
+		}
+	}
+	return nil, fmt.Errorf("the key %q cannot check %s signatures", kid, token.Method.Alg())
+}
+
+//stringsClaim returns the values of a claim given as a list of strings, or as a string separated by spaces
+func stringsClaim(claims jwt.MapClaims, name string) []string {
+	switch value := claims[name].(type) {
+	case string:
+		return strings.Fields(value)
+	case []interface{}:
+		var values []string
+		for _, item := range value {
+			if s, ok := item.(string); ok && len(s) > 0 {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+//claimName returns the configured name of a claim, or its default name
+func claimName(configured string, defaultName string) string {
+	if len(configured) > 0 {
+		return configured
+	}
+	return defaultName
+}
+
+//authenticateToken checks the signature, the validity, the issuer and the audience of the token and returns its subject
+func (a *authenticator) authenticateToken(tokenString string) (*Principal, error) {
+	claims := jwt.MapClaims{}
+	if _, err := a.parser.ParseWithClaims(tokenString, claims, a.key); err != nil {
+		return nil, err
+	}
+	jwtConfig := &a.configuration.JWT
+	if len(jwtConfig.Issuer) > 0 && !claims.VerifyIssuer(jwtConfig.Issuer, true) {
+		return nil, errors.New("invalid issuer")
+	}
+	if len(jwtConfig.Audience) > 0 && !claims.VerifyAudience(jwtConfig.Audience, true) {
+		return nil, errors.New("invalid audience")
+	}
+	subject, _ := claims["sub"].(string)
+	if len(strings.TrimSpace(subject)) == 0 {
+		return nil, errors.New("the token has no subject")
+	}
+	scopes := stringsClaim(claims, "scope")
+	if len(scopes) == 0 {
+		scopes = stringsClaim(claims, "scp")
+	}
+	return &Principal{
+		Subject: subject,
+		Groups:  stringsClaim(claims, claimName(jwtConfig.GroupsClaim, defaultGroupsClaim)),
+		Roles:   stringsClaim(claims, claimName(jwtConfig.RolesClaim, defaultRolesClaim)),
+		Scopes:  scopes,
+		Claims:  claims,
+	}, nil
+}
+
+//authenticateAPIKey returns the client of the API key, comparing the hash of the key with all the configured ones in constant time
+func (a *authenticator) authenticateAPIKey(key string) (*Principal, error) {
+	hash := sha256.Sum256([]byte(key))
+	var found *Principal
+	for i := range a.apiKeys {
+		if subtle.ConstantTimeCompare(hash[:], a.apiKeys[i].hash) == 1 {
+			principal := a.apiKeys[i].principal
+			found = &principal
+		}
+	}
+	if found == nil {
+		return nil, errors.New("unknown API key")
+	}
+	return found, nil
+}
+
+//authenticate returns the principal of the bearer token or of the API key of the request, with the scopes of its roles
+func (a *authenticator) authenticate(header func(name string) string) (*Principal, error) {
+	principal, err := a.credentials(header)
+	if err != nil {
+		return nil, err
+	}
+	principal.Scopes = grantedScopes(principal.Scopes, principal.Roles, a.configuration.Authorization.Roles)
+	return principal, nil
+}
+
+//credentials returns the principal of the bearer token or of the API key of the request
+func (a *authenticator) credentials(header func(name string) string) (*Principal, error) {
+	if authorization := header("Authorization"); len(authorization) > 0 {
+		scheme, token, _ := cut(authorization, " ")
+		if !strings.EqualFold(scheme, "Bearer") || len(strings.TrimSpace(token)) == 0 {
+			return nil, errors.New("the Authorization header must be a Bearer token")
+		}
+		return a.authenticateToken(strings.TrimSpace(token))
+	}
+	if key := header(a.apiKeyHeader); len(key) > 0 {
+		return a.authenticateAPIKey(key)
+	}
+	return nil, fmt.Errorf("a Bearer token or an API key in the header %s is required", a.apiKeyHeader)
+}
+
+//cut slices s around the first instance of sep
+func cut(s string, sep string) (string, string, bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// Middleware authenticates the requests with a JWT bearer token or an API key, the others get a 401.
+// The principal is set in the gin context with the scopes of its roles for RequireScopes, its tenant for the tenants middleware that must be used after this one,
+// and its subject and groups in the context of the request as the actor of the services.
+// It does nothing when the authentication is not enabled.
+func Middleware(configuration *config.AuthConfig) (gin.HandlerFunc, error) {
+	if !configuration.Enabled {
+		return func(c *gin.Context) { c.Next() }, nil
+	}
+	a, err := newAuthenticator(configuration)
+	if err != nil {
+		return nil, err
+	}
+	return func(c *gin.Context) {
+		if a.public(c.Request.URL.Path) {
+			c.Next()
+			return
+		}
+		principal, err := a.authenticate(c.GetHeader)
+		if err != nil {
+			c.Header("WWW-Authenticate", `Bearer realm="goapi"`)
+			_ = c.Error(problems.New(http.StatusUnauthorized, "Authentication failed [err=%s]", err))
+			c.Abort()
+			return
+		}
+
+		c.Set(PrincipalKey, principal)
+		if configuration.Authorization.Enabled {
+			c.Set(authorizationKey, &configuration.Authorization)
+		}
+		c.Set(tenants.BindingKey, principal.Binding())
+		ctx := servicedocuments.WithGroups(servicedocuments.WithActor(c.Request.Context(), principal.Subject), principal.Groups)
+		c.Request = c.Request.WithContext(servicedocuments.WithAdmin(ctx, principal.HasScope(AdminScope)))
+		c.Next()
+	}, nil
+}
+
+// Authenticator authenticates the calls of the APIs not served by gin, like gRPC, with the same tokens and API keys as Middleware
+type Authenticator struct {
+	authenticator *authenticator
+}
+
+// NewAuthenticator returns the authenticator of the configuration, nil when the authentication is not enabled
+func NewAuthenticator(configuration *config.AuthConfig) (*Authenticator, error) {
+	if !configuration.Enabled {
+		return nil, nil
+	}
+	a, err := newAuthenticator(configuration)
+	if err != nil {
+		return nil, err
+	}
+	return &Authenticator{authenticator: a}, nil
+}
+
+// Authenticate returns the principal of the bearer token or of the API key read by header, with the scopes of its roles
+func (a *Authenticator) Authenticate(header func(name string) string) (*Principal, error) {
+	return a.authenticator.authenticate(header)
+}
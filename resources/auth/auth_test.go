@@ -0,0 +1,290 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"goapi/config"
+	"goapi/models"
+	"goapi/resources/problems"
+	"goapi/resources/tenants"
+	"goapi/services/servicedocuments"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+//configureRouter returns a router answering the actor, the groups and the tenant of the requests
+func configureRouter(t *testing.T, configuration *config.AuthConfig) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	authentication, err := Middleware(configuration)
+	assert.Nil(t, err)
+	router := gin.New()
+	router.Use(problems.Handler())
+	router.Use(authentication)
+	router.Use(tenants.Middleware(&config.TenancyConfig{Claim: "tenant"}))
+	answer := func(c *gin.Context) {
+		ctx := c.Request.Context()
+		c.String(http.StatusOK, servicedocuments.ActorFrom(ctx)+" "+strings.Join(servicedocuments.GroupsFrom(ctx), ",")+" "+servicedocuments.TenantFrom(ctx))
+	}
+	router.GET("/documents", answer)
+	router.GET("/documents/purge", RequireScopes("documents:write", "admin:purge"), answer)
+	router.GET("/documents/admin", func(c *gin.Context) {
+		c.String(http.StatusOK, strconv.FormatBool(servicedocuments.AdminFrom(c.Request.Context())))
+	})
+	router.GET("/health", answer)
+	router.GET("/healthz", answer)
+	router.GET("/health/live", answer)
+	return router
+}
+
+func executeRequest(router *gin.Engine, path string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", path, nil)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func bearer(token string) map[string]string {
+	return map[string]string{"Authorization": "Bearer " + token}
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	if len(kid) > 0 {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	assert.Nil(t, err)
+	return signed
+}
+
+func checkUnauthorized(t *testing.T, recorder *httptest.ResponseRecorder, expectedDetail string) {
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+	assert.Equal(t, `Bearer realm="goapi"`, recorder.Header().Get("WWW-Authenticate"))
+	var problem models.Problem
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &problem))
+	assert.Equal(t, expectedDetail, problem.Detail)
+}
+
+func TestMiddlewareDisabled(t *testing.T) {
+	router := configureRouter(t, &config.AuthConfig{})
+
+	recorder := executeRequest(router, "/documents", nil)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "anonymous  default", recorder.Body.String())
+}
+
+func TestMiddlewareHMAC(t *testing.T) {
+	router := configureRouter(t, &config.AuthConfig{
+		Enabled: true,
+		JWT:     config.JWTConfig{Keys: []config.JWTKeyConfig{{Secret: "secret"}}, Issuer: "https://issuer", Audience: "goapi"},
+	})
+	valid := jwt.MapClaims{"sub": "alice", "iss": "https://issuer", "aud": []string{"other", "goapi"},
+		"groups": []string{"finance", "legal"}, "tenant": "payments", "exp": time.Now().Add(time.Hour).Unix()}
+
+	recorder := executeRequest(router, "/documents", bearer(sign(t, jwt.SigningMethodHS256, "", []byte("secret"), valid)))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "alice finance,legal payments", recorder.Body.String())
+
+	for _, test := range []struct {
+		claims         jwt.MapClaims
+		secret         string
+		expectedDetail string
+	}{
+		{jwt.MapClaims{"sub": "alice", "iss": "https://issuer", "aud": "goapi"}, "wrong", "Authentication failed [err=signature is invalid]"},
+		{jwt.MapClaims{"sub": "alice", "iss": "https://other", "aud": "goapi"}, "secret", "Authentication failed [err=invalid issuer]"},
+		{jwt.MapClaims{"sub": "alice", "iss": "https://issuer", "aud": "other"}, "secret", "Authentication failed [err=invalid audience]"},
+		{jwt.MapClaims{"iss": "https://issuer", "aud": "goapi"}, "secret", "Authentication failed [err=the token has no subject]"},
+		{jwt.MapClaims{"sub": "alice", "iss": "https://issuer", "aud": "goapi", "exp": time.Now().Add(-time.Minute).Unix()}, "secret",
+			"Authentication failed [err=Token is expired]"},
+	} {
+		recorder = executeRequest(router, "/documents", bearer(sign(t, jwt.SigningMethodHS256, "", []byte(test.secret), test.claims)))
+		checkUnauthorized(t, recorder, test.expectedDetail)
+	}
+
+	//the unsigned tokens are refused
+	unsigned := sign(t, jwt.SigningMethodNone, "", jwt.UnsafeAllowNoneSignatureType, valid)
+	checkUnauthorized(t, executeRequest(router, "/documents", bearer(unsigned)),
+		"Authentication failed [err=signing method none is invalid]")
+	checkUnauthorized(t, executeRequest(router, "/documents", map[string]string{"Authorization": "Basic YWxpY2U6cGFzcw=="}),
+		"Authentication failed [err=the Authorization header must be a Bearer token]")
+	checkUnauthorized(t, executeRequest(router, "/documents", nil),
+		"Authentication failed [err=a Bearer token or an API key in the header X-API-Key is required]")
+}
+
+func TestMiddlewareAdmin(t *testing.T) {
+	router := configureRouter(t, &config.AuthConfig{Enabled: true, JWT: config.JWTConfig{Keys: []config.JWTKeyConfig{{Secret: "secret"}}}})
+	token := func(claims jwt.MapClaims) map[string]string {
+		return bearer(sign(t, jwt.SigningMethodHS256, "", []byte("secret"), claims))
+	}
+
+	//only the principals with the admin scope can change the ACL of any document
+	for _, test := range []struct {
+		scope         string
+		expectedAdmin string
+	}{{"documents:read documents:write", "false"}, {"documents:admin", "true"}, {"documents:*", "true"}} {
+		recorder := executeRequest(router, "/documents/admin", token(jwt.MapClaims{"sub": "alice", "scope": test.scope}))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, test.expectedAdmin, recorder.Body.String(), test.scope)
+	}
+}
+
+func TestMiddlewareRSA(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	jwksKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	//one key in a PEM file, the other in a JWKS file
+	dir := t.TempDir()
+	publicKey, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	assert.Nil(t, err)
+	pemFile := filepath.Join(dir, "public.pem")
+	assert.Nil(t, ioutil.WriteFile(pemFile, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKey}), 0600))
+	jwks, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kty": "EC", "kid": "ec", "crv": "P-256"},
+		{"kty": "RSA", "kid": "jwks", "use": "sig",
+			"n": base64.RawURLEncoding.EncodeToString(jwksKey.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(jwksKey.E)).Bytes())},
+	}})
+	jwksFile := filepath.Join(dir, "jwks.json")
+	assert.Nil(t, ioutil.WriteFile(jwksFile, jwks, 0600))
+
+	router := configureRouter(t, &config.AuthConfig{
+		Enabled: true,
+		JWT: config.JWTConfig{
+			Keys:        []config.JWTKeyConfig{{ID: "pem", PublicKeyFile: pemFile}, {ID: "hmac", Secret: "secret"}},
+			JWKSFile:    jwksFile,
+			GroupsClaim: "teams",
+		},
+	})
+
+	recorder := executeRequest(router, "/documents", bearer(sign(t, jwt.SigningMethodRS256, "pem", privateKey, jwt.MapClaims{"sub": "alice"})))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	recorder = executeRequest(router, "/documents", bearer(sign(t, jwt.SigningMethodRS512, "jwks", jwksKey, jwt.MapClaims{"sub": "bob", "teams": "finance legal"})))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "bob finance,legal default", recorder.Body.String())
+
+	//the key is chosen by the kid of the token, and must be of the algorithm of the token
+	checkUnauthorized(t, executeRequest(router, "/documents", bearer(sign(t, jwt.SigningMethodRS256, "jwks", privateKey, jwt.MapClaims{"sub": "alice"}))),
+		"Authentication failed [err=crypto/rsa: verification error]")
+	checkUnauthorized(t, executeRequest(router, "/documents", bearer(sign(t, jwt.SigningMethodRS256, "other", privateKey, jwt.MapClaims{"sub": "alice"}))),
+		`Authentication failed [err=unknown key id "other"]`)
+	checkUnauthorized(t, executeRequest(router, "/documents", bearer(sign(t, jwt.SigningMethodHS256, "pem", []byte("secret"), jwt.MapClaims{"sub": "alice"}))),
+		`Authentication failed [err=the key "pem" cannot check HS256 signatures]`)
+}
+
+func TestMiddlewareAPIKey(t *testing.T) {
+	hash := sha256.Sum256([]byte("s3cr3t-k3y"))
+	router := configureRouter(t, &config.AuthConfig{
+		Enabled:     true,
+		APIKeys:     []config.APIKeyConfig{{Name: "billing-batch", Hash: hex.EncodeToString(hash[:]), Groups: []string{"billing"}, Tenant: "payments"}},
+		PublicPaths: []string{"/health"},
+	})
+
+	//the actor cannot be overridden by the headers
+	recorder := executeRequest(router, "/documents", map[string]string{DefaultAPIKeyHeader: "s3cr3t-k3y", "X-User": "alice", "X-Tenant": "payments"})
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "billing-batch billing payments", recorder.Body.String())
+	checkUnauthorized(t, executeRequest(router, "/documents", map[string]string{DefaultAPIKeyHeader: "wrong"}),
+		"Authentication failed [err=unknown API key]")
+
+	//the requests of the key are made for its tenant, the header cannot choose another one
+	recorder = executeRequest(router, "/documents", map[string]string{DefaultAPIKeyHeader: "s3cr3t-k3y"})
+	assert.Equal(t, "billing-batch billing payments", recorder.Body.String())
+	recorder = executeRequest(router, "/documents", map[string]string{DefaultAPIKeyHeader: "s3cr3t-k3y", "X-Tenant": "billing"})
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+
+	//the public paths need no authentication
+	recorder = executeRequest(router, "/health", nil)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "anonymous  default", recorder.Body.String())
+	recorder = executeRequest(router, "/health/live", nil)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	//a path only sharing the beginning of a public path is not public
+	recorder = executeRequest(router, "/healthz", nil)
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+}
+
+func TestMiddlewareInvalidConfiguration(t *testing.T) {
+	for _, test := range []struct {
+		configuration config.AuthConfig
+		expectedError string
+	}{
+		{config.AuthConfig{Enabled: true, JWT: config.JWTConfig{Keys: []config.JWTKeyConfig{{ID: "k1"}}}},
+			`the key "k1" has neither secret nor public key file`},
+		{config.AuthConfig{Enabled: true, JWT: config.JWTConfig{Keys: []config.JWTKeyConfig{{Secret: "a"}, {Secret: "b"}}}},
+			`the key id "" is defined twice`},
+		{config.AuthConfig{Enabled: true, APIKeys: []config.APIKeyConfig{{Name: "batch", Hash: "s3cr3t"}}},
+			`the API key "batch" must have a name and the hex SHA-256 of the key as hash`},
+	} {
+		_, err := Middleware(&test.configuration)
+		assert.EqualError(t, err, test.expectedError)
+	}
+}
+
+func TestPrincipal_HasScope(t *testing.T) {
+	principal := Principal{Scopes: []string{"documents:read", "admin:*"}}
+	assert.True(t, principal.HasScope("documents:read"))
+	assert.True(t, principal.HasScope("admin:purge"))
+	assert.False(t, principal.HasScope("documents:write"))
+	assert.False(t, principal.HasScope("administrator:purge"))
+	assert.True(t, (&Principal{Scopes: []string{"*"}}).HasScope("emails:send"))
+}
+
+func TestRequireScopes(t *testing.T) {
+	configuration := config.AuthConfig{
+		Enabled: true,
+		JWT:     config.JWTConfig{Keys: []config.JWTKeyConfig{{Secret: "secret"}}},
+		Authorization: config.AuthorizationConfig{
+			Enabled: true,
+			Roles:   map[string][]string{"editor": {"documents:read", "documents:write"}, "admin": {"admin:*"}},
+		},
+	}
+	router := configureRouter(t, &configuration)
+	token := func(claims jwt.MapClaims) map[string]string {
+		return bearer(sign(t, jwt.SigningMethodHS256, "", []byte("secret"), claims))
+	}
+
+	//the scopes come from the token and from the roles
+	recorder := executeRequest(router, "/documents/purge", token(jwt.MapClaims{"sub": "alice", "roles": []string{"editor", "admin"}}))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	recorder = executeRequest(router, "/documents/purge", token(jwt.MapClaims{"sub": "alice", "scope": "admin:purge", "roles": []string{"editor"}}))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	//the missing scopes are listed
+	recorder = executeRequest(router, "/documents/purge", token(jwt.MapClaims{"sub": "bob", "scp": []string{"documents:read"}}))
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+	var problem models.Problem
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &problem))
+	assert.Equal(t, "Authorization failed [err=missing scopes documents:write admin:purge]", problem.Detail)
+	assert.Equal(t, []string{"documents:write", "admin:purge"}, problem.MissingScopes)
+
+	//the routes without scopes only need the authentication
+	recorder = executeRequest(router, "/documents", token(jwt.MapClaims{"sub": "bob"}))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	//in dry run, the denials are only logged
+	configuration.Authorization.DryRun = true
+	recorder = executeRequest(configureRouter(t, &configuration), "/documents/purge", token(jwt.MapClaims{"sub": "bob"}))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+}
@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"goapi/config"
+	"io/ioutil"
+	"math/big"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+//verificationKey checks the signature of the tokens having its kid, with HMAC or with RSA
+type verificationKey struct {
+	secret    []byte
+	publicKey *rsa.PublicKey
+}
+
+//jsonWebKey is a key of a JSON Web Key Set (RFC 7517), only the RSA public keys are used
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+//rsaPublicKey returns the RSA public key of the modulus and the exponent of a JSON Web Key
+func (key jsonWebKey) rsaPublicKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(key.N)
+	if err != nil {
+		return nil, fmt.Errorf("invalid modulus [err=%w]", err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(key.E)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exponent [err=%w]", err)
+	}
+	exponent := new(big.Int).SetBytes(e)
+	if len(n) == 0 || !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+		return nil, fmt.Errorf("invalid modulus or exponent")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+}
+
+//loadKeys returns the keys of the configuration and of its JWKS file by kid
+func loadKeys(configuration *config.JWTConfig) (map[string]verificationKey, error) {
+	keys := make(map[string]verificationKey)
+	add := func(id string, key verificationKey) error {
+		if _, found := keys[id]; found {
+			return fmt.Errorf("the key id %q is defined twice", id)
+		}
+		keys[id] = key
+		return nil
+	}
+
+	for _, keyConfig := range configuration.Keys {
+		var key verificationKey
+		switch {
+		case len(keyConfig.Secret) > 0:
+			key.secret = []byte(keyConfig.Secret)
+		case len(keyConfig.PublicKeyFile) > 0:
+			pem, err := ioutil.ReadFile(keyConfig.PublicKeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("Cannot read public key %s [err=%w]", keyConfig.PublicKeyFile, err)
+			}
+			if key.publicKey, err = jwt.ParseRSAPublicKeyFromPEM(pem); err != nil {
+				return nil, fmt.Errorf("Cannot parse public key %s [err=%w]", keyConfig.PublicKeyFile, err)
+			}
+		default:
+			return nil, fmt.Errorf("the key %q has neither secret nor public key file", keyConfig.ID)
+		}
+		if err := add(keyConfig.ID, key); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(configuration.JWKSFile) == 0 {
+		return keys, nil
+	}
+	content, err := ioutil.ReadFile(configuration.JWKSFile)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read JWKS %s [err=%w]", configuration.JWKSFile, err)
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(content, &set); err != nil {
+		return nil, fmt.Errorf("Cannot parse JWKS %s [err=%w]", configuration.JWKSFile, err)
+	}
+	for _, webKey := range set.Keys {
+		//the encryption keys and the keys of other types are not used to check signatures
+		if webKey.Kty != "RSA" || (len(webKey.Use) > 0 && webKey.Use != "sig") {
+			continue
+		}
+		publicKey, err := webKey.rsaPublicKey()
+		if err != nil {
+			return nil, fmt.Errorf("Cannot parse the key %q of JWKS %s [err=%w]", webKey.Kid, configuration.JWKSFile, err)
+		}
+		if err := add(webKey.Kid, verificationKey{publicKey: publicKey}); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"goapi/config"
+	"goapi/resources/problems"
+	"goapi/services/servicedocuments"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+//authorizationKey is the key of the authorization configuration in the gin context, set when the scopes are checked
+const authorizationKey = "authorization"
+
+// AdminScope lets a principal change the ACL of any document, the documents without ACL have no owner that could
+const AdminScope = "documents:admin"
+
+//wildcard grants all the scopes, or all the scopes of a prefix like documents:*
+const wildcard = "*"
+
+// HasScope tells if the principal was granted the scope, itself or with a wildcard like documents:* or *
+func (p *Principal) HasScope(scope string) bool {
+	for _, granted := range p.Scopes {
+		if granted == scope || granted == wildcard ||
+			(strings.HasSuffix(granted, ":"+wildcard) && strings.HasPrefix(scope, strings.TrimSuffix(granted, wildcard))) {
+			return true
+		}
+	}
+	return false
+}
+
+// MissingScopes returns the scopes the principal was not granted, in their order
+func (p *Principal) MissingScopes(scopes ...string) []string {
+	var missing []string
+	for _, scope := range scopes {
+		if !p.HasScope(scope) {
+			missing = append(missing, scope)
+		}
+	}
+	return missing
+}
+
+//grantedScopes returns the scopes of the token followed by the scopes of the roles, without duplicates
+func grantedScopes(scopes []string, roles []string, roleScopes map[string][]string) []string {
+	var granted []string
+	unique := make(map[string]bool)
+	add := func(scopes []string) {
+		for _, scope := range scopes {
+			if !unique[scope] {
+				unique[scope] = true
+				granted = append(granted, scope)
+			}
+		}
+	}
+	add(scopes)
+	for _, role := range roles {
+		add(roleScopes[role])
+	}
+	return granted
+}
+
+// CheckScopes returns a 403 error when the principal of the request lacks some of the scopes, nil in dry run where it is only logged.
+// The scopes are only checked when the authorization is enabled, the requests of the public paths are never checked.
+func CheckScopes(c *gin.Context, scopes ...string) error {
+	value, enforced := c.Get(authorizationKey)
+	principal, authenticated := PrincipalFrom(c)
+	if !enforced || !authenticated {
+		return nil
+	}
+	missing := principal.MissingScopes(scopes...)
+	if len(missing) == 0 {
+		return nil
+	}
+
+	err := &problems.MissingScopesError{Scopes: missing}
+	if value.(*config.AuthorizationConfig).DryRun {
+		servicedocuments.LoggerFrom(c.Request.Context()).Warnf("%s %s of %s would be denied in dry run [err=%s]",
+			c.Request.Method, c.Request.URL.Path, principal.Subject, err)
+		return nil
+	}
+	return err
+}
+
+// RequireScopes rejects with a 403 the requests of the principals without all the scopes, or only logs them in dry run, see CheckScopes.
+func RequireScopes(scopes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := CheckScopes(c, scopes...); err != nil {
+			_ = c.Error(err)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
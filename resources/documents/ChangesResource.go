@@ -0,0 +1,184 @@
+package documents
+
+import (
+	"errors"
+	"fmt"
+	"goapi/models"
+	"goapi/repositories/repodocuments"
+	"goapi/resources/problems"
+	"goapi/services/servicedocuments"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// KeepAliveInterval is how often an idle change feed sends something, so that proxies keep the connection open
+const KeepAliveInterval = 30 * time.Second
+
+//newUpgrader returns the upgrader of the websockets opened by the pages of the API itself or of the allowed origins.
+//CORS does not apply to the handshake, a page of any site could open a websocket with the cookies of its visitor without this check.
+func newUpgrader(allowedOrigins []string) websocket.Upgrader {
+	return websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			//the clients other than the browsers do not send it
+			if len(origin) == 0 {
+				return true
+			}
+			if parsed, err := url.Parse(origin); err == nil && strings.EqualFold(parsed.Host, r.Host) {
+				return true
+			}
+			for _, allowed := range allowedOrigins {
+				if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+					return true
+				}
+			}
+			return false
+		},
+	}
+}
+
+//lastEventIDFrom returns the event to resume the feed after, browsers send the Last-Event-ID header when they reconnect
+func lastEventIDFrom(c *gin.Context) string {
+	if lastEventID := c.GetHeader("Last-Event-ID"); len(lastEventID) > 0 {
+		return lastEventID
+	}
+	return c.Query("lastEventId")
+}
+
+//watchChanges subscribes to the change feed, the request is answered if it fails
+func (resource ResourceDocument) watchChanges(c *gin.Context) (<-chan models.DocumentChange, bool) {
+	changes, err := resource.documentService.WatchChanges(CallerContext(c), lastEventIDFrom(c), c.Query("prefix"))
+	if errors.Is(err, repodocuments.ErrUnknownEvent) {
+		_ = c.Error(fmt.Errorf("Cannot resume the changes [err=%w]", err))
+		return nil, false
+	}
+	if err != nil {
+		_ = c.Error(fmt.Errorf("Cannot watch the changes [err=%w]", err))
+		return nil, false
+	}
+	return changes, true
+}
+
+// Endpoint to stream the changes of the documents
+// @Summary Stream the changes of the documents
+// @Description Stream the creations, updates and deletions of the documents as Server-Sent Events.
+// @Description Each event is named after the type of change, and its id can be used to resume the stream.
+// @Produce  text/event-stream
+// @Param prefix query string false "Only the changes of the documents whose id starts with prefix"
+// @Param Last-Event-ID header string false "Resume the stream after this event"
+// @Param lastEventId query string false "Resume the stream after this event, when the header cannot be set"
+// @Param X-Tenant header string false "Tenant of the documents, the default tenant if absent"
+// @Success 200 {object} models.DocumentChange
+// @Failure 401 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Failure 410 {object} models.Problem
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Router /documents/changes [get]
+func (resource ResourceDocument) StreamChanges(c *gin.Context) {
+	changes, ok := resource.watchChanges(c)
+	if !ok {
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	keepAlive := time.NewTicker(KeepAliveInterval)
+	defer keepAlive.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case change, open := <-changes:
+			if !open {
+				return false
+			}
+			c.Render(-1, sse.Event{Id: change.EventID, Event: change.Type, Data: change})
+		case <-keepAlive.C:
+			//a comment line is ignored by the clients
+			if _, err := io.WriteString(w, ":\n\n"); err != nil {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// Endpoint to stream the changes of the documents over a WebSocket
+// @Summary Stream the changes of the documents over a WebSocket
+// @Description Upgrade to a WebSocket and send each creation, update or deletion of a document as a JSON message.
+// @Description The eventId of a message can be used to resume the stream.
+// @Param prefix query string false "Only the changes of the documents whose id starts with prefix"
+// @Param lastEventId query string false "Resume the stream after this event"
+// @Param X-Tenant header string false "Tenant of the documents, the default tenant if absent"
+// @Success 101 {object} models.DocumentChange
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Failure 410 {object} models.Problem
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Router /documents/changes/ws [get]
+func (resource ResourceDocument) StreamChangesWebSocket(c *gin.Context) {
+	//checked before watching the changes, the upgrader would check it again
+	if !resource.upgrader.CheckOrigin(c.Request) {
+		_ = c.Error(problems.New(http.StatusForbidden, "Origin not allowed [err=%s is not an allowed origin]", c.GetHeader("Origin")))
+		return
+	}
+	changes, ok := resource.watchChanges(c)
+	if !ok {
+		return
+	}
+
+	connection, err := resource.upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		//the upgrader already answered the request
+		servicedocuments.LoggerFrom(c.Request.Context()).Error("Cannot upgrade to websocket", err)
+		return
+	}
+	defer func() {
+		if err := connection.Close(); err != nil {
+			servicedocuments.LoggerFrom(c.Request.Context()).Error("Cannot close websocket", err)
+		}
+	}()
+
+	//the client only sends control messages, read them until it goes away
+	clientGone := make(chan struct{})
+	go func() {
+		defer close(clientGone)
+		for {
+			if _, _, err := connection.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	keepAlive := time.NewTicker(KeepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case change, open := <-changes:
+			if !open {
+				_ = connection.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+				return
+			}
+			if err := connection.WriteJSON(change); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if err := connection.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-clientGone:
+			return
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package documents
+
+import (
+	"encoding/json"
+	"fmt"
+	"goapi/models"
+	"goapi/repositories/repodocuments"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+//changesOf returns a closed channel with the changes
+func changesOf(changes ...models.DocumentChange) <-chan models.DocumentChange {
+	channel := make(chan models.DocumentChange, len(changes))
+	for _, change := range changes {
+		channel <- change
+	}
+	close(channel)
+	return channel
+}
+
+var feedChanges = []models.DocumentChange{
+	{
+		EventID:    "1",
+		Type:       models.ChangeCreated,
+		DocumentID: "toto",
+		Document:   &models.Document{ID: "toto", Version: 1},
+		Timestamp:  time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC),
+	},
+	{
+		EventID:    "2",
+		Type:       models.ChangeDeleted,
+		DocumentID: "toto",
+		Timestamp:  time.Date(2021, 10, 1, 12, 0, 1, 0, time.UTC),
+	},
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_streamChanges() {
+
+	//add handler mock service
+	suite.documentServiceMock.On("WatchChanges", mock.Anything, "", "to").Return(changesOf(feedChanges...), nil)
+
+	//create request
+	req, err := http.NewRequest("GET", suite.testServer.URL+"/documents/changes?prefix=to", nil)
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
+
+	//execute the request, the stream ends with the changes
+	resp, err := suite.testServer.Client().Do(req)
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't send request: %v", err))
+	suite.documentServiceMock.AssertExpectations(suite.T())
+	assert.Equal(suite.T(), http.StatusOK, resp.StatusCode)
+	assert.Equal(suite.T(), "text/event-stream", resp.Header.Get("Content-Type"))
+
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't read body response: %v", err))
+	created, _ := json.Marshal(feedChanges[0])
+	deleted, _ := json.Marshal(feedChanges[1])
+	expected := "id:1\nevent:created\ndata:" + string(created) + "\n\n" + "id:2\nevent:deleted\ndata:" + string(deleted) + "\n\n"
+	assert.Equal(suite.T(), expected, string(body))
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_streamChangesResumed() {
+
+	//add handler mock service
+	suite.documentServiceMock.On("WatchChanges", mock.Anything, "1", "").Return(changesOf(feedChanges[1]), nil)
+
+	//create request
+	req, err := http.NewRequest("GET", suite.testServer.URL+"/documents/changes", nil)
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
+	req.Header.Set("Last-Event-ID", "1")
+
+	//execute the request
+	resp, err := suite.testServer.Client().Do(req)
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't send request: %v", err))
+	suite.documentServiceMock.AssertExpectations(suite.T())
+	body, _ := ioutil.ReadAll(resp.Body)
+	assert.True(suite.T(), strings.HasPrefix(string(body), "id:2\nevent:deleted\n"))
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_streamChangesExpired() {
+
+	//add handler mock service
+	suite.documentServiceMock.On("WatchChanges", mock.Anything, "1", "").Return(nil, repodocuments.ErrUnknownEvent)
+
+	//create request
+	req, err := http.NewRequest("GET", suite.testServer.URL+"/documents/changes?lastEventId=1", nil)
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
+
+	//check result
+	var expectedError = problemOf(req, http.StatusGone, "Cannot resume the changes [err=unknown or expired event id]")
+	executeRequest(suite, req, expectedError, http.StatusGone)
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_streamChangesWebSocket() {
+
+	//add handler mock service
+	suite.documentServiceMock.On("WatchChanges", mock.Anything, "", "").Return(changesOf(feedChanges...), nil)
+
+	//connect
+	url := "ws" + strings.TrimPrefix(suite.testServer.URL, "http") + "/documents/changes/ws"
+	connection, _, err := websocket.DefaultDialer.Dial(url, nil)
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't connect: %v", err))
+	defer connection.Close()
+
+	//the changes are received as json messages, then the connection is closed
+	for _, expected := range feedChanges {
+		var change models.DocumentChange
+		err = connection.ReadJSON(&change)
+		assert.Nil(suite.T(), err)
+		assert.Equal(suite.T(), expected, change)
+	}
+	_, _, err = connection.ReadMessage()
+	assert.True(suite.T(), websocket.IsCloseError(err, websocket.CloseNormalClosure))
+	suite.documentServiceMock.AssertExpectations(suite.T())
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_streamChangesWebSocketOrigin() {
+
+	//add handler mock service
+	suite.documentServiceMock.On("WatchChanges", mock.Anything, "", "").Return(changesOf(feedChanges...), nil)
+
+	//the pages of another site cannot open the websocket, the ones of the allowed origins can
+	url := "ws" + strings.TrimPrefix(suite.testServer.URL, "http") + "/documents/changes/ws"
+	_, response, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": []string{"https://evil.example.com"}})
+	assert.Equal(suite.T(), websocket.ErrBadHandshake, err)
+	assert.Equal(suite.T(), http.StatusForbidden, response.StatusCode)
+	for _, origin := range []string{"https://app.example.com", suite.testServer.URL} {
+		connection, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": []string{origin}})
+		assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't connect from %s: %v", origin, err))
+		if err == nil {
+			connection.Close()
+		}
+	}
+}
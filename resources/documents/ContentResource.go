@@ -0,0 +1,163 @@
+package documents
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"goapi/repositories/repodocuments"
+	"goapi/resources/auth"
+	"goapi/resources/negotiation"
+	"goapi/resources/problems"
+	"goapi/services/servicedocuments"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultContentType is the type of a content uploaded without Content-Type
+const DefaultContentType = "application/octet-stream"
+
+type ResourceContent struct {
+	contentService servicedocuments.ContentService
+}
+
+//digest returns the value of the Digest header for a hex encoded SHA-256 checksum
+func digest(sha256 string) string {
+	sum, err := hex.DecodeString(sha256)
+	if err != nil {
+		return ""
+	}
+	return "sha-256=" + base64.StdEncoding.EncodeToString(sum)
+}
+
+// Endpoint to upload the content of a document
+// @Summary Upload the content of a document
+// @Description Store the binary content of a given document id, replacing the previous one. The body is streamed as is.
+// @Accept  application/octet-stream
+// @Produce  json,xml,application/yaml,application/msgpack
+// @Param id path int true "Document ID"
+// @Param Content-Type header string false "Type of the content (default application/octet-stream)"
+// @Param content body string true "The binary content"
+// @Param X-Tenant header string false "Tenant of the documents, the default tenant if absent"
+// @Success 200 {object} models.ContentMetadata
+// @Failure 401 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 406 {object} models.Problem
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Router /documents/{id}/content [put]
+func (resource ResourceContent) PutContent(c *gin.Context) {
+	id := c.Param("id")
+	contentType := c.GetHeader("Content-Type")
+	if len(contentType) == 0 {
+		contentType = DefaultContentType
+	}
+
+	metadata, err := resource.contentService.PutContent(CallerContext(c), id, contentType, c.Request.Body)
+	if errors.Is(err, repodocuments.ErrNotFound) {
+		_ = c.Error(problems.NotFound("document id %s not found", id))
+		return
+	}
+	if err != nil {
+		_ = c.Error(fmt.Errorf("Cannot store the content of document id %s [err=%w]", id, err))
+		return
+	}
+
+	c.Header("ETag", fmt.Sprintf("\"%s\"", metadata.SHA256))
+	negotiation.Render(c, http.StatusOK, metadata)
+}
+
+// Endpoint to download the content of a document
+// @Summary Download the content of a document
+// @Description Retrieve the binary content of a given document id. A Range header downloads only a part of it.
+// @Produce  application/octet-stream
+// @Param id path int true "Document ID"
+// @Param Range header string false "Bytes to download, like bytes=0-1023"
+// @Param X-Tenant header string false "Tenant of the documents, the default tenant if absent"
+// @Success 200 {file} binary
+// @Success 206 {file} binary "partial content"
+// @Header 200,206 {string} ETag "the SHA-256 checksum of the content"
+// @Header 200,206 {string} Digest "the SHA-256 checksum of the content, base64 encoded"
+// @Failure 401 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 416 "range not satisfiable"
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Router /documents/{id}/content [get]
+func (resource ResourceContent) GetContent(c *gin.Context) {
+	id := c.Param("id")
+
+	metadata, content, err := resource.contentService.GetContent(CallerContext(c), id)
+	switch {
+	case errors.Is(err, servicedocuments.ErrNoContent):
+		_ = c.Error(problems.NotFound("document id %s has no content", id))
+		return
+	case errors.Is(err, repodocuments.ErrNotFound):
+		_ = c.Error(problems.NotFound("document id %s not found", id))
+		return
+	case err != nil:
+		_ = c.Error(fmt.Errorf("Cannot get the content of document id %s [err=%w]", id, err))
+		return
+	}
+	defer func() {
+		if err := content.Close(); err != nil {
+			servicedocuments.LoggerFrom(c.Request.Context()).Error("Cannot close content", err)
+		}
+	}()
+
+	//ServeContent handles the Range, If-Range and conditional headers
+	c.Header("Content-Type", metadata.ContentType)
+	c.Header("ETag", fmt.Sprintf("\"%s\"", metadata.SHA256))
+	c.Header("Digest", digest(metadata.SHA256))
+	http.ServeContent(c.Writer, c.Request, "", metadata.UploadedAt, content)
+}
+
+// Endpoint to delete the content of a document
+// @Summary Delete the content of a document
+// @Description Delete the binary content of a given document id, the document itself is kept
+// @Param id path int true "Document ID"
+// @Param X-Tenant header string false "Tenant of the documents, the default tenant if absent"
+// @Success 200 "OK"
+// @Failure 401 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 406 {object} models.Problem
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Router /documents/{id}/content [delete]
+func (resource ResourceContent) DeleteContent(c *gin.Context) {
+	id := c.Param("id")
+
+	found, err := resource.contentService.DeleteContent(CallerContext(c), id)
+	if errors.Is(err, repodocuments.ErrNotFound) {
+		_ = c.Error(problems.NotFound("document id %s not found", id))
+		return
+	}
+	if err != nil {
+		_ = c.Error(fmt.Errorf("Cannot delete the content of document id %s [err=%w]", id, err))
+		return
+	}
+
+	if !found {
+		_ = c.Error(problems.NotFound("document id %s has no content", id))
+		return
+	}
+	negotiation.Render(c, http.StatusOK, nil)
+}
+
+// RegisterContentHandlers register the handlers of the documents content for a router
+func RegisterContentHandlers(r *gin.Engine, contentService servicedocuments.ContentService) {
+	resource := ResourceContent{contentService}
+	//the scopes the principals need on each route
+	read, write := auth.RequireScopes(ReadScope), auth.RequireScopes(WriteScope)
+	//the metadata are answered in the negotiated format, the content as is
+	negotiate := negotiation.Middleware()
+
+	r.PUT("/documents/:id/content", write, negotiate, resource.PutContent)
+	r.GET("/documents/:id/content", read, resource.GetContent)
+	r.HEAD("/documents/:id/content", read, resource.GetContent)
+	r.DELETE("/documents/:id/content", write, negotiate, resource.DeleteContent)
+}
@@ -0,0 +1,221 @@
+package documents
+
+import (
+	"context"
+	"bytes"
+	"fmt"
+	"goapi/models"
+	"goapi/repositories/repodocuments"
+	"goapi/resources/problems"
+	"goapi/services/servicedocuments"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+/*
+	Mock ContentService
+*/
+
+type ContentServiceMock struct {
+	mock.Mock
+}
+
+func (s *ContentServiceMock) PutContent(ctx context.Context, id string, contentType string, content io.Reader) (models.ContentMetadata, error) {
+	data, _ := ioutil.ReadAll(content)
+	args := s.Called(id, contentType, string(data))
+	return args.Get(0).(models.ContentMetadata), args.Error(1)
+}
+
+func (s *ContentServiceMock) GetContent(ctx context.Context, id string) (models.ContentMetadata, io.ReadSeekCloser, error) {
+	args := s.Called(id)
+	content, _ := args.Get(1).(io.ReadSeekCloser)
+	return args.Get(0).(models.ContentMetadata), content, args.Error(2)
+}
+
+func (s *ContentServiceMock) DeleteContent(ctx context.Context, id string) (bool, error) {
+	args := s.Called(id)
+	return args.Get(0).(bool), args.Error(1)
+}
+
+type contentReader struct {
+	*bytes.Reader
+}
+
+func (c contentReader) Close() error {
+	return nil
+}
+
+/*
+	Test suite definition
+*/
+
+type ContentResourceTestSuite struct {
+	suite.Suite
+	contentServiceMock *ContentServiceMock
+	testServer         *httptest.Server
+}
+
+//beforeAll method
+func (suite *ContentResourceTestSuite) SetupSuite() {
+	//create the mock service
+	suite.contentServiceMock = new(ContentServiceMock)
+	//create http server
+	gin.SetMode(gin.TestMode)
+	router := gin.Default()
+	router.Use(problems.Handler())
+	RegisterContentHandlers(router, suite.contentServiceMock)
+	suite.testServer = httptest.NewServer(router)
+}
+
+//the suite runner
+func TestContentResourceTestSuite(t *testing.T) {
+	suite.Run(t, new(ContentResourceTestSuite))
+}
+
+// The SetupTest method will be run before every test in the suite.
+func (suite *ContentResourceTestSuite) SetupTest() {
+	suite.contentServiceMock.ExpectedCalls = nil
+}
+
+//afterAll method
+func (suite *ContentResourceTestSuite) TearDownSuite() {
+	suite.testServer.Close()
+}
+
+var helloMetadata = models.ContentMetadata{
+	DocumentID:  "toto",
+	ContentType: "text/plain",
+	Size:        11,
+	SHA256:      "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9",
+	UploadedAt:  time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC),
+}
+
+func (suite *ContentResourceTestSuite) executeRequest(req *http.Request, expectedCodeStatus int) (*http.Response, string) {
+	resp, err := suite.testServer.Client().Do(req)
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't send request: %v", err))
+
+	// assert that the expectations were met
+	suite.contentServiceMock.AssertExpectations(suite.T())
+
+	//check the status
+	assert.Equal(suite.T(), expectedCodeStatus, resp.StatusCode)
+
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't read body response: %v", err))
+	return resp, string(body)
+}
+
+func (suite *ContentResourceTestSuite) TestResourceContent_putContent() {
+
+	//add handler mock service
+	suite.contentServiceMock.On("PutContent", "toto", "text/plain", "hello world").Return(helloMetadata, nil)
+
+	//create request
+	req, err := http.NewRequest("PUT", suite.testServer.URL+"/documents/toto/content", bytes.NewBufferString("hello world"))
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
+	req.Header.Set("Content-Type", "text/plain")
+
+	//check result
+	resp, body := suite.executeRequest(req, http.StatusOK)
+	require.JSONEq(suite.T(), `{"documentId":"toto","contentType":"text/plain","size":11,"sha256":"b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9","uploadedAt":"2021-10-01T12:00:00Z"}`, body)
+	assert.Equal(suite.T(), `"`+helloMetadata.SHA256+`"`, resp.Header.Get("ETag"))
+}
+
+func (suite *ContentResourceTestSuite) TestResourceContent_putContentDocumentNotFound() {
+
+	//add handler mock service
+	suite.contentServiceMock.On("PutContent", "toto", DefaultContentType, "hello").Return(models.ContentMetadata{}, repodocuments.ErrNotFound)
+
+	//create request
+	req, err := http.NewRequest("PUT", suite.testServer.URL+"/documents/toto/content", bytes.NewBufferString("hello"))
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
+
+	//check result
+	_, body := suite.executeRequest(req, http.StatusNotFound)
+	require.JSONEq(suite.T(), problemOf(req, http.StatusNotFound, "document id toto not found"), body)
+}
+
+func (suite *ContentResourceTestSuite) TestResourceContent_getContent() {
+
+	//add handler mock service
+	suite.contentServiceMock.On("GetContent", "toto").Return(helloMetadata, contentReader{bytes.NewReader([]byte("hello world"))}, nil)
+
+	//create request
+	req, err := http.NewRequest("GET", suite.testServer.URL+"/documents/toto/content", nil)
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
+
+	//check result
+	resp, body := suite.executeRequest(req, http.StatusOK)
+	assert.Equal(suite.T(), "hello world", body)
+	assert.Equal(suite.T(), "text/plain", resp.Header.Get("Content-Type"))
+	assert.Equal(suite.T(), "11", resp.Header.Get("Content-Length"))
+	assert.Equal(suite.T(), "sha-256=uU0nuZNNPgilLlLX2n2r+sSE7+N6U4DukIj3rOLvzek=", resp.Header.Get("Digest"))
+}
+
+func (suite *ContentResourceTestSuite) TestResourceContent_getContentRange() {
+
+	//add handler mock service
+	suite.contentServiceMock.On("GetContent", "toto").Return(helloMetadata, contentReader{bytes.NewReader([]byte("hello world"))}, nil)
+
+	//create request
+	req, err := http.NewRequest("GET", suite.testServer.URL+"/documents/toto/content", nil)
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
+	req.Header.Set("Range", "bytes=6-")
+
+	//check result
+	resp, body := suite.executeRequest(req, http.StatusPartialContent)
+	assert.Equal(suite.T(), "world", body)
+	assert.Equal(suite.T(), "bytes 6-10/11", resp.Header.Get("Content-Range"))
+}
+
+func (suite *ContentResourceTestSuite) TestResourceContent_getContentRangeNotSatisfiable() {
+
+	//add handler mock service
+	suite.contentServiceMock.On("GetContent", "toto").Return(helloMetadata, contentReader{bytes.NewReader([]byte("hello world"))}, nil)
+
+	//create request
+	req, err := http.NewRequest("GET", suite.testServer.URL+"/documents/toto/content", nil)
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
+	req.Header.Set("Range", "bytes=20-")
+
+	//check result
+	suite.executeRequest(req, http.StatusRequestedRangeNotSatisfiable)
+}
+
+func (suite *ContentResourceTestSuite) TestResourceContent_getNoContent() {
+
+	//add handler mock service
+	suite.contentServiceMock.On("GetContent", "toto").Return(models.ContentMetadata{}, nil, servicedocuments.ErrNoContent)
+
+	//create request
+	req, err := http.NewRequest("GET", suite.testServer.URL+"/documents/toto/content", nil)
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
+
+	//check result
+	_, body := suite.executeRequest(req, http.StatusNotFound)
+	require.JSONEq(suite.T(), problemOf(req, http.StatusNotFound, "document id toto has no content"), body)
+}
+
+func (suite *ContentResourceTestSuite) TestResourceContent_deleteContent() {
+
+	//add handler mock service
+	suite.contentServiceMock.On("DeleteContent", "toto").Return(true, nil)
+
+	//create request
+	req, err := http.NewRequest("DELETE", suite.testServer.URL+"/documents/toto/content", nil)
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
+
+	//check result
+	_, body := suite.executeRequest(req, http.StatusOK)
+	require.JSONEq(suite.T(), "null", body)
+}
@@ -1,18 +1,38 @@
 package documents
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"goapi/config"
 	"goapi/models"
+	"goapi/repositories/repodocuments"
+	"goapi/resources/auth"
+	"goapi/resources/negotiation"
+	"goapi/resources/problems"
 	"goapi/services/servicedocuments"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	_ "github.com/swaggo/swag/example/celler/httputil"
+	"github.com/gorilla/websocket"
+)
+
+// MaxBatchOperations is the maximum number of operations of a bulk request
+const MaxBatchOperations = 1000
+
+// The scopes of the document routes, checked when the authorization is enabled
+const (
+	ReadScope  = "documents:read"
+	WriteScope = "documents:write"
 )
 
 type ResourceDocument struct {
 	documentService servicedocuments.DocumentService
+	upgrader        websocket.Upgrader
 }
 
 func (resource ResourceDocument) validationID(id string) error {
@@ -22,130 +42,840 @@ func (resource ResourceDocument) validationID(id string) error {
 	return nil
 }
 
-/*
-One could consider adding endpoints for bulk creation or deletion for a list of documents.
-Then we should add a patch endpoint with a payload that would contain a list of objects telling if the state
-should be deleted or created, and the document itself.
-*/
+// CallerContext is the context of the service calls of the request, with their actor and its groups.
+// The actor of an authenticated request is its principal, set by the authentication. The requests without principal are
+// made by the anonymous actor without groups, the headers of the client never tell who makes a request.
+func CallerContext(c *gin.Context) context.Context {
+	if _, authenticated := auth.PrincipalFrom(c); authenticated {
+		return c.Request.Context()
+	}
+	return servicedocuments.WithGroups(servicedocuments.WithActor(c.Request.Context(), servicedocuments.AnonymousActor), nil)
+}
+
+//the ETag of a document is its version
+func etag(document models.Document) string {
+	return fmt.Sprintf("\"%d\"", document.Version)
+}
+
+//parseETag returns the version of an ETag like "3"
+func parseETag(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	if len(value) < 2 || !strings.HasPrefix(value, "\"") || !strings.HasSuffix(value, "\"") {
+		return 0, fmt.Errorf("invalid ETag %s", value)
+	}
+	version, err := strconv.ParseInt(value[1:len(value)-1], 10, 64)
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("invalid ETag %s", value)
+	}
+	return version, nil
+}
+
+//preconditionFrom reads the If-Match and If-None-Match headers of the request
+func (resource ResourceDocument) preconditionFrom(c *gin.Context) (repodocuments.Precondition, error) {
+	var precondition repodocuments.Precondition
+
+	if ifMatch := strings.TrimSpace(c.GetHeader("If-Match")); len(ifMatch) > 0 {
+		if ifMatch == "*" {
+			precondition.MustExist = true
+		} else {
+			version, err := parseETag(ifMatch)
+			if err != nil {
+				return precondition, err
+			}
+			precondition.IfMatch = version
+		}
+	}
+
+	if ifNoneMatch := strings.TrimSpace(c.GetHeader("If-None-Match")); len(ifNoneMatch) > 0 {
+		if ifNoneMatch != "*" {
+			return precondition, errors.New("only If-None-Match: * is supported")
+		}
+		precondition.MustNotExist = true
+	}
+	return precondition, nil
+}
+
+func (resource ResourceDocument) queryFrom(c *gin.Context) (repodocuments.DocumentQuery, error) {
+	query := repodocuments.DocumentQuery{Cursor: c.Query("cursor")}
+
+	if value, found := c.GetQuery("limit"); found {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > repodocuments.MaxPageLimit {
+			return query, fmt.Errorf("limit must be between 1 and %d", repodocuments.MaxPageLimit)
+		}
+		query.Limit = limit
+	}
+
+	sort, err := repodocuments.ParseDocumentSort(c.Query("sort"))
+	if err != nil {
+		return query, fmt.Errorf("sort must be one of %s, prefixed by - for a descending order", strings.Join(repodocuments.SortFields(), ", "))
+	}
+	query.Sort = sort
+
+	query.Filter, err = resource.filterFrom(c)
+	return query, err
+}
+
+//filterFrom returns the filter of the documents given by the label selector and the audit fields of the request
+func (resource ResourceDocument) filterFrom(c *gin.Context) (repodocuments.DocumentFilter, error) {
+	var filter repodocuments.DocumentFilter
+	selector, err := repodocuments.ParseLabelSelector(c.Query("selector"))
+	if err != nil {
+		return filter, err
+	}
+	filter.Selector = selector
+
+	filter.CreatedBy = c.Query("createdBy")
+	filter.UpdatedBy = c.Query("updatedBy")
+	for name, bound := range map[string]*time.Time{
+		"createdAfter":  &filter.CreatedAfter,
+		"createdBefore": &filter.CreatedBefore,
+		"updatedAfter":  &filter.UpdatedAfter,
+		"updatedBefore": &filter.UpdatedBefore,
+	} {
+		if value, found := c.GetQuery(name); found {
+			bounded, err := time.Parse(time.RFC3339Nano, value)
+			if err != nil {
+				return filter, fmt.Errorf("%s must be a RFC 3339 time", name)
+			}
+			*bound = bounded.UTC()
+		}
+	}
+	return filter, nil
+}
+
+//the link to the next page is the current request with the cursor of the next page
+func nextPageLink(c *gin.Context, nextCursor string) string {
+	values := c.Request.URL.Query()
+	values.Set("cursor", nextCursor)
+	return c.Request.URL.Path + "?" + values.Encode()
+}
 
 // Endpoint to retrieve all documents
 // @Summary Retrieve all documents
-// @Description Retrieve all documents
-// @Produce  json
-// @Success 200 {array} models.Document
-// @Failure 500 {object} httputil.HTTPError
+// @Description Retrieve all documents, page by page. Follow the next link to get the next page.
+// @Produce  json,xml,application/yaml,application/msgpack
+// @Param limit query int false "Maximum number of documents in the page (default 100, max 1000)"
+// @Param cursor query string false "Cursor of the page, as returned in nextCursor"
+// @Param sort query string false "Sort order" Enums(id, -id, name, -name, createdAt, -createdAt, createdBy, -createdBy, updatedAt, -updatedAt, updatedBy, -updatedBy)
+// @Param createdBy query string false "Only the documents created by this actor"
+// @Param updatedBy query string false "Only the documents last modified by this actor"
+// @Param createdAfter query string false "Only the documents created after this RFC 3339 time"
+// @Param createdBefore query string false "Only the documents created before this RFC 3339 time"
+// @Param updatedAfter query string false "Only the documents last modified after this RFC 3339 time"
+// @Param updatedBefore query string false "Only the documents last modified before this RFC 3339 time"
+// @Param selector query string false "Only the documents whose labels match the selector, like team=payments,env!=prod,tier in (front,back),!deprecated"
+// @Param X-Tenant header string false "Tenant of the documents, the default tenant if absent"
+// @Success 200 {object} models.DocumentPage
+// @Failure 401 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Failure 400 {object} models.Problem
+// @Failure 406 {object} models.Problem
+// @Security BearerAuth
+// @Security ApiKeyAuth
 // @Router /documents [get]
 func (resource ResourceDocument) GetAllDocuments(c *gin.Context) {
-	docs, err := resource.documentService.GetAll()
+	resource.listDocuments(c, false)
+}
+
+// Endpoint to retrieve the documents of the trash
+// @Summary Retrieve the documents of the trash
+// @Description Retrieve the deleted documents that are not purged yet, page by page. Follow the next link to get the next page.
+// @Produce  json,xml,application/yaml,application/msgpack
+// @Param limit query int false "Maximum number of documents in the page (default 100, max 1000)"
+// @Param cursor query string false "Cursor of the page, as returned in nextCursor"
+// @Param sort query string false "Sort order" Enums(id, -id, name, -name, createdAt, -createdAt, createdBy, -createdBy, updatedAt, -updatedAt, updatedBy, -updatedBy)
+// @Param createdBy query string false "Only the documents created by this actor"
+// @Param updatedBy query string false "Only the documents last modified by this actor"
+// @Param createdAfter query string false "Only the documents created after this RFC 3339 time"
+// @Param createdBefore query string false "Only the documents created before this RFC 3339 time"
+// @Param updatedAfter query string false "Only the documents last modified after this RFC 3339 time"
+// @Param updatedBefore query string false "Only the documents last modified before this RFC 3339 time"
+// @Param selector query string false "Only the documents whose labels match the selector, like team=payments,env!=prod,tier in (front,back),!deprecated"
+// @Param X-Tenant header string false "Tenant of the documents, the default tenant if absent"
+// @Success 200 {object} models.DocumentPage
+// @Failure 401 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Failure 400 {object} models.Problem
+// @Failure 406 {object} models.Problem
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Router /documents/trash [get]
+func (resource ResourceDocument) GetTrash(c *gin.Context) {
+	resource.listDocuments(c, true)
+}
+
+func (resource ResourceDocument) listDocuments(c *gin.Context, trashed bool) {
+	query, err := resource.queryFrom(c)
+	if err != nil {
+		_ = c.Error(problems.Validation("Validation failed [err=%s]", err))
+		return
+	}
+	query.Trashed = trashed
+
+	page, err := resource.documentService.List(CallerContext(c), query)
+	if errors.Is(err, repodocuments.ErrInvalidCursor) {
+		_ = c.Error(problems.Validation("Validation failed [err=%s]", err))
+		return
+	}
 	if err != nil {
-		c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": fmt.Sprintf("Cannot get documents [err=%s]", err)})
+		_ = c.Error(fmt.Errorf("Cannot get documents [err=%w]", err))
 		return
 	}
-	c.IndentedJSON(http.StatusOK, docs)
+
+	if len(page.NextCursor) > 0 {
+		page.Next = nextPageLink(c, page.NextCursor)
+	}
+	negotiation.Render(c, http.StatusOK, page)
+}
+
+// Endpoint to search documents
+// @Summary Search documents
+// @Description Search the documents whose name or description contain at least one of the words of q, the most relevant first.
+// @Description Follow the next link to get the next page.
+// @Produce  json,xml,application/yaml,application/msgpack
+// @Param q query string true "The words to search"
+// @Param limit query int false "Maximum number of hits in the page (default 100, max 1000)"
+// @Param cursor query string false "Cursor of the page, as returned in nextCursor"
+// @Param X-Tenant header string false "Tenant of the documents, the default tenant if absent"
+// @Success 200 {object} models.SearchPage
+// @Failure 401 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Failure 400 {object} models.Problem
+// @Failure 406 {object} models.Problem
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Router /documents/search [get]
+func (resource ResourceDocument) SearchDocuments(c *gin.Context) {
+	text := c.Query("q")
+	if len(repodocuments.SearchTerms(text)) == 0 {
+		_ = c.Error(problems.Validation("Validation failed [err=q must contain at least one word]"))
+		return
+	}
+
+	listQuery, err := resource.queryFrom(c)
+	if err != nil {
+		_ = c.Error(problems.Validation("Validation failed [err=%s]", err))
+		return
+	}
+
+	page, err := resource.documentService.Search(CallerContext(c), repodocuments.SearchQuery{Text: text, Limit: listQuery.Limit, Cursor: listQuery.Cursor})
+	if errors.Is(err, repodocuments.ErrInvalidCursor) {
+		_ = c.Error(problems.Validation("Validation failed [err=%s]", err))
+		return
+	}
+	if err != nil {
+		_ = c.Error(fmt.Errorf("Cannot search documents [err=%w]", err))
+		return
+	}
+
+	if len(page.NextCursor) > 0 {
+		page.Next = nextPageLink(c, page.NextCursor)
+	}
+	negotiation.Render(c, http.StatusOK, page)
 }
 
 // Endpoint to retrieve a given document from the path param id
 // @Summary Retrieve a given document
 // @Description Retrieve  a given document from the path param id
-// @Produce  json
+// @Produce  json,xml,application/yaml,application/msgpack
 // @Param id path int true "Document ID"
+// @Param X-Tenant header string false "Tenant of the documents, the default tenant if absent"
 // @Success 200 {object} models.Document
-// @Failure 500 {object} httputil.HTTPError
-// @Failure 404 {object} httputil.HTTPError
+// @Header 200 {string} ETag "the version of the document"
+// @Failure 401 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 406 {object} models.Problem
+// @Security BearerAuth
+// @Security ApiKeyAuth
 // @Router /documents/{id} [get]
 func (resource ResourceDocument) GetDocument(c *gin.Context) {
 	id := c.Param("id")
-	doc, err := resource.documentService.Get(id)
-	if err != nil {
-		c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": fmt.Sprintf("Cannot get document id %s [err=%s]", id, err)})
+	doc, err := resource.documentService.Get(CallerContext(c), id)
+	if errors.Is(err, repodocuments.ErrNotFound) {
+		_ = c.Error(problems.NotFound("document id %s not found", id))
 		return
 	}
-	if (models.Document{}) == doc {
-		c.IndentedJSON(http.StatusNotFound, gin.H{"message": fmt.Sprintf("document id %s not found", id)})
+	if err != nil {
+		_ = c.Error(fmt.Errorf("Cannot get document id %s [err=%w]", id, err))
 		return
 	}
-	c.IndentedJSON(http.StatusOK, doc)
+	c.Header("ETag", etag(doc))
+	negotiation.Render(c, http.StatusOK, doc)
 }
 
 // Endpoint to create or update a document
 // @Summary Create or update a document
-// @Description Create or update a document
-// @Accept  json
-// @Produce  json
+// @Description Create or update a document. A document breaking the validation rules gets a 400 listing all the invalid fields.
+// @Accept  json,xml,application/yaml,application/msgpack
+// @Produce  json,xml,application/yaml,application/msgpack
 // @Param id path int true "Document ID"
+// @Param If-Match header string false "ETag of the document to update, * for any existing document"
+// @Param If-None-Match header string false "* to only create the document"
 // @Param data body models.Document true "The document struct"
+// @Param X-Tenant header string false "Tenant of the documents, the default tenant if absent"
 // @Success 200 {object} models.Document "update"
 // @Success 201 {object} models.Document "creation"
-// @Failure 500 {object} httputil.HTTPError
-// @Failure 400 {object} httputil.HTTPError
-// @Failure 404 {object} httputil.HTTPError
+// @Header 200,201 {string} ETag "the version of the document"
+// @Failure 401 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Failure 400 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 412 {object} models.Problem
+// @Failure 406 {object} models.Problem
+// @Failure 415 {object} models.Problem
+// @Security BearerAuth
+// @Security ApiKeyAuth
 // @Router /documents/{id} [put]
 func (resource ResourceDocument) CreateOrUpdateDocument(c *gin.Context) {
 	id := c.Param("id")
 
 	err := resource.validationID(id)
 	if err != nil {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("Validation failed [err=%s]", err)})
+		_ = c.Error(problems.Validation("Validation failed [err=%s]", err))
+		return
+	}
+
+	precondition, err := resource.preconditionFrom(c)
+	if err != nil {
+		_ = c.Error(problems.Validation("Validation failed [err=%s]", err))
 		return
 	}
 
 	var docToCreateOrUpdate models.Document
-	if err := c.BindJSON(&docToCreateOrUpdate); err != nil {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("Cannot deserialize document [err=%s]", err)})
+	if err := negotiation.Bind(c, "document", &docToCreateOrUpdate); err != nil {
+		_ = c.Error(err)
 		return
 	}
 	docToCreateOrUpdate.ID = id
+	//the version is managed by the server
+	docToCreateOrUpdate.Version = 0
 
-	docUpdated, err := resource.documentService.CreateOrUpdate(docToCreateOrUpdate)
+	doc, docUpdated, err := resource.documentService.CreateOrUpdate(CallerContext(c), docToCreateOrUpdate, precondition)
+	if errors.Is(err, repodocuments.ErrPreconditionFailed) {
+		_ = c.Error(fmt.Errorf("document id %s has been modified [err=%w]", id, err))
+		return
+	}
 	if err != nil {
-		c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": fmt.Sprintf("Cannot create or update document [err=%s]", err)})
+		_ = c.Error(fmt.Errorf("Cannot create or update document [err=%w]", err))
 		return
 	}
 
+	c.Header("ETag", etag(doc))
 	if docUpdated {
-		c.IndentedJSON(http.StatusOK, docToCreateOrUpdate)
+		negotiation.Render(c, http.StatusOK, doc)
 	} else {
-		c.IndentedJSON(http.StatusCreated, docToCreateOrUpdate)
+		negotiation.Render(c, http.StatusCreated, doc)
+	}
+}
+
+// Endpoint to Patch a given document id
+// @Summary  Patch a given document id
+// @Description  Apply a JSON Merge Patch (RFC 7396) or a JSON Patch (RFC 6902) to the document, depending on the Content-Type.
+// @Description  The fields managed by the server cannot be modified. The patch is applied on the current version of the document,
+// @Description  so concurrent patches don't lose each other's changes. Without If-Match, a document that keeps being modified answers 409.
+// @Accept application/merge-patch+json,application/json-patch+json
+// @Produce  json,xml,application/yaml,application/msgpack
+// @Param id path int true "Document ID"
+// @Param If-Match header string false "ETag of the document to patch"
+// @Param data body object true "The patch"
+// @Param X-Tenant header string false "Tenant of the documents, the default tenant if absent"
+// @Success 200 {object} models.Document
+// @Header 200 {string} ETag "the version of the document"
+// @Failure 401 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Failure 400 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 409 {object} models.Problem
+// @Failure 412 {object} models.Problem
+// @Failure 415 {object} models.Problem
+// @Failure 422 {object} models.Problem
+// @Failure 406 {object} models.Problem
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Router /documents/{id} [patch]
+func (resource ResourceDocument) PatchDocument(c *gin.Context) {
+	id := c.Param("id")
+
+	patchType := servicedocuments.PatchType(c.ContentType())
+	if patchType != servicedocuments.MergePatch && patchType != servicedocuments.JSONPatch {
+		_ = c.Error(problems.New(http.StatusUnsupportedMediaType, "Content-Type must be %s or %s", servicedocuments.MergePatch, servicedocuments.JSONPatch))
+		return
+	}
+
+	precondition, err := resource.preconditionFrom(c)
+	if err != nil {
+		_ = c.Error(problems.Validation("Validation failed [err=%s]", err))
+		return
 	}
+
+	patch, err := c.GetRawData()
+	if err != nil || !json.Valid(patch) {
+		_ = c.Error(problems.Validation("Cannot deserialize patch [err=invalid JSON]"))
+		return
+	}
+
+	doc, err := resource.documentService.Patch(CallerContext(c), id, patchType, patch, precondition)
+	switch {
+	case errors.Is(err, repodocuments.ErrNotFound):
+		_ = c.Error(problems.NotFound("document id %s not found", id))
+		return
+	case errors.Is(err, repodocuments.ErrPreconditionFailed):
+		_ = c.Error(fmt.Errorf("document id %s has been modified [err=%w]", id, err))
+		return
+	case errors.Is(err, servicedocuments.ErrInvalidPatch):
+		_ = c.Error(fmt.Errorf("Cannot apply patch [err=%w]", err))
+		return
+	case err != nil:
+		_ = c.Error(fmt.Errorf("Cannot patch document [err=%w]", err))
+		return
+	}
+
+	c.Header("ETag", etag(doc))
+	negotiation.Render(c, http.StatusOK, doc)
 }
 
 // Endpoint to Delete a given document id
 // @Summary  Delete a given document id
-// @Description  Delete a given document id
+// @Description  Move a given document id to the trash, it can be restored until it is purged
 // @Param id path int true "Document ID"
+// @Param If-Match header string false "ETag of the document to delete"
+// @Param X-Tenant header string false "Tenant of the documents, the default tenant if absent"
 // @Success 200 "OK"
-// @Failure 500 {object} httputil.HTTPError
-// @Failure 400 {object} httputil.HTTPError
-// @Failure 404 {object} httputil.HTTPError
+// @Failure 401 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Failure 400 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 412 {object} models.Problem
+// @Failure 406 {object} models.Problem
+// @Security BearerAuth
+// @Security ApiKeyAuth
 // @Router /documents/{id} [delete]
 func (resource ResourceDocument) DeleteDocument(c *gin.Context) {
 	idToDelete := c.Param("id")
 
 	err := resource.validationID(idToDelete)
 	if err != nil {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("Validation failed [err=%s]", err)})
+		_ = c.Error(problems.Validation("Validation failed [err=%s]", err))
+		return
+	}
+
+	precondition, err := resource.preconditionFrom(c)
+	if err != nil {
+		_ = c.Error(problems.Validation("Validation failed [err=%s]", err))
+		return
+	}
+
+	found, err := resource.documentService.Delete(CallerContext(c), idToDelete, precondition)
+	if errors.Is(err, repodocuments.ErrPreconditionFailed) {
+		_ = c.Error(fmt.Errorf("document id %s has been modified [err=%w]", idToDelete, err))
+		return
+	}
+	if err != nil {
+		_ = c.Error(fmt.Errorf("Cannot delete documents [err=%w]", err))
+		return
+	}
+
+	if !found {
+		_ = c.Error(problems.NotFound("document id %s not found", idToDelete))
+		return
+	}
+	negotiation.Render(c, http.StatusOK, nil)
+}
+
+// Endpoint to restore a document from the trash
+// @Summary Restore a document from the trash
+// @Description Move back a deleted document from the trash
+// @Produce  json,xml,application/yaml,application/msgpack
+// @Param id path int true "Document ID"
+// @Param X-Tenant header string false "Tenant of the documents, the default tenant if absent"
+// @Success 200 {object} models.Document
+// @Header 200 {string} ETag "the version of the document"
+// @Failure 401 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 406 {object} models.Problem
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Router /documents/{id}/restore [post]
+func (resource ResourceDocument) RestoreDocument(c *gin.Context) {
+	id := c.Param("id")
+
+	doc, err := resource.documentService.Restore(CallerContext(c), id)
+	if errors.Is(err, repodocuments.ErrNotFound) {
+		_ = c.Error(problems.NotFound("document id %s not found in the trash", id))
+		return
+	}
+	if err != nil {
+		_ = c.Error(fmt.Errorf("Cannot restore document id %s [err=%w]", id, err))
 		return
 	}
 
-	found, err := resource.documentService.Delete(idToDelete)
+	c.Header("ETag", etag(doc))
+	negotiation.Render(c, http.StatusOK, doc)
+}
+
+func (resource ResourceDocument) validationOperation(operation models.DocumentOperation) error {
+	if operation.Op != models.OperationUpsert && operation.Op != models.OperationDelete {
+		return fmt.Errorf("op must be %s or %s", models.OperationUpsert, models.OperationDelete)
+	}
+	if operation.IfMatch < 0 {
+		return errors.New("ifMatch must be a version")
+	}
+	return resource.validationID(operation.ID)
+}
+
+//operationResultFrom gives the status of an operation as if it was done alone on its endpoint
+func operationResultFrom(operation models.DocumentOperation, outcome repodocuments.OperationResult) models.DocumentOperationResult {
+	result := models.DocumentOperationResult{ID: operation.ID}
+	switch {
+	case outcome.Err == nil && operation.Op == models.OperationDelete:
+		result.Status = http.StatusOK
+	case outcome.Err == nil && outcome.Existed:
+		result.Status = http.StatusOK
+		result.Document = &outcome.Document
+	case outcome.Err == nil:
+		result.Status = http.StatusCreated
+		result.Document = &outcome.Document
+	default:
+		result.Status = problems.StatusOf(outcome.Err)
+	}
+	if outcome.Err != nil {
+		result.Error = outcome.Err.Error()
+	}
+	return result
+}
+
+// Endpoint to create, update or delete a list of documents
+// @Summary Create, update or delete a list of documents
+// @Description Apply a list of operations, each one upserts or deletes a document. The results are in the order of the operations,
+// @Description with the status each operation would have had on its own endpoint.
+// @Description With atomic=true, either all operations are applied or none of them (the others get a 424 status).
+// @Accept  json,xml,application/yaml,application/msgpack
+// @Produce  json,xml,application/yaml,application/msgpack
+// @Param atomic query bool false "Apply all the operations or none of them"
+// @Param data body []models.DocumentOperation true "The operations"
+// @Param X-Tenant header string false "Tenant of the documents, the default tenant if absent"
+// @Success 200 {array} models.DocumentOperationResult "all operations succeeded"
+// @Success 207 {array} models.DocumentOperationResult "some operations failed"
+// @Failure 401 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Failure 400 {object} models.Problem
+// @Failure 406 {object} models.Problem
+// @Failure 415 {object} models.Problem
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Router /documents [patch]
+func (resource ResourceDocument) PatchDocuments(c *gin.Context) {
+	atomic, err := strconv.ParseBool(c.DefaultQuery("atomic", "false"))
 	if err != nil {
-		c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": fmt.Sprintf("Cannot delete documents [err=%s]", err)})
+		_ = c.Error(problems.Validation("Validation failed [err=atomic must be a boolean]"))
 		return
 	}
 
+	var operations []models.DocumentOperation
+	if err := negotiation.Bind(c, "operations", &operations); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if len(operations) == 0 || len(operations) > MaxBatchOperations {
+		_ = c.Error(problems.Validation("Validation failed [err=the number of operations must be between 1 and %d]", MaxBatchOperations))
+		return
+	}
+
+	//invalid operations are not sent to the service, keep where the valid ones are in the results
+	results := make([]models.DocumentOperationResult, len(operations))
+	batch := make([]repodocuments.Operation, 0, len(operations))
+	positions := make([]int, 0, len(operations))
+	for i, operation := range operations {
+		if err := resource.validationOperation(operation); err != nil {
+			results[i] = models.DocumentOperationResult{ID: operation.ID, Status: http.StatusBadRequest, Error: err.Error()}
+			continue
+		}
+		document := operation.Document
+		document.ID = operation.ID
+		//the version is managed by the server
+		document.Version = 0
+		batch = append(batch, repodocuments.Operation{
+			Delete:       operation.Op == models.OperationDelete,
+			Document:     document,
+			Precondition: repodocuments.Precondition{IfMatch: operation.IfMatch},
+		})
+		positions = append(positions, i)
+	}
+
+	if atomic && len(batch) < len(operations) {
+		//nothing is applied
+		for _, position := range positions {
+			results[position] = models.DocumentOperationResult{ID: operations[position].ID, Status: http.StatusFailedDependency, Error: repodocuments.ErrRolledBack.Error()}
+		}
+	} else if len(batch) > 0 {
+		outcomes, err := resource.documentService.ApplyBatch(CallerContext(c), batch, atomic)
+		if err != nil {
+			_ = c.Error(fmt.Errorf("Cannot apply operations [err=%w]", err))
+			return
+		}
+		for k, outcome := range outcomes {
+			results[positions[k]] = operationResultFrom(operations[positions[k]], outcome)
+		}
+	}
+
+	status := http.StatusOK
+	for _, result := range results {
+		if result.Status >= http.StatusBadRequest {
+			status = http.StatusMultiStatus
+			break
+		}
+	}
+	negotiation.Render(c, status, results)
+}
+
+func (resource ResourceDocument) revisionFrom(c *gin.Context) (int64, error) {
+	revision, err := strconv.ParseInt(c.Param("rev"), 10, 64)
+	if err != nil || revision < 1 {
+		return 0, errors.New("rev must be a revision number")
+	}
+	return revision, nil
+}
+
+// Endpoint to retrieve the revisions of a document
+// @Summary Retrieve the revisions of a document
+// @Description Retrieve the kept revisions of a document, the oldest first. Each write of the document makes a revision.
+// @Produce  json,xml,application/yaml,application/msgpack
+// @Param id path int true "Document ID"
+// @Param X-Tenant header string false "Tenant of the documents, the default tenant if absent"
+// @Success 200 {array} models.DocumentRevision
+// @Failure 401 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 406 {object} models.Problem
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Router /documents/{id}/revisions [get]
+func (resource ResourceDocument) GetRevisions(c *gin.Context) {
+	id := c.Param("id")
+	revisions, err := resource.documentService.GetRevisions(CallerContext(c), id)
+	if err != nil {
+		_ = c.Error(fmt.Errorf("Cannot get revisions of document id %s [err=%w]", id, err))
+		return
+	}
+	if len(revisions) == 0 {
+		_ = c.Error(problems.NotFound("no revision for document id %s", id))
+		return
+	}
+	negotiation.Render(c, http.StatusOK, revisions)
+}
+
+// Endpoint to retrieve a revision of a document
+// @Summary Retrieve a revision of a document
+// @Description Retrieve a revision of a document
+// @Produce  json,xml,application/yaml,application/msgpack
+// @Param id path int true "Document ID"
+// @Param rev path int true "Revision number"
+// @Param X-Tenant header string false "Tenant of the documents, the default tenant if absent"
+// @Success 200 {object} models.DocumentRevision
+// @Failure 401 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Failure 400 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 406 {object} models.Problem
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Router /documents/{id}/revisions/{rev} [get]
+func (resource ResourceDocument) GetRevision(c *gin.Context) {
+	id := c.Param("id")
+	revisionNumber, err := resource.revisionFrom(c)
+	if err != nil {
+		_ = c.Error(problems.Validation("Validation failed [err=%s]", err))
+		return
+	}
+
+	revision, found, err := resource.documentService.GetRevision(CallerContext(c), id, revisionNumber)
+	if err != nil {
+		_ = c.Error(fmt.Errorf("Cannot get revision %d of document id %s [err=%w]", revisionNumber, id, err))
+		return
+	}
 	if !found {
-		c.IndentedJSON(http.StatusNotFound, gin.H{"message": fmt.Sprintf("document id %s not found", idToDelete)})
+		_ = c.Error(problems.NotFound("revision %d of document id %s not found", revisionNumber, id))
+		return
+	}
+	negotiation.Render(c, http.StatusOK, revision)
+}
+
+// Endpoint to restore a revision of a document
+// @Summary Restore a revision of a document
+// @Description Write back the document as it was in the revision. The restoration makes a new revision.
+// @Produce  json,xml,application/yaml,application/msgpack
+// @Param id path int true "Document ID"
+// @Param rev path int true "Revision number"
+// @Param If-Match header string false "ETag of the current document"
+// @Param X-Tenant header string false "Tenant of the documents, the default tenant if absent"
+// @Success 200 {object} models.Document "update"
+// @Success 201 {object} models.Document "creation"
+// @Header 200,201 {string} ETag "the version of the document"
+// @Failure 401 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Failure 400 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 412 {object} models.Problem
+// @Failure 422 {object} models.Problem
+// @Failure 406 {object} models.Problem
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Router /documents/{id}/revisions/{rev}/restore [post]
+func (resource ResourceDocument) RestoreRevision(c *gin.Context) {
+	id := c.Param("id")
+	revisionNumber, err := resource.revisionFrom(c)
+	if err != nil {
+		_ = c.Error(problems.Validation("Validation failed [err=%s]", err))
+		return
+	}
+
+	precondition, err := resource.preconditionFrom(c)
+	if err != nil {
+		_ = c.Error(problems.Validation("Validation failed [err=%s]", err))
+		return
+	}
+
+	doc, docUpdated, err := resource.documentService.RestoreRevision(CallerContext(c), id, revisionNumber, precondition)
+	switch {
+	case errors.Is(err, repodocuments.ErrNotFound):
+		_ = c.Error(problems.NotFound("revision %d of document id %s not found", revisionNumber, id))
+		return
+	case errors.Is(err, servicedocuments.ErrRevisionDeleted):
+		_ = c.Error(fmt.Errorf("Cannot restore revision %d of document id %s [err=%w]", revisionNumber, id, err))
+		return
+	case errors.Is(err, repodocuments.ErrPreconditionFailed):
+		_ = c.Error(fmt.Errorf("document id %s has been modified [err=%w]", id, err))
+		return
+	case err != nil:
+		_ = c.Error(fmt.Errorf("Cannot restore revision %d of document id %s [err=%w]", revisionNumber, id, err))
+		return
+	}
+
+	c.Header("ETag", etag(doc))
+	if docUpdated {
+		negotiation.Render(c, http.StatusOK, doc)
+	} else {
+		negotiation.Render(c, http.StatusCreated, doc)
+	}
+}
+
+// Endpoint to retrieve the ACL of a document
+// @Summary Retrieve the ACL of a document
+// @Description Retrieve who can read and write the document. A document written before the ACLs has no owner, anyone can use it and only an admin can share it.
+// @Produce  json,xml,application/yaml,application/msgpack
+// @Param id path int true "Document ID"
+// @Param X-Tenant header string false "Tenant of the documents, the default tenant if absent"
+// @Success 200 {object} models.DocumentACL
+// @Header 200 {string} ETag "the version of the document"
+// @Failure 401 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 406 {object} models.Problem
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Router /documents/{id}/acl [get]
+func (resource ResourceDocument) GetDocumentACL(c *gin.Context) {
+	id := c.Param("id")
+	doc, err := resource.documentService.Get(CallerContext(c), id)
+	if errors.Is(err, repodocuments.ErrNotFound) {
+		_ = c.Error(problems.NotFound("document id %s not found", id))
+		return
+	}
+	if err != nil {
+		_ = c.Error(fmt.Errorf("Cannot get document id %s [err=%w]", id, err))
+		return
+	}
+
+	acl := models.DocumentACL{Readers: []string{}, Writers: []string{}}
+	if doc.ACL != nil {
+		acl = *doc.ACL
+	}
+	c.Header("ETag", etag(doc))
+	negotiation.Render(c, http.StatusOK, acl)
+}
+
+// Endpoint to share a document
+// @Summary Replace the ACL of a document
+// @Description Replace who can read and write the document, only its owner or an admin (scope documents:admin) can. The readers and the writers are principals like user:alice or group:finance,
+// @Description the writers can also read the document. Giving the document to another owner makes the current one lose it.
+// @Accept  json,xml,application/yaml,application/msgpack
+// @Produce  json,xml,application/yaml,application/msgpack
+// @Param id path int true "Document ID"
+// @Param If-Match header string false "ETag of the document"
+// @Param data body models.DocumentACL true "The ACL"
+// @Param X-Tenant header string false "Tenant of the documents, the default tenant if absent"
+// @Success 200 {object} models.DocumentACL
+// @Header 200 {string} ETag "the version of the document"
+// @Failure 401 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Failure 400 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 404 {object} models.Problem
+// @Failure 412 {object} models.Problem
+// @Failure 406 {object} models.Problem
+// @Failure 415 {object} models.Problem
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Router /documents/{id}/acl [put]
+func (resource ResourceDocument) SetDocumentACL(c *gin.Context) {
+	id := c.Param("id")
+
+	precondition, err := resource.preconditionFrom(c)
+	if err != nil {
+		_ = c.Error(problems.Validation("Validation failed [err=%s]", err))
+		return
+	}
+
+	var acl models.DocumentACL
+	if err := negotiation.Bind(c, "ACL", &acl); err != nil {
+		_ = c.Error(err)
 		return
 	}
-	c.IndentedJSON(http.StatusOK, nil)
+
+	doc, err := resource.documentService.SetACL(CallerContext(c), id, acl, precondition)
+	switch {
+	case errors.Is(err, repodocuments.ErrNotFound):
+		_ = c.Error(problems.NotFound("document id %s not found", id))
+		return
+	case errors.Is(err, repodocuments.ErrPreconditionFailed):
+		_ = c.Error(fmt.Errorf("document id %s has been modified [err=%w]", id, err))
+		return
+	case err != nil:
+		_ = c.Error(fmt.Errorf("Cannot change the ACL of document id %s [err=%w]", id, err))
+		return
+	}
+
+	c.Header("ETag", etag(doc))
+	negotiation.Render(c, http.StatusOK, doc.ACL)
 }
 
 // RegisterHandlers register all handlers for a router
-func RegisterHandlers(r *gin.Engine, documentService servicedocuments.DocumentService) {
-	resource := ResourceDocument{documentService}
+func RegisterHandlers(r *gin.Engine, configuration *config.CORSConfig, documentService servicedocuments.DocumentService) {
+	resource := ResourceDocument{documentService, newUpgrader(configuration.AllowOrigins)}
+	//the scopes the principals need on each route
+	read, write := auth.RequireScopes(ReadScope), auth.RequireScopes(WriteScope)
+	//the format of the responses, the export and the changes have their own
+	negotiate := negotiation.Middleware()
 
-	r.GET("/documents", resource.GetAllDocuments)
-	r.GET("/documents/:id", resource.GetDocument)
-	r.PUT("/documents/:id", resource.CreateOrUpdateDocument)
-	r.DELETE("/documents/:id", resource.DeleteDocument)
+	r.GET("/documents", read, negotiate, resource.GetAllDocuments)
+	r.PATCH("/documents", write, negotiate, resource.PatchDocuments)
+	r.GET("/documents/search", read, negotiate, resource.SearchDocuments)
+	r.GET("/documents/trash", read, negotiate, resource.GetTrash)
+	r.GET("/documents/export", read, resource.ExportDocuments)
+	r.POST("/documents/import", write, negotiate, resource.ImportDocuments)
+	r.GET("/documents/changes", read, resource.StreamChanges)
+	r.GET("/documents/changes/ws", read, resource.StreamChangesWebSocket)
+	r.GET("/documents/:id", read, negotiate, resource.GetDocument)
+	r.PUT("/documents/:id", write, negotiate, resource.CreateOrUpdateDocument)
+	r.PATCH("/documents/:id", write, negotiate, resource.PatchDocument)
+	r.DELETE("/documents/:id", write, negotiate, resource.DeleteDocument)
+	r.POST("/documents/:id/restore", write, negotiate, resource.RestoreDocument)
+	r.GET("/documents/:id/acl", read, negotiate, resource.GetDocumentACL)
+	r.PUT("/documents/:id/acl", write, negotiate, resource.SetDocumentACL)
+	r.GET("/documents/:id/revisions", read, negotiate, resource.GetRevisions)
+	r.GET("/documents/:id/revisions/:rev", read, negotiate, resource.GetRevision)
+	r.POST("/documents/:id/revisions/:rev/restore", write, negotiate, resource.RestoreRevision)
 }
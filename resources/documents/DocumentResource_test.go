@@ -2,15 +2,24 @@ package documents
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	_ "encoding/json"
 	"errors"
 	"fmt"
+	"goapi/config"
 	"goapi/models"
+	"goapi/repositories/repodocuments"
+	"goapi/resources/auth"
+	"goapi/resources/problems"
+	"goapi/services/servicedocuments"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
@@ -26,26 +35,99 @@ type DocumentServiceMock struct {
 	mock.Mock
 }
 
-func (s *DocumentServiceMock) Get(id string) (models.Document, error) {
+func (s *DocumentServiceMock) Get(ctx context.Context, id string) (models.Document, error) {
 	args := s.Called(id)
 	return args.Get(0).(models.Document), args.Error(1)
 }
 
-func (s *DocumentServiceMock) GetAll() ([]models.Document, error) {
+func (s *DocumentServiceMock) GetAll(ctx context.Context) ([]models.Document, error) {
 	args := s.Called()
 	return args.Get(0).([]models.Document), args.Error(1)
 }
 
-func (s *DocumentServiceMock) CreateOrUpdate(documentToCreate models.Document) (bool, error) {
-	args := s.Called(documentToCreate)
-	return args.Get(0).(bool), args.Error(1)
+//the documents returned by the mock are sent to f
+func (s *DocumentServiceMock) Export(ctx context.Context, filter repodocuments.DocumentFilter, f func(document models.Document) error) error {
+	args := s.Called(filter)
+	for _, document := range args.Get(0).([]models.Document) {
+		if err := f(document); err != nil {
+			return err
+		}
+	}
+	return args.Error(1)
+}
+
+func (s *DocumentServiceMock) List(ctx context.Context, query repodocuments.DocumentQuery) (models.DocumentPage, error) {
+	args := s.Called(query)
+	return args.Get(0).(models.DocumentPage), args.Error(1)
+}
+
+func (s *DocumentServiceMock) Search(ctx context.Context, query repodocuments.SearchQuery) (models.SearchPage, error) {
+	args := s.Called(query)
+	return args.Get(0).(models.SearchPage), args.Error(1)
+}
+
+//the writes are called with the actor of their context
+func (s *DocumentServiceMock) CreateOrUpdate(ctx context.Context, documentToCreate models.Document, precondition repodocuments.Precondition) (models.Document, bool, error) {
+	args := s.Called(servicedocuments.ActorFrom(ctx), documentToCreate, precondition)
+	return args.Get(0).(models.Document), args.Get(1).(bool), args.Error(2)
 }
 
-func (s *DocumentServiceMock) Delete(idToDelete string) (bool, error) {
-	args := s.Called(idToDelete)
+func (s *DocumentServiceMock) Patch(ctx context.Context, id string, patchType servicedocuments.PatchType, patch []byte, precondition repodocuments.Precondition) (models.Document, error) {
+	args := s.Called(servicedocuments.ActorFrom(ctx), id, patchType, string(patch), precondition)
+	return args.Get(0).(models.Document), args.Error(1)
+}
+
+func (s *DocumentServiceMock) Delete(ctx context.Context, idToDelete string, precondition repodocuments.Precondition) (bool, error) {
+	args := s.Called(idToDelete, precondition)
 	return args.Get(0).(bool), args.Error(1)
 }
 
+func (s *DocumentServiceMock) Restore(ctx context.Context, id string) (models.Document, error) {
+	args := s.Called(id)
+	return args.Get(0).(models.Document), args.Error(1)
+}
+
+func (s *DocumentServiceMock) SetACL(ctx context.Context, id string, acl models.DocumentACL, precondition repodocuments.Precondition) (models.Document, error) {
+	args := s.Called(servicedocuments.PrincipalsFrom(ctx), id, acl, precondition)
+	return args.Get(0).(models.Document), args.Error(1)
+}
+
+func (s *DocumentServiceMock) PurgeTrash() (int64, error) {
+	args := s.Called()
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (s *DocumentServiceMock) ApplyBatch(ctx context.Context, operations []repodocuments.Operation, atomic bool) ([]repodocuments.OperationResult, error) {
+	args := s.Called(servicedocuments.ActorFrom(ctx), operations, atomic)
+	return args.Get(0).([]repodocuments.OperationResult), args.Error(1)
+}
+
+func (s *DocumentServiceMock) GetRevisions(ctx context.Context, id string) ([]models.DocumentRevision, error) {
+	args := s.Called(id)
+	return args.Get(0).([]models.DocumentRevision), args.Error(1)
+}
+
+func (s *DocumentServiceMock) GetRevision(ctx context.Context, id string, revision int64) (models.DocumentRevision, bool, error) {
+	args := s.Called(id, revision)
+	return args.Get(0).(models.DocumentRevision), args.Get(1).(bool), args.Error(2)
+}
+
+func (s *DocumentServiceMock) RestoreRevision(ctx context.Context, id string, revision int64, precondition repodocuments.Precondition) (models.Document, bool, error) {
+	args := s.Called(servicedocuments.ActorFrom(ctx), id, revision, precondition)
+	return args.Get(0).(models.Document), args.Get(1).(bool), args.Error(2)
+}
+
+func (s *DocumentServiceMock) WatchChanges(ctx context.Context, lastEventID string, prefix string) (<-chan models.DocumentChange, error) {
+	args := s.Called(ctx, lastEventID, prefix)
+	changes, _ := args.Get(0).(<-chan models.DocumentChange)
+	return changes, args.Error(1)
+}
+
+func (s *DocumentServiceMock) Validate(document models.Document) error {
+	args := s.Called(document)
+	return args.Error(0)
+}
+
 /*
 	Test suite definition
 */
@@ -87,21 +169,23 @@ func (suite *DocumentResourceTestSuite) TearDownSuite() {
 
 func (suite *DocumentResourceTestSuite) TestResourceDocument_getAllDocuments() {
 
-	expected := []models.Document{
-		{
-			ID:          "toto",
-			Name:        "nameOfToto",
-			Description: "descOfToto",
-		},
-		{
-			ID:          "titi",
-			Name:        "nameOfTiti",
-			Description: "descOfTiti",
+	expected := models.DocumentPage{
+		Documents: []models.Document{
+			{
+				ID:          "titi",
+				Name:        "nameOfTiti",
+				Description: "descOfTiti",
+			},
+			{
+				ID:          "toto",
+				Name:        "nameOfToto",
+				Description: "descOfToto",
+			},
 		},
 	}
 
 	//add handler mock service
-	suite.documentServiceMock.On("GetAll").Return(expected, nil)
+	suite.documentServiceMock.On("List", repodocuments.DocumentQuery{Sort: repodocuments.DocumentSort{Field: "id"}}).Return(expected, nil)
 
 	//create request
 	req, err := http.NewRequest("GET", suite.testServer.URL+"/documents", nil)
@@ -112,30 +196,196 @@ func (suite *DocumentResourceTestSuite) TestResourceDocument_getAllDocuments() {
 	executeRequest(suite, req, string(expectedBody[:]), http.StatusOK)
 }
 
-func (suite *DocumentResourceTestSuite) TestResourceDocument_getAllDocumentsErrorService() {
+func (suite *DocumentResourceTestSuite) TestResourceDocument_getAllDocumentsWithNextPage() {
 
-	expected := []models.Document{
-		{
-			ID:          "toto",
-			Name:        "nameOfToto",
-			Description: "descOfToto",
+	expected := models.DocumentPage{
+		Documents: []models.Document{
+			{
+				ID:          "toto",
+				Name:        "nameOfToto",
+				Description: "descOfToto",
+			},
 		},
-		{
-			ID:          "titi",
-			Name:        "nameOfTiti",
-			Description: "descOfTiti",
+		NextCursor: "nextCursor",
+	}
+
+	//add handler mock service
+	query := repodocuments.DocumentQuery{Limit: 1, Cursor: "cursor", Sort: repodocuments.DocumentSort{Field: "name", Descending: true}}
+	suite.documentServiceMock.On("List", query).Return(expected, nil)
+
+	//create request
+	req, err := http.NewRequest("GET", suite.testServer.URL+"/documents?limit=1&sort=-name&cursor=cursor", nil)
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
+
+	//check result has the link to the next page
+	expected.Next = "/documents?cursor=nextCursor&limit=1&sort=-name"
+	expectedBody, err := json.Marshal(expected)
+	executeRequest(suite, req, string(expectedBody[:]), http.StatusOK)
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_getAllDocumentsWrongLimit() {
+
+	//create request
+	req, err := http.NewRequest("GET", suite.testServer.URL+"/documents?limit=0", nil)
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
+
+	//check result
+	var expectedError = problemOf(req, http.StatusBadRequest, "Validation failed [err=limit must be between 1 and 1000]")
+	executeRequest(suite, req, expectedError, http.StatusBadRequest)
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_getAllDocumentsWrongSort() {
+
+	//create request
+	req, err := http.NewRequest("GET", suite.testServer.URL+"/documents?sort=description", nil)
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
+
+	//check result
+	var expectedError = problemOf(req, http.StatusBadRequest, "Validation failed [err=sort must be one of id, name, createdAt, createdBy, updatedAt, updatedBy, prefixed by - for a descending order]")
+	executeRequest(suite, req, expectedError, http.StatusBadRequest)
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_getAllDocumentsAudited() {
+
+	//add handler mock service
+	query := repodocuments.DocumentQuery{
+		Sort: repodocuments.DocumentSort{Field: "updatedAt", Descending: true},
+		Filter: repodocuments.DocumentFilter{
+			CreatedBy:    "alice",
+			UpdatedAfter: time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC),
 		},
 	}
+	suite.documentServiceMock.On("List", query).Return(models.DocumentPage{Documents: []models.Document{}}, nil)
+
+	//create request
+	req, err := http.NewRequest("GET", suite.testServer.URL+"/documents?sort=-updatedAt&createdBy=alice&updatedAfter=2021-10-01T14:00:00%2B02:00", nil)
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
+
+	//check result
+	executeRequest(suite, req, `{"documents":[]}`, http.StatusOK)
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_getAllDocumentsSelected() {
+
+	//add handler mock service
+	query := repodocuments.DocumentQuery{
+		Sort: repodocuments.DocumentSort{Field: "id"},
+		Filter: repodocuments.DocumentFilter{Selector: repodocuments.LabelSelector{
+			{Key: "team", Operator: repodocuments.LabelEquals, Values: []string{"payments"}},
+			{Key: "env", Operator: repodocuments.LabelNotIn, Values: []string{"prod", "staging"}},
+		}},
+	}
+	document := models.Document{ID: "toto", Labels: map[string]string{"team": "payments"}}
+	suite.documentServiceMock.On("List", query).Return(models.DocumentPage{Documents: []models.Document{document}}, nil)
+
+	//create request
+	req, err := http.NewRequest("GET", suite.testServer.URL+"/documents?selector="+url.QueryEscape("team=payments,env notin (prod,staging)"), nil)
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
+
+	//check result
+	expectedBody, err := json.Marshal(models.DocumentPage{Documents: []models.Document{document}})
+	executeRequest(suite, req, string(expectedBody), http.StatusOK)
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_getAllDocumentsWrongSelector() {
+
+	//create request
+	req, err := http.NewRequest("GET", suite.testServer.URL+"/documents?selector=%3Dpayments", nil)
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
+
+	//check result
+	var expectedError = problemOf(req, http.StatusBadRequest, `Validation failed [err=invalid selector: "" is not a label key]`)
+	executeRequest(suite, req, expectedError, http.StatusBadRequest)
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_getAllDocumentsWrongTime() {
+
+	//create request
+	req, err := http.NewRequest("GET", suite.testServer.URL+"/documents?createdBefore=yesterday", nil)
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
+
+	//check result
+	var expectedError = problemOf(req, http.StatusBadRequest, "Validation failed [err=createdBefore must be a RFC 3339 time]")
+	executeRequest(suite, req, expectedError, http.StatusBadRequest)
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_getAllDocumentsWrongCursor() {
 
 	//add handler mock service
-	suite.documentServiceMock.On("GetAll").Return(expected, errors.New("error_service_getAll"))
+	query := repodocuments.DocumentQuery{Cursor: "wrong", Sort: repodocuments.DocumentSort{Field: "id"}}
+	suite.documentServiceMock.On("List", query).Return(models.DocumentPage{}, repodocuments.ErrInvalidCursor)
+
+	//create request
+	req, err := http.NewRequest("GET", suite.testServer.URL+"/documents?cursor=wrong", nil)
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
+
+	//check result
+	var expectedError = problemOf(req, http.StatusBadRequest, "Validation failed [err=invalid cursor]")
+	executeRequest(suite, req, expectedError, http.StatusBadRequest)
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_getAllDocumentsErrorService() {
+
+	//add handler mock service
+	suite.documentServiceMock.On("List", repodocuments.DocumentQuery{Sort: repodocuments.DocumentSort{Field: "id"}}).Return(models.DocumentPage{}, errors.New("error_service_list"))
 
 	//create request
 	req, err := http.NewRequest("GET", suite.testServer.URL+"/documents", nil)
 	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
 
 	//check result
-	var expectedError = `{"message":"Cannot get documents [err=error_service_getAll]"}`
+	var expectedError = problemOf(req, http.StatusInternalServerError, "Cannot get documents [err=error_service_list]")
+	executeRequest(suite, req, expectedError, http.StatusInternalServerError)
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_searchDocuments() {
+
+	expected := models.SearchPage{
+		Hits: []models.SearchHit{
+			{
+				Document:   models.Document{ID: "toto", Name: "report of toto"},
+				Score:      1.5,
+				Highlights: map[string]string{"name": "<em>report</em> of toto"},
+			},
+		},
+		NextCursor: "nextCursor",
+	}
+
+	//add handler mock service
+	suite.documentServiceMock.On("Search", repodocuments.SearchQuery{Text: "report", Limit: 1}).Return(expected, nil)
+
+	//create request
+	req, err := http.NewRequest("GET", suite.testServer.URL+"/documents/search?q=report&limit=1", nil)
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
+
+	//check result
+	expected.Next = "/documents/search?cursor=nextCursor&limit=1&q=report"
+	expectedBody, err := json.Marshal(expected)
+	executeRequest(suite, req, string(expectedBody), http.StatusOK)
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_searchDocumentsNoText() {
+
+	//create request
+	req, err := http.NewRequest("GET", suite.testServer.URL+"/documents/search?q=%20-", nil)
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
+
+	//check result
+	var expectedError = problemOf(req, http.StatusBadRequest, "Validation failed [err=q must contain at least one word]")
+	executeRequest(suite, req, expectedError, http.StatusBadRequest)
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_searchDocumentsErrorService() {
+
+	//add handler mock service
+	suite.documentServiceMock.On("Search", repodocuments.SearchQuery{Text: "report"}).Return(models.SearchPage{}, errors.New("error_service_search"))
+
+	//create request
+	req, err := http.NewRequest("GET", suite.testServer.URL+"/documents/search?q=report", nil)
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
+
+	//check result
+	var expectedError = problemOf(req, http.StatusInternalServerError, "Cannot search documents [err=error_service_search]")
 	executeRequest(suite, req, expectedError, http.StatusInternalServerError)
 }
 
@@ -145,6 +395,7 @@ func (suite *DocumentResourceTestSuite) TestResourceDocument_getDocumentOK() {
 		ID:          "toto",
 		Name:        "nameOfToto",
 		Description: "descOfToto",
+		Version:     4,
 	}
 
 	//add handler mock service
@@ -156,7 +407,8 @@ func (suite *DocumentResourceTestSuite) TestResourceDocument_getDocumentOK() {
 
 	//check result is OK
 	expectedBody, err := json.Marshal(expected)
-	executeRequest(suite, req, string(expectedBody[:]), http.StatusOK)
+	resp := executeRequest(suite, req, string(expectedBody[:]), http.StatusOK)
+	assert.Equal(suite.T(), `"4"`, resp.Header.Get("ETag"))
 }
 
 func (suite *DocumentResourceTestSuite) TestResourceDocument_getDocumentErrorService() {
@@ -168,7 +420,7 @@ func (suite *DocumentResourceTestSuite) TestResourceDocument_getDocumentErrorSer
 	req, err := http.NewRequest("GET", suite.testServer.URL+"/documents/toto", nil)
 	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
 
-	var expectedError = `{"message":"Cannot get document id toto [err=error_service_get]"}`
+	var expectedError = problemOf(req, http.StatusInternalServerError, "Cannot get document id toto [err=error_service_get]")
 	//check result is error
 	executeRequest(suite, req, expectedError, http.StatusInternalServerError)
 }
@@ -176,13 +428,13 @@ func (suite *DocumentResourceTestSuite) TestResourceDocument_getDocumentErrorSer
 func (suite *DocumentResourceTestSuite) TestResourceDocument_getDocumentNotFound() {
 
 	//add handler mock service
-	suite.documentServiceMock.On("Get", "toto").Return(models.Document{}, nil)
+	suite.documentServiceMock.On("Get", "toto").Return(models.Document{}, repodocuments.ErrNotFound)
 
 	//create the request
 	req, err := http.NewRequest("GET", suite.testServer.URL+"/documents/toto", nil)
 	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
 
-	var expectedError = `{"message":"document id toto not found"}`
+	var expectedError = problemOf(req, http.StatusNotFound, "document id toto not found")
 	//check result is error
 	executeRequest(suite, req, expectedError, http.StatusNotFound)
 }
@@ -196,7 +448,9 @@ func (suite *DocumentResourceTestSuite) TestResourceDocument_createOrUpdateDocum
 	}
 
 	//add handler mock service
-	suite.documentServiceMock.On("CreateOrUpdate", expected).Return(false, nil)
+	created := expected
+	created.Version = 1
+	suite.documentServiceMock.On("CreateOrUpdate", servicedocuments.AnonymousActor, expected, repodocuments.Precondition{}).Return(created, false, nil)
 
 	//create request
 	payload, err := json.Marshal(expected)
@@ -204,7 +458,124 @@ func (suite *DocumentResourceTestSuite) TestResourceDocument_createOrUpdateDocum
 	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
 
 	//check result
-	executeRequest(suite, req, string(payload), http.StatusCreated)
+	expectedBody, err := json.Marshal(created)
+	resp := executeRequest(suite, req, string(expectedBody), http.StatusCreated)
+	assert.Equal(suite.T(), `"1"`, resp.Header.Get("ETag"))
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_createOrUpdateDocumentXML() {
+
+	expected := models.Document{
+		ID:          "toto",
+		Name:        "nameOfToto",
+		Description: "descOfToto",
+		Labels:      models.Labels{"team": "payments"},
+	}
+
+	//add handler mock service
+	created := expected
+	created.Version = 1
+	suite.documentServiceMock.On("CreateOrUpdate", servicedocuments.AnonymousActor, expected, repodocuments.Precondition{}).Return(created, false, nil)
+
+	//create request, the body is decoded and the response encoded in XML
+	req, err := http.NewRequest("PUT", suite.testServer.URL+"/documents/toto", strings.NewReader(
+		`<document><name>nameOfToto</name><description>descOfToto</description><labels><label key="team">payments</label></labels></document>`))
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
+	req.Header.Set("Content-Type", "application/xml")
+	req.Header.Set("Accept", "application/xml")
+
+	//check result
+	resp, err := suite.testServer.Client().Do(req)
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't send request: %v", err))
+	suite.documentServiceMock.AssertExpectations(suite.T())
+	assert.Equal(suite.T(), http.StatusCreated, resp.StatusCode)
+	assert.Equal(suite.T(), "application/xml; charset=utf-8", resp.Header.Get("Content-Type"))
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't read body response: %v", err))
+	assert.Contains(suite.T(), string(body), `<document><id>toto</id><name>nameOfToto</name><description>descOfToto</description>`+
+		`<labels><label key="team">payments</label></labels><version>1</version>`)
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_createOrUpdateDocumentNotAcceptable() {
+
+	//create request, nothing is written when the response cannot be answered
+	req, err := http.NewRequest("PUT", suite.testServer.URL+"/documents/toto", strings.NewReader(`{"name": "nameOfToto"}`))
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
+	req.Header.Set("Accept", "text/html")
+
+	//check result
+	var expectedError = problemOf(req, http.StatusNotAcceptable,
+		"Not acceptable [err=the supported media types are application/json, application/xml, application/yaml, application/msgpack]")
+	executeRequest(suite, req, expectedError, http.StatusNotAcceptable)
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_createOrUpdateDocumentUnsupportedMediaType() {
+
+	//create request
+	req, err := http.NewRequest("PUT", suite.testServer.URL+"/documents/toto", strings.NewReader(`name=nameOfToto`))
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	//check result
+	var expectedError = problemOf(req, http.StatusUnsupportedMediaType, "Cannot deserialize document [err=the media type application/x-www-form-urlencoded "+
+		"is not supported, use one of application/json, application/xml, application/yaml, application/msgpack]")
+	executeRequest(suite, req, expectedError, http.StatusUnsupportedMediaType)
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_createOrUpdateDocumentCreateOnly() {
+
+	expected := models.Document{
+		ID:          "toto",
+		Name:        "nameOfToto",
+		Description: "descOfToto",
+	}
+
+	//add handler mock service
+	suite.documentServiceMock.On("CreateOrUpdate", servicedocuments.AnonymousActor, expected, repodocuments.Precondition{MustNotExist: true}).Return(models.Document{}, true, repodocuments.ErrPreconditionFailed)
+
+	//create request
+	payload, err := json.Marshal(expected)
+	req, err := http.NewRequest("PUT", suite.testServer.URL+"/documents/"+expected.ID, bytes.NewBuffer(payload))
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
+	req.Header.Set("If-None-Match", "*")
+
+	//check result
+	var expectedError = problemOf(req, http.StatusPreconditionFailed, "document id toto has been modified [err=precondition failed]")
+	executeRequest(suite, req, expectedError, http.StatusPreconditionFailed)
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_createOrUpdateDocumentStaleVersion() {
+
+	expected := models.Document{
+		ID:          "toto",
+		Name:        "nameOfToto",
+		Description: "descOfToto",
+	}
+
+	//add handler mock service
+	suite.documentServiceMock.On("CreateOrUpdate", servicedocuments.AnonymousActor, expected, repodocuments.Precondition{IfMatch: 2}).Return(models.Document{}, true, repodocuments.ErrPreconditionFailed)
+
+	//create request, the version in the payload is ignored
+	payload, err := json.Marshal(models.Document{Name: expected.Name, Description: expected.Description, Version: 5})
+	req, err := http.NewRequest("PUT", suite.testServer.URL+"/documents/"+expected.ID, bytes.NewBuffer(payload))
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
+	req.Header.Set("If-Match", `"2"`)
+
+	//check result
+	var expectedError = problemOf(req, http.StatusPreconditionFailed, "document id toto has been modified [err=precondition failed]")
+	executeRequest(suite, req, expectedError, http.StatusPreconditionFailed)
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_createOrUpdateDocumentWrongIfMatch() {
+
+	//create request
+	req, err := http.NewRequest("PUT", suite.testServer.URL+"/documents/toto", bytes.NewBuffer([]byte("{\"name\":\"nameToto\"}")))
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
+	req.Header.Set("If-Match", "2")
+
+	//check result
+	var expectedError = problemOf(req, http.StatusBadRequest, "Validation failed [err=invalid ETag 2]")
+	executeRequest(suite, req, expectedError, http.StatusBadRequest)
 }
 
 func (suite *DocumentResourceTestSuite) TestResourceDocument_createOrUpdateDocumentUpdate() {
@@ -216,16 +587,20 @@ func (suite *DocumentResourceTestSuite) TestResourceDocument_createOrUpdateDocum
 	}
 
 	//add handler mock service
-	suite.documentServiceMock.On("CreateOrUpdate", expected).Return(true, nil)
+	updated := expected
+	updated.Version = 3
+	suite.documentServiceMock.On("CreateOrUpdate", servicedocuments.AnonymousActor, expected, repodocuments.Precondition{IfMatch: 2}).Return(updated, true, nil)
 
 	//create request
 	payload, err := json.Marshal(models.Document{Name: expected.Name, Description: expected.Description})
 	req, err := http.NewRequest("PUT", suite.testServer.URL+"/documents/"+expected.ID, bytes.NewBuffer(payload))
 	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
+	req.Header.Set("If-Match", `"2"`)
 
 	//check result
-	expectedBody, err := json.Marshal(expected)
-	executeRequest(suite, req, string(expectedBody), http.StatusOK)
+	expectedBody, err := json.Marshal(updated)
+	resp := executeRequest(suite, req, string(expectedBody), http.StatusOK)
+	assert.Equal(suite.T(), `"3"`, resp.Header.Get("ETag"))
 }
 
 func (suite *DocumentResourceTestSuite) TestResourceDocument_createOrUpdateDocumentErrorService() {
@@ -237,7 +612,7 @@ func (suite *DocumentResourceTestSuite) TestResourceDocument_createOrUpdateDocum
 	}
 
 	//add handler mock service
-	suite.documentServiceMock.On("CreateOrUpdate", expected).Return(false, errors.New("error_service_create"))
+	suite.documentServiceMock.On("CreateOrUpdate", servicedocuments.AnonymousActor, expected, repodocuments.Precondition{}).Return(models.Document{}, false, errors.New("error_service_create"))
 
 	//create request
 	payload, err := json.Marshal(expected)
@@ -245,10 +620,34 @@ func (suite *DocumentResourceTestSuite) TestResourceDocument_createOrUpdateDocum
 	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
 
 	//check result
-	var expectedError = `{"message":"Cannot create or update document [err=error_service_create]"}`
+	var expectedError = problemOf(req, http.StatusInternalServerError, "Cannot create or update document [err=error_service_create]")
 	executeRequest(suite, req, expectedError, http.StatusInternalServerError)
 }
 
+func (suite *DocumentResourceTestSuite) TestResourceDocument_createOrUpdateDocumentInvalid() {
+
+	expected := models.Document{ID: "toto"}
+
+	//add handler mock service
+	invalidFields := []models.FieldError{
+		{Field: "name", Message: "name must be defined"},
+		{Field: "description", Message: "description must be defined"},
+	}
+	suite.documentServiceMock.On("CreateOrUpdate", servicedocuments.AnonymousActor, expected, repodocuments.Precondition{}).
+		Return(models.Document{}, false, &servicedocuments.ValidationError{Fields: invalidFields})
+
+	//create request
+	payload, err := json.Marshal(expected)
+	req, err := http.NewRequest("PUT", suite.testServer.URL+"/documents/"+expected.ID, bytes.NewBuffer(payload))
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
+
+	//check result lists all the invalid fields
+	problem := problems.Of(http.StatusBadRequest, "Cannot create or update document [err=name must be defined, description must be defined]", req.URL.RequestURI())
+	problem.Errors = invalidFields
+	expectedError, err := json.Marshal(problem)
+	executeRequest(suite, req, string(expectedError), http.StatusBadRequest)
+}
+
 func (suite *DocumentResourceTestSuite) TestResourceDocument_createOrUpdateDocumentNoPayload() {
 
 	//create request
@@ -256,7 +655,7 @@ func (suite *DocumentResourceTestSuite) TestResourceDocument_createOrUpdateDocum
 	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
 
 	//check result
-	var expectedError = `{"message":"Cannot deserialize document [err=EOF]"}`
+	var expectedError = problemOf(req, http.StatusBadRequest, "Cannot deserialize document [err=EOF]")
 	executeRequest(suite, req, expectedError, http.StatusBadRequest)
 }
 
@@ -267,14 +666,14 @@ func (suite *DocumentResourceTestSuite) TestResourceDocument_createOrUpdateDocum
 	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
 
 	//check result
-	var expectedError = `{"message":"Validation failed [err=id must be defined]"}`
+	var expectedError = problemOf(req, http.StatusBadRequest, "Validation failed [err=id must be defined]")
 	executeRequest(suite, req, expectedError, http.StatusBadRequest)
 }
 
 func (suite *DocumentResourceTestSuite) TestResourceDocument_deleteDocument() {
 
 	//add handler mock service
-	suite.documentServiceMock.On("Delete", "toto").Return(true, nil)
+	suite.documentServiceMock.On("Delete", "toto", repodocuments.Precondition{}).Return(true, nil)
 
 	//create request
 	req, err := http.NewRequest("DELETE", suite.testServer.URL+"/documents/toto", nil)
@@ -284,17 +683,32 @@ func (suite *DocumentResourceTestSuite) TestResourceDocument_deleteDocument() {
 	executeRequest(suite, req, "null", http.StatusOK)
 }
 
+func (suite *DocumentResourceTestSuite) TestResourceDocument_deleteDocumentStaleVersion() {
+
+	//add handler mock service
+	suite.documentServiceMock.On("Delete", "toto", repodocuments.Precondition{IfMatch: 1}).Return(true, repodocuments.ErrPreconditionFailed)
+
+	//create request
+	req, err := http.NewRequest("DELETE", suite.testServer.URL+"/documents/toto", nil)
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
+	req.Header.Set("If-Match", `"1"`)
+
+	//check result
+	var expectedError = problemOf(req, http.StatusPreconditionFailed, "document id toto has been modified [err=precondition failed]")
+	executeRequest(suite, req, expectedError, http.StatusPreconditionFailed)
+}
+
 func (suite *DocumentResourceTestSuite) TestResourceDocument_deleteDocumentErrorService() {
 
 	//add handler mock service
-	suite.documentServiceMock.On("Delete", "toto").Return(false, errors.New("error_service_delete"))
+	suite.documentServiceMock.On("Delete", "toto", repodocuments.Precondition{}).Return(false, errors.New("error_service_delete"))
 
 	//create request
 	req, err := http.NewRequest("DELETE", suite.testServer.URL+"/documents/toto", nil)
 	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
 
 	//check result
-	var expectedError = `{"message":"Cannot delete documents [err=error_service_delete]"}`
+	var expectedError = problemOf(req, http.StatusInternalServerError, "Cannot delete documents [err=error_service_delete]")
 	executeRequest(suite, req, expectedError, http.StatusInternalServerError)
 }
 
@@ -305,28 +719,460 @@ func (suite *DocumentResourceTestSuite) TestResourceDocument_deleteDocumentsWron
 	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
 
 	//check result
-	var expectedError = `{"message":"Validation failed [err=id must be defined]"}`
+	var expectedError = problemOf(req, http.StatusBadRequest, "Validation failed [err=id must be defined]")
 	executeRequest(suite, req, expectedError, http.StatusBadRequest)
 }
 
 func (suite *DocumentResourceTestSuite) TestResourceDocument_deleteDocumentNotFound() {
 
 	//add handler mock service
-	suite.documentServiceMock.On("Delete", "toto").Return(false, nil)
+	suite.documentServiceMock.On("Delete", "toto", repodocuments.Precondition{}).Return(false, nil)
 
 	//create request
 	req, err := http.NewRequest("DELETE", suite.testServer.URL+"/documents/toto", nil)
 	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
 
 	//check result
-	var expectedError = `{"message":"document id toto not found"}`
+	var expectedError = problemOf(req, http.StatusNotFound, "document id toto not found")
 	executeRequest(suite, req, expectedError, http.StatusNotFound)
 }
 
+func (suite *DocumentResourceTestSuite) TestResourceDocument_getTrash() {
+
+	deletedAt := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+	expected := models.DocumentPage{
+		Documents: []models.Document{
+			{ID: "toto", Name: "nameOfToto", Version: 2, DeletedAt: &deletedAt},
+		},
+	}
+
+	//add handler mock service
+	suite.documentServiceMock.On("List", repodocuments.DocumentQuery{Sort: repodocuments.DocumentSort{Field: "name"}, Trashed: true}).Return(expected, nil)
+
+	//create request
+	req, err := http.NewRequest("GET", suite.testServer.URL+"/documents/trash?sort=name", nil)
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
+
+	//check result
+	expectedBody, err := json.Marshal(expected)
+	executeRequest(suite, req, string(expectedBody), http.StatusOK)
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_restoreDocument() {
+
+	restored := models.Document{ID: "toto", Name: "nameOfToto", Version: 3}
+
+	//add handler mock service
+	suite.documentServiceMock.On("Restore", "toto").Return(restored, nil)
+
+	//create request
+	req, err := http.NewRequest("POST", suite.testServer.URL+"/documents/toto/restore", nil)
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
+
+	//check result
+	expectedBody, err := json.Marshal(restored)
+	resp := executeRequest(suite, req, string(expectedBody), http.StatusOK)
+	assert.Equal(suite.T(), `"3"`, resp.Header.Get("ETag"))
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_restoreDocumentNotInTrash() {
+
+	//add handler mock service
+	suite.documentServiceMock.On("Restore", "toto").Return(models.Document{}, repodocuments.ErrNotFound)
+
+	//create request
+	req, err := http.NewRequest("POST", suite.testServer.URL+"/documents/toto/restore", nil)
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
+
+	//check result
+	var expectedError = problemOf(req, http.StatusNotFound, "document id toto not found in the trash")
+	executeRequest(suite, req, expectedError, http.StatusNotFound)
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_patchDocument() {
+
+	//add handler mock service
+	patched := models.Document{ID: "toto", Name: "nameOfToto", Description: "newDesc", Version: 3}
+	suite.documentServiceMock.On("Patch", "alice", "toto", servicedocuments.MergePatch, `{"description":"newDesc"}`, repodocuments.Precondition{IfMatch: 2}).Return(patched, nil)
+
+	//create request
+	req, err := http.NewRequest("PATCH", suite.testServer.URL+"/documents/toto", bytes.NewBufferString(`{"description":"newDesc"}`))
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	req.Header.Set("If-Match", `"2"`)
+	req.Header.Set(testUserHeader, "alice")
+
+	//check result
+	expectedBody, err := json.Marshal(patched)
+	resp := executeRequest(suite, req, string(expectedBody), http.StatusOK)
+	assert.Equal(suite.T(), `"3"`, resp.Header.Get("ETag"))
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_patchDocumentErrors() {
+
+	patch := `[{"op":"remove","path":"/unknown"}]`
+	for _, test := range []struct {
+		id             string
+		contentType    string
+		body           string
+		serviceError   error
+		expectedDetail string
+		expectedStatus int
+	}{
+		{"toto", "application/json-patch+json", patch, fmt.Errorf("%w: missing path", servicedocuments.ErrInvalidPatch),
+			"Cannot apply patch [err=invalid patch: missing path]", http.StatusUnprocessableEntity},
+		{"titi", "application/json-patch+json", patch, repodocuments.ErrNotFound,
+			"document id titi not found", http.StatusNotFound},
+		{"toto", "application/json-patch+json", `[{"op"`, nil,
+			"Cannot deserialize patch [err=invalid JSON]", http.StatusBadRequest},
+		{"toto", "application/json", `{}`, nil,
+			"Content-Type must be application/merge-patch+json or application/json-patch+json", http.StatusUnsupportedMediaType},
+	} {
+		//add handler mock service, only called for valid requests
+		if test.serviceError != nil {
+			suite.documentServiceMock.On("Patch", servicedocuments.AnonymousActor, test.id, servicedocuments.PatchType(test.contentType), test.body, repodocuments.Precondition{}).
+				Return(models.Document{}, test.serviceError).Once()
+		}
+
+		//create request
+		req, err := http.NewRequest("PATCH", suite.testServer.URL+"/documents/"+test.id, bytes.NewBufferString(test.body))
+		assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
+		req.Header.Set("Content-Type", test.contentType)
+
+		//check result
+		executeRequest(suite, req, problemOf(req, test.expectedStatus, test.expectedDetail), test.expectedStatus)
+	}
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_patchDocuments() {
+
+	//add handler mock service
+	operations := []repodocuments.Operation{
+		{Document: models.Document{ID: "toto", Name: "nameOfToto"}},
+		{Document: models.Document{ID: "titi", Name: "nameOfTiti"}, Precondition: repodocuments.Precondition{IfMatch: 3}},
+		{Delete: true, Document: models.Document{ID: "tata"}},
+		{Delete: true, Document: models.Document{ID: "tutu"}},
+	}
+	now := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+	outcomes := []repodocuments.OperationResult{
+		{Document: models.Document{ID: "toto", Name: "nameOfToto", Version: 1, CreatedAt: now, CreatedBy: "alice", UpdatedAt: now, UpdatedBy: "alice"}},
+		{Document: models.Document{ID: "titi", Name: "nameOfTiti", Version: 4, CreatedAt: now.Add(-time.Hour), CreatedBy: "bob", UpdatedAt: now, UpdatedBy: "alice"}, Existed: true},
+		{Existed: true},
+		{Err: repodocuments.ErrNotFound},
+	}
+	suite.documentServiceMock.On("ApplyBatch", "alice", operations, false).Return(outcomes, nil)
+
+	//create request
+	payload := `[
+		{"op":"upsert","id":"toto","document":{"name":"nameOfToto"}},
+		{"op":"upsert","id":"titi","document":{"name":"nameOfTiti"},"ifMatch":3},
+		{"op":"delete","id":"tata"},
+		{"op":"delete","id":"tutu"},
+		{"op":"unknown","id":"tyty"}
+	]`
+	req, err := http.NewRequest("PATCH", suite.testServer.URL+"/documents", bytes.NewBufferString(payload))
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
+	req.Header.Set(testUserHeader, "alice")
+
+	//check result
+	var expected = `[
+		{"id":"toto","status":201,"document":{"id":"toto","name":"nameOfToto","description":"","version":1,
+			"createdAt":"2021-10-01T12:00:00Z","createdBy":"alice","updatedAt":"2021-10-01T12:00:00Z","updatedBy":"alice"}},
+		{"id":"titi","status":200,"document":{"id":"titi","name":"nameOfTiti","description":"","version":4,
+			"createdAt":"2021-10-01T11:00:00Z","createdBy":"bob","updatedAt":"2021-10-01T12:00:00Z","updatedBy":"alice"}},
+		{"id":"tata","status":200},
+		{"id":"tutu","status":404,"error":"document not found"},
+		{"id":"tyty","status":400,"error":"op must be upsert or delete"}
+	]`
+	executeRequest(suite, req, expected, http.StatusMultiStatus)
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_patchDocumentsAtomicWithInvalidOperation() {
+
+	//create request, the service is not called
+	payload := `[{"op":"upsert","id":"toto","document":{"name":"nameOfToto"}},{"op":"delete","id":""}]`
+	req, err := http.NewRequest("PATCH", suite.testServer.URL+"/documents?atomic=true", bytes.NewBufferString(payload))
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
+
+	//check result
+	var expected = `[
+		{"id":"toto","status":424,"error":"rolled back because another operation failed"},
+		{"id":"","status":400,"error":"id must be defined"}
+	]`
+	executeRequest(suite, req, expected, http.StatusMultiStatus)
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_patchDocumentsAtomic() {
+
+	//add handler mock service
+	operations := []repodocuments.Operation{
+		{Document: models.Document{ID: "toto", Name: "nameOfToto"}},
+		{Delete: true, Document: models.Document{ID: "tata"}},
+	}
+	outcomes := []repodocuments.OperationResult{
+		{Document: models.Document{ID: "toto", Name: "nameOfToto", Version: 1}},
+		{Existed: true},
+	}
+	suite.documentServiceMock.On("ApplyBatch", servicedocuments.AnonymousActor, operations, true).Return(outcomes, nil)
+
+	//create request
+	payload := `[{"op":"upsert","id":"toto","document":{"name":"nameOfToto"}},{"op":"delete","id":"tata"}]`
+	req, err := http.NewRequest("PATCH", suite.testServer.URL+"/documents?atomic=true", bytes.NewBufferString(payload))
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
+
+	//check result
+	var expected = `[
+		{"id":"toto","status":201,"document":{"id":"toto","name":"nameOfToto","description":"","version":1,
+			"createdAt":"0001-01-01T00:00:00Z","createdBy":"","updatedAt":"0001-01-01T00:00:00Z","updatedBy":""}},
+		{"id":"tata","status":200}
+	]`
+	executeRequest(suite, req, expected, http.StatusOK)
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_patchDocumentsErrorService() {
+
+	//add handler mock service
+	operations := []repodocuments.Operation{{Delete: true, Document: models.Document{ID: "tata"}}}
+	suite.documentServiceMock.On("ApplyBatch", servicedocuments.AnonymousActor, operations, false).Return([]repodocuments.OperationResult{}, errors.New("error_service_batch"))
+
+	//create request
+	req, err := http.NewRequest("PATCH", suite.testServer.URL+"/documents", bytes.NewBufferString(`[{"op":"delete","id":"tata"}]`))
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
+
+	//check result
+	var expectedError = problemOf(req, http.StatusInternalServerError, "Cannot apply operations [err=error_service_batch]")
+	executeRequest(suite, req, expectedError, http.StatusInternalServerError)
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_patchDocumentsNoOperation() {
+
+	//create request
+	req, err := http.NewRequest("PATCH", suite.testServer.URL+"/documents", bytes.NewBufferString(`[]`))
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
+
+	//check result
+	var expectedError = problemOf(req, http.StatusBadRequest, "Validation failed [err=the number of operations must be between 1 and 1000]")
+	executeRequest(suite, req, expectedError, http.StatusBadRequest)
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_getRevisions() {
+
+	timestamp := time.Date(2021, 11, 10, 8, 0, 0, 0, time.UTC)
+	expected := []models.DocumentRevision{
+		{DocumentID: "toto", Revision: 1, Timestamp: timestamp, Document: &models.Document{ID: "toto", Name: "nameOfToto", Version: 1}},
+		{DocumentID: "toto", Revision: 2, Timestamp: timestamp, Deleted: true},
+	}
+
+	//add handler mock service
+	suite.documentServiceMock.On("GetRevisions", "toto").Return(expected, nil)
+
+	//create request
+	req, err := http.NewRequest("GET", suite.testServer.URL+"/documents/toto/revisions", nil)
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
+
+	//check result
+	expectedBody, err := json.Marshal(expected)
+	executeRequest(suite, req, string(expectedBody), http.StatusOK)
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_getRevisionsNotFound() {
+
+	//add handler mock service
+	suite.documentServiceMock.On("GetRevisions", "toto").Return([]models.DocumentRevision{}, nil)
+
+	//create request
+	req, err := http.NewRequest("GET", suite.testServer.URL+"/documents/toto/revisions", nil)
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
+
+	//check result
+	var expectedError = problemOf(req, http.StatusNotFound, "no revision for document id toto")
+	executeRequest(suite, req, expectedError, http.StatusNotFound)
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_getRevision() {
+
+	expected := models.DocumentRevision{DocumentID: "toto", Revision: 3, Timestamp: time.Date(2021, 11, 10, 8, 0, 0, 0, time.UTC), Document: &models.Document{ID: "toto", Version: 2}}
+
+	//add handler mock service
+	suite.documentServiceMock.On("GetRevision", "toto", int64(3)).Return(expected, true, nil)
+
+	//create request
+	req, err := http.NewRequest("GET", suite.testServer.URL+"/documents/toto/revisions/3", nil)
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
+
+	//check result
+	expectedBody, err := json.Marshal(expected)
+	executeRequest(suite, req, string(expectedBody), http.StatusOK)
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_getRevisionWrongNumber() {
+
+	//create request
+	req, err := http.NewRequest("GET", suite.testServer.URL+"/documents/toto/revisions/last", nil)
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
+
+	//check result
+	var expectedError = problemOf(req, http.StatusBadRequest, "Validation failed [err=rev must be a revision number]")
+	executeRequest(suite, req, expectedError, http.StatusBadRequest)
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_restoreRevision() {
+
+	restored := models.Document{ID: "toto", Name: "nameOfToto", Version: 5}
+
+	//add handler mock service
+	suite.documentServiceMock.On("RestoreRevision", servicedocuments.AnonymousActor, "toto", int64(2), repodocuments.Precondition{IfMatch: 4}).Return(restored, true, nil)
+
+	//create request
+	req, err := http.NewRequest("POST", suite.testServer.URL+"/documents/toto/revisions/2/restore", nil)
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
+	req.Header.Set("If-Match", `"4"`)
+
+	//check result
+	expectedBody, err := json.Marshal(restored)
+	resp := executeRequest(suite, req, string(expectedBody), http.StatusOK)
+	assert.Equal(suite.T(), `"5"`, resp.Header.Get("ETag"))
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_restoreRevisionDeletion() {
+
+	//add handler mock service
+	suite.documentServiceMock.On("RestoreRevision", servicedocuments.AnonymousActor, "toto", int64(2), repodocuments.Precondition{}).Return(models.Document{}, false, servicedocuments.ErrRevisionDeleted)
+
+	//create request
+	req, err := http.NewRequest("POST", suite.testServer.URL+"/documents/toto/revisions/2/restore", nil)
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
+
+	//check result
+	var expectedError = problemOf(req, http.StatusUnprocessableEntity, "Cannot restore revision 2 of document id toto [err=the revision is a deletion]")
+	executeRequest(suite, req, expectedError, http.StatusUnprocessableEntity)
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_restoreRevisionNotFound() {
+
+	//add handler mock service
+	suite.documentServiceMock.On("RestoreRevision", servicedocuments.AnonymousActor, "toto", int64(2), repodocuments.Precondition{}).Return(models.Document{}, false, repodocuments.ErrNotFound)
+
+	//create request
+	req, err := http.NewRequest("POST", suite.testServer.URL+"/documents/toto/revisions/2/restore", nil)
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
+
+	//check result
+	var expectedError = problemOf(req, http.StatusNotFound, "revision 2 of document id toto not found")
+	executeRequest(suite, req, expectedError, http.StatusNotFound)
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_getDocumentACL() {
+
+	acl := models.DocumentACL{Owner: "alice", Readers: []string{"group:finance"}, Writers: []string{}}
+
+	//add handler mock service
+	suite.documentServiceMock.On("Get", "toto").Return(models.Document{ID: "toto", Version: 2, ACL: &acl}, nil)
+
+	//create request
+	req, err := http.NewRequest("GET", suite.testServer.URL+"/documents/toto/acl", nil)
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
+
+	//check result
+	expectedBody, err := json.Marshal(acl)
+	resp := executeRequest(suite, req, string(expectedBody), http.StatusOK)
+	assert.Equal(suite.T(), `"2"`, resp.Header.Get("ETag"))
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_getDocumentACLWithoutOwner() {
+
+	//add handler mock service
+	suite.documentServiceMock.On("Get", "toto").Return(models.Document{ID: "toto", Version: 1}, nil)
+
+	//create request
+	req, err := http.NewRequest("GET", suite.testServer.URL+"/documents/toto/acl", nil)
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
+
+	//check result
+	executeRequest(suite, req, `{"owner":"","readers":[],"writers":[]}`, http.StatusOK)
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_setDocumentACL() {
+
+	acl := models.DocumentACL{Owner: "alice", Readers: []string{"group:finance"}, Writers: []string{"user:bob"}}
+
+	//add handler mock service, the principals come from the actor and its groups
+	suite.documentServiceMock.On("SetACL", []string{"user:alice", "group:legal", "group:finance"}, "toto", acl, repodocuments.Precondition{IfMatch: 2}).
+		Return(models.Document{ID: "toto", Version: 3, ACL: &acl}, nil)
+
+	//create request
+	body, _ := json.Marshal(acl)
+	req, err := http.NewRequest("PUT", suite.testServer.URL+"/documents/toto/acl", bytes.NewBuffer(body))
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
+	req.Header.Set(testUserHeader, "alice")
+	req.Header.Set(testGroupsHeader, "legal, finance")
+	req.Header.Set("If-Match", `"2"`)
+
+	//check result
+	resp := executeRequest(suite, req, string(body), http.StatusOK)
+	assert.Equal(suite.T(), `"3"`, resp.Header.Get("ETag"))
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_setDocumentACLNotOwner() {
+
+	acl := models.DocumentACL{Owner: "bob"}
+
+	//add handler mock service
+	suite.documentServiceMock.On("SetACL", []string{"user:bob"}, "toto", acl, repodocuments.Precondition{}).Return(models.Document{}, servicedocuments.ErrNotOwner)
+
+	//create request
+	body, _ := json.Marshal(acl)
+	req, err := http.NewRequest("PUT", suite.testServer.URL+"/documents/toto/acl", bytes.NewBuffer(body))
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't create request: %v", err))
+	req.Header.Set(testUserHeader, "bob")
+
+	//check result
+	var expectedError = problemOf(req, http.StatusForbidden, "Cannot change the ACL of document id toto [err=only the owner of the document or an admin can change its ACL]")
+	executeRequest(suite, req, expectedError, http.StatusForbidden)
+}
+
+func TestCallerContext(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/documents", nil)
+	//the headers of the client do not tell the actor of a request without principal
+	c.Request.Header.Set("X-User", "alice")
+	c.Request.Header.Set("X-Groups", "legal")
+	assert.Equal(t, []string{"user:" + servicedocuments.AnonymousActor}, servicedocuments.PrincipalsFrom(CallerContext(c)))
+
+	//the actor of an authenticated request is its principal, set by the authentication
+	c.Set(auth.PrincipalKey, &auth.Principal{Subject: "bob"})
+	c.Request = c.Request.WithContext(servicedocuments.WithActor(c.Request.Context(), "bob"))
+	assert.Equal(t, []string{"user:bob"}, servicedocuments.PrincipalsFrom(CallerContext(c)))
+}
+
+//the headers of the tests telling the principal of a request, as the authentication would
+const (
+	testUserHeader   = "X-Test-User"
+	testGroupsHeader = "X-Test-Groups"
+)
+
+//authenticateTest sets the principal of the test headers, with its actor and its groups like the authentication middleware
+func authenticateTest(c *gin.Context) {
+	if user := c.GetHeader(testUserHeader); len(user) > 0 {
+		var groups []string
+		for _, group := range strings.Split(c.GetHeader(testGroupsHeader), ",") {
+			if group = strings.TrimSpace(group); len(group) > 0 {
+				groups = append(groups, group)
+			}
+		}
+		c.Set(auth.PrincipalKey, &auth.Principal{Subject: user, Groups: groups})
+		ctx := servicedocuments.WithActor(c.Request.Context(), user)
+		c.Request = c.Request.WithContext(servicedocuments.WithGroups(ctx, groups))
+	}
+	c.Next()
+}
+
 func configureRouter(service *DocumentServiceMock) *gin.Engine {
 	gin.SetMode(gin.TestMode)
 	router := gin.Default()
-	RegisterHandlers(router, service)
+	router.Use(problems.Handler(), authenticateTest)
+	RegisterHandlers(router, &config.CORSConfig{AllowOrigins: []string{"https://app.example.com"}}, service)
 	return router
 }
 
@@ -337,7 +1183,13 @@ func createTestServer(service *DocumentServiceMock) *httptest.Server {
 	return testServer
 }
 
-func executeRequest(suite *DocumentResourceTestSuite, req *http.Request, expectedBody string, expectedCodeStatus int) {
+//problemOf returns the body of the error response to the request
+func problemOf(req *http.Request, status int, detail string) string {
+	problem, _ := json.Marshal(problems.Of(status, detail, req.URL.RequestURI()))
+	return string(problem)
+}
+
+func executeRequest(suite *DocumentResourceTestSuite, req *http.Request, expectedBody string, expectedCodeStatus int) *http.Response {
 
 	//execute the request
 	resp, err := suite.testServer.Client().Do(req)
@@ -355,4 +1207,5 @@ func executeRequest(suite *DocumentResourceTestSuite, req *http.Request, expecte
 
 	//check the body value
 	require.JSONEq(suite.T(), expectedBody, string(body[:]))
+	return resp
 }
@@ -0,0 +1,485 @@
+package documents
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"goapi/models"
+	"goapi/repositories/repodocuments"
+	"goapi/resources/negotiation"
+	"goapi/resources/problems"
+	"goapi/services/servicedocuments"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The formats of the exports and the imports
+const (
+	FormatNDJSON = "ndjson"
+	FormatCSV    = "csv"
+)
+
+// The content types of the formats
+const (
+	NDJSONContentType = "application/x-ndjson"
+	CSVContentType    = "text/csv"
+)
+
+// ImportBatchSize is the number of documents written at once by an import
+const ImportBatchSize = 100
+
+// MaxImportErrors is the number of failed lines listed in the outcome of an import
+const MaxImportErrors = 1000
+
+//maxLineSize is the maximum size of a line of an NDJSON import
+const maxLineSize = 1 << 20
+
+//csvColumns are the columns of the CSV exports, the imports only read the id, the name, the description and the labels
+var csvColumns = []string{"id", "name", "description", "labels", "version", "createdAt", "createdBy", "updatedAt", "updatedBy"}
+
+//formatLabels writes the labels as in a label selector, like env=prod,team=payments
+func formatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for key, value := range labels {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+//parseLabels reads the labels written by formatLabels
+func parseLabels(value string) (map[string]string, error) {
+	if len(strings.TrimSpace(value)) == 0 {
+		return nil, nil
+	}
+	labels := make(map[string]string)
+	for _, pair := range strings.Split(value, ",") {
+		key, labelValue, found := cut(strings.TrimSpace(pair), "=")
+		if !found || len(key) == 0 {
+			return nil, fmt.Errorf("labels must be like key=value,key2=value2")
+		}
+		labels[key] = labelValue
+	}
+	return labels, nil
+}
+
+//cut slices s around the first instance of sep
+func cut(s string, sep string) (string, string, bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+//documentWriter writes the documents of an export in a format
+type documentWriter interface {
+	write(document models.Document) error
+	//close writes what is left
+	close() error
+}
+
+type ndjsonWriter struct {
+	encoder *json.Encoder
+}
+
+func (w *ndjsonWriter) write(document models.Document) error {
+	return w.encoder.Encode(document)
+}
+
+func (w *ndjsonWriter) close() error {
+	return nil
+}
+
+type csvWriter struct {
+	writer *csv.Writer
+}
+
+func (w *csvWriter) write(document models.Document) error {
+	return w.writer.Write([]string{
+		document.ID,
+		document.Name,
+		document.Description,
+		formatLabels(document.Labels),
+		strconv.FormatInt(document.Version, 10),
+		document.CreatedAt.UTC().Format(time.RFC3339Nano),
+		document.CreatedBy,
+		document.UpdatedAt.UTC().Format(time.RFC3339Nano),
+		document.UpdatedBy,
+	})
+}
+
+func (w *csvWriter) close() error {
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+//newDocumentWriter returns the writer of the format, the CSV header is written first
+func newDocumentWriter(format string, writer io.Writer) (documentWriter, error) {
+	if format == FormatCSV {
+		w := &csvWriter{writer: csv.NewWriter(writer)}
+		return w, w.writer.Write(csvColumns)
+	}
+	encoder := json.NewEncoder(writer)
+	encoder.SetEscapeHTML(false)
+	return &ndjsonWriter{encoder: encoder}, nil
+}
+
+//documentReader reads the documents of an import one by one
+type documentReader interface {
+	//next returns the next document and its line. A document that cannot be read is returned with invalid, the next ones can still be read.
+	//err is io.EOF at the end, or the error that stops the import.
+	next() (document models.Document, line int, invalid error, err error)
+}
+
+type ndjsonReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func (r *ndjsonReader) next() (models.Document, int, error, error) {
+	for r.scanner.Scan() {
+		r.line++
+		text := bytes.TrimSpace(r.scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		var document models.Document
+		if err := json.Unmarshal(text, &document); err != nil {
+			return models.Document{}, r.line, fmt.Errorf("Cannot deserialize document [err=%s]", err), nil
+		}
+		return document, r.line, nil, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return models.Document{}, r.line + 1, nil, fmt.Errorf("Cannot read line %d [err=%w]", r.line+1, err)
+	}
+	return models.Document{}, r.line, nil, io.EOF
+}
+
+type csvReader struct {
+	reader *csv.Reader
+	//the position of each column in the records
+	columns map[string]int
+}
+
+//newCSVReader reads the header of the CSV file, it must have an id column
+func newCSVReader(body io.Reader) (*csvReader, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("the CSV file must start with a header")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Cannot read the CSV header [err=%w]", err)
+	}
+	r := &csvReader{reader: reader, columns: make(map[string]int)}
+	for i, column := range header {
+		r.columns[strings.TrimSpace(column)] = i
+	}
+	if _, found := r.columns["id"]; !found {
+		return nil, errors.New("the CSV header must have an id column")
+	}
+	return r, nil
+}
+
+//field returns the value of the column in the record, empty if the file has no such column
+func (r *csvReader) field(record []string, column string) string {
+	if i, found := r.columns[column]; found {
+		return record[i]
+	}
+	return ""
+}
+
+func (r *csvReader) next() (models.Document, int, error, error) {
+	record, err := r.reader.Read()
+	var parseError *csv.ParseError
+	switch {
+	case err == io.EOF:
+		return models.Document{}, 0, nil, io.EOF
+	case errors.As(err, &parseError):
+		return models.Document{}, parseError.StartLine, fmt.Errorf("Cannot read the CSV record [err=%s]", parseError.Err), nil
+	case err != nil:
+		return models.Document{}, 0, nil, fmt.Errorf("Cannot read the CSV file [err=%w]", err)
+	}
+
+	line, _ := r.reader.FieldPos(0)
+	if len(record) != len(r.columns) {
+		return models.Document{}, line, fmt.Errorf("the record must have %d fields like the header", len(r.columns)), nil
+	}
+	labels, err := parseLabels(r.field(record, "labels"))
+	if err != nil {
+		return models.Document{}, line, err, nil
+	}
+	return models.Document{
+		ID:          r.field(record, "id"),
+		Name:        r.field(record, "name"),
+		Description: r.field(record, "description"),
+		Labels:      labels,
+	}, line, nil, nil
+}
+
+//newDocumentReader returns the reader of the format
+func newDocumentReader(format string, body io.Reader) (documentReader, error) {
+	if format == FormatCSV {
+		return newCSVReader(body)
+	}
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	return &ndjsonReader{scanner: scanner}, nil
+}
+
+//importFormat is the format of the format parameter, otherwise of the content type of the request
+func importFormat(c *gin.Context) string {
+	if format, found := c.GetQuery("format"); found {
+		return format
+	}
+	if mediaType, _, _ := mime.ParseMediaType(c.ContentType()); mediaType == CSVContentType {
+		return FormatCSV
+	}
+	return FormatNDJSON
+}
+
+//validFormat tells if the documents can be exported and imported in the format
+func validFormat(format string) bool {
+	return format == FormatNDJSON || format == FormatCSV
+}
+
+// Endpoint to export the documents
+// @Summary Export the documents
+// @Description Stream all the documents matching the filters, as NDJSON (one JSON document per line) or as CSV.
+// @Description The CSV columns are id, name, description, labels (like env=prod,team=payments), version and the audit fields.
+// @Produce  application/x-ndjson
+// @Produce  text/csv
+// @Param format query string false "Format of the export" Enums(ndjson, csv)
+// @Param createdBy query string false "Only the documents created by this actor"
+// @Param updatedBy query string false "Only the documents last modified by this actor"
+// @Param createdAfter query string false "Only the documents created after this RFC 3339 time"
+// @Param createdBefore query string false "Only the documents created before this RFC 3339 time"
+// @Param updatedAfter query string false "Only the documents last modified after this RFC 3339 time"
+// @Param updatedBefore query string false "Only the documents last modified before this RFC 3339 time"
+// @Param selector query string false "Only the documents whose labels match the selector, like team=payments,env!=prod,tier in (front,back),!deprecated"
+// @Param X-Tenant header string false "Tenant of the documents, the default tenant if absent"
+// @Success 200 {file} binary "the documents"
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Router /documents/export [get]
+func (resource ResourceDocument) ExportDocuments(c *gin.Context) {
+	format := c.DefaultQuery("format", FormatNDJSON)
+	if !validFormat(format) {
+		_ = c.Error(problems.Validation("Validation failed [err=format must be %s or %s]", FormatNDJSON, FormatCSV))
+		return
+	}
+	filter, err := resource.filterFrom(c)
+	if err != nil {
+		_ = c.Error(problems.Validation("Validation failed [err=%s]", err))
+		return
+	}
+
+	//the response starts with the first document, so that an error before it is answered as a problem
+	var writer documentWriter
+	start := func() error {
+		contentType := NDJSONContentType
+		if format == FormatCSV {
+			contentType = CSVContentType
+		}
+		c.Header("Content-Type", contentType)
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="documents.%s"`, format))
+		c.Status(http.StatusOK)
+		var err error
+		writer, err = newDocumentWriter(format, c.Writer)
+		return err
+	}
+	ctx := CallerContext(c)
+	err = resource.documentService.Export(ctx, filter, func(document models.Document) error {
+		if writer == nil {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		return writer.write(document)
+	})
+	if err == nil && writer == nil {
+		err = start()
+	}
+	if writer != nil {
+		if closeErr := writer.close(); err == nil {
+			err = closeErr
+		}
+	}
+
+	if err != nil && !c.Writer.Written() {
+		_ = c.Error(fmt.Errorf("Cannot export documents [err=%w]", err))
+	} else if err != nil {
+		//the response is cut short, like when the client goes away
+		servicedocuments.LoggerFrom(ctx).Errorf("Cannot export documents [err=%s]", err)
+	}
+}
+
+//importLine is a document of an import with its line
+type importLine struct {
+	line     int
+	document models.Document
+}
+
+//addFailure counts a failed line of the import, and lists it if there are not too many
+func addFailure(report *models.DocumentImport, line int, id string, err error) {
+	report.Failed++
+	if len(report.Errors) < MaxImportErrors {
+		report.Errors = append(report.Errors, models.ImportError{Line: line, ID: id, Error: err.Error()})
+	}
+}
+
+//importBatch writes the documents, the existing ones are left as they are in the skip mode
+func (resource ResourceDocument) importBatch(c *gin.Context, lines []importLine, skipExisting bool, report *models.DocumentImport) error {
+	operations := make([]repodocuments.Operation, len(lines))
+	for i, line := range lines {
+		operations[i] = repodocuments.Operation{Document: line.document, Precondition: repodocuments.Precondition{MustNotExist: skipExisting}}
+	}
+	outcomes, err := resource.documentService.ApplyBatch(CallerContext(c), operations, false)
+	if err != nil {
+		return err
+	}
+	for i, outcome := range outcomes {
+		switch {
+		case outcome.Err == nil && outcome.Existed:
+			report.Updated++
+		case outcome.Err == nil:
+			report.Created++
+		case skipExisting && errors.Is(outcome.Err, repodocuments.ErrPreconditionFailed):
+			report.Skipped++
+		default:
+			addFailure(report, lines[i].line, lines[i].document.ID, outcome.Err)
+		}
+	}
+	return nil
+}
+
+// Endpoint to import documents
+// @Summary Import documents
+// @Description Create or update the documents of an NDJSON (one JSON document per line) or CSV upload, as made by the export, in batches.
+// @Description The CSV file starts with a header, only its id, name, description and labels columns are read.
+// @Description Each line is applied on its own, the failed lines are listed with their error. The fields managed by the server are ignored.
+// @Description With mode=skip the documents that already exist are left as they are, with dryRun=true the documents are only validated.
+// @Description When the upload cannot be read or a batch cannot be written once others were, the report of the written batches is answered with the error.
+// @Accept  application/x-ndjson
+// @Accept  text/csv
+// @Produce  json,xml,application/yaml,application/msgpack
+// @Param format query string false "Format of the upload, given by the Content-Type if absent" Enums(ndjson, csv)
+// @Param mode query string false "upsert writes all the documents, skip only creates the new ones (default upsert)" Enums(upsert, skip)
+// @Param dryRun query bool false "Only validate the documents"
+// @Param data body string true "The documents"
+// @Param X-Tenant header string false "Tenant of the documents, the default tenant if absent"
+// @Success 200 {object} models.DocumentImport
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Failure 406 {object} models.Problem
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Router /documents/import [post]
+func (resource ResourceDocument) ImportDocuments(c *gin.Context) {
+	format := importFormat(c)
+	if !validFormat(format) {
+		_ = c.Error(problems.Validation("Validation failed [err=format must be %s or %s]", FormatNDJSON, FormatCSV))
+		return
+	}
+	mode := c.DefaultQuery("mode", models.ImportModeUpsert)
+	if mode != models.ImportModeUpsert && mode != models.ImportModeSkip {
+		_ = c.Error(problems.Validation("Validation failed [err=mode must be %s or %s]", models.ImportModeUpsert, models.ImportModeSkip))
+		return
+	}
+	dryRun, err := strconv.ParseBool(c.DefaultQuery("dryRun", "false"))
+	if err != nil {
+		_ = c.Error(problems.Validation("Validation failed [err=dryRun must be a boolean]"))
+		return
+	}
+	reader, err := newDocumentReader(format, c.Request.Body)
+	if err != nil {
+		_ = c.Error(problems.Validation("Validation failed [err=%s]", err))
+		return
+	}
+
+	report := models.DocumentImport{DryRun: dryRun, Errors: []models.ImportError{}}
+	batch := make([]importLine, 0, ImportBatchSize)
+	//written tells that a batch was written, the report of the import is then answered even if it fails later
+	written := false
+	writeBatch := func() error {
+		if err := resource.importBatch(c, batch, mode == models.ImportModeSkip, &report); err != nil {
+			return fmt.Errorf("Cannot import documents [err=%w]", err)
+		}
+		written = true
+		batch = batch[:0]
+		return nil
+	}
+
+	var readErr, writeErr error
+	for writeErr == nil {
+		document, line, invalid, err := reader.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			readErr = problems.Validation("Cannot import documents [err=%s]", err)
+			break
+		}
+		report.Lines++
+		if invalid == nil {
+			invalid = resource.validationID(document.ID)
+		}
+		if invalid == nil {
+			invalid = resource.documentService.Validate(document)
+		}
+		if invalid != nil {
+			addFailure(&report, line, document.ID, invalid)
+			continue
+		}
+		report.Valid++
+		if dryRun {
+			continue
+		}
+
+		//the fields managed by the server are not imported
+		batch = append(batch, importLine{line: line, document: models.Document{
+			ID: document.ID, Name: document.Name, Description: document.Description, Labels: document.Labels,
+		}})
+		if len(batch) == ImportBatchSize {
+			writeErr = writeBatch()
+		}
+	}
+	//the documents read before an unreadable line are still written
+	if writeErr == nil && len(batch) > 0 {
+		writeErr = writeBatch()
+	}
+
+	err = writeErr
+	if err == nil {
+		err = readErr
+	}
+	switch {
+	case err == nil:
+		negotiation.Render(c, http.StatusOK, report)
+	case !written:
+		_ = c.Error(err)
+	default:
+		//the batches written before the error are reported with it
+		status := problems.StatusOf(err)
+		if status >= http.StatusInternalServerError {
+			servicedocuments.LoggerFrom(c.Request.Context()).Errorf("%s %s failed [err=%s]", c.Request.Method, c.Request.URL.Path, err)
+		}
+		report.Error = err.Error()
+		negotiation.Render(c, status, report)
+	}
+}
@@ -0,0 +1,234 @@
+package documents
+
+import (
+	"errors"
+	"fmt"
+	"goapi/models"
+	"goapi/repositories/repodocuments"
+	"goapi/services/servicedocuments"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var exported = []models.Document{
+	{ID: "titi", Name: "nameOfTiti", Labels: map[string]string{"team": "payments", "env": "prod"}, Version: 2,
+		CreatedAt: time.Date(2021, 10, 1, 8, 0, 0, 0, time.UTC), CreatedBy: "alice", UpdatedAt: time.Date(2021, 10, 2, 8, 0, 0, 0, time.UTC), UpdatedBy: "bob"},
+	{ID: "toto", Name: "nameOfToto", Description: "desc, with a comma", Version: 1,
+		CreatedAt: time.Date(2021, 10, 3, 8, 0, 0, 0, time.UTC), CreatedBy: "alice", UpdatedAt: time.Date(2021, 10, 3, 8, 0, 0, 0, time.UTC), UpdatedBy: "alice"},
+}
+
+//exportDocuments returns the response to the export and its body
+func exportDocuments(suite *DocumentResourceTestSuite, query string) (*http.Response, string) {
+	resp, err := suite.testServer.Client().Get(suite.testServer.URL + "/documents/export" + query)
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't send request: %v", err))
+	suite.documentServiceMock.AssertExpectations(suite.T())
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.Nil(suite.T(), err, fmt.Sprintf("Couldn't read body response: %v", err))
+	return resp, string(body)
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_exportDocumentsNDJSON() {
+
+	//add handler mock service, with the filter of the request
+	selector, _ := repodocuments.ParseLabelSelector("team=payments")
+	suite.documentServiceMock.On("Export", repodocuments.DocumentFilter{Selector: selector, CreatedBy: "alice"}).Return(exported, nil)
+
+	//check result
+	resp, body := exportDocuments(suite, "?selector=team%3Dpayments&createdBy=alice")
+	assert.Equal(suite.T(), http.StatusOK, resp.StatusCode)
+	assert.Equal(suite.T(), NDJSONContentType, resp.Header.Get("Content-Type"))
+	assert.Equal(suite.T(), `attachment; filename="documents.ndjson"`, resp.Header.Get("Content-Disposition"))
+	lines := strings.Split(strings.TrimSuffix(body, "\n"), "\n")
+	assert.Equal(suite.T(), 2, len(lines))
+	assert.JSONEq(suite.T(), `{"id":"titi","name":"nameOfTiti","description":"","labels":{"env":"prod","team":"payments"},"version":2,
+		"createdAt":"2021-10-01T08:00:00Z","createdBy":"alice","updatedAt":"2021-10-02T08:00:00Z","updatedBy":"bob"}`, lines[0])
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_exportDocumentsCSV() {
+
+	//add handler mock service
+	suite.documentServiceMock.On("Export", repodocuments.DocumentFilter{}).Return(exported, nil)
+
+	//check result
+	resp, body := exportDocuments(suite, "?format=csv")
+	assert.Equal(suite.T(), http.StatusOK, resp.StatusCode)
+	assert.Equal(suite.T(), CSVContentType, resp.Header.Get("Content-Type"))
+	assert.Equal(suite.T(), "id,name,description,labels,version,createdAt,createdBy,updatedAt,updatedBy\n"+
+		"titi,nameOfTiti,,\"env=prod,team=payments\",2,2021-10-01T08:00:00Z,alice,2021-10-02T08:00:00Z,bob\n"+
+		"toto,nameOfToto,\"desc, with a comma\",,1,2021-10-03T08:00:00Z,alice,2021-10-03T08:00:00Z,alice\n", body)
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_exportDocumentsEmpty() {
+
+	//add handler mock service
+	suite.documentServiceMock.On("Export", repodocuments.DocumentFilter{}).Return([]models.Document{}, nil)
+
+	//an empty CSV export still has its header
+	resp, body := exportDocuments(suite, "?format=csv")
+	assert.Equal(suite.T(), http.StatusOK, resp.StatusCode)
+	assert.Equal(suite.T(), "id,name,description,labels,version,createdAt,createdBy,updatedAt,updatedBy\n", body)
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_exportDocumentsError() {
+
+	//add handler mock service
+	suite.documentServiceMock.On("Export", repodocuments.DocumentFilter{}).Return([]models.Document{}, repodocuments.ErrNoDatastore)
+
+	//create request
+	req, _ := http.NewRequest("GET", suite.testServer.URL+"/documents/export", nil)
+
+	//check result, nothing was sent yet so the error is answered
+	var expectedError = problemOf(req, http.StatusServiceUnavailable, "Cannot export documents [err=no datastore]")
+	executeRequest(suite, req, expectedError, http.StatusServiceUnavailable)
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_exportDocumentsWrongFormat() {
+
+	//create request
+	req, _ := http.NewRequest("GET", suite.testServer.URL+"/documents/export?format=xml", nil)
+
+	//check result
+	var expectedError = problemOf(req, http.StatusBadRequest, "Validation failed [err=format must be ndjson or csv]")
+	executeRequest(suite, req, expectedError, http.StatusBadRequest)
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_importDocumentsNDJSON() {
+
+	//add handler mock service, the fields managed by the server are not imported
+	suite.documentServiceMock.On("Validate", mock.Anything).Return(nil)
+	operations := []repodocuments.Operation{
+		{Document: models.Document{ID: "titi", Name: "nameOfTiti", Labels: map[string]string{"team": "payments"}}},
+		{Document: models.Document{ID: "toto", Name: "nameOfToto"}},
+		{Document: models.Document{ID: "tata"}},
+	}
+	suite.documentServiceMock.On("ApplyBatch", "alice", operations, false).Return([]repodocuments.OperationResult{
+		{Document: operations[0].Document, Existed: true},
+		{Document: operations[1].Document},
+		{Err: servicedocuments.ErrWriteDenied},
+	}, nil)
+
+	//create request
+	body := `{"id":"titi","name":"nameOfTiti","labels":{"team":"payments"},"version":12,"createdBy":"mallory"}
+
+{"id":"toto","name":"nameOfToto"}
+{"id":
+{"name":"without id"}
+{"id":"tata"}
+`
+	req, _ := http.NewRequest("POST", suite.testServer.URL+"/documents/import", strings.NewReader(body))
+	req.Header.Set("Content-Type", NDJSONContentType)
+	req.Header.Set(testUserHeader, "alice")
+
+	//check result
+	executeRequest(suite, req, `{"dryRun": false, "lines": 5, "valid": 3, "created": 1, "updated": 1, "skipped": 0, "failed": 3, "errors": [
+		{"line": 4, "error": "Cannot deserialize document [err=unexpected end of JSON input]"},
+		{"line": 5, "error": "id must be defined"},
+		{"line": 6, "id": "tata", "error": "the document is not shared for writing with the actor"}
+	]}`, http.StatusOK)
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_importDocumentsCSVSkip() {
+
+	//add handler mock service, the existing documents are not written
+	suite.documentServiceMock.On("Validate", mock.Anything).Return(nil)
+	operations := []repodocuments.Operation{
+		{Document: models.Document{ID: "titi", Name: "nameOfTiti", Labels: map[string]string{"env": "prod", "team": "payments"}},
+			Precondition: repodocuments.Precondition{MustNotExist: true}},
+		{Document: models.Document{ID: "toto", Description: "desc, with a comma"}, Precondition: repodocuments.Precondition{MustNotExist: true}},
+	}
+	suite.documentServiceMock.On("ApplyBatch", servicedocuments.AnonymousActor, operations, false).Return([]repodocuments.OperationResult{
+		{Err: repodocuments.ErrPreconditionFailed},
+		{Document: operations[1].Document},
+	}, nil)
+
+	//create request, the columns are found by the header
+	body := "labels,id,name,description\n" +
+		"\"env=prod,team=payments\",titi,nameOfTiti,\n" +
+		",toto,,\"desc, with a comma\"\n" +
+		"team,tata,,\n" +
+		"too,many,fields,in,the,record\n"
+	req, _ := http.NewRequest("POST", suite.testServer.URL+"/documents/import?mode=skip", strings.NewReader(body))
+	req.Header.Set("Content-Type", CSVContentType+"; charset=utf-8")
+
+	//check result
+	executeRequest(suite, req, `{"dryRun": false, "lines": 4, "valid": 2, "created": 1, "updated": 0, "skipped": 1, "failed": 2, "errors": [
+		{"line": 4, "error": "labels must be like key=value,key2=value2"},
+		{"line": 5, "error": "the record must have 4 fields like the header"}
+	]}`, http.StatusOK)
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_importDocumentsDryRun() {
+
+	//add handler mock service, nothing is written
+	suite.documentServiceMock.On("Validate", models.Document{ID: "toto"}).Return(nil)
+	suite.documentServiceMock.On("Validate", models.Document{ID: "toto!"}).Return(&servicedocuments.ValidationError{
+		Fields: []models.FieldError{{Field: "id", Message: "id must only contain the characters a-zA-Z0-9_.:-"}},
+	})
+
+	//create request
+	req, _ := http.NewRequest("POST", suite.testServer.URL+"/documents/import?dryRun=true&format=ndjson", strings.NewReader(`{"id":"toto"}
+{"id":"toto!"}`))
+
+	//check result
+	executeRequest(suite, req, `{"dryRun": true, "lines": 2, "valid": 1, "created": 0, "updated": 0, "skipped": 0, "failed": 1, "errors": [
+		{"line": 2, "id": "toto!", "error": "id must only contain the characters a-zA-Z0-9_.:-"}
+	]}`, http.StatusOK)
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_importDocumentsError() {
+
+	//add handler mock service
+	suite.documentServiceMock.On("Validate", mock.Anything).Return(nil)
+	suite.documentServiceMock.On("ApplyBatch", servicedocuments.AnonymousActor, mock.Anything, false).
+		Return([]repodocuments.OperationResult{}, errors.New("error_service_batch"))
+
+	//create request
+	req, _ := http.NewRequest("POST", suite.testServer.URL+"/documents/import", strings.NewReader(`{"id":"toto"}`))
+
+	//check result
+	var expectedError = problemOf(req, http.StatusInternalServerError, "Cannot import documents [err=error_service_batch]")
+	executeRequest(suite, req, expectedError, http.StatusInternalServerError)
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_importDocumentsReadError() {
+
+	//add handler mock service, the document read before the error is written
+	suite.documentServiceMock.On("Validate", mock.Anything).Return(nil)
+	operations := []repodocuments.Operation{{Document: models.Document{ID: "toto"}}}
+	suite.documentServiceMock.On("ApplyBatch", servicedocuments.AnonymousActor, operations, false).Return([]repodocuments.OperationResult{
+		{Document: operations[0].Document},
+	}, nil)
+
+	//create request, the second line is too long to be read
+	body := `{"id":"toto"}` + "\n" + `{"id":"titi","description":"` + strings.Repeat("a", maxLineSize) + `"}` + "\n"
+	req, _ := http.NewRequest("POST", suite.testServer.URL+"/documents/import", strings.NewReader(body))
+
+	//check result
+	executeRequest(suite, req, `{"dryRun": false, "lines": 1, "valid": 1, "created": 1, "updated": 0, "skipped": 0, "failed": 0, "errors": [],
+		"error": "Cannot import documents [err=Cannot read line 2 [err=bufio.Scanner: token too long]]"}`, http.StatusBadRequest)
+}
+
+func (suite *DocumentResourceTestSuite) TestResourceDocument_importDocumentsWrongParameters() {
+	for _, test := range []struct {
+		query          string
+		body           string
+		expectedDetail string
+	}{
+		{"?mode=replace", `{"id":"toto"}`, "Validation failed [err=mode must be upsert or skip]"},
+		{"?dryRun=maybe", `{"id":"toto"}`, "Validation failed [err=dryRun must be a boolean]"},
+		{"?format=xml", `{"id":"toto"}`, "Validation failed [err=format must be ndjson or csv]"},
+		{"?format=csv", "name\ntoto\n", "Validation failed [err=the CSV header must have an id column]"},
+		{"?format=csv", "", "Validation failed [err=the CSV file must start with a header]"},
+	} {
+		//create request
+		req, _ := http.NewRequest("POST", suite.testServer.URL+"/documents/import"+test.query, strings.NewReader(test.body))
+
+		//check result
+		executeRequest(suite, req, problemOf(req, http.StatusBadRequest, test.expectedDetail), http.StatusBadRequest)
+	}
+}
@@ -6,6 +6,9 @@ import (
 	"goapi/config"
 	"goapi/emails"
 	"goapi/kafka"
+	"goapi/resources/auth"
+	"goapi/resources/negotiation"
+	"goapi/resources/problems"
 	"io"
 	"mime/multipart"
 	"net/http"
@@ -13,10 +16,26 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
+// SendScope is the scope of the email routes, checked when the authorization is enabled
+const SendScope = "emails:send"
+
 type ResourceEmails struct {
 	emailKafkaProducer *kafka.EmailKafkaProducer
 }
 
+// NewResourceEmails returns the resource posting the emails to kafka, they are sent by the email consumers
+func NewResourceEmails(configuration *config.Config) *ResourceEmails {
+	return &ResourceEmails{emailKafkaProducer: kafka.NewEmailKafkaProducer(&configuration.KafkaConfig)}
+}
+
+// Send posts an email to kafka, as the POST /emails endpoint does
+func (r *ResourceEmails) Send(emailMessage emails.EmailMessage) error {
+	if err := r.emailKafkaProducer.ProduceEmails(emailMessage); err != nil {
+		return fmt.Errorf("Cannot post message [err=%w]", err)
+	}
+	return nil
+}
+
 type formEmailBody struct {
 	From        string                  `form:"from"`
 	To          []string                `form:"to[]"`
@@ -25,14 +44,21 @@ type formEmailBody struct {
 	Subject     string                  `form:"subject"`
 	TextBody    string                  `form:"textBody"`
 	HtmlBody    string                  `form:"htmlBody"`
-	Attachments []*multipart.FileHeader `form:"attachments[]""`
+	Attachments []*multipart.FileHeader `form:"attachments[]"`
 }
 
 // Endpoint to Post messages to kafka
 // @Summary  Post messages to kafka
 // @Description  Post messages to kafka
 // @Success 200 "OK"
-// @Failure 500 {object} httputil.HTTPError
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 403 {object} models.Problem
+// @Failure 429 {object} models.Problem
+// @Failure 500 {object} models.Problem
+// @Failure 406 {object} models.Problem
+// @Security BearerAuth
+// @Security ApiKeyAuth
 // @Router /emails [post]
 func (r *ResourceEmails) sendEmail(c *gin.Context) {
 
@@ -40,7 +66,7 @@ func (r *ResourceEmails) sendEmail(c *gin.Context) {
 	err := c.ShouldBind(&form)
 
 	if err != nil {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("Cannot deserialize formEmailBody [err=%s]", err)})
+		_ = c.Error(problems.Validation("Cannot deserialize formEmailBody [err=%s]", err))
 		return
 	}
 
@@ -59,28 +85,27 @@ func (r *ResourceEmails) sendEmail(c *gin.Context) {
 
 		src, err := attachment.Open()
 		if err != nil {
-			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("Cannot open attachment [err=%s]", attachment.Filename)})
+			_ = c.Error(problems.Validation("Cannot open attachment [err=%s]", attachment.Filename))
+			return
 		}
 		defer src.Close()
 
 		buf := bytes.NewBuffer(nil)
 		if _, err := io.Copy(buf, src); err != nil {
-			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("Cannot copy attachment content [err=%s]", attachment.Filename)})
+			_ = c.Error(problems.Validation("Cannot copy attachment content [err=%s]", attachment.Filename))
+			return
 		}
 		emailMessage.Attachments[attachment.Filename] = buf.Bytes()
 	}
 
-	err = r.emailKafkaProducer.ProduceEmails(emailMessage)
-	if err != nil {
-		c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": fmt.Sprintf("Cannot post message [err=%s]", err)})
-	} else {
-		c.IndentedJSON(http.StatusOK, nil)
+	if err := r.Send(emailMessage); err != nil {
+		_ = c.Error(err)
+		return
 	}
+	negotiation.Render(c, http.StatusOK, nil)
 }
 
 // RegisterHandlers register all handlers for a router
-func RegisterHandlers(r *gin.Engine, configuration *config.Config) {
-	resource := ResourceEmails{emailKafkaProducer: kafka.NewEmailKafkaProducer(&configuration.KafkaConfig)}
-
-	r.POST("/emails", resource.sendEmail)
+func RegisterHandlers(r *gin.Engine, resource *ResourceEmails) {
+	r.POST("/emails", auth.RequireScopes(SendScope), negotiation.Middleware(), resource.sendEmail)
 }
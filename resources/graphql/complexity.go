@@ -0,0 +1,88 @@
+package graphql
+
+import (
+	"goapi/repositories/repodocuments"
+	"strconv"
+
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+//pagedFields are the fields of the root answering a page of documents, their selection is counted once per document
+var pagedFields = map[string]bool{"documents": true}
+
+// complexity is the cost of a query: each field costs 1 plus the cost of its selection,
+// the selection of a paged field costs as many times as the documents it can answer, its limit.
+type complexity struct {
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]interface{}
+	//visiting are the fragments being counted, a fragment spreading itself is rejected by the validation but is not followed twice here
+	visiting map[string]bool
+}
+
+//complexityOf returns the cost of the operation of the document
+func complexityOf(document *ast.Document, operation *ast.OperationDefinition, variables map[string]interface{}) int {
+	c := complexity{fragments: map[string]*ast.FragmentDefinition{}, variables: variables, visiting: map[string]bool{}}
+	for _, definition := range document.Definitions {
+		if fragment, isFragment := definition.(*ast.FragmentDefinition); isFragment {
+			c.fragments[fragment.Name.Value] = fragment
+		}
+	}
+	return c.selectionSet(operation.SelectionSet, true)
+}
+
+func (c complexity) selectionSet(selectionSet *ast.SelectionSet, root bool) int {
+	if selectionSet == nil {
+		return 0
+	}
+	cost := 0
+	for _, selection := range selectionSet.Selections {
+		switch s := selection.(type) {
+		case *ast.Field:
+			children := c.selectionSet(s.SelectionSet, false)
+			if root && pagedFields[s.Name.Value] {
+				children *= c.limit(s)
+			}
+			cost += 1 + children
+		case *ast.InlineFragment:
+			cost += c.selectionSet(s.SelectionSet, root)
+		case *ast.FragmentSpread:
+			name := s.Name.Value
+			fragment, found := c.fragments[name]
+			if !found || c.visiting[name] {
+				continue
+			}
+			c.visiting[name] = true
+			cost += c.selectionSet(fragment.SelectionSet, root)
+			delete(c.visiting, name)
+		}
+	}
+	return cost
+}
+
+//limit returns the limit argument of a paged field, the default limit of the pages when it is not given
+func (c complexity) limit(field *ast.Field) int {
+	for _, argument := range field.Arguments {
+		if argument.Name.Value != "limit" {
+			continue
+		}
+		switch value := argument.Value.(type) {
+		case *ast.IntValue:
+			if limit, err := strconv.Atoi(value.Value); err == nil && limit > 0 {
+				return limit
+			}
+		case *ast.Variable:
+			//the variables are decoded from JSON, their numbers are float64
+			switch limit := c.variables[value.Name.Value].(type) {
+			case float64:
+				if limit > 0 {
+					return int(limit)
+				}
+			case int:
+				if limit > 0 {
+					return limit
+				}
+			}
+		}
+	}
+	return repodocuments.DefaultPageLimit
+}
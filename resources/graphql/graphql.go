@@ -0,0 +1,244 @@
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"goapi/config"
+	"goapi/resources/documents"
+	"goapi/resources/problems"
+	"goapi/resources/ratelimit"
+	"goapi/services/servicedocuments"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	gql "github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultMaxComplexity is the complexity limit of the queries when the configuration has none
+const DefaultMaxComplexity = 2000
+
+//mimeGraphQL is the media type of a body made of the query only
+const mimeGraphQL = "application/graphql"
+
+// Request is a GraphQL request, the body of POST /graphql or the query parameters of GET /graphql
+type Request struct {
+	Query         string                 `json:"query" form:"query"`
+	OperationName string                 `json:"operationName,omitempty" form:"operationName"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+} // @name GraphQLRequest
+
+// Response is the result of a GraphQL request, the errors of the fields come with the data of the other fields
+type Response struct {
+	Data   interface{}                `json:"data"`
+	Errors []gqlerrors.FormattedError `json:"errors,omitempty" swaggertype:"array,object"`
+} // @name GraphQLResponse
+
+type resourceGraphQL struct {
+	schema        gql.Schema
+	maxComplexity int
+}
+
+//readRequest reads the request from the query parameters of a GET, the JSON body of a POST or its raw query with application/graphql
+func readRequest(c *gin.Context) (Request, error) {
+	var request Request
+	if c.Request.Method == http.MethodGet {
+		request.Query = c.Query("query")
+		request.OperationName = c.Query("operationName")
+		if variables := c.Query("variables"); len(variables) > 0 {
+			if err := json.Unmarshal([]byte(variables), &request.Variables); err != nil {
+				return request, problems.Validation("Cannot deserialize variables [err=%s]", err)
+			}
+		}
+		return request, nil
+	}
+
+	if c.ContentType() == mimeGraphQL {
+		body, err := ioutil.ReadAll(c.Request.Body)
+		if err != nil {
+			return request, problems.Validation("Cannot read the query [err=%s]", err)
+		}
+		request.Query = string(body)
+		return request, nil
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		return request, problems.Validation("Cannot deserialize GraphQL request [err=%s]", err)
+	}
+	return request, nil
+}
+
+//operationOf returns the operation of the document to execute, the one named by the request or the only one
+func operationOf(document *ast.Document, operationName string) (*ast.OperationDefinition, error) {
+	var operation *ast.OperationDefinition
+	for _, definition := range document.Definitions {
+		candidate, isOperation := definition.(*ast.OperationDefinition)
+		if !isOperation {
+			continue
+		}
+		if len(operationName) == 0 {
+			if operation != nil {
+				return nil, fmt.Errorf("operationName is required when the query has several operations")
+			}
+			operation = candidate
+		} else if candidate.Name != nil && candidate.Name.Value == operationName {
+			return candidate, nil
+		}
+	}
+	if operation == nil {
+		if len(operationName) > 0 {
+			return nil, fmt.Errorf("unknown operation %s", operationName)
+		}
+		return nil, fmt.Errorf("the query has no operation")
+	}
+	return operation, nil
+}
+
+//errorsOf answers the errors found before the execution, there is no data then
+func errorsOf(c *gin.Context, errs ...error) {
+	response := Response{}
+	for _, err := range errs {
+		response.Errors = append(response.Errors, gqlerrors.FormatError(err))
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Endpoint to query and mutate the documents and send emails with GraphQL
+// @Summary  Execute a GraphQL query
+// @Description  Execute a GraphQL query or mutation over the documents and the emails, the schema is browsable in the GraphiQL playground at /graphiql.
+// @Description  The errors of the query, its fields included, are answered in the errors of a 200, with the status the REST endpoints would answer in their extensions.
+// @Description  A query more complex than the limit is rejected before its execution: each field costs 1 and the fields of a page of documents cost once per document of the limit.
+// @Accept json,application/graphql
+// @Produce json
+// @Param request body Request true "GraphQL request"
+// @Success 200 {object} Response
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 429 {object} models.Problem
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Router /graphql [post]
+func (r *resourceGraphQL) postQuery(c *gin.Context) {
+	r.execute(c)
+}
+
+// Endpoint to query the documents with GraphQL
+// @Summary  Execute a GraphQL query
+// @Description  Execute a GraphQL query given in the query parameters, the mutations must be posted.
+// @Produce json
+// @Param query query string true "GraphQL query"
+// @Param operationName query string false "Operation to execute when the query has several"
+// @Param variables query string false "Variables of the query as a JSON object"
+// @Success 200 {object} Response
+// @Failure 400 {object} models.Problem
+// @Failure 401 {object} models.Problem
+// @Failure 405 {object} models.Problem
+// @Failure 429 {object} models.Problem
+// @Security BearerAuth
+// @Security ApiKeyAuth
+// @Router /graphql [get]
+func (r *resourceGraphQL) getQuery(c *gin.Context) {
+	r.execute(c)
+}
+
+//execute parses, validates and checks the complexity of the query before executing it
+func (r *resourceGraphQL) execute(c *gin.Context) {
+	request, err := readRequest(c)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if len(request.Query) == 0 {
+		_ = c.Error(problems.Validation("Validation failed [err=query is required]"))
+		return
+	}
+
+	document, err := parser.Parse(parser.ParseParams{Source: source.NewSource(&source.Source{Body: []byte(request.Query), Name: "GraphQL request"})})
+	if err != nil {
+		errorsOf(c, err)
+		return
+	}
+	if validation := gql.ValidateDocument(&r.schema, document, nil); !validation.IsValid {
+		c.JSON(http.StatusOK, Response{Errors: validation.Errors})
+		return
+	}
+	operation, err := operationOf(document, request.OperationName)
+	if err != nil {
+		errorsOf(c, err)
+		return
+	}
+	//a GET must not change anything, it can be sent again by the browsers and the proxies
+	if c.Request.Method == http.MethodGet && operation.Operation != ast.OperationTypeQuery {
+		_ = c.Error(problems.New(http.StatusMethodNotAllowed, "Method not allowed [err=the %s operations must be posted]", operation.Operation))
+		return
+	}
+	if complexity := complexityOf(document, operation, request.Variables); r.maxComplexity > 0 && complexity > r.maxComplexity {
+		log.Warnf("Rejected a GraphQL query too complex [complexity=%d] [max=%d]", complexity, r.maxComplexity)
+		errorsOf(c, fmt.Errorf("Query too complex [err=complexity %d is over the limit of %d]", complexity, r.maxComplexity))
+		return
+	}
+
+	//the resolvers call the services as the REST endpoints, with the actor of the request, and check its scopes
+	ctx := context.WithValue(documents.CallerContext(c), ginContextKey{}, c)
+	result := gql.Execute(gql.ExecuteParams{
+		Schema:        r.schema,
+		AST:           document,
+		OperationName: request.OperationName,
+		Args:          request.Variables,
+		Context:       ctx,
+	})
+	c.JSON(http.StatusOK, Response{Data: result.Data, Errors: result.Errors})
+}
+
+//playground is the GraphiQL page, it sends the queries to /graphql with the headers of the request editor
+const playground = `<!DOCTYPE html>
+<html>
+<head>
+  <title>GraphiQL</title>
+  <link href="https://unpkg.com/graphiql@1.5.16/graphiql.min.css" rel="stylesheet" />
+</head>
+<body style="margin: 0;">
+  <div id="graphiql" style="height: 100vh;"></div>
+  <script crossorigin src="https://unpkg.com/react@17/umd/react.production.min.js"></script>
+  <script crossorigin src="https://unpkg.com/react-dom@17/umd/react-dom.production.min.js"></script>
+  <script crossorigin src="https://unpkg.com/graphiql@1.5.16/graphiql.min.js"></script>
+  <script>
+    const fetcher = GraphiQL.createFetcher({ url: window.location.origin + '/graphql' });
+    ReactDOM.render(React.createElement(GraphiQL, { fetcher: fetcher, headerEditorEnabled: true }), document.getElementById('graphiql'));
+  </script>
+</body>
+</html>
+`
+
+func servePlayground(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(playground))
+}
+
+// RegisterHandlers register the GraphQL endpoint, and its playground when enabled.
+// The mutations doing what a route does take their tokens from the buckets of the route with the limiter.
+func RegisterHandlers(r *gin.Engine, configuration *config.GraphQLConfig, documentService servicedocuments.DocumentService, emailSender EmailSender,
+	limiter *ratelimit.Limiter) {
+	if !configuration.Enabled {
+		return
+	}
+	schema, err := newSchema(documentService, emailSender, limiter)
+	if err != nil {
+		log.Fatalf("Cannot build the GraphQL schema [err=%s]", err)
+	}
+	maxComplexity := configuration.MaxComplexity
+	if maxComplexity == 0 {
+		maxComplexity = DefaultMaxComplexity
+	}
+	resource := &resourceGraphQL{schema: schema, maxComplexity: maxComplexity}
+
+	r.GET("/graphql", resource.getQuery)
+	r.POST("/graphql", resource.postQuery)
+	if configuration.Playground {
+		r.GET("/graphiql", servePlayground)
+	}
+}
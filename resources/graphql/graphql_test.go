@@ -0,0 +1,339 @@
+package graphql
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"goapi/config"
+	"goapi/emails"
+	"goapi/models"
+	"goapi/repositories/repodocuments"
+	"goapi/repositories/reporatelimits"
+	"goapi/resources/auth"
+	"goapi/resources/problems"
+	"goapi/resources/ratelimit"
+	"goapi/services/servicedocuments"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+/*
+	Mock of the calls of the resolvers, the other methods of the service are not called
+*/
+type DocumentServiceMock struct {
+	mock.Mock
+	servicedocuments.DocumentService
+}
+
+func (s *DocumentServiceMock) Get(ctx context.Context, id string) (models.Document, error) {
+	args := s.Called(id)
+	return args.Get(0).(models.Document), args.Error(1)
+}
+
+func (s *DocumentServiceMock) List(ctx context.Context, query repodocuments.DocumentQuery) (models.DocumentPage, error) {
+	args := s.Called(query)
+	return args.Get(0).(models.DocumentPage), args.Error(1)
+}
+
+//the writes are called with the actor of their context
+func (s *DocumentServiceMock) CreateOrUpdate(ctx context.Context, document models.Document, precondition repodocuments.Precondition) (models.Document, bool, error) {
+	args := s.Called(servicedocuments.ActorFrom(ctx), document, precondition)
+	return args.Get(0).(models.Document), args.Get(1).(bool), args.Error(2)
+}
+
+func (s *DocumentServiceMock) Delete(ctx context.Context, id string, precondition repodocuments.Precondition) (bool, error) {
+	args := s.Called(id, precondition)
+	return args.Get(0).(bool), args.Error(1)
+}
+
+type EmailSenderMock struct {
+	mock.Mock
+}
+
+func (s *EmailSenderMock) Send(emailMessage emails.EmailMessage) error {
+	args := s.Called(emailMessage)
+	return args.Error(0)
+}
+
+var document = models.Document{
+	ID: "toto", Name: "nameOfToto", Description: "descOfToto", Labels: models.Labels{"team": "payments", "env": "prod"}, Version: 2,
+	CreatedAt: time.Date(2021, 10, 1, 8, 0, 0, 0, time.UTC), CreatedBy: "alice", UpdatedAt: time.Date(2021, 10, 2, 8, 0, 0, 0, time.UTC), UpdatedBy: "bob",
+}
+
+//the API keys of the principals with the scopes of their role
+var apiKeys = map[string]string{"reader": "r34d3r-k3y", "editor": "3d1t0r-k3y"}
+
+func configureRouter(t *testing.T, documentService servicedocuments.DocumentService, emailSender EmailSender, maxComplexity int) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	authConfig := config.AuthConfig{
+		Enabled:     true,
+		PublicPaths: []string{"/graphiql"},
+		Authorization: config.AuthorizationConfig{
+			Enabled: true,
+			Roles:   map[string][]string{"reader": {"documents:read"}, "editor": {"documents:read", "documents:write", "emails:send"}},
+		},
+	}
+	for role, key := range apiKeys {
+		hash := sha256.Sum256([]byte(key))
+		authConfig.APIKeys = append(authConfig.APIKeys, config.APIKeyConfig{Name: role + "-client", Hash: hex.EncodeToString(hash[:]), Roles: []string{role}})
+	}
+	authentication, err := auth.Middleware(&authConfig)
+	assert.Nil(t, err)
+
+	router := gin.New()
+	router.Use(problems.Handler(), authentication)
+	//the emails are limited as in config.yml
+	limiter, err := ratelimit.NewLimiter(&config.RateLimitConfig{
+		Enabled: true,
+		Routes:  []config.RouteRateLimitConfig{{Method: "POST", Path: "/emails", RequestsPerMinute: 10, Burst: 5}},
+	}, &reporatelimits.InMemoryRateLimitRepo{})
+	assert.Nil(t, err)
+	RegisterHandlers(router, &config.GraphQLConfig{Enabled: true, MaxComplexity: maxComplexity, Playground: true}, documentService, emailSender, limiter)
+	return router
+}
+
+//post posts the query as the principal of the role
+func post(router *gin.Engine, role string, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/graphql", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(auth.DefaultAPIKeyHeader, apiKeys[role])
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func query(t *testing.T, query string, variables map[string]interface{}) string {
+	body, err := json.Marshal(Request{Query: query, Variables: variables})
+	assert.Nil(t, err)
+	return string(body)
+}
+
+func TestQueryDocument(t *testing.T) {
+	documentService := new(DocumentServiceMock)
+	router := configureRouter(t, documentService, new(EmailSenderMock), 0)
+	documentService.On("Get", "toto").Return(document, nil)
+	documentService.On("Get", "titi").Return(models.Document{}, repodocuments.ErrNotFound)
+
+	//only the asked fields are answered, a missing document is null
+	recorder := post(router, "reader", query(t, `{
+		toto: document(id: "toto") { id name labels { key value } version updatedAt }
+		titi: document(id: "titi") { id }
+	}`, nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, `{"data": {
+		"toto": {"id": "toto", "name": "nameOfToto", "labels": [{"key": "env", "value": "prod"}, {"key": "team", "value": "payments"}],
+			"version": 2, "updatedAt": "2021-10-02T08:00:00Z"},
+		"titi": null
+	}}`, recorder.Body.String())
+}
+
+func TestQueryDocuments(t *testing.T) {
+	documentService := new(DocumentServiceMock)
+	router := configureRouter(t, documentService, new(EmailSenderMock), 0)
+	selector, err := repodocuments.ParseLabelSelector("team=payments")
+	assert.Nil(t, err)
+	expectedQuery := repodocuments.DocumentQuery{
+		Limit: 10, Cursor: "cursor", Sort: repodocuments.DocumentSort{Field: "name", Descending: true},
+		Filter: repodocuments.DocumentFilter{Selector: selector, CreatedBy: "alice", UpdatedAfter: time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	documentService.On("List", expectedQuery).Return(models.DocumentPage{Documents: []models.Document{document}, NextCursor: "next"}, nil)
+
+	recorder := post(router, "reader", query(t, `query Page($limit: Int) {
+		documents(limit: $limit, cursor: "cursor", sort: "-name", selector: "team=payments", createdBy: "alice", updatedAfter: "2021-10-01T02:00:00+02:00") {
+			documents { id createdBy }
+			nextCursor
+		}
+	}`, map[string]interface{}{"limit": 10}))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, `{"data": {"documents": {"documents": [{"id": "toto", "createdBy": "alice"}], "nextCursor": "next"}}}`, recorder.Body.String())
+
+	//the arguments are checked as the query parameters of GET /documents
+	recorder = post(router, "reader", query(t, `{ documents(sort: "size") { nextCursor } }`, nil))
+	assert.JSONEq(t, `{"data": null, "errors": [{"message": "Validation failed [err=sort must be one of id, name, createdAt, createdBy, updatedAt, updatedBy, prefixed by - for a descending order]",
+		"locations": [{"line": 1, "column": 3}], "path": ["documents"], "extensions": {"status": 400}}]}`, recorder.Body.String())
+}
+
+func TestMutations(t *testing.T) {
+	documentService := new(DocumentServiceMock)
+	emailSender := new(EmailSenderMock)
+	router := configureRouter(t, documentService, emailSender, 0)
+	toUpsert := models.Document{ID: "toto", Name: "nameOfToto", Labels: models.Labels{"team": "payments"}}
+	documentService.On("CreateOrUpdate", "editor-client", toUpsert, repodocuments.Precondition{IfMatch: 1}).Return(document, true, nil)
+	documentService.On("Delete", "titi", repodocuments.Precondition{}).Return(false, nil)
+	emailSender.On("Send", emails.EmailMessage{From: "a@b.c", To: []string{"d@e.f"}, Subject: "hello", Attachments: map[string][]byte{}}).Return(nil)
+
+	recorder := post(router, "editor", query(t, `mutation {
+		upsertDocument(id: "toto", ifMatch: 1, document: {name: "nameOfToto", labels: [{key: "team", value: "payments"}]}) { id version }
+		deleteDocument(id: "titi")
+		sendEmail(email: {from: "a@b.c", to: ["d@e.f"], subject: "hello"})
+	}`, nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, `{"data": {"upsertDocument": {"id": "toto", "version": 2}, "deleteDocument": false, "sendEmail": true}}`, recorder.Body.String())
+	documentService.AssertExpectations(t)
+	emailSender.AssertExpectations(t)
+}
+
+func TestMutationErrors(t *testing.T) {
+	documentService := new(DocumentServiceMock)
+	emailSender := new(EmailSenderMock)
+	router := configureRouter(t, documentService, emailSender, 0)
+	documentService.On("Delete", "toto", repodocuments.Precondition{IfMatch: 1}).Return(false, repodocuments.ErrPreconditionFailed)
+	emailSender.On("Send", mock.Anything).Return(errors.New("Cannot post message [err=kafka is down]"))
+
+	recorder := post(router, "editor", query(t, `mutation { deleteDocument(id: "toto", ifMatch: 1) }`, nil))
+	var response struct {
+		Data   interface{}
+		Errors []struct {
+			Message    string
+			Extensions map[string]interface{}
+		}
+	}
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	assert.Nil(t, response.Data)
+	assert.Equal(t, "document id toto has been modified [err=precondition failed]", response.Errors[0].Message)
+	assert.Equal(t, map[string]interface{}{"status": float64(http.StatusPreconditionFailed)}, response.Errors[0].Extensions)
+
+	recorder = post(router, "editor", query(t, `mutation { sendEmail(email: {from: "a@b.c", to: []}) }`, nil))
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	assert.Equal(t, "Cannot post message [err=kafka is down]", response.Errors[0].Message)
+	assert.Equal(t, map[string]interface{}{"status": float64(http.StatusInternalServerError)}, response.Errors[0].Extensions)
+}
+
+func TestSendEmailRateLimit(t *testing.T) {
+	emailSender := new(EmailSenderMock)
+	router := configureRouter(t, new(DocumentServiceMock), emailSender, 0)
+	emailSender.On("Send", mock.Anything).Return(nil)
+
+	//the aliases of a query take a token each, the 6th email of the burst is refused
+	recorder := post(router, "editor", query(t, `mutation {
+		e1: sendEmail(email: {from: "a@b.c", to: ["d@e.f"]})
+		e2: sendEmail(email: {from: "a@b.c", to: ["d@e.f"]})
+		e3: sendEmail(email: {from: "a@b.c", to: ["d@e.f"]})
+		e4: sendEmail(email: {from: "a@b.c", to: ["d@e.f"]})
+		e5: sendEmail(email: {from: "a@b.c", to: ["d@e.f"]})
+		e6: sendEmail(email: {from: "a@b.c", to: ["d@e.f"]})
+	}`, nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, `{"data": null, "errors": [
+		{"message": "Rate limit of 10 requests per minute exceeded, retry after 6 seconds", "locations": [{"line": 7, "column": 3}], "path": ["e6"],
+			"extensions": {"status": 429}}
+	]}`, recorder.Body.String())
+	emailSender.AssertNumberOfCalls(t, "Send", 5)
+
+	//the next query has no token left either
+	recorder = post(router, "editor", query(t, `mutation { sendEmail(email: {from: "a@b.c", to: ["d@e.f"]}) }`, nil))
+	assert.Contains(t, recorder.Body.String(), `"status":429`)
+	emailSender.AssertNumberOfCalls(t, "Send", 5)
+}
+
+func TestAuthorization(t *testing.T) {
+	documentService := new(DocumentServiceMock)
+	router := configureRouter(t, documentService, new(EmailSenderMock), 0)
+	documentService.On("Get", "toto").Return(document, nil)
+
+	//the fields are answered or denied one by one
+	recorder := post(router, "reader", query(t, `mutation { deleteDocument(id: "toto") sendEmail(email: {from: "a@b.c", to: []}) }`, nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, `{"data": null, "errors": [
+		{"message": "Authorization failed [err=missing scopes documents:write]", "locations": [{"line": 1, "column": 12}], "path": ["deleteDocument"],
+			"extensions": {"status": 403, "missingScopes": ["documents:write"]}}
+	]}`, recorder.Body.String())
+	documentService.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything)
+
+	//the authentication is required before anything
+	req := httptest.NewRequest("POST", "/graphql", strings.NewReader(query(t, `{ document(id: "toto") { id } }`, nil)))
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+}
+
+func TestComplexity(t *testing.T) {
+	for _, test := range []struct {
+		query              string
+		variables          map[string]interface{}
+		expectedComplexity int
+	}{
+		{`{ document(id: "toto") { id name acl { owner } } }`, nil, 5},
+		{`{ documents { documents { id } nextCursor } }`, nil, 1 + 100*3},
+		{`{ documents(limit: 5) { documents { id name } } }`, nil, 1 + 5*3},
+		{`query($limit: Int) { documents(limit: $limit) { ...page } } fragment page on DocumentPage { documents { id } }`,
+			map[string]interface{}{"limit": float64(20)}, 1 + 20*2},
+		{`mutation { upsertDocument(id: "toto", document: {}) { ... on Document { id labels { key } } } }`, nil, 4},
+	} {
+		document, err := parser.Parse(parser.ParseParams{Source: test.query})
+		assert.Nil(t, err)
+		operation, err := operationOf(document, "")
+		assert.Nil(t, err)
+		assert.Equal(t, test.expectedComplexity, complexityOf(document, operation, test.variables), test.query)
+	}
+}
+
+func TestQueryTooComplex(t *testing.T) {
+	documentService := new(DocumentServiceMock)
+	router := configureRouter(t, documentService, new(EmailSenderMock), 250)
+
+	recorder := post(router, "reader", query(t, `{ documents { documents { id name description } } }`, nil))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, `{"data": null, "errors": [{"message": "Query too complex [err=complexity 401 is over the limit of 250]", "locations": []}]}`,
+		recorder.Body.String())
+	documentService.AssertNotCalled(t, "List", mock.Anything)
+
+	//a smaller page is executed
+	documentService.On("List", repodocuments.DocumentQuery{Limit: 50, Sort: repodocuments.DocumentSort{Field: "id"}}).Return(models.DocumentPage{Documents: []models.Document{}}, nil)
+	recorder = post(router, "reader", query(t, `{ documents(limit: 50) { documents { id name description } } }`, nil))
+	assert.JSONEq(t, `{"data": {"documents": {"documents": []}}}`, recorder.Body.String())
+}
+
+func TestRequests(t *testing.T) {
+	documentService := new(DocumentServiceMock)
+	router := configureRouter(t, documentService, new(EmailSenderMock), 0)
+	documentService.On("Get", "toto").Return(document, nil)
+	execute := func(method string, target string, contentType string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set(auth.DefaultAPIKeyHeader, apiKeys["reader"])
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	//a query can be sent in the query parameters and as a raw body
+	recorder := execute("GET", "/graphql?"+url.Values{"query": {`query Get($id: ID!) { document(id: $id) { name } }`}, "variables": {`{"id": "toto"}`}}.Encode(), "", "")
+	assert.JSONEq(t, `{"data": {"document": {"name": "nameOfToto"}}}`, recorder.Body.String())
+	recorder = execute("POST", "/graphql", "application/graphql", `{ document(id: "toto") { name } }`)
+	assert.JSONEq(t, `{"data": {"document": {"name": "nameOfToto"}}}`, recorder.Body.String())
+
+	//the operation to execute is told by its name
+	recorder = execute("POST", "/graphql", "application/json", `{"query": "query A { document(id: \"toto\") { id } } query B { document(id: \"toto\") { name } }", "operationName": "B"}`)
+	assert.JSONEq(t, `{"data": {"document": {"name": "nameOfToto"}}}`, recorder.Body.String())
+
+	//a mutation must be posted
+	recorder = execute("GET", "/graphql?"+url.Values{"query": {`mutation { deleteDocument(id: "toto") }`}}.Encode(), "", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+	var problem models.Problem
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &problem))
+	assert.Equal(t, "Method not allowed [err=the mutation operations must be posted]", problem.Detail)
+
+	//an invalid request is a 400, an invalid query is answered in the errors
+	recorder = execute("POST", "/graphql", "application/json", `{"query": 1}`)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	recorder = execute("POST", "/graphql", "application/json", `{"query": "{ document(id: \"toto\") { size } }"}`)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), `Cannot query field \"size\" on type \"Document\".`)
+
+	//the playground is a page
+	recorder = execute("GET", "/graphiql", "", "")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "GraphiQL.createFetcher")
+}
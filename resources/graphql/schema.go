@@ -0,0 +1,386 @@
+package graphql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"goapi/emails"
+	"goapi/models"
+	"goapi/repositories/repodocuments"
+	"goapi/resources/auth"
+	"goapi/resources/documents"
+	emailsresource "goapi/resources/emails"
+	"goapi/resources/problems"
+	"goapi/resources/ratelimit"
+	"goapi/services/servicedocuments"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	gql "github.com/graphql-go/graphql"
+)
+
+// EmailSender posts the emails to send, like the POST /emails endpoint
+type EmailSender interface {
+	Send(emailMessage emails.EmailMessage) error
+}
+
+//ginContextKey is the key of the gin context of the request in the context of the resolvers
+type ginContextKey struct{}
+
+// resolverError is an error of a resolver, its extensions tell the status the REST endpoints would answer
+type resolverError struct {
+	err error
+}
+
+func (e *resolverError) Error() string {
+	return e.err.Error()
+}
+
+func (e *resolverError) Unwrap() error {
+	return e.err
+}
+
+func (e *resolverError) Extensions() map[string]interface{} {
+	extensions := map[string]interface{}{"status": problems.StatusOf(e.err)}
+	var missingScopes *problems.MissingScopesError
+	if errors.As(e.err, &missingScopes) {
+		extensions["missingScopes"] = missingScopes.Scopes
+	}
+	return extensions
+}
+
+//authorize returns an error when the principal of the request lacks some of the scopes
+func authorize(ctx context.Context, scopes ...string) error {
+	c, _ := ctx.Value(ginContextKey{}).(*gin.Context)
+	if c == nil {
+		return nil
+	}
+	if err := auth.CheckScopes(c, scopes...); err != nil {
+		return &resolverError{err}
+	}
+	return nil
+}
+
+//takeToken takes a token from the bucket of the client of the request on the route, an error when there is none
+func takeToken(ctx context.Context, limiter *ratelimit.Limiter, method string, path string) error {
+	c, _ := ctx.Value(ginContextKey{}).(*gin.Context)
+	if c == nil {
+		return nil
+	}
+	if err := limiter.Take(ctx, method, path, limiter.ClientOf(c)); err != nil {
+		return &resolverError{err}
+	}
+	return nil
+}
+
+//label is a label of a document, the labels are listed by key
+type label struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+var labelType = gql.NewObject(gql.ObjectConfig{
+	Name: "Label",
+	Fields: gql.Fields{
+		"key":   &gql.Field{Type: gql.NewNonNull(gql.String)},
+		"value": &gql.Field{Type: gql.NewNonNull(gql.String)},
+	},
+})
+
+var labelInputType = gql.NewInputObject(gql.InputObjectConfig{
+	Name: "LabelInput",
+	Fields: gql.InputObjectConfigFieldMap{
+		"key":   &gql.InputObjectFieldConfig{Type: gql.NewNonNull(gql.String)},
+		"value": &gql.InputObjectFieldConfig{Type: gql.NewNonNull(gql.String)},
+	},
+})
+
+var aclType = gql.NewObject(gql.ObjectConfig{
+	Name:        "DocumentACL",
+	Description: "Who can read and write a document, the readers and the writers are principals like user:alice or group:finance",
+	Fields: gql.Fields{
+		"owner":   &gql.Field{Type: gql.NewNonNull(gql.String)},
+		"readers": &gql.Field{Type: gql.NewNonNull(gql.NewList(gql.NewNonNull(gql.String)))},
+		"writers": &gql.Field{Type: gql.NewNonNull(gql.NewList(gql.NewNonNull(gql.String)))},
+	},
+})
+
+var documentType = gql.NewObject(gql.ObjectConfig{
+	Name: "Document",
+	Fields: gql.Fields{
+		"id":          &gql.Field{Type: gql.NewNonNull(gql.ID)},
+		"name":        &gql.Field{Type: gql.String},
+		"description": &gql.Field{Type: gql.String},
+		"labels": &gql.Field{
+			Type: gql.NewNonNull(gql.NewList(gql.NewNonNull(labelType))),
+			Resolve: func(p gql.ResolveParams) (interface{}, error) {
+				document := p.Source.(models.Document)
+				labels := make([]label, 0, len(document.Labels))
+				for key, value := range document.Labels {
+					labels = append(labels, label{Key: key, Value: value})
+				}
+				sort.Slice(labels, func(i, j int) bool { return labels[i].Key < labels[j].Key })
+				return labels, nil
+			},
+		},
+		"version":   &gql.Field{Type: gql.NewNonNull(gql.Int)},
+		"deletedAt": &gql.Field{Type: gql.DateTime},
+		"createdAt": &gql.Field{Type: gql.NewNonNull(gql.DateTime)},
+		"createdBy": &gql.Field{Type: gql.NewNonNull(gql.String)},
+		"updatedAt": &gql.Field{Type: gql.NewNonNull(gql.DateTime)},
+		"updatedBy": &gql.Field{Type: gql.NewNonNull(gql.String)},
+		"acl":       &gql.Field{Type: aclType},
+	},
+})
+
+var documentPageType = gql.NewObject(gql.ObjectConfig{
+	Name: "DocumentPage",
+	Fields: gql.Fields{
+		"documents":  &gql.Field{Type: gql.NewNonNull(gql.NewList(gql.NewNonNull(documentType)))},
+		"nextCursor": &gql.Field{Type: gql.String, Description: "The cursor of the next page, null on the last page"},
+	},
+})
+
+var documentInputType = gql.NewInputObject(gql.InputObjectConfig{
+	Name: "DocumentInput",
+	Fields: gql.InputObjectConfigFieldMap{
+		"name":        &gql.InputObjectFieldConfig{Type: gql.String},
+		"description": &gql.InputObjectFieldConfig{Type: gql.String},
+		"labels":      &gql.InputObjectFieldConfig{Type: gql.NewList(gql.NewNonNull(labelInputType))},
+	},
+})
+
+var emailInputType = gql.NewInputObject(gql.InputObjectConfig{
+	Name: "EmailInput",
+	Fields: gql.InputObjectConfigFieldMap{
+		"from":     &gql.InputObjectFieldConfig{Type: gql.NewNonNull(gql.String)},
+		"to":       &gql.InputObjectFieldConfig{Type: gql.NewNonNull(gql.NewList(gql.NewNonNull(gql.String)))},
+		"cc":       &gql.InputObjectFieldConfig{Type: gql.NewList(gql.NewNonNull(gql.String))},
+		"bcc":      &gql.InputObjectFieldConfig{Type: gql.NewList(gql.NewNonNull(gql.String))},
+		"subject":  &gql.InputObjectFieldConfig{Type: gql.String},
+		"textBody": &gql.InputObjectFieldConfig{Type: gql.String},
+		"htmlBody": &gql.InputObjectFieldConfig{Type: gql.String},
+	},
+})
+
+//stringArg returns a string argument, empty when absent
+func stringArg(args map[string]interface{}, name string) string {
+	value, _ := args[name].(string)
+	return value
+}
+
+//stringsArg returns a list of strings argument, nil when absent
+func stringsArg(args map[string]interface{}, name string) []string {
+	values, _ := args[name].([]interface{})
+	var strs []string
+	for _, value := range values {
+		strs = append(strs, value.(string))
+	}
+	return strs
+}
+
+//preconditionArg returns the precondition given by the ifMatch argument, the version the document must have
+func preconditionArg(args map[string]interface{}) repodocuments.Precondition {
+	version, _ := args["ifMatch"].(int)
+	return repodocuments.Precondition{IfMatch: int64(version)}
+}
+
+//queryArgs returns the query of the documents argument, as the query parameters of GET /documents
+func queryArgs(args map[string]interface{}) (repodocuments.DocumentQuery, error) {
+	query := repodocuments.DocumentQuery{Cursor: stringArg(args, "cursor")}
+
+	if limit, found := args["limit"].(int); found {
+		if limit < 1 || limit > repodocuments.MaxPageLimit {
+			return query, fmt.Errorf("limit must be between 1 and %d", repodocuments.MaxPageLimit)
+		}
+		query.Limit = limit
+	}
+
+	sort, err := repodocuments.ParseDocumentSort(stringArg(args, "sort"))
+	if err != nil {
+		return query, fmt.Errorf("sort must be one of %s, prefixed by - for a descending order", strings.Join(repodocuments.SortFields(), ", "))
+	}
+	query.Sort = sort
+
+	query.Filter.Selector, err = repodocuments.ParseLabelSelector(stringArg(args, "selector"))
+	if err != nil {
+		return query, err
+	}
+	query.Filter.CreatedBy = stringArg(args, "createdBy")
+	query.Filter.UpdatedBy = stringArg(args, "updatedBy")
+	for name, bound := range map[string]*time.Time{
+		"createdAfter":  &query.Filter.CreatedAfter,
+		"createdBefore": &query.Filter.CreatedBefore,
+		"updatedAfter":  &query.Filter.UpdatedAfter,
+		"updatedBefore": &query.Filter.UpdatedBefore,
+	} {
+		if value, found := args[name].(time.Time); found {
+			*bound = value.UTC()
+		}
+	}
+	return query, nil
+}
+
+//documentArg returns the document of the document argument with its id
+func documentArg(id string, input map[string]interface{}) models.Document {
+	document := models.Document{ID: id, Name: stringArg(input, "name"), Description: stringArg(input, "description")}
+	if labels, found := input["labels"].([]interface{}); found {
+		document.Labels = make(models.Labels, len(labels))
+		for _, value := range labels {
+			entry := value.(map[string]interface{})
+			document.Labels[entry["key"].(string)] = entry["value"].(string)
+		}
+	}
+	return document
+}
+
+//newSchema returns the schema of the documents and the emails
+func newSchema(documentService servicedocuments.DocumentService, emailSender EmailSender, limiter *ratelimit.Limiter) (gql.Schema, error) {
+	query := gql.NewObject(gql.ObjectConfig{
+		Name: "Query",
+		Fields: gql.Fields{
+			"document": &gql.Field{
+				Type:        documentType,
+				Description: "The document of the id, null when it does not exist",
+				Args: gql.FieldConfigArgument{
+					"id": &gql.ArgumentConfig{Type: gql.NewNonNull(gql.ID)},
+				},
+				Resolve: func(p gql.ResolveParams) (interface{}, error) {
+					if err := authorize(p.Context, documents.ReadScope); err != nil {
+						return nil, err
+					}
+					id := stringArg(p.Args, "id")
+					document, err := documentService.Get(p.Context, id)
+					if errors.Is(err, repodocuments.ErrNotFound) {
+						return nil, nil
+					}
+					if err != nil {
+						return nil, &resolverError{fmt.Errorf("Cannot get document id %s [err=%w]", id, err)}
+					}
+					return document, nil
+				},
+			},
+			"documents": &gql.Field{
+				Type:        gql.NewNonNull(documentPageType),
+				Description: "The documents page by page, pass the nextCursor of a page as cursor to get the next one",
+				Args: gql.FieldConfigArgument{
+					"limit":         &gql.ArgumentConfig{Type: gql.Int, Description: "Maximum number of documents in the page (default 100, max 1000)"},
+					"cursor":        &gql.ArgumentConfig{Type: gql.String},
+					"sort":          &gql.ArgumentConfig{Type: gql.String, Description: "Sort order like name or -updatedAt"},
+					"selector":      &gql.ArgumentConfig{Type: gql.String, Description: "Label selector like team=payments,env!=prod"},
+					"createdBy":     &gql.ArgumentConfig{Type: gql.String},
+					"updatedBy":     &gql.ArgumentConfig{Type: gql.String},
+					"createdAfter":  &gql.ArgumentConfig{Type: gql.DateTime},
+					"createdBefore": &gql.ArgumentConfig{Type: gql.DateTime},
+					"updatedAfter":  &gql.ArgumentConfig{Type: gql.DateTime},
+					"updatedBefore": &gql.ArgumentConfig{Type: gql.DateTime},
+				},
+				Resolve: func(p gql.ResolveParams) (interface{}, error) {
+					if err := authorize(p.Context, documents.ReadScope); err != nil {
+						return nil, err
+					}
+					query, err := queryArgs(p.Args)
+					if err != nil {
+						return nil, &resolverError{problems.Validation("Validation failed [err=%s]", err)}
+					}
+					page, err := documentService.List(p.Context, query)
+					if errors.Is(err, repodocuments.ErrInvalidCursor) {
+						return nil, &resolverError{problems.Validation("Validation failed [err=%s]", err)}
+					}
+					if err != nil {
+						return nil, &resolverError{fmt.Errorf("Cannot get documents [err=%w]", err)}
+					}
+					return page, nil
+				},
+			},
+		},
+	})
+
+	mutation := gql.NewObject(gql.ObjectConfig{
+		Name: "Mutation",
+		Fields: gql.Fields{
+			"upsertDocument": &gql.Field{
+				Type:        gql.NewNonNull(documentType),
+				Description: "Create or replace the document of the id, only if it has the version ifMatch when given",
+				Args: gql.FieldConfigArgument{
+					"id":       &gql.ArgumentConfig{Type: gql.NewNonNull(gql.ID)},
+					"document": &gql.ArgumentConfig{Type: gql.NewNonNull(documentInputType)},
+					"ifMatch":  &gql.ArgumentConfig{Type: gql.Int},
+				},
+				Resolve: func(p gql.ResolveParams) (interface{}, error) {
+					if err := authorize(p.Context, documents.WriteScope); err != nil {
+						return nil, err
+					}
+					id := stringArg(p.Args, "id")
+					document := documentArg(id, p.Args["document"].(map[string]interface{}))
+					upserted, _, err := documentService.CreateOrUpdate(p.Context, document, preconditionArg(p.Args))
+					if errors.Is(err, repodocuments.ErrPreconditionFailed) {
+						return nil, &resolverError{fmt.Errorf("document id %s has been modified [err=%w]", id, err)}
+					}
+					if err != nil {
+						return nil, &resolverError{fmt.Errorf("Cannot create or update document [err=%w]", err)}
+					}
+					return upserted, nil
+				},
+			},
+			"deleteDocument": &gql.Field{
+				Type:        gql.NewNonNull(gql.Boolean),
+				Description: "Move the document of the id to the trash, only if it has the version ifMatch when given. False when it does not exist.",
+				Args: gql.FieldConfigArgument{
+					"id":      &gql.ArgumentConfig{Type: gql.NewNonNull(gql.ID)},
+					"ifMatch": &gql.ArgumentConfig{Type: gql.Int},
+				},
+				Resolve: func(p gql.ResolveParams) (interface{}, error) {
+					if err := authorize(p.Context, documents.WriteScope); err != nil {
+						return nil, err
+					}
+					id := stringArg(p.Args, "id")
+					found, err := documentService.Delete(p.Context, id, preconditionArg(p.Args))
+					if errors.Is(err, repodocuments.ErrPreconditionFailed) {
+						return nil, &resolverError{fmt.Errorf("document id %s has been modified [err=%w]", id, err)}
+					}
+					if err != nil {
+						return nil, &resolverError{fmt.Errorf("Cannot delete documents [err=%w]", err)}
+					}
+					return found, nil
+				},
+			},
+			"sendEmail": &gql.Field{
+				Type:        gql.NewNonNull(gql.Boolean),
+				Description: "Post an email to send, as POST /emails without the attachments",
+				Args: gql.FieldConfigArgument{
+					"email": &gql.ArgumentConfig{Type: gql.NewNonNull(emailInputType)},
+				},
+				Resolve: func(p gql.ResolveParams) (interface{}, error) {
+					if err := authorize(p.Context, emailsresource.SendScope); err != nil {
+						return nil, err
+					}
+					//each email takes a token of POST /emails, so that the aliases of a query cannot send more emails than the route
+					if err := takeToken(p.Context, limiter, http.MethodPost, "/emails"); err != nil {
+						return nil, err
+					}
+					input := p.Args["email"].(map[string]interface{})
+					emailMessage := emails.EmailMessage{
+						From:        stringArg(input, "from"),
+						To:          stringsArg(input, "to"),
+						CC:          stringsArg(input, "cc"),
+						BCC:         stringsArg(input, "bcc"),
+						Subject:     stringArg(input, "subject"),
+						TextContent: stringArg(input, "textBody"),
+						HtmlContent: stringArg(input, "htmlBody"),
+						Attachments: make(map[string][]byte),
+					}
+					if err := emailSender.Send(emailMessage); err != nil {
+						return nil, &resolverError{err}
+					}
+					return true, nil
+				},
+			},
+		},
+	})
+
+	return gql.NewSchema(gql.SchemaConfig{Query: query, Mutation: mutation})
+}
@@ -0,0 +1,335 @@
+package negotiation
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"goapi/resources/problems"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/ugorji/go/codec"
+	"gopkg.in/yaml.v2"
+)
+
+// The media types of the formats, the first one of each format is answered to the clients accepting any media type
+const (
+	MIMEJSON     = "application/json"
+	MIMEXML      = "application/xml"
+	MIMEXML2     = "text/xml"
+	MIMEYAML     = "application/yaml"
+	MIMEYAML2    = "application/x-yaml"
+	MIMEYAML3    = "text/yaml"
+	MIMEMsgPack  = "application/msgpack"
+	MIMEMsgPack2 = "application/x-msgpack"
+	MIMEMsgPack3 = "application/vnd.msgpack"
+)
+
+//formatKey is the key of the format negotiated by the middleware in the context of gin
+const formatKey = "format"
+
+// format is a representation of the resources
+type format struct {
+	mediaTypes []string
+	//binary formats have no charset
+	binary bool
+	encode func(w io.Writer, obj interface{}, pretty bool) error
+	decode func(r io.Reader, obj interface{}) error
+}
+
+//the supported formats, JSON first as it is answered when nothing is asked
+var formats = []*format{
+	{mediaTypes: []string{MIMEJSON}, encode: encodeJSON, decode: decodeJSON},
+	{mediaTypes: []string{MIMEXML, MIMEXML2}, encode: encodeXML, decode: decodeXML},
+	{mediaTypes: []string{MIMEYAML, MIMEYAML2, MIMEYAML3}, encode: encodeYAML, decode: decodeYAML},
+	{mediaTypes: []string{MIMEMsgPack, MIMEMsgPack2, MIMEMsgPack3}, binary: true, encode: encodeMsgPack, decode: decodeMsgPack},
+}
+
+//supported lists the main media type of each format
+func supported() string {
+	mediaTypes := make([]string, 0, len(formats))
+	for _, f := range formats {
+		mediaTypes = append(mediaTypes, f.mediaTypes[0])
+	}
+	return strings.Join(mediaTypes, ", ")
+}
+
+// negotiated is the format answering a request, with the media type asked by the client
+type negotiated struct {
+	format    *format
+	mediaType string
+}
+
+func (n negotiated) contentType() string {
+	if n.format.binary {
+		return n.mediaType
+	}
+	return n.mediaType + "; charset=utf-8"
+}
+
+//accepted returns the media ranges of an Accept header, the preferred first, without the ones refused with q=0
+func accepted(header string) []string {
+	type mediaRange struct {
+		mediaType string
+		quality   float64
+	}
+	var ranges []mediaRange
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		accepted := mediaRange{mediaType: strings.ToLower(strings.TrimSpace(params[0])), quality: 1}
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				quality, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64)
+				if err == nil {
+					accepted.quality = quality
+				}
+			}
+		}
+		if len(accepted.mediaType) > 0 && accepted.quality > 0 {
+			ranges = append(ranges, accepted)
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].quality > ranges[j].quality })
+
+	mediaTypes := make([]string, 0, len(ranges))
+	for _, r := range ranges {
+		mediaTypes = append(mediaTypes, r.mediaType)
+	}
+	return mediaTypes
+}
+
+//negotiate returns the format of the response preferred by the client, JSON when there is no Accept header
+func negotiate(header string) (negotiated, bool) {
+	if len(strings.TrimSpace(header)) == 0 {
+		return negotiated{formats[0], formats[0].mediaTypes[0]}, true
+	}
+	for _, mediaRange := range accepted(header) {
+		for _, f := range formats {
+			for _, mediaType := range f.mediaTypes {
+				if mediaRange == mediaType || mediaRange == "*/*" ||
+					(strings.HasSuffix(mediaRange, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(mediaRange, "*"))) {
+					return negotiated{f, mediaType}, true
+				}
+			}
+		}
+	}
+	return negotiated{}, false
+}
+
+//formatOf returns the format of a body, JSON when there is no Content-Type header
+func formatOf(contentType string) (*format, bool) {
+	if len(contentType) == 0 {
+		return formats[0], true
+	}
+	for _, f := range formats {
+		for _, mediaType := range f.mediaTypes {
+			if strings.EqualFold(contentType, mediaType) {
+				return f, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// Middleware negotiates the format of the response from the Accept header, it answers a 406 when none of the accepted media types is supported.
+// It must be used on the routes answering with Render, before the handler does anything.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Vary", "Accept")
+		answer, acceptable := negotiate(c.GetHeader("Accept"))
+		if !acceptable {
+			_ = c.Error(problems.New(http.StatusNotAcceptable, "Not acceptable [err=the supported media types are %s]", supported()))
+			c.Abort()
+			return
+		}
+		c.Set(formatKey, answer)
+		c.Next()
+	}
+}
+
+//pretty tells if the client asked for an indented response with ?pretty or ?pretty=true
+func pretty(c *gin.Context) bool {
+	value, present := c.GetQuery("pretty")
+	if !present {
+		return false
+	}
+	indented, err := strconv.ParseBool(value)
+	return len(value) == 0 || (err == nil && indented)
+}
+
+// Render answers with the object in the format negotiated by the middleware, JSON when it did not negotiate it.
+// JSON is compact unless the client asked for ?pretty.
+func Render(c *gin.Context, status int, obj interface{}) {
+	answer := negotiated{formats[0], formats[0].mediaTypes[0]}
+	if value, negotiatedBefore := c.Get(formatKey); negotiatedBefore {
+		answer = value.(negotiated)
+	}
+
+	var body bytes.Buffer
+	if err := answer.format.encode(&body, obj, pretty(c)); err != nil {
+		_ = c.Error(fmt.Errorf("Cannot write the response [err=%w]", err))
+		return
+	}
+	c.Data(status, answer.contentType(), body.Bytes())
+}
+
+// Bind decodes the body of the request in the format of its Content-Type, JSON when there is none.
+// The error, telling what could not be deserialized, is a 415 for an unsupported media type and a 400 for an invalid body.
+func Bind(c *gin.Context, what string, obj interface{}) error {
+	f, supportedType := formatOf(c.ContentType())
+	if !supportedType {
+		return problems.New(http.StatusUnsupportedMediaType, "Cannot deserialize %s [err=the media type %s is not supported, use one of %s]",
+			what, c.ContentType(), supported())
+	}
+	if err := f.decode(c.Request.Body, obj); err != nil {
+		return problems.Validation("Cannot deserialize %s [err=%s]", what, err)
+	}
+	return nil
+}
+
+func encodeJSON(w io.Writer, obj interface{}, pretty bool) error {
+	encoder := json.NewEncoder(w)
+	if pretty {
+		encoder.SetIndent("", "    ")
+	}
+	return encoder.Encode(obj)
+}
+
+func decodeJSON(r io.Reader, obj interface{}) error {
+	return decodeWith(binding.JSON, r, obj)
+}
+
+//elementName is the name of the XML element of a type, like documentPage for models.DocumentPage
+func elementName(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	name := t.Name()
+	first, size := utf8.DecodeRuneInString(name)
+	return string(unicode.ToLower(first)) + name[size:]
+}
+
+//encodeXML writes the object in its element, a list is written in an element named after its items like <documentRevisions><documentRevision>...
+func encodeXML(w io.Writer, obj interface{}, pretty bool) error {
+	if obj == nil {
+		return nil
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	if pretty {
+		encoder.Indent("", "    ")
+	}
+
+	value := reflect.Indirect(reflect.ValueOf(obj))
+	if value.Kind() != reflect.Slice {
+		return encoder.Encode(xmlElement{obj, elementName(value.Type())})
+	}
+	item := elementName(value.Type().Elem())
+	list := xml.StartElement{Name: xml.Name{Local: item + "s"}}
+	if err := encoder.EncodeToken(list); err != nil {
+		return err
+	}
+	for i := 0; i < value.Len(); i++ {
+		if err := encoder.EncodeElement(value.Index(i).Interface(), xml.StartElement{Name: xml.Name{Local: item}}); err != nil {
+			return err
+		}
+	}
+	if err := encoder.EncodeToken(list.End()); err != nil {
+		return err
+	}
+	return encoder.Flush()
+}
+
+//xmlElement writes an object in an element of the given name
+type xmlElement struct {
+	obj  interface{}
+	name string
+}
+
+func (e xmlElement) MarshalXML(encoder *xml.Encoder, _ xml.StartElement) error {
+	return encoder.EncodeElement(e.obj, xml.StartElement{Name: xml.Name{Local: e.name}})
+}
+
+//decodeXML reads the object from the root element whatever its name, the items of a list are its child elements
+func decodeXML(r io.Reader, obj interface{}) error {
+	list := reflect.ValueOf(obj).Elem()
+	if list.Kind() != reflect.Slice {
+		return decodeWith(binding.XML, r, obj)
+	}
+
+	decoder := xml.NewDecoder(r)
+	inList := false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF && !inList {
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			if !inList {
+				inList = true
+				continue
+			}
+			item := reflect.New(list.Type().Elem())
+			if err := decoder.DecodeElement(item.Interface(), &t); err != nil {
+				return err
+			}
+			list.Set(reflect.Append(list, item.Elem()))
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+func encodeYAML(w io.Writer, obj interface{}, _ bool) error {
+	body, err := yaml.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(body)
+	return err
+}
+
+func decodeYAML(r io.Reader, obj interface{}) error {
+	return decodeWith(binding.YAML, r, obj)
+}
+
+//msgPackHandle writes the keys of the maps sorted, so that a document, like its labels, is always encoded the same way
+var msgPackHandle = func() *codec.MsgpackHandle {
+	handle := &codec.MsgpackHandle{}
+	handle.Canonical = true
+	return handle
+}()
+
+func encodeMsgPack(w io.Writer, obj interface{}, _ bool) error {
+	return codec.NewEncoder(w, msgPackHandle).Encode(obj)
+}
+
+func decodeMsgPack(r io.Reader, obj interface{}) error {
+	return decodeWith(binding.MsgPack, r, obj)
+}
+
+//decodeWith decodes the body with a binding of gin, which also validates the object
+func decodeWith(b binding.BindingBody, r io.Reader, obj interface{}) error {
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return b.BindBody(body, obj)
+}
@@ -0,0 +1,206 @@
+package negotiation
+
+import (
+	"encoding/json"
+	"goapi/models"
+	"goapi/resources/problems"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/ugorji/go/codec"
+	"gopkg.in/yaml.v2"
+)
+
+var document = models.Document{
+	ID: "toto", Name: "nameOfToto", Description: "a <b>bold</b> description", Labels: models.Labels{"team": "payments", "env": "prod"}, Version: 2,
+	CreatedAt: time.Date(2021, 10, 1, 8, 0, 0, 0, time.UTC), CreatedBy: "alice", UpdatedAt: time.Date(2021, 10, 2, 8, 0, 0, 0, time.UTC), UpdatedBy: "bob",
+	ACL: &models.DocumentACL{Owner: "user:alice", Readers: []string{"group:finance"}, Writers: []string{}},
+}
+
+//configureRouter returns a router answering the document and echoing the documents or the revisions it receives
+func configureRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(problems.Handler())
+	router.GET("/documents/toto", Middleware(), func(c *gin.Context) {
+		Render(c, http.StatusOK, document)
+	})
+	router.GET("/documents/toto/revisions", Middleware(), func(c *gin.Context) {
+		Render(c, http.StatusOK, []models.DocumentRevision{{DocumentID: "toto", Revision: 1, Timestamp: document.CreatedAt, Document: &document}})
+	})
+	router.PUT("/documents/toto", Middleware(), func(c *gin.Context) {
+		var received models.Document
+		if err := Bind(c, "document", &received); err != nil {
+			_ = c.Error(err)
+			return
+		}
+		Render(c, http.StatusOK, received)
+	})
+	router.PATCH("/documents", Middleware(), func(c *gin.Context) {
+		var operations []models.DocumentOperation
+		if err := Bind(c, "operations", &operations); err != nil {
+			_ = c.Error(err)
+			return
+		}
+		Render(c, http.StatusOK, operations)
+	})
+	return router
+}
+
+func executeRequest(router *gin.Engine, method string, path string, body string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	for header, value := range headers {
+		req.Header.Set(header, value)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestNegotiate(t *testing.T) {
+	for _, test := range []struct {
+		accept            string
+		expectedMediaType string
+	}{
+		{"", MIMEJSON},
+		{"*/*", MIMEJSON},
+		{"application/json", MIMEJSON},
+		{"text/xml", MIMEXML2},
+		{"text/*", MIMEXML2},
+		{"application/x-yaml", MIMEYAML2},
+		{"application/msgpack", MIMEMsgPack},
+		{"text/html, application/xml;q=0.9, */*;q=0.8", MIMEXML},
+		{"application/json;q=0.5, application/yaml", MIMEYAML},
+		{"application/json;q=0, */*", MIMEJSON},
+		{"application/pdf, application/vnd.msgpack;q=0.1", MIMEMsgPack3},
+		{"application/pdf", ""},
+		{"application/xml;q=0", ""},
+	} {
+		answer, acceptable := negotiate(test.accept)
+		assert.Equal(t, len(test.expectedMediaType) > 0, acceptable, test.accept)
+		assert.Equal(t, test.expectedMediaType, answer.mediaType, test.accept)
+	}
+}
+
+func TestRenderJSON(t *testing.T) {
+	router := configureRouter()
+
+	//compact by default
+	recorder := executeRequest(router, "GET", "/documents/toto", "", nil)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "application/json; charset=utf-8", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, "Accept", recorder.Header().Get("Vary"))
+	assert.Equal(t, 1, strings.Count(recorder.Body.String(), "\n"))
+	var received models.Document
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &received))
+	assert.Equal(t, document, received)
+
+	//indented when asked
+	for _, query := range []string{"?pretty", "?pretty=true"} {
+		recorder = executeRequest(router, "GET", "/documents/toto"+query, "", nil)
+		assert.Contains(t, recorder.Body.String(), "\n    \"id\": \"toto\",\n")
+	}
+	recorder = executeRequest(router, "GET", "/documents/toto?pretty=false", "", nil)
+	assert.Equal(t, 1, strings.Count(recorder.Body.String(), "\n"))
+}
+
+func TestRenderXML(t *testing.T) {
+	router := configureRouter()
+
+	recorder := executeRequest(router, "GET", "/documents/toto", "", map[string]string{"Accept": "application/xml"})
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "application/xml; charset=utf-8", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, `<?xml version="1.0" encoding="UTF-8"?>`+"\n"+
+		`<document><id>toto</id><name>nameOfToto</name><description>a &lt;b&gt;bold&lt;/b&gt; description</description>`+
+		`<labels><label key="env">prod</label><label key="team">payments</label></labels><version>2</version>`+
+		`<createdAt>2021-10-01T08:00:00Z</createdAt><createdBy>alice</createdBy><updatedAt>2021-10-02T08:00:00Z</updatedAt><updatedBy>bob</updatedBy>`+
+		`<acl><owner>user:alice</owner><readers><reader>group:finance</reader></readers><writers></writers></acl></document>`, recorder.Body.String())
+
+	//a list is in an element named after its items
+	recorder = executeRequest(router, "GET", "/documents/toto/revisions?pretty", "", map[string]string{"Accept": "text/xml"})
+	assert.Equal(t, "text/xml; charset=utf-8", recorder.Header().Get("Content-Type"))
+	assert.True(t, strings.HasPrefix(recorder.Body.String(), `<?xml version="1.0" encoding="UTF-8"?>`+"\n"+
+		"<documentRevisions>\n    <documentRevision>\n        <documentId>toto</documentId>\n"), recorder.Body.String())
+}
+
+func TestRenderYAMLAndMsgPack(t *testing.T) {
+	router := configureRouter()
+
+	recorder := executeRequest(router, "GET", "/documents/toto", "", map[string]string{"Accept": "application/yaml"})
+	assert.Equal(t, "application/yaml; charset=utf-8", recorder.Header().Get("Content-Type"))
+	assert.Contains(t, recorder.Body.String(), "\ncreatedBy: alice\n")
+	var received models.Document
+	assert.Nil(t, yaml.Unmarshal(recorder.Body.Bytes(), &received))
+	assert.Equal(t, document, received)
+
+	recorder = executeRequest(router, "GET", "/documents/toto", "", map[string]string{"Accept": "application/msgpack"})
+	assert.Equal(t, "application/msgpack", recorder.Header().Get("Content-Type"))
+	received = models.Document{}
+	assert.Nil(t, codec.NewDecoderBytes(recorder.Body.Bytes(), &codec.MsgpackHandle{}).Decode(&received))
+	assert.Equal(t, document.Labels, received.Labels)
+	assert.True(t, document.UpdatedAt.Equal(received.UpdatedAt))
+}
+
+func TestNotAcceptable(t *testing.T) {
+	router := configureRouter()
+
+	recorder := executeRequest(router, "GET", "/documents/toto", "", map[string]string{"Accept": "text/html"})
+	assert.Equal(t, http.StatusNotAcceptable, recorder.Code)
+	assert.Equal(t, problems.ContentType, recorder.Header().Get("Content-Type"))
+	var problem models.Problem
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &problem))
+	assert.Equal(t, "Not acceptable [err=the supported media types are application/json, application/xml, application/yaml, application/msgpack]", problem.Detail)
+}
+
+func TestBind(t *testing.T) {
+	router := configureRouter()
+
+	//each format is read back as it is written
+	for _, mediaType := range []string{MIMEJSON, MIMEXML, MIMEYAML, MIMEMsgPack} {
+		written := executeRequest(router, "GET", "/documents/toto", "", map[string]string{"Accept": mediaType})
+		recorder := executeRequest(router, "PUT", "/documents/toto", written.Body.String(), map[string]string{"Content-Type": mediaType, "Accept": mediaType})
+		assert.Equal(t, http.StatusOK, recorder.Code, mediaType)
+		assert.Equal(t, written.Body.String(), recorder.Body.String(), mediaType)
+	}
+
+	//the items of a list are the elements of the root element
+	recorder := executeRequest(router, "PATCH", "/documents", `<operations>
+		<operation><op>upsert</op><id>toto</id><document><name>nameOfToto</name><labels><label key="team">payments</label></labels></document></operation>
+		<operation><op>delete</op><id>titi</id><ifMatch>3</ifMatch></operation>
+	</operations>`, map[string]string{"Content-Type": "text/xml; charset=utf-8"})
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, `[
+		{"op": "upsert", "id": "toto", "document": {"id": "", "name": "nameOfToto", "description": "", "labels": {"team": "payments"}, "version": 0,
+			"createdAt": "0001-01-01T00:00:00Z", "createdBy": "", "updatedAt": "0001-01-01T00:00:00Z", "updatedBy": ""}},
+		{"op": "delete", "id": "titi", "ifMatch": 3, "document": {"id": "", "description": "", "version": 0,
+			"createdAt": "0001-01-01T00:00:00Z", "createdBy": "", "updatedAt": "0001-01-01T00:00:00Z", "updatedBy": ""}}
+	]`, recorder.Body.String())
+}
+
+func TestBindErrors(t *testing.T) {
+	router := configureRouter()
+
+	for _, test := range []struct {
+		contentType    string
+		body           string
+		expectedStatus int
+		expectedDetail string
+	}{
+		{"text/plain", "toto", http.StatusUnsupportedMediaType,
+			"Cannot deserialize document [err=the media type text/plain is not supported, use one of application/json, application/xml, application/yaml, application/msgpack]"},
+		{MIMEXML, "<document><id>toto</id>", http.StatusBadRequest, "Cannot deserialize document [err=XML syntax error on line 1: unexpected EOF]"},
+		{MIMEYAML, "id: [toto", http.StatusBadRequest, "Cannot deserialize document [err=yaml: line 1: did not find expected ',' or ']']"},
+		{"", "{", http.StatusBadRequest, "Cannot deserialize document [err=unexpected EOF]"},
+	} {
+		recorder := executeRequest(router, "PUT", "/documents/toto", test.body, map[string]string{"Content-Type": test.contentType})
+		assert.Equal(t, test.expectedStatus, recorder.Code, test.contentType)
+		var problem models.Problem
+		assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &problem))
+		assert.Equal(t, test.expectedDetail, problem.Detail)
+	}
+}
@@ -0,0 +1,149 @@
+package problems
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"goapi/models"
+	"goapi/repositories/repodocuments"
+	"goapi/services/servicedocuments"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ContentType is the media type of the error responses
+const ContentType = "application/problem+json"
+
+// RetryAfterSeconds is how long the clients are asked to wait when a dependency is unavailable
+const RetryAfterSeconds = 5
+
+//the errors answered with another status than the one of their kind, checked first
+var specificStatuses = []struct {
+	err    error
+	status int
+}{
+	{repodocuments.ErrPreconditionFailed, http.StatusPreconditionFailed},
+	{repodocuments.ErrRolledBack, http.StatusFailedDependency},
+	{repodocuments.ErrUnknownEvent, http.StatusGone},
+	{servicedocuments.ErrUnsupportedPatch, http.StatusUnsupportedMediaType},
+	{servicedocuments.ErrInvalidPatch, http.StatusUnprocessableEntity},
+	{servicedocuments.ErrRevisionDeleted, http.StatusUnprocessableEntity},
+}
+
+var kindStatuses = []struct {
+	kind   error
+	status int
+}{
+	{repodocuments.ErrNotFound, http.StatusNotFound},
+	{repodocuments.ErrConflict, http.StatusConflict},
+	{repodocuments.ErrValidation, http.StatusBadRequest},
+	{repodocuments.ErrUnavailable, http.StatusServiceUnavailable},
+	{repodocuments.ErrForbidden, http.StatusForbidden},
+}
+
+// statusError is an error of the http layer, answered with its status
+type statusError struct {
+	status int
+	detail string
+}
+
+func (e *statusError) Error() string {
+	return e.detail
+}
+
+// MissingScopesError is an error for a principal without the scopes of a route, answered with a 403 listing them
+type MissingScopesError struct {
+	Scopes []string
+}
+
+func (e *MissingScopesError) Error() string {
+	return fmt.Sprintf("Authorization failed [err=missing scopes %s]", strings.Join(e.Scopes, " "))
+}
+
+// New returns an error answered with the status
+func New(status int, format string, args ...interface{}) error {
+	return &statusError{status: status, detail: fmt.Sprintf(format, args...)}
+}
+
+// Validation returns an error for an invalid request
+func Validation(format string, args ...interface{}) error {
+	return repodocuments.NewError(repodocuments.ErrValidation, format, args...)
+}
+
+// NotFound returns an error for a missing resource
+func NotFound(format string, args ...interface{}) error {
+	return repodocuments.NewError(repodocuments.ErrNotFound, format, args...)
+}
+
+// StatusOf returns the status answering the error, 500 for an unexpected error
+func StatusOf(err error) int {
+	var withStatus *statusError
+	if errors.As(err, &withStatus) {
+		return withStatus.status
+	}
+	var missingScopes *MissingScopesError
+	if errors.As(err, &missingScopes) {
+		return http.StatusForbidden
+	}
+	for _, specific := range specificStatuses {
+		if errors.Is(err, specific.err) {
+			return specific.status
+		}
+	}
+	for _, kind := range kindStatuses {
+		if errors.Is(err, kind.kind) {
+			return kind.status
+		}
+	}
+	return http.StatusInternalServerError
+}
+
+//typeOf is the type of the problems of a status, like /problems/not-found
+func typeOf(status int) string {
+	return "/problems/" + strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "-")
+}
+
+// Of returns the problem answering a request with the status
+func Of(status int, detail string, instance string) models.Problem {
+	return models.Problem{
+		Type:     typeOf(status),
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: instance,
+	}
+}
+
+// Handler answers the requests whose handler reported an error with c.Error and did not write a response
+func Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+		err := c.Errors.Last().Err
+		status := StatusOf(err)
+		if status >= http.StatusInternalServerError {
+			servicedocuments.LoggerFrom(c.Request.Context()).Errorf("%s %s failed [err=%s]", c.Request.Method, c.Request.URL.Path, err)
+		}
+		if status == http.StatusServiceUnavailable {
+			c.Header("Retry-After", strconv.Itoa(RetryAfterSeconds))
+		}
+
+		problem := Of(status, err.Error(), c.Request.URL.RequestURI())
+		var invalid *servicedocuments.ValidationError
+		if errors.As(err, &invalid) {
+			problem.Errors = invalid.Fields
+		}
+		var missingScopes *MissingScopesError
+		if errors.As(err, &missingScopes) {
+			problem.MissingScopes = missingScopes.Scopes
+		}
+		body, _ := json.MarshalIndent(problem, "", "    ")
+		c.Data(status, ContentType, body)
+	}
+}
@@ -0,0 +1,82 @@
+package problems
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"goapi/models"
+	"goapi/repositories/repodocuments"
+	"goapi/services/servicedocuments"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusOf(t *testing.T) {
+	for _, test := range []struct {
+		err            error
+		expectedStatus int
+	}{
+		{New(http.StatusUnsupportedMediaType, "wrong type"), http.StatusUnsupportedMediaType},
+		{fmt.Errorf("document id toto not found [err=%w]", repodocuments.ErrNotFound), http.StatusNotFound},
+		{NotFound("document id %s not found", "toto"), http.StatusNotFound},
+		{Validation("Validation failed [err=%s]", "id must be defined"), http.StatusBadRequest},
+		{repodocuments.ErrInvalidCursor, http.StatusBadRequest},
+		{fmt.Errorf("document id toto has been modified [err=%w]", repodocuments.ErrPreconditionFailed), http.StatusPreconditionFailed},
+		{repodocuments.NewError(repodocuments.ErrConflict, "already exists"), http.StatusConflict},
+		{repodocuments.ErrRolledBack, http.StatusFailedDependency},
+		{repodocuments.ErrUnknownEvent, http.StatusGone},
+		{fmt.Errorf("%w: missing path", servicedocuments.ErrInvalidPatch), http.StatusUnprocessableEntity},
+		{repodocuments.ErrNoDatastore, http.StatusServiceUnavailable},
+		{&MissingScopesError{Scopes: []string{"documents:write"}}, http.StatusForbidden},
+		{errors.New("error_service"), http.StatusInternalServerError},
+	} {
+		assert.Equal(t, test.expectedStatus, StatusOf(test.err), test.err.Error())
+	}
+}
+
+func TestHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Handler())
+	router.GET("/documents/:id", func(c *gin.Context) {
+		_ = c.Error(fmt.Errorf("Cannot get document id %s [err=%w]", c.Param("id"), repodocuments.ErrNoDatastore))
+	})
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", "/documents/toto?fields=name", nil))
+
+	//check result
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.Equal(t, ContentType, recorder.Header().Get("Content-Type"))
+	assert.Equal(t, "5", recorder.Header().Get("Retry-After"))
+	var problem models.Problem
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &problem))
+	assert.Equal(t, models.Problem{
+		Type:     "/problems/service-unavailable",
+		Title:    "Service Unavailable",
+		Status:   http.StatusServiceUnavailable,
+		Detail:   "Cannot get document id toto [err=no datastore]",
+		Instance: "/documents/toto?fields=name",
+	}, problem)
+}
+
+func TestHandlerWrittenResponse(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Handler())
+	router.GET("/documents", func(c *gin.Context) {
+		_ = c.Error(errors.New("already answered"))
+		c.IndentedJSON(http.StatusOK, []models.Document{})
+	})
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", "/documents", nil))
+
+	//check result is the response of the handler
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.JSONEq(t, `[]`, recorder.Body.String())
+}
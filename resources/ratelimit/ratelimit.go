@@ -0,0 +1,259 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"goapi/config"
+	"goapi/repositories/reporatelimits"
+	"goapi/resources/auth"
+	"goapi/resources/problems"
+	"goapi/services/servicedocuments"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// The keys the requests are counted by
+const (
+	//KeyClient counts the requests by API key or principal, by client IP when not authenticated
+	KeyClient = "client"
+	//KeyIP counts the requests by client IP
+	KeyIP = "ip"
+)
+
+//defaultRoute is the route of the bucket shared by the routes without their own limit
+const defaultRoute = "*"
+
+//limitOf returns the limit of the route and the route of its bucket
+func limitOf(configuration *config.RateLimitConfig, method string, path string) (config.RouteRateLimitConfig, string) {
+	for _, route := range configuration.Routes {
+		if route.Path == path && (len(route.Method) == 0 || strings.EqualFold(route.Method, method)) {
+			return route, route.Method + " " + route.Path
+		}
+	}
+	return configuration.Default, defaultRoute
+}
+
+//trustedProxies are the networks of the proxies in front of the server, the only ones whose X-Forwarded-For is read
+type trustedProxies []*net.IPNet
+
+//parseTrustedProxies parses the IPs and the CIDRs of the proxies
+func parseTrustedProxies(values []string) (trustedProxies, error) {
+	proxies := make(trustedProxies, 0, len(values))
+	for _, value := range values {
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %s", value)
+			}
+			value += "/128"
+			if ip.To4() != nil {
+				value = ip.To4().String() + "/32"
+			}
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %s", value)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+func (p trustedProxies) trusted(ip net.IP) bool {
+	for _, network := range p {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+//clientIP returns the IP of the client of the request. It is the address of the connection, unless it comes from a trusted proxy:
+//the client is then the last address of X-Forwarded-For that is not a trusted proxy, each proxy adding the address it was called by.
+//The X-Forwarded-For sent by the clients themselves are never read.
+func (p trustedProxies) clientIP(c *gin.Context) string {
+	remote := strings.TrimSpace(c.Request.RemoteAddr)
+	if host, _, err := net.SplitHostPort(remote); err == nil {
+		remote = host
+	}
+	ip := net.ParseIP(remote)
+	if ip == nil || !p.trusted(ip) {
+		return remote
+	}
+	forwarded := strings.Split(c.GetHeader("X-Forwarded-For"), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		forwardedIP := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if forwardedIP == nil {
+			break
+		}
+		ip = forwardedIP
+		if !p.trusted(ip) {
+			break
+		}
+	}
+	return ip.String()
+}
+
+//clientOf returns who the request is counted for
+func (p trustedProxies) clientOf(c *gin.Context, key string) string {
+	principal, _ := auth.PrincipalFrom(c)
+	return clientKey(key, principal, p.clientIP(c))
+}
+
+//clientKey returns who a request of the principal from the IP is counted for, by IP when there is no principal
+func clientKey(key string, principal *auth.Principal, ip string) string {
+	if key != KeyIP && principal != nil {
+		//an API key has no claims
+		if principal.Claims == nil {
+			return "apikey:" + principal.Subject
+		}
+		return "principal:" + principal.Subject
+	}
+	return "ip:" + ip
+}
+
+//headerSeconds is a duration in the headers, in whole seconds rounded up
+func headerSeconds(duration time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(duration.Seconds())))
+}
+
+//limitFrom returns the bucket of the configured limit
+func limitFrom(configured config.RouteRateLimitConfig) reporatelimits.Limit {
+	limit := reporatelimits.Limit{RequestsPerMinute: configured.RequestsPerMinute, Burst: configured.Burst}
+	if limit.Burst <= 0 {
+		limit.Burst = limit.RequestsPerMinute
+	}
+	return limit
+}
+
+//exceeded is the error refusing a request without token
+func exceeded(limit reporatelimits.Limit, result reporatelimits.Result) error {
+	return problems.New(http.StatusTooManyRequests, "Rate limit of %d requests per minute exceeded, retry after %s seconds",
+		limit.RequestsPerMinute, headerSeconds(result.RetryAfter))
+}
+
+//limit takes a token from the bucket of the key for the request, and refuses the request with a 429 when there is none.
+//It returns false when the request is refused. The requests are not refused when the bucket cannot be read.
+func limit(c *gin.Context, repository reporatelimits.RateLimitRepository, key string, configured config.RouteRateLimitConfig) bool {
+	limit := limitFrom(configured)
+	result, err := repository.Take(key, limit)
+	if err != nil {
+		servicedocuments.LoggerFrom(c.Request.Context()).Warnf("Cannot check the rate limit of %s [err=%s]", key, err)
+		return true
+	}
+
+	c.Header("RateLimit-Limit", strconv.Itoa(limit.Burst))
+	c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	c.Header("RateLimit-Reset", headerSeconds(result.ResetAfter))
+	if !result.Allowed {
+		c.Header("Retry-After", headerSeconds(result.RetryAfter))
+		_ = c.Error(exceeded(limit, result))
+		c.Abort()
+		return false
+	}
+	return true
+}
+
+// Middleware limits the requests of each client on each route with a token bucket, a client over the limit gets a 429.
+// The RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers tell the state of the bucket of the client.
+// It must be used after the authentication when the requests are counted by principal.
+// The requests are not refused when the buckets cannot be read, like when the database is unavailable.
+func Middleware(configuration *config.RateLimitConfig, repository reporatelimits.RateLimitRepository) (gin.HandlerFunc, error) {
+	proxies, err := parseTrustedProxies(configuration.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	if !configuration.Enabled {
+		return func(c *gin.Context) { c.Next() }, nil
+	}
+	return func(c *gin.Context) {
+		routeLimit, route := limitOf(configuration, c.Request.Method, c.FullPath())
+		if routeLimit.RequestsPerMinute <= 0 || limit(c, repository, route+"|"+proxies.clientOf(c, configuration.Key), routeLimit) {
+			c.Next()
+		}
+	}, nil
+}
+
+// IPMiddleware limits all the requests of each client IP with a token bucket, whatever their route.
+// It must be used before the authentication, so that the requests failing to authenticate, like the ones guessing API keys, are limited too.
+func IPMiddleware(configuration *config.RateLimitConfig, repository reporatelimits.RateLimitRepository) (gin.HandlerFunc, error) {
+	proxies, err := parseTrustedProxies(configuration.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	if !configuration.Enabled || configuration.PerIP.RequestsPerMinute <= 0 {
+		return func(c *gin.Context) { c.Next() }, nil
+	}
+	return func(c *gin.Context) {
+		if limit(c, repository, "ip|"+proxies.clientIP(c), configuration.PerIP) {
+			c.Next()
+		}
+	}, nil
+}
+
+// Limiter takes the tokens of the routes for the calls of the other APIs, like a GraphQL mutation or a gRPC method doing what a route does.
+// A call takes its token from the bucket of the client on the route, shared with the requests of the route.
+type Limiter struct {
+	configuration *config.RateLimitConfig
+	repository    reporatelimits.RateLimitRepository
+	proxies       trustedProxies
+}
+
+// NewLimiter returns the limiter of the buckets of the configuration
+func NewLimiter(configuration *config.RateLimitConfig, repository reporatelimits.RateLimitRepository) (*Limiter, error) {
+	proxies, err := parseTrustedProxies(configuration.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	return &Limiter{configuration: configuration, repository: repository, proxies: proxies}, nil
+}
+
+// ClientOf returns who the request is counted for, as the middleware counts it
+func (l *Limiter) ClientOf(c *gin.Context) string {
+	return l.proxies.clientOf(c, l.configuration.Key)
+}
+
+// ClientKey returns who a call of the principal from the IP is counted for, by IP when there is no principal
+func (l *Limiter) ClientKey(principal *auth.Principal, ip string) string {
+	return clientKey(l.configuration.Key, principal, ip)
+}
+
+// Take takes a token from the bucket of the client on the route, and returns an error with the status 429 when there is none.
+// There is no error when the limits are disabled or the bucket cannot be read, as with the middleware.
+func (l *Limiter) Take(ctx context.Context, method string, path string, client string) error {
+	if !l.configuration.Enabled {
+		return nil
+	}
+	routeLimit, route := limitOf(l.configuration, method, path)
+	return l.take(ctx, route+"|"+client, routeLimit)
+}
+
+// TakeIP takes a token from the bucket of the IP, as IPMiddleware does before the authentication
+func (l *Limiter) TakeIP(ctx context.Context, ip string) error {
+	if !l.configuration.Enabled {
+		return nil
+	}
+	return l.take(ctx, "ip|"+ip, l.configuration.PerIP)
+}
+
+func (l *Limiter) take(ctx context.Context, key string, configured config.RouteRateLimitConfig) error {
+	if configured.RequestsPerMinute <= 0 {
+		return nil
+	}
+	limit := limitFrom(configured)
+	result, err := l.repository.Take(key, limit)
+	if err != nil {
+		servicedocuments.LoggerFrom(ctx).Warnf("Cannot check the rate limit of %s [err=%s]", key, err)
+		return nil
+	}
+	if !result.Allowed {
+		return exceeded(limit, result)
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"goapi/config"
+	"goapi/models"
+	"goapi/repositories/reporatelimits"
+	"goapi/resources/auth"
+	"goapi/resources/problems"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+var configuration = config.RateLimitConfig{
+	Enabled: true,
+	Default: config.RouteRateLimitConfig{RequestsPerMinute: 60, Burst: 3},
+	Routes:  []config.RouteRateLimitConfig{{Method: "POST", Path: "/emails", RequestsPerMinute: 6, Burst: 1}},
+}
+
+//configureRouter returns a router with the principal of the X-User header when it is given
+func configureRouter(configuration *config.RateLimitConfig, repository reporatelimits.RateLimitRepository) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(problems.Handler())
+	router.Use(func(c *gin.Context) {
+		if user := c.GetHeader("X-User"); len(user) > 0 {
+			c.Set(auth.PrincipalKey, &auth.Principal{Subject: user})
+		}
+	})
+	middleware, err := Middleware(configuration, repository)
+	if err != nil {
+		panic(err)
+	}
+	router.Use(middleware)
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	router.GET("/documents", ok)
+	router.GET("/documents/:id", ok)
+	router.POST("/emails", ok)
+	return router
+}
+
+func executeRequest(router *gin.Engine, method string, path string, ip string, user string, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req.RemoteAddr = ip + ":40000"
+	if len(user) > 0 {
+		req.Header.Set("X-User", user)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestMiddleware(t *testing.T) {
+	router := configureRouter(&configuration, &reporatelimits.InMemoryRateLimitRepo{})
+
+	//the routes without their own limit share the bucket of the client
+	for i, path := range []string{"/documents", "/documents/toto", "/documents/titi"} {
+		recorder := executeRequest(router, "GET", path, "10.0.0.1", "")
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Equal(t, "3", recorder.Header().Get("RateLimit-Limit"))
+		assert.Equal(t, []string{"2", "1", "0"}[i], recorder.Header().Get("RateLimit-Remaining"))
+	}
+	recorder := executeRequest(router, "GET", "/documents", "10.0.0.1", "")
+	assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+	assert.Equal(t, "1", recorder.Header().Get("Retry-After"))
+	assert.Equal(t, "3", recorder.Header().Get("RateLimit-Reset"))
+	var problem models.Problem
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &problem))
+	assert.Equal(t, "Rate limit of 60 requests per minute exceeded, retry after 1 seconds", problem.Detail)
+
+	//a route with its own limit has its own bucket
+	recorder = executeRequest(router, "POST", "/emails", "10.0.0.1", "")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	recorder = executeRequest(router, "POST", "/emails", "10.0.0.1", "")
+	assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+	assert.Equal(t, "10", recorder.Header().Get("Retry-After"))
+
+	//the other clients have their own buckets
+	recorder = executeRequest(router, "GET", "/documents", "10.0.0.2", "")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+}
+
+func TestMiddlewareByPrincipal(t *testing.T) {
+	router := configureRouter(&configuration, &reporatelimits.InMemoryRateLimitRepo{})
+
+	//the requests of a principal are counted together whatever their IP
+	recorder := executeRequest(router, "POST", "/emails", "10.0.0.1", "alice")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	recorder = executeRequest(router, "POST", "/emails", "10.0.0.2", "alice")
+	assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+	recorder = executeRequest(router, "POST", "/emails", "10.0.0.2", "bob")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	//unless they are counted by IP
+	byIP := configuration
+	byIP.Key = KeyIP
+	router = configureRouter(&byIP, &reporatelimits.InMemoryRateLimitRepo{})
+	recorder = executeRequest(router, "POST", "/emails", "10.0.0.1", "alice")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	recorder = executeRequest(router, "POST", "/emails", "10.0.0.1", "bob")
+	assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+}
+
+func TestMiddlewareForwardedFor(t *testing.T) {
+	router := configureRouter(&configuration, &reporatelimits.InMemoryRateLimitRepo{})
+
+	//the X-Forwarded-For of the clients is ignored, they cannot get a new bucket with each request
+	for i, forwarded := range []string{"1.1.1.1", "2.2.2.2", "3.3.3.3", "4.4.4.4"} {
+		recorder := executeRequest(router, "GET", "/documents", "10.0.0.1", "", "X-Forwarded-For", forwarded)
+		assert.Equal(t, []int{http.StatusOK, http.StatusOK, http.StatusOK, http.StatusTooManyRequests}[i], recorder.Code)
+	}
+
+	//the one of a trusted proxy tells the client, the addresses added by the client before are ignored
+	behindProxy := configuration
+	behindProxy.TrustedProxies = []string{"10.0.0.0/8", "192.168.1.1"}
+	router = configureRouter(&behindProxy, &reporatelimits.InMemoryRateLimitRepo{})
+	for i := 0; i < 3; i++ {
+		recorder := executeRequest(router, "GET", "/documents", "10.0.0.1", "", "X-Forwarded-For", fmt.Sprintf("9.9.9.%d, 1.1.1.1, 192.168.1.1", i))
+		assert.Equal(t, http.StatusOK, recorder.Code)
+	}
+	recorder := executeRequest(router, "GET", "/documents", "10.0.0.2", "", "X-Forwarded-For", "1.1.1.1")
+	assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+	recorder = executeRequest(router, "GET", "/documents", "10.0.0.2", "", "X-Forwarded-For", "2.2.2.2")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	_, err := Middleware(&config.RateLimitConfig{TrustedProxies: []string{"proxy"}}, &reporatelimits.InMemoryRateLimitRepo{})
+	assert.NotNil(t, err)
+}
+
+func TestIPMiddleware(t *testing.T) {
+	perIP := configuration
+	perIP.PerIP = config.RouteRateLimitConfig{RequestsPerMinute: 60, Burst: 2}
+	middleware, err := IPMiddleware(&perIP, &reporatelimits.InMemoryRateLimitRepo{})
+	assert.Nil(t, err)
+
+	//the requests are limited before the authentication, the ones refused by it are counted too
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(problems.Handler(), middleware, func(c *gin.Context) {
+		_ = c.Error(problems.New(http.StatusUnauthorized, "Authentication failed [err=unknown API key]"))
+		c.Abort()
+	})
+	router.GET("/documents", func(c *gin.Context) { c.Status(http.StatusOK) })
+	for i, expected := range []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests} {
+		recorder := executeRequest(router, "GET", "/documents", "10.0.0.1", "", "X-API-Key", fmt.Sprintf("guess-%d", i))
+		assert.Equal(t, expected, recorder.Code)
+	}
+	recorder := executeRequest(router, "GET", "/unknown", "10.0.0.2", "")
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+}
+
+//unavailableRepo is a repository whose database is unavailable
+type unavailableRepo struct{}
+
+func (r unavailableRepo) Take(key string, limit reporatelimits.Limit) (reporatelimits.Result, error) {
+	return reporatelimits.Result{}, errors.New("no datastore")
+}
+
+func TestMiddlewareUnavailable(t *testing.T) {
+	router := configureRouter(&configuration, unavailableRepo{})
+
+	//the requests are not refused when the limit cannot be checked
+	for i := 0; i < 5; i++ {
+		recorder := executeRequest(router, "GET", "/documents", "10.0.0.1", "")
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Empty(t, recorder.Header().Get("RateLimit-Limit"))
+	}
+}
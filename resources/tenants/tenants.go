@@ -0,0 +1,112 @@
+package tenants
+
+import (
+	"fmt"
+	"goapi/config"
+	"goapi/repositories/repodocuments"
+	"goapi/resources/problems"
+	"goapi/services/servicedocuments"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultHeader is the header telling the tenant of a request when the configuration has none
+const DefaultHeader = "X-Tenant"
+
+// Key is the key of the tenant of the request in the gin context, it is logged with the request
+const Key = "tenant"
+
+// BindingKey is the key of the Binding of the authenticated principal in the gin context
+const BindingKey = "tenantBinding"
+
+// Binding tells the tenant an authenticated principal is bound to, its requests cannot be made for another tenant
+type Binding struct {
+	//Tenant is the tenant of an API key, empty when it has none
+	Tenant string
+	//Claims are the claims of a token, its tenant is the one of the claim of the configuration
+	Claims map[string]interface{}
+}
+
+//tenant returns the tenant of the principal, empty when it has none
+func (b *Binding) tenant(configuration *config.TenancyConfig) string {
+	if len(configuration.Claim) > 0 {
+		if claimed, ok := b.Claims[configuration.Claim].(string); ok && len(claimed) > 0 {
+			return claimed
+		}
+	}
+	return b.Tenant
+}
+
+// HeaderOf returns the header telling the tenant of a request
+func HeaderOf(configuration *config.TenancyConfig) string {
+	if len(configuration.Header) == 0 {
+		return DefaultHeader
+	}
+	return configuration.Header
+}
+
+// Resolve returns the tenant of a request, the default tenant when it has none. The tenant of an authenticated request is the one
+// its principal is bound to, by the claim of its token or by its API key, the header can only repeat it. The tenant of the other
+// requests is the value of their header. The error is a validation error telling why the tenant is refused, or a forbidden error
+// when the header is not the tenant of the principal.
+func Resolve(configuration *config.TenancyConfig, binding *Binding, headerValue string) (string, error) {
+	header := strings.TrimSpace(headerValue)
+	tenant := header
+	if binding != nil {
+		tenant = binding.tenant(configuration)
+		if len(tenant) == 0 && !configuration.Required {
+			tenant = repodocuments.DefaultTenant
+		}
+		if len(header) > 0 && header != tenant {
+			return "", repodocuments.NewError(repodocuments.ErrForbidden, "Tenant forbidden [err=the principal cannot use the tenant %s]", header)
+		}
+	}
+	switch {
+	case len(tenant) == 0 && binding != nil:
+		return "", repodocuments.NewError(repodocuments.ErrForbidden, "Tenant forbidden [err=the principal has no tenant]")
+	case len(tenant) == 0 && configuration.Required:
+		return "", problems.Validation("Validation failed [err=the tenant must be defined with the header %s]", HeaderOf(configuration))
+	case len(tenant) == 0:
+		return repodocuments.DefaultTenant, nil
+	case !repodocuments.ValidTenant(tenant):
+		return "", problems.Validation("Validation failed [err=the tenant must be alphanumeric with - or _, and at most 64 characters]")
+	}
+	return tenant, nil
+}
+
+// Middleware resolves the tenant of each request, so that the services only see the documents of the tenant.
+// It must be used after the middleware authenticating the requests, that sets the Binding of their principal.
+func Middleware(configuration *config.TenancyConfig) gin.HandlerFunc {
+	header := HeaderOf(configuration)
+	return func(c *gin.Context) {
+		binding, _ := c.Get(BindingKey)
+		principalBinding, _ := binding.(*Binding)
+		tenant, err := Resolve(configuration, principalBinding, c.GetHeader(header))
+		if err != nil {
+			_ = c.Error(err)
+			c.Abort()
+			return
+		}
+
+		c.Set(Key, tenant)
+		c.Request = c.Request.WithContext(servicedocuments.WithTenant(c.Request.Context(), tenant))
+		c.Next()
+	}
+}
+
+// LogFormatter is the format of the access log, with the tenant of the request
+func LogFormatter(param gin.LogFormatterParams) string {
+	tenant, _ := param.Keys[Key].(string)
+	return fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | tenant=%s | %-7s %#v\n%s",
+		param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+		param.StatusCode,
+		param.Latency.Round(time.Microsecond),
+		param.ClientIP,
+		tenant,
+		param.Method,
+		param.Path,
+		param.ErrorMessage,
+	)
+}
@@ -0,0 +1,111 @@
+package tenants
+
+import (
+	"encoding/json"
+	"goapi/config"
+	"goapi/models"
+	"goapi/resources/problems"
+	"goapi/services/servicedocuments"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+//configureRouter returns a router answering the tenant of the requests, made by the principal of the binding when it is given
+func configureRouter(configuration *config.TenancyConfig, binding *Binding) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(problems.Handler())
+	//the binding set by the authentication
+	router.Use(func(c *gin.Context) {
+		if binding != nil {
+			c.Set(BindingKey, binding)
+		}
+	})
+	router.Use(Middleware(configuration))
+	router.GET("/documents", func(c *gin.Context) {
+		c.String(http.StatusOK, servicedocuments.TenantFrom(c.Request.Context())+" "+c.GetString(Key))
+	})
+	return router
+}
+
+func executeRequest(router *gin.Engine, tenant string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "/documents", nil)
+	if len(tenant) > 0 {
+		req.Header.Set(DefaultHeader, tenant)
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestMiddleware(t *testing.T) {
+	router := configureRouter(&config.TenancyConfig{}, nil)
+
+	recorder := executeRequest(router, "payments")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "payments payments", recorder.Body.String())
+
+	//without tenant, the request is made for the default tenant
+	recorder = executeRequest(router, "")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "default default", recorder.Body.String())
+}
+
+func TestMiddlewareClaim(t *testing.T) {
+	router := configureRouter(&config.TenancyConfig{Claim: "tenant"}, &Binding{Claims: map[string]interface{}{"tenant": "billing"}})
+
+	//the tenant is the claim of the token, the header can only repeat it
+	recorder := executeRequest(router, "")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "billing billing", recorder.Body.String())
+	recorder = executeRequest(router, "billing")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	checkForbidden(t, executeRequest(router, "payments"), "Tenant forbidden [err=the principal cannot use the tenant payments]")
+}
+
+func TestMiddlewareBinding(t *testing.T) {
+	//an API key is bound to its tenant
+	router := configureRouter(&config.TenancyConfig{Claim: "tenant"}, &Binding{Tenant: "billing"})
+	recorder := executeRequest(router, "")
+	assert.Equal(t, "billing billing", recorder.Body.String())
+	checkForbidden(t, executeRequest(router, "payments"), "Tenant forbidden [err=the principal cannot use the tenant payments]")
+
+	//a principal without tenant is bound to the default tenant, or to none when the tenant is required
+	router = configureRouter(&config.TenancyConfig{Claim: "tenant"}, &Binding{Claims: map[string]interface{}{"sub": "alice"}})
+	recorder = executeRequest(router, "")
+	assert.Equal(t, "default default", recorder.Body.String())
+	checkForbidden(t, executeRequest(router, "payments"), "Tenant forbidden [err=the principal cannot use the tenant payments]")
+	router = configureRouter(&config.TenancyConfig{Claim: "tenant", Required: true}, &Binding{})
+	checkForbidden(t, executeRequest(router, ""), "Tenant forbidden [err=the principal has no tenant]")
+}
+
+//checkForbidden checks the request has been refused with a 403 and the detail
+func checkForbidden(t *testing.T, recorder *httptest.ResponseRecorder, expectedDetail string) {
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+	var problem models.Problem
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &problem))
+	assert.Equal(t, expectedDetail, problem.Detail)
+}
+
+func TestMiddlewareWrongTenant(t *testing.T) {
+	for _, test := range []struct {
+		configuration  config.TenancyConfig
+		tenant         string
+		expectedDetail string
+	}{
+		{config.TenancyConfig{Required: true}, "", "Validation failed [err=the tenant must be defined with the header X-Tenant]"},
+		{config.TenancyConfig{}, "pay/ments", "Validation failed [err=the tenant must be alphanumeric with - or _, and at most 64 characters]"},
+	} {
+		recorder := executeRequest(configureRouter(&test.configuration, nil), test.tenant)
+
+		//check result
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+		var problem models.Problem
+		assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &problem))
+		assert.Equal(t, test.expectedDetail, problem.Detail)
+	}
+}
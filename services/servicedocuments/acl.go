@@ -0,0 +1,124 @@
+package servicedocuments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"goapi/models"
+	"goapi/repositories/repodocuments"
+	"strings"
+)
+
+var ErrWriteDenied = NewError(ErrForbidden, "the document is not shared for writing with the actor")
+var ErrNotOwner = NewError(ErrForbidden, "only the owner of the document or an admin can change its ACL")
+
+// ValidateACL returns a ValidationError listing every invalid field of the ACL, nil if the ACL is valid
+func ValidateACL(acl models.DocumentACL) error {
+	var fields []models.FieldError
+	if len(strings.TrimSpace(acl.Owner)) == 0 {
+		fields = append(fields, models.FieldError{Field: "owner", Message: "owner must be defined"})
+	}
+	for _, list := range []struct {
+		name       string
+		principals []string
+	}{{"readers", acl.Readers}, {"writers", acl.Writers}} {
+		for i, principal := range list.principals {
+			if !repodocuments.ValidPrincipal(principal) {
+				field := fmt.Sprintf("%s[%d]", list.name, i)
+				fields = append(fields, models.FieldError{Field: field, Message: field + " must be user:<id> or group:<id>"})
+			}
+		}
+	}
+	if len(fields) > 0 {
+		return &ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+//checkWrite tells if the actor of the context can write the stored document
+func checkWrite(ctx context.Context, document models.Document) error {
+	if !repodocuments.CanWrite(document.ACL, PrincipalsFrom(ctx)) {
+		return ErrWriteDenied
+	}
+	return nil
+}
+
+//checkWritable tells if the actor of the context can write document id, a document that does not exist can be created by anyone.
+//A document of the trash keeps its ACL when it is written again, so only its writers can, as only they can restore it.
+//It returns the ACL of the stored document, nil when there is none.
+func (s *DocumentServiceImpl) checkWritable(ctx context.Context, id string) (*models.DocumentACL, error) {
+	document, err := s.repo(ctx).GetById(id)
+	if errors.Is(err, ErrNotFound) {
+		document, err = s.trashed(ctx, id)
+	}
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return document.ACL, checkWrite(ctx, document)
+}
+
+//trashed returns document id from the trash, ErrNotFound when it is not in the trash
+func (s *DocumentServiceImpl) trashed(ctx context.Context, id string) (models.Document, error) {
+	trashed, err := s.repo(ctx).List(repodocuments.DocumentQuery{Limit: 1, Trashed: true, Filter: repodocuments.DocumentFilter{ID: id}})
+	if err != nil {
+		return models.Document{}, err
+	}
+	if len(trashed.Documents) == 0 {
+		return models.Document{}, ErrNotFound
+	}
+	return trashed.Documents[0], nil
+}
+
+//readableRevisions tells if the actor of the context can read the revisions of a document, with the ACL the document had when it was last written.
+//The revisions of a document whose writes were all dropped can be read by anyone, like a document without ACL.
+func readableRevisions(ctx context.Context, revisions []models.DocumentRevision) bool {
+	for i := len(revisions) - 1; i >= 0; i-- {
+		if revisions[i].Document != nil {
+			return repodocuments.CanRead(revisions[i].Document.ACL, PrincipalsFrom(ctx))
+		}
+	}
+	return true
+}
+
+// SetACL replaces the ACL of the document if the precondition holds, only the owner of the document or an admin can.
+// A document without ACL has no owner, only an admin can give it one.
+// The new ACL makes a new version and a new revision of the document.
+func (s *DocumentServiceImpl) SetACL(ctx context.Context, id string, acl models.DocumentACL, precondition repodocuments.Precondition) (models.Document, error) {
+	var current models.Document
+	var err error
+	if AdminFrom(ctx) {
+		//an admin also changes the ACL of the documents it cannot read
+		current, err = s.repo(ctx).GetById(id)
+	} else {
+		current, err = s.Get(ctx, id)
+	}
+	if err != nil {
+		return models.Document{}, err
+	}
+	if precondition.MustNotExist {
+		return models.Document{}, repodocuments.ErrPreconditionFailed
+	}
+	if !AdminFrom(ctx) && !repodocuments.IsOwner(current.ACL, PrincipalsFrom(ctx)) {
+		return models.Document{}, ErrNotOwner
+	}
+	if err := ValidateACL(acl); err != nil {
+		return models.Document{}, err
+	}
+
+	//an ACL without readers or writers is answered with empty lists
+	if acl.Readers == nil {
+		acl.Readers = []string{}
+	}
+	if acl.Writers == nil {
+		acl.Writers = []string{}
+	}
+	document, err := s.repo(ctx).SetACL(id, acl, precondition)
+	if err == nil {
+		s.addRevision(ctx, id, &document)
+		s.notify(ctx, id, &document, true)
+	}
+	return document, err
+}
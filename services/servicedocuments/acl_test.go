@@ -0,0 +1,218 @@
+package servicedocuments
+
+import (
+	"context"
+	"goapi/models"
+	"goapi/repositories/repocontents"
+	"goapi/repositories/repodocuments"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrincipalsFrom(t *testing.T) {
+	assert.Equal(t, []string{"user:" + AnonymousActor}, PrincipalsFrom(context.Background()))
+	ctx := WithGroups(WithActor(context.Background(), "alice"), []string{"finance", "legal"})
+	assert.Equal(t, []string{"user:alice", "group:finance", "group:legal"}, PrincipalsFrom(ctx))
+}
+
+func TestDocumentServiceImpl_ACL(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	documentServiceImpl := NewDocumentServiceImpl(&repo)
+	alice := WithActor(context.Background(), "alice")
+	bob := WithActor(context.Background(), "bob")
+	carol := WithGroups(WithActor(context.Background(), "carol"), []string{"finance"})
+
+	//a new document is owned by its creator, the ACL sent by the client is ignored
+	created, _, err := documentServiceImpl.CreateOrUpdate(alice,
+		models.Document{ID: "toto", Name: "annual report", ACL: &models.DocumentACL{Owner: "bob"}}, repodocuments.Precondition{})
+	assert.Nil(t, err)
+	assert.Equal(t, &models.DocumentACL{Owner: "alice", Readers: []string{}, Writers: []string{}}, created.ACL)
+	_, _, _ = documentServiceImpl.CreateOrUpdate(bob, models.Document{ID: "titi", Name: "annual budget"}, repodocuments.Precondition{})
+
+	//the others neither see it nor write it
+	_, err = documentServiceImpl.Get(bob, "toto")
+	assert.ErrorIs(t, err, ErrNotFound)
+	all, _ := documentServiceImpl.GetAll(bob)
+	assert.Equal(t, 1, len(all))
+	assert.Equal(t, "titi", all[0].ID)
+	page, _ := documentServiceImpl.List(carol, repodocuments.DocumentQuery{})
+	assert.Equal(t, 0, len(page.Documents))
+	search, _ := documentServiceImpl.Search(bob, repodocuments.SearchQuery{Text: "annual"})
+	assert.Equal(t, 1, len(search.Hits))
+	_, _, err = documentServiceImpl.CreateOrUpdate(bob, models.Document{ID: "toto"}, repodocuments.Precondition{})
+	assert.ErrorIs(t, err, ErrWriteDenied)
+	_, err = documentServiceImpl.Delete(bob, "toto", repodocuments.Precondition{})
+	assert.ErrorIs(t, err, ErrForbidden)
+	revisions, _ := documentServiceImpl.GetRevisions(bob, "toto")
+	assert.Equal(t, 0, len(revisions))
+
+	//only the owner shares it
+	acl := models.DocumentACL{Owner: "alice", Readers: []string{"group:finance"}, Writers: []string{"user:bob"}}
+	_, err = documentServiceImpl.SetACL(bob, "titi", models.DocumentACL{Owner: "bob", Readers: []string{"user:alice"}}, repodocuments.Precondition{})
+	assert.Nil(t, err)
+	_, err = documentServiceImpl.SetACL(carol, "toto", acl, repodocuments.Precondition{})
+	assert.ErrorIs(t, err, ErrNotFound)
+	shared, err := documentServiceImpl.SetACL(alice, "toto", acl, repodocuments.Precondition{IfMatch: 1})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), shared.Version)
+	_, err = documentServiceImpl.SetACL(bob, "toto", acl, repodocuments.Precondition{})
+	assert.Equal(t, ErrNotOwner, err)
+
+	//the readers read it, the writers write it
+	docFound, err := documentServiceImpl.Get(carol, "toto")
+	assert.Nil(t, err)
+	assert.Equal(t, &acl, docFound.ACL)
+	_, _, err = documentServiceImpl.CreateOrUpdate(carol, models.Document{ID: "toto"}, repodocuments.Precondition{})
+	assert.ErrorIs(t, err, ErrWriteDenied)
+	updated, _, err := documentServiceImpl.CreateOrUpdate(bob, models.Document{ID: "toto", Name: "annual report 2021"}, repodocuments.Precondition{})
+	assert.Nil(t, err)
+	//an update keeps the ACL
+	assert.Equal(t, &acl, updated.ACL)
+	all, _ = documentServiceImpl.GetAll(alice)
+	assert.Equal(t, 2, len(all))
+	revisions, _ = documentServiceImpl.GetRevisions(carol, "toto")
+	assert.Equal(t, 3, len(revisions))
+	found, err := documentServiceImpl.Delete(bob, "toto", repodocuments.Precondition{})
+	assert.Nil(t, err)
+	assert.True(t, found)
+
+	//the trash is shared like the documents
+	trash, _ := documentServiceImpl.List(bob, repodocuments.DocumentQuery{Trashed: true})
+	assert.Equal(t, 1, len(trash.Documents))
+	_, err = documentServiceImpl.Restore(carol, "toto")
+	assert.ErrorIs(t, err, ErrWriteDenied)
+	_, err = documentServiceImpl.Restore(alice, "toto")
+	assert.Nil(t, err)
+}
+
+func TestDocumentServiceImpl_ACLTrashed(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	documentServiceImpl := NewDocumentServiceImpl(&repo)
+	alice := WithActor(context.Background(), "alice")
+	bob := WithActor(context.Background(), "bob")
+	_, _, _ = documentServiceImpl.CreateOrUpdate(alice, models.Document{ID: "toto", Name: "annual report"}, repodocuments.Precondition{})
+	_, _ = documentServiceImpl.Delete(alice, "toto", repodocuments.Precondition{})
+
+	//a document of the trash keeps its ACL, the others cannot write it again
+	_, _, err := documentServiceImpl.CreateOrUpdate(bob, models.Document{ID: "toto", Name: "mine"}, repodocuments.Precondition{})
+	assert.ErrorIs(t, err, ErrWriteDenied)
+	results, err := documentServiceImpl.ApplyBatch(bob, []repodocuments.Operation{{Document: models.Document{ID: "toto"}}}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, ErrWriteDenied, results[0].Err)
+	_, err = documentServiceImpl.Get(alice, "toto")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	//its owner can
+	written, _, err := documentServiceImpl.CreateOrUpdate(alice, models.Document{ID: "toto", Name: "annual report 2021"}, repodocuments.Precondition{})
+	assert.Nil(t, err)
+	assert.Equal(t, "alice", written.ACL.Owner)
+}
+
+func TestDocumentServiceImpl_ACLWithoutOwner(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	documentServiceImpl := NewDocumentServiceImpl(&repo)
+
+	bob := WithActor(context.Background(), "bob")
+	admin := WithAdmin(WithActor(context.Background(), "carol"), true)
+
+	//a document written before the ACLs can be used by anyone, but nobody owns it to take it over
+	repo.DocumentsById.Store("toto", models.Document{ID: "toto", Version: 1})
+	_, err := documentServiceImpl.Get(bob, "toto")
+	assert.Nil(t, err)
+	_, _, err = documentServiceImpl.CreateOrUpdate(bob, models.Document{ID: "toto", Name: "annual report"}, repodocuments.Precondition{})
+	assert.Nil(t, err)
+	_, err = documentServiceImpl.SetACL(bob, "toto", models.DocumentACL{Owner: "bob"}, repodocuments.Precondition{})
+	assert.Equal(t, ErrNotOwner, err)
+	_, err = documentServiceImpl.Get(WithActor(context.Background(), "alice"), "toto")
+	assert.Nil(t, err)
+
+	//an admin gives it an owner
+	shared, err := documentServiceImpl.SetACL(admin, "toto", models.DocumentACL{Owner: "bob"}, repodocuments.Precondition{})
+	assert.Nil(t, err)
+	assert.Equal(t, "bob", shared.ACL.Owner)
+	_, err = documentServiceImpl.Get(WithActor(context.Background(), "alice"), "toto")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = documentServiceImpl.SetACL(bob, "toto", models.DocumentACL{Owner: "bob", Readers: []string{"user:alice"}}, repodocuments.Precondition{})
+	assert.Nil(t, err)
+	//and changes the ACL of the documents it does not own
+	_, err = documentServiceImpl.SetACL(admin, "toto", models.DocumentACL{Owner: "alice"}, repodocuments.Precondition{})
+	assert.Nil(t, err)
+}
+
+func TestDocumentServiceImpl_InvalidACL(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	documentServiceImpl := NewDocumentServiceImpl(&repo)
+	_, _, _ = documentServiceImpl.CreateOrUpdate(context.Background(), models.Document{ID: "toto"}, repodocuments.Precondition{})
+
+	_, err := documentServiceImpl.SetACL(context.Background(), "toto",
+		models.DocumentACL{Readers: []string{"user:bob", "finance"}, Writers: []string{"group:"}}, repodocuments.Precondition{})
+	assert.ErrorIs(t, err, ErrValidation)
+	var invalid *ValidationError
+	assert.ErrorAs(t, err, &invalid)
+	assert.Equal(t, []models.FieldError{
+		{Field: "owner", Message: "owner must be defined"},
+		{Field: "readers[1]", Message: "readers[1] must be user:<id> or group:<id>"},
+		{Field: "writers[0]", Message: "writers[0] must be user:<id> or group:<id>"},
+	}, invalid.Fields)
+}
+
+func TestDocumentServiceImpl_ACLBatch(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	documentServiceImpl := NewDocumentServiceImpl(&repo)
+	_, _, _ = documentServiceImpl.CreateOrUpdate(WithActor(context.Background(), "alice"), models.Document{ID: "toto"}, repodocuments.Precondition{})
+
+	//the documents of another owner are not written, nor any document of an atomic batch having one
+	results, err := documentServiceImpl.ApplyBatch(WithActor(context.Background(), "bob"), []repodocuments.Operation{
+		{Document: models.Document{ID: "titi"}},
+		{Document: models.Document{ID: "toto"}, Delete: true},
+	}, true)
+	assert.Nil(t, err)
+	assert.Equal(t, repodocuments.ErrRolledBack, results[0].Err)
+	assert.Equal(t, ErrWriteDenied, results[1].Err)
+	_, err = repo.GetById("titi")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestDocumentServiceImpl_ACLChanges(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	documentServiceImpl := NewDocumentServiceImpl(&repo)
+
+	ctx, cancel := context.WithCancel(WithActor(context.Background(), "bob"))
+	defer cancel()
+	changes, err := documentServiceImpl.WatchChanges(ctx, "", "")
+	assert.Nil(t, err)
+
+	//the changes of the documents bob cannot read are not sent, nor their deletions
+	alice := WithActor(context.Background(), "alice")
+	_, _, _ = documentServiceImpl.CreateOrUpdate(alice, models.Document{ID: "toto"}, repodocuments.Precondition{})
+	_, _ = documentServiceImpl.Delete(alice, "toto", repodocuments.Precondition{})
+	_, _ = documentServiceImpl.ApplyBatch(alice, []repodocuments.Operation{{Document: models.Document{ID: "tata"}}, {Document: models.Document{ID: "tata"}, Delete: true}}, false)
+	_, _, _ = documentServiceImpl.CreateOrUpdate(WithActor(context.Background(), "bob"), models.Document{ID: "titi"}, repodocuments.Precondition{})
+	_, _ = documentServiceImpl.Delete(WithActor(context.Background(), "bob"), "titi", repodocuments.Precondition{})
+
+	received := receive(t, changes, 2)
+	assert.Equal(t, "titi", received[0].DocumentID)
+	assert.Equal(t, models.ChangeCreated, received[0].Type)
+	assert.Equal(t, "titi", received[1].DocumentID)
+	assert.Equal(t, models.ChangeDeleted, received[1].Type)
+}
+
+func TestContentServiceImpl_ACL(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	contentServiceImpl := NewContentServiceImpl(&repo, &repocontents.InMemoryContentRepo{})
+	repo.DocumentsById.Store("toto", models.Document{ID: "toto", ACL: &models.DocumentACL{Owner: "alice", Readers: []string{"user:bob"}}})
+
+	_, err := contentServiceImpl.PutContent(WithActor(context.Background(), "alice"), "toto", "text/plain", strings.NewReader("hello"))
+	assert.Nil(t, err)
+
+	//a reader gets the content but cannot change it, the others don't see it
+	_, content, err := contentServiceImpl.GetContent(WithActor(context.Background(), "bob"), "toto")
+	assert.Nil(t, err)
+	defer content.Close()
+	_, err = contentServiceImpl.DeleteContent(WithActor(context.Background(), "bob"), "toto")
+	assert.ErrorIs(t, err, ErrWriteDenied)
+	_, _, err = contentServiceImpl.GetContent(WithActor(context.Background(), "carol"), "toto")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
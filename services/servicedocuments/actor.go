@@ -0,0 +1,60 @@
+package servicedocuments
+
+import (
+	"context"
+	"goapi/repositories/repodocuments"
+)
+
+// AnonymousActor is the actor of the writes made without identity
+const AnonymousActor = "anonymous"
+
+type actorKey struct{}
+
+// WithActor returns a context telling who makes the reads and the writes
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFrom returns who makes the reads and the writes of the context, AnonymousActor if nobody is known
+func ActorFrom(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && len(actor) > 0 {
+		return actor
+	}
+	return AnonymousActor
+}
+
+type groupsKey struct{}
+
+// WithGroups returns a context telling the groups of the actor, the documents shared with one of them can be used by the actor
+func WithGroups(ctx context.Context, groups []string) context.Context {
+	return context.WithValue(ctx, groupsKey{}, groups)
+}
+
+// GroupsFrom returns the groups of the actor of the context
+func GroupsFrom(ctx context.Context) []string {
+	groups, _ := ctx.Value(groupsKey{}).([]string)
+	return groups
+}
+
+type adminKey struct{}
+
+// WithAdmin returns a context telling if the actor administers the documents, an admin can change the ACL of any document
+// like the documents written before the ACLs, that have no owner
+func WithAdmin(ctx context.Context, admin bool) context.Context {
+	return context.WithValue(ctx, adminKey{}, admin)
+}
+
+// AdminFrom tells if the actor of the context administers the documents
+func AdminFrom(ctx context.Context) bool {
+	admin, _ := ctx.Value(adminKey{}).(bool)
+	return admin
+}
+
+// PrincipalsFrom returns the principals of the actor of the context and of its groups, the documents shared with one of them can be used
+func PrincipalsFrom(ctx context.Context) []string {
+	principals := []string{repodocuments.UserPrincipal(ActorFrom(ctx))}
+	for _, group := range GroupsFrom(ctx) {
+		principals = append(principals, repodocuments.GroupPrincipal(group))
+	}
+	return principals
+}
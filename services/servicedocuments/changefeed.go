@@ -0,0 +1,118 @@
+package servicedocuments
+
+import (
+	"context"
+	"goapi/models"
+	"goapi/repositories/repodocuments"
+	"strconv"
+	"sync"
+)
+
+const (
+	// ChangeHistorySize is the number of changes kept in memory to resume a feed
+	ChangeHistorySize = 1000
+	//the number of changes a subscriber can be late of before it is dropped
+	subscriberBufferSize = 256
+)
+
+//changeFeed publishes the changes of the documents to the subscribers
+type changeFeed interface {
+	//publish is called after each write of this instance
+	publish(change models.DocumentChange)
+	//subscribe returns the changes after the event lastEventID, the channel is closed when the context is done
+	subscribe(ctx context.Context, lastEventID string) (<-chan models.DocumentChange, error)
+}
+
+//newChangeFeed uses the changes seen by the repository when it can see the writes of all the instances,
+//otherwise the changes published by this instance
+func newChangeFeed(documentRepo repodocuments.DocumentRepository) changeFeed {
+	if watcher, ok := documentRepo.(repodocuments.ChangeWatcher); ok {
+		return &watchedChangeFeed{watcher: watcher}
+	}
+	return &memoryChangeFeed{subscribers: make(map[chan models.DocumentChange]bool)}
+}
+
+//watchedChangeFeed streams the changes seen by the repository, the published changes are already seen by the repository
+type watchedChangeFeed struct {
+	watcher repodocuments.ChangeWatcher
+}
+
+func (f *watchedChangeFeed) publish(models.DocumentChange) {
+}
+
+func (f *watchedChangeFeed) subscribe(ctx context.Context, lastEventID string) (<-chan models.DocumentChange, error) {
+	return f.watcher.Watch(ctx, lastEventID)
+}
+
+//memoryChangeFeed numbers the published changes and keeps the last ones to resume the subscriptions
+type memoryChangeFeed struct {
+	lock        sync.Mutex
+	lastEventID int64
+	history     []models.DocumentChange
+	subscribers map[chan models.DocumentChange]bool
+}
+
+func (f *memoryChangeFeed) publish(change models.DocumentChange) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.lastEventID++
+	change.EventID = strconv.FormatInt(f.lastEventID, 10)
+	f.history = append(f.history, change)
+	if len(f.history) > ChangeHistorySize {
+		//copy so that the dropped changes are not kept by the underlying array
+		f.history = append([]models.DocumentChange(nil), f.history[len(f.history)-ChangeHistorySize:]...)
+	}
+
+	for subscriber := range f.subscribers {
+		select {
+		case subscriber <- change:
+		default:
+			//a subscriber too late is dropped, it can resume from its last event
+			delete(f.subscribers, subscriber)
+			close(subscriber)
+		}
+	}
+}
+
+//since returns the changes after the event lastEventID
+func (f *memoryChangeFeed) since(lastEventID string) ([]models.DocumentChange, error) {
+	if len(lastEventID) == 0 {
+		return nil, nil
+	}
+	last, err := strconv.ParseInt(lastEventID, 10, 64)
+	if err != nil || last < 0 || last > f.lastEventID {
+		return nil, repodocuments.ErrUnknownEvent
+	}
+	missed := int(f.lastEventID - last)
+	if missed > len(f.history) {
+		return nil, repodocuments.ErrUnknownEvent
+	}
+	return f.history[len(f.history)-missed:], nil
+}
+
+func (f *memoryChangeFeed) subscribe(ctx context.Context, lastEventID string) (<-chan models.DocumentChange, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	missed, err := f.since(lastEventID)
+	if err != nil {
+		return nil, err
+	}
+	subscriber := make(chan models.DocumentChange, len(missed)+subscriberBufferSize)
+	for _, change := range missed {
+		subscriber <- change
+	}
+	f.subscribers[subscriber] = true
+
+	go func() {
+		<-ctx.Done()
+		f.lock.Lock()
+		defer f.lock.Unlock()
+		if f.subscribers[subscriber] {
+			delete(f.subscribers, subscriber)
+			close(subscriber)
+		}
+	}()
+	return subscriber, nil
+}
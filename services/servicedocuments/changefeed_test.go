@@ -0,0 +1,115 @@
+package servicedocuments
+
+import (
+	"context"
+	"goapi/models"
+	"goapi/repositories/repodocuments"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//receive reads n changes from the channel
+func receive(t *testing.T, changes <-chan models.DocumentChange, n int) []models.DocumentChange {
+	received := make([]models.DocumentChange, 0, n)
+	for i := 0; i < n; i++ {
+		change, open := <-changes
+		assert.True(t, open)
+		received = append(received, change)
+	}
+	return received
+}
+
+func TestDocumentServiceImpl_WatchChanges(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	documentServiceImpl := NewDocumentServiceImpl(&repo)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	changes, err := documentServiceImpl.WatchChanges(ctx, "", "")
+	assert.Nil(t, err)
+
+	_, _, _ = documentServiceImpl.CreateOrUpdate(context.Background(), models.Document{ID: "toto"}, repodocuments.Precondition{})
+	_, _, _ = documentServiceImpl.CreateOrUpdate(context.Background(), models.Document{ID: "toto", Name: "nameToto"}, repodocuments.Precondition{})
+	_, _ = documentServiceImpl.Delete(context.Background(), "toto", repodocuments.Precondition{})
+	_, _ = documentServiceImpl.Restore(context.Background(), "toto")
+
+	received := receive(t, changes, 4)
+	assert.Equal(t, "1", received[0].EventID)
+	assert.Equal(t, models.ChangeCreated, received[0].Type)
+	assert.Equal(t, models.Document{ID: "toto", Version: 1}, withoutAudit(*received[0].Document))
+	assert.Equal(t, models.ChangeUpdated, received[1].Type)
+	assert.Equal(t, "nameToto", received[1].Document.Name)
+	assert.Equal(t, models.ChangeDeleted, received[2].Type)
+	assert.Equal(t, "toto", received[2].DocumentID)
+	assert.Nil(t, received[2].Document)
+	assert.Equal(t, models.ChangeCreated, received[3].Type)
+	assert.Equal(t, "4", received[3].EventID)
+
+	//the channel is closed with the context
+	cancel()
+	_, open := <-changes
+	assert.False(t, open)
+}
+
+func TestDocumentServiceImpl_WatchChangesWithPrefix(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	documentServiceImpl := NewDocumentServiceImpl(&repo)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes, err := documentServiceImpl.WatchChanges(ctx, "", "invoice-")
+	assert.Nil(t, err)
+
+	_, _ = documentServiceImpl.ApplyBatch(context.Background(), []repodocuments.Operation{
+		{Document: models.Document{ID: "report-1"}},
+		{Document: models.Document{ID: "invoice-1"}},
+		{Document: models.Document{ID: "invoice-2"}},
+	}, false)
+
+	received := receive(t, changes, 2)
+	assert.Equal(t, "invoice-1", received[0].DocumentID)
+	assert.Equal(t, "invoice-2", received[1].DocumentID)
+}
+
+func TestDocumentServiceImpl_WatchChangesResumed(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	documentServiceImpl := NewDocumentServiceImpl(&repo)
+
+	for i := 0; i < 3; i++ {
+		_, _, _ = documentServiceImpl.CreateOrUpdate(context.Background(), models.Document{ID: "toto" + strconv.Itoa(i)}, repodocuments.Precondition{})
+	}
+
+	//the changes after the last event are sent first
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes, err := documentServiceImpl.WatchChanges(ctx, "1", "")
+	assert.Nil(t, err)
+	_, _, _ = documentServiceImpl.CreateOrUpdate(context.Background(), models.Document{ID: "toto3"}, repodocuments.Precondition{})
+
+	received := receive(t, changes, 3)
+	assert.Equal(t, "toto1", received[0].DocumentID)
+	assert.Equal(t, "toto2", received[1].DocumentID)
+	assert.Equal(t, "toto3", received[2].DocumentID)
+
+	//a feed cannot be resumed from an unknown event
+	_, err = documentServiceImpl.WatchChanges(ctx, "10", "")
+	assert.Equal(t, repodocuments.ErrUnknownEvent, err)
+	_, err = documentServiceImpl.WatchChanges(ctx, "abc", "")
+	assert.Equal(t, repodocuments.ErrUnknownEvent, err)
+}
+
+func TestDocumentServiceImpl_WatchChangesExpired(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	documentServiceImpl := NewDocumentServiceImpl(&repo)
+
+	for i := 0; i < ChangeHistorySize+1; i++ {
+		_, _, _ = documentServiceImpl.CreateOrUpdate(context.Background(), models.Document{ID: "toto"}, repodocuments.Precondition{})
+	}
+
+	//the first change is not kept anymore
+	_, err := documentServiceImpl.WatchChanges(context.Background(), "0", "")
+	assert.Equal(t, repodocuments.ErrUnknownEvent, err)
+	_, err = documentServiceImpl.WatchChanges(context.Background(), "1", "")
+	assert.Nil(t, err)
+}
@@ -0,0 +1,89 @@
+package servicedocuments
+
+import (
+	"context"
+	"goapi/models"
+	"goapi/repositories/repocontents"
+	"goapi/repositories/repodocuments"
+	"io"
+)
+
+var ErrNoContent = NewError(ErrNotFound, "the document has no content")
+
+// ContentService manages the binary content attached to the documents of the tenant of the context
+type ContentService interface {
+	PutContent(ctx context.Context, id string, contentType string, content io.Reader) (models.ContentMetadata, error)
+	// GetContent returns the metadata and a reader on the content, the reader must be closed
+	GetContent(ctx context.Context, id string) (models.ContentMetadata, io.ReadSeekCloser, error)
+	DeleteContent(ctx context.Context, id string) (bool, error)
+}
+
+// ContentServiceImpl Default implementation for ContentService
+type ContentServiceImpl struct {
+	documentRepo repodocuments.DocumentRepository
+	contentRepo  repocontents.ContentRepository
+}
+
+func NewContentServiceImpl(documentRepo repodocuments.DocumentRepository, contentRepo repocontents.ContentRepository) *ContentServiceImpl {
+	return &ContentServiceImpl{documentRepo: documentRepo, contentRepo: contentRepo}
+}
+
+//contents is the repository of the contents of the tenant of the context
+func (s *ContentServiceImpl) contents(ctx context.Context) repocontents.ContentRepository {
+	return s.contentRepo.ForTenant(TenantFrom(ctx))
+}
+
+//checkDocument tells if the document exists and the actor can read it, and write it when write is true.
+//The content of a trashed document cannot be used.
+func (s *ContentServiceImpl) checkDocument(ctx context.Context, id string, write bool) error {
+	document, err := s.documentRepo.ForTenant(TenantFrom(ctx)).GetById(id)
+	switch {
+	case err != nil:
+		return err
+	case !repodocuments.CanRead(document.ACL, PrincipalsFrom(ctx)):
+		return ErrNotFound
+	case write:
+		return checkWrite(ctx, document)
+	}
+	return nil
+}
+
+// PutContent stores the content of document id, replacing the previous one
+func (s *ContentServiceImpl) PutContent(ctx context.Context, id string, contentType string, content io.Reader) (models.ContentMetadata, error) {
+	if err := s.checkDocument(ctx, id, true); err != nil {
+		return models.ContentMetadata{}, err
+	}
+	return s.contents(ctx).Put(id, contentType, content)
+}
+
+// GetContent returns the content of document id
+func (s *ContentServiceImpl) GetContent(ctx context.Context, id string) (models.ContentMetadata, io.ReadSeekCloser, error) {
+	if err := s.checkDocument(ctx, id, false); err != nil {
+		return models.ContentMetadata{}, nil, err
+	}
+	metadata, content, found, err := s.contents(ctx).Open(id)
+	if err != nil {
+		return models.ContentMetadata{}, nil, err
+	}
+	if !found {
+		return models.ContentMetadata{}, nil, ErrNoContent
+	}
+	return metadata, content, nil
+}
+
+// DeleteContent deletes the content of document id
+func (s *ContentServiceImpl) DeleteContent(ctx context.Context, id string) (bool, error) {
+	if err := s.checkDocument(ctx, id, true); err != nil {
+		return false, err
+	}
+	return s.contents(ctx).Delete(id)
+}
+
+// DocumentsPurged deletes the contents of the documents purged from the trash (interface PurgeObserver implementation)
+func (s *ContentServiceImpl) DocumentsPurged(ctx context.Context, ids []string) {
+	for _, id := range ids {
+		if _, err := s.contents(ctx).Delete(id); err != nil {
+			LoggerFrom(ctx).Errorf("Cannot delete the content of purged document %s [err=%s]", id, err)
+		}
+	}
+}
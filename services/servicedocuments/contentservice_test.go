@@ -0,0 +1,110 @@
+package servicedocuments
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"goapi/config"
+	"goapi/models"
+	"goapi/repositories/repocontents"
+	"goapi/repositories/repodocuments"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContentServiceImpl_PutAndGet(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	contentServiceImpl := NewContentServiceImpl(&repo, &repocontents.InMemoryContentRepo{})
+	repo.DocumentsById.Store("toto", models.Document{ID: "toto"})
+
+	metadata, err := contentServiceImpl.PutContent(context.Background(), "toto", "text/plain", strings.NewReader("hello world"))
+	assert.Nil(t, err)
+	sum := sha256.Sum256([]byte("hello world"))
+	assert.Equal(t, "toto", metadata.DocumentID)
+	assert.Equal(t, "text/plain", metadata.ContentType)
+	assert.Equal(t, int64(11), metadata.Size)
+	assert.Equal(t, hex.EncodeToString(sum[:]), metadata.SHA256)
+	assert.False(t, metadata.UploadedAt.IsZero())
+
+	stored, content, err := contentServiceImpl.GetContent(context.Background(), "toto")
+	assert.Nil(t, err)
+	defer content.Close()
+	assert.Equal(t, metadata, stored)
+
+	//the content can be read from any position
+	_, err = content.Seek(6, io.SeekStart)
+	assert.Nil(t, err)
+	data, err := ioutil.ReadAll(content)
+	assert.Nil(t, err)
+	assert.Equal(t, "world", string(data))
+
+	//a new content replaces the previous one
+	_, err = contentServiceImpl.PutContent(context.Background(), "toto", "text/plain", strings.NewReader("bye"))
+	assert.Nil(t, err)
+	stored, content, err = contentServiceImpl.GetContent(context.Background(), "toto")
+	assert.Nil(t, err)
+	defer content.Close()
+	assert.Equal(t, int64(3), stored.Size)
+}
+
+func TestContentServiceImpl_DocumentNotFound(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	contentServiceImpl := NewContentServiceImpl(&repo, &repocontents.InMemoryContentRepo{})
+
+	_, err := contentServiceImpl.PutContent(context.Background(), "toto", "text/plain", strings.NewReader("hello"))
+	assert.Equal(t, repodocuments.ErrNotFound, err)
+	_, _, err = contentServiceImpl.GetContent(context.Background(), "toto")
+	assert.Equal(t, repodocuments.ErrNotFound, err)
+	_, err = contentServiceImpl.DeleteContent(context.Background(), "toto")
+	assert.Equal(t, repodocuments.ErrNotFound, err)
+}
+
+func TestContentServiceImpl_Delete(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	contentServiceImpl := NewContentServiceImpl(&repo, &repocontents.InMemoryContentRepo{})
+	repo.DocumentsById.Store("toto", models.Document{ID: "toto"})
+
+	found, err := contentServiceImpl.DeleteContent(context.Background(), "toto")
+	assert.Nil(t, err)
+	assert.False(t, found)
+
+	_, _ = contentServiceImpl.PutContent(context.Background(), "toto", "text/plain", strings.NewReader("hello"))
+	found, err = contentServiceImpl.DeleteContent(context.Background(), "toto")
+	assert.Nil(t, err)
+	assert.True(t, found)
+
+	_, _, err = contentServiceImpl.GetContent(context.Background(), "toto")
+	assert.Equal(t, ErrNoContent, err)
+}
+
+func TestContentServiceImpl_PurgedWithDocument(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	contentRepo := repocontents.InMemoryContentRepo{}
+	documentServiceImpl, _ := NewDocumentServiceImplWithConfig(&repo, &config.DocumentsConfig{TrashRetentionHours: 1})
+	contentServiceImpl := NewContentServiceImpl(&repo, &contentRepo)
+	documentServiceImpl.RegisterPurgeObserver(contentServiceImpl)
+
+	_, _, _ = documentServiceImpl.CreateOrUpdate(context.Background(), models.Document{ID: "toto"}, repodocuments.Precondition{})
+	_, _ = contentServiceImpl.PutContent(context.Background(), "toto", "text/plain", strings.NewReader("hello"))
+
+	//the content of a trashed document is kept, but cannot be read
+	_, _ = documentServiceImpl.Delete(context.Background(), "toto", repodocuments.Precondition{})
+	_, _, err := contentServiceImpl.GetContent(context.Background(), "toto")
+	assert.Equal(t, repodocuments.ErrNotFound, err)
+	_, _, found, _ := contentRepo.Open("toto")
+	assert.True(t, found)
+
+	//it is deleted when the document is purged
+	deletedAt := time.Now().UTC().Add(-2 * time.Hour)
+	repo.DocumentsById.Store("toto", models.Document{ID: "toto", DeletedAt: &deletedAt})
+	purged, err := documentServiceImpl.PurgeTrash()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), purged)
+	_, _, found, _ = contentRepo.Open("toto")
+	assert.False(t, found)
+}
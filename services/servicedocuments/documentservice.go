@@ -1,42 +1,471 @@
 package servicedocuments
 
 import (
+	"context"
+	"goapi/config"
 	"goapi/models"
 	"goapi/repositories/repodocuments"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-co-op/gocron"
+	log "github.com/sirupsen/logrus"
 )
 
+// DefaultMaxRevisions is the number of revisions kept per document when there is no configuration
+const DefaultMaxRevisions = 20
+
+// DefaultPurgeIntervalMinutes is how often the trash is purged when there is no configuration
+const DefaultPurgeIntervalMinutes = 60
+
+var ErrRevisionDeleted = NewError(ErrValidation, "the revision is a deletion")
+
+// PurgeObserver is notified of the documents permanently removed from the trash, the context tells their tenant
+type PurgeObserver interface {
+	DocumentsPurged(ctx context.Context, ids []string)
+}
+
+// DocumentService manages the documents of the tenant of the context, the documents of the other tenants are never seen.
+// The actor of the context only sees the documents it can read, and only writes the ones it can write.
 type DocumentService interface {
-	Get(id string) (models.Document, error)
-	GetAll() ([]models.Document, error)
-	CreateOrUpdate(document models.Document) (bool, error)
-	Delete(id string) (bool, error)
+	// Get returns the document, ErrNotFound if the actor cannot read it
+	Get(ctx context.Context, id string) (models.Document, error)
+	GetAll(ctx context.Context) ([]models.Document, error)
+	// Export streams the documents matching the filter to f, it stops at the first error of f
+	Export(ctx context.Context, filter repodocuments.DocumentFilter, f func(document models.Document) error) error
+	List(ctx context.Context, query repodocuments.DocumentQuery) (models.DocumentPage, error)
+	Search(ctx context.Context, query repodocuments.SearchQuery) (models.SearchPage, error)
+	// CreateOrUpdate writes the document, the actor of the context is its creator or its last modifier.
+	// A new document is owned by the actor, an existing one keeps its ACL.
+	CreateOrUpdate(ctx context.Context, document models.Document, precondition repodocuments.Precondition) (models.Document, bool, error)
+	// Patch modifies the document with a patch of the given type, without losing the concurrent writes
+	Patch(ctx context.Context, id string, patchType PatchType, patch []byte, precondition repodocuments.Precondition) (models.Document, error)
+	Delete(ctx context.Context, id string, precondition repodocuments.Precondition) (bool, error)
+	Restore(ctx context.Context, id string) (models.Document, error)
+	// SetACL replaces the ACL of the document, only its owner can
+	SetACL(ctx context.Context, id string, acl models.DocumentACL, precondition repodocuments.Precondition) (models.Document, error)
+	// PurgeTrash purges the trash of all the tenants
+	PurgeTrash() (int64, error)
+	ApplyBatch(ctx context.Context, operations []repodocuments.Operation, atomic bool) ([]repodocuments.OperationResult, error)
+	GetRevisions(ctx context.Context, id string) ([]models.DocumentRevision, error)
+	GetRevision(ctx context.Context, id string, revision int64) (models.DocumentRevision, bool, error)
+	RestoreRevision(ctx context.Context, id string, revision int64, precondition repodocuments.Precondition) (models.Document, bool, error)
+	// WatchChanges streams the changes of the documents whose id starts with prefix, after the event lastEventID.
+	// The channel is closed when the context is done.
+	WatchChanges(ctx context.Context, lastEventID string, prefix string) (<-chan models.DocumentChange, error)
+	// Validate checks the document against the validation rules, the error lists all the invalid fields
+	Validate(document models.Document) error
 }
 
 // DocumentServiceImpl Default implementation for DocumentService
 type DocumentServiceImpl struct {
-	documentRepo repodocuments.DocumentRepository
+	documentRepo   repodocuments.DocumentRepository
+	maxRevisions   int
+	trashRetention time.Duration
+	purgeInterval  int
+	purgeScheduler *gocron.Scheduler
+	purgeObservers []PurgeObserver
+	validator      *Validator
+	//the change feed of each tenant
+	changes     map[string]changeFeed
+	changesLock sync.Mutex
 }
 
 func NewDocumentServiceImpl(documentRepo repodocuments.DocumentRepository) *DocumentServiceImpl {
-	return &DocumentServiceImpl{documentRepo}
+	//without validation rules, the configuration is always valid
+	documentService, _ := NewDocumentServiceImplWithConfig(documentRepo, &config.DocumentsConfig{MaxRevisions: DefaultMaxRevisions})
+	return documentService
+}
+
+// NewDocumentServiceImplWithConfig returns the service configured by configuration, an error if its validation rules are invalid
+func NewDocumentServiceImplWithConfig(documentRepo repodocuments.DocumentRepository, configuration *config.DocumentsConfig) (*DocumentServiceImpl, error) {
+	purgeInterval := configuration.PurgeIntervalMinutes
+	if purgeInterval <= 0 {
+		purgeInterval = DefaultPurgeIntervalMinutes
+	}
+	//the rules are checked once at startup, like the rest of the configuration
+	validator, err := NewValidator(&configuration.Validation)
+	if err != nil {
+		return nil, err
+	}
+	return &DocumentServiceImpl{
+		documentRepo:   documentRepo,
+		maxRevisions:   configuration.MaxRevisions,
+		trashRetention: time.Duration(configuration.TrashRetentionHours) * time.Hour,
+		purgeInterval:  purgeInterval,
+		changes:        make(map[string]changeFeed),
+		validator:      validator,
+	}, nil
+}
+
+//repo is the repository of the tenant of the context
+func (s *DocumentServiceImpl) repo(ctx context.Context) repodocuments.DocumentRepository {
+	return s.documentRepo.ForTenant(TenantFrom(ctx))
+}
+
+//feed is the change feed of the tenant of the context, created on its first use
+func (s *DocumentServiceImpl) feed(ctx context.Context) changeFeed {
+	s.changesLock.Lock()
+	defer s.changesLock.Unlock()
+
+	tenant := TenantFrom(ctx)
+	feed, found := s.changes[tenant]
+	if !found {
+		feed = newChangeFeed(s.repo(ctx))
+		s.changes[tenant] = feed
+	}
+	return feed
+}
+
+// Get returns the document with ID, ErrNotFound if there is none.
+// The documents the actor cannot read are not told apart from the missing ones.
+func (s *DocumentServiceImpl) Get(ctx context.Context, id string) (models.Document, error) {
+	document, err := s.repo(ctx).GetById(id)
+	if err == nil && !repodocuments.CanRead(document.ACL, PrincipalsFrom(ctx)) {
+		return models.Document{}, ErrNotFound
+	}
+	return document, err
+}
+
+// GetAll return all documents the actor can read sorted by ID
+func (s *DocumentServiceImpl) GetAll(ctx context.Context) ([]models.Document, error) {
+	return s.repo(ctx).GetAll(repodocuments.DocumentFilter{ReadableBy: PrincipalsFrom(ctx)})
+}
+
+// Export calls f with each document matching the filter the actor can read, without loading them all, and stops at the first error of f
+func (s *DocumentServiceImpl) Export(ctx context.Context, filter repodocuments.DocumentFilter, f func(document models.Document) error) error {
+	filter.ReadableBy = PrincipalsFrom(ctx)
+	return s.repo(ctx).Export(filter, f)
+}
+
+// List returns the page of documents described by the query, among the ones the actor can read
+func (s *DocumentServiceImpl) List(ctx context.Context, query repodocuments.DocumentQuery) (models.DocumentPage, error) {
+	query.Filter.ReadableBy = PrincipalsFrom(ctx)
+	return s.repo(ctx).List(query)
+}
+
+// Search returns the documents matching the text, the most relevant first, with the snippets of the matching fields
+func (s *DocumentServiceImpl) Search(ctx context.Context, query repodocuments.SearchQuery) (models.SearchPage, error) {
+	query.ReadableBy = PrincipalsFrom(ctx)
+	page, err := s.repo(ctx).Search(query)
+	if err != nil {
+		return page, err
+	}
+	addHighlights(query.Text, &page)
+	return page, nil
+}
+
+// CreateOrUpdate creates or update given document if the precondition holds, and returns it with its new version
+func (s *DocumentServiceImpl) CreateOrUpdate(ctx context.Context, documentToCreate models.Document, precondition repodocuments.Precondition) (models.Document, bool, error) {
+	if err := s.Validate(documentToCreate); err != nil {
+		return models.Document{}, false, err
+	}
+	if _, err := s.checkWritable(ctx, documentToCreate.ID); err != nil {
+		return models.Document{}, false, err
+	}
+	stampAudit(&documentToCreate, ActorFrom(ctx), auditTime())
+	stampOwner(&documentToCreate, ActorFrom(ctx))
+	document, updated, err := s.repo(ctx).CreateOrUpdate(documentToCreate, precondition)
+	if err == nil {
+		s.addRevision(ctx, document.ID, &document)
+		s.notify(ctx, document.ID, &document, updated)
+	}
+	return document, updated, err
+}
+
+// Delete moves document id to the trash if the precondition holds
+func (s *DocumentServiceImpl) Delete(ctx context.Context, idToDelete string, precondition repodocuments.Precondition) (bool, error) {
+	acl, err := s.checkWritable(ctx, idToDelete)
+	if err != nil {
+		return false, err
+	}
+	found, err := s.repo(ctx).Delete(idToDelete, precondition)
+	if err == nil && found {
+		s.addRevision(ctx, idToDelete, nil)
+		s.notifyDeletion(ctx, idToDelete, acl)
+	}
+	return found, err
+}
+
+// Restore moves back document id from the trash, if the actor can write it
+func (s *DocumentServiceImpl) Restore(ctx context.Context, id string) (models.Document, error) {
+	trashed, err := s.trashed(ctx, id)
+	if err != nil {
+		return models.Document{}, err
+	}
+	if err := checkWrite(ctx, trashed); err != nil {
+		return models.Document{}, err
+	}
+
+	document, err := s.repo(ctx).Restore(id)
+	if err == nil {
+		s.addRevision(ctx, id, &document)
+		s.notify(ctx, id, &document, false)
+	}
+	return document, err
+}
+
+// RegisterPurgeObserver registers an observer of the purges of the trash
+func (s *DocumentServiceImpl) RegisterPurgeObserver(o PurgeObserver) {
+	s.purgeObservers = append(s.purgeObservers, o)
+}
+
+// PurgeTrash permanently removes the documents that stayed in the trash longer than the retention period, in all the tenants.
+// A tenant failing to be purged does not prevent the purge of the others, the first error is returned.
+func (s *DocumentServiceImpl) PurgeTrash() (int64, error) {
+	if s.trashRetention <= 0 {
+		return 0, nil
+	}
+	tenants, err := s.documentRepo.Tenants()
+	if err != nil {
+		log.Errorf("Cannot list the tenants to purge [err=%s]", err)
+		return 0, err
+	}
+
+	deletedBefore := time.Now().UTC().Add(-s.trashRetention)
+	var total int64
+	var firstErr error
+	for _, tenant := range tenants {
+		ctx := WithTenant(context.Background(), tenant)
+		purged, err := s.repo(ctx).Purge(deletedBefore)
+		if len(purged) > 0 {
+			for _, o := range s.purgeObservers {
+				o.DocumentsPurged(ctx, purged)
+			}
+			LoggerFrom(ctx).Infof("%d documents purged from the trash", len(purged))
+		}
+		if err != nil {
+			LoggerFrom(ctx).Errorf("Cannot purge the trash [err=%s]", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		total += int64(len(purged))
+	}
+	return total, firstErr
+}
+
+// StartPurge schedules the purge of the trash, unless the trash is kept forever
+func (s *DocumentServiceImpl) StartPurge() {
+	if s.trashRetention <= 0 || s.purgeScheduler != nil {
+		return
+	}
+	s.purgeScheduler = gocron.NewScheduler(time.UTC)
+	_, err := s.purgeScheduler.Every(s.purgeInterval).Minutes().Do(func() {
+		//the purge of each tenant is logged with its tenant
+		_, _ = s.PurgeTrash()
+	})
+	if err != nil {
+		log.Errorf("Cannot schedule the purge of the trash [err=%s]", err)
+		return
+	}
+	s.purgeScheduler.StartAsync()
+}
+
+// StopPurge stops the scheduled purge of the trash
+func (s *DocumentServiceImpl) StopPurge() {
+	if s.purgeScheduler != nil {
+		s.purgeScheduler.Stop()
+		s.purgeScheduler = nil
+	}
+}
+
+// ApplyBatch applies the operations in order, all or none of them when atomic
+func (s *DocumentServiceImpl) ApplyBatch(ctx context.Context, operations []repodocuments.Operation, atomic bool) ([]repodocuments.OperationResult, error) {
+	//the operations of a batch are made at the same time
+	actor, now := ActorFrom(ctx), auditTime()
+	stamped := make([]repodocuments.Operation, len(operations))
+	for i, operation := range operations {
+		if !operation.Delete {
+			stampAudit(&operation.Document, actor, now)
+			stampOwner(&operation.Document, actor)
+		}
+		stamped[i] = operation
+	}
+	operations = stamped
+
+	//the invalid documents and the ones the actor cannot write are not written, nor any document of an atomic batch having one
+	results := make([]repodocuments.OperationResult, len(operations))
+	acls := make([]*models.DocumentACL, len(operations))
+	valid := make([]repodocuments.Operation, 0, len(operations))
+	positions := make([]int, 0, len(operations))
+	for i, operation := range operations {
+		if !operation.Delete {
+			if err := s.Validate(operation.Document); err != nil {
+				results[i].Err = err
+				continue
+			}
+		}
+		acl, err := s.checkWritable(ctx, operation.Document.ID)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		acls[i] = acl
+		valid = append(valid, operation)
+		positions = append(positions, i)
+	}
+	if atomic && len(valid) < len(operations) {
+		for _, position := range positions {
+			results[position].Err = repodocuments.ErrRolledBack
+		}
+		return results, nil
+	}
+	if len(valid) == 0 {
+		return results, nil
+	}
+
+	//a document deleted after a write of the same batch has the ACL of the write, not the one checked before the batch
+	outcomes, err := s.repo(ctx).ApplyBatch(valid, atomic)
+	for k, outcome := range outcomes {
+		i := positions[k]
+		results[i] = outcome
+		if outcome.Err != nil {
+			continue
+		}
+		if operations[i].Delete {
+			s.addRevision(ctx, operations[i].Document.ID, nil)
+			s.notifyDeletion(ctx, operations[i].Document.ID, acls[i])
+		} else {
+			document := outcome.Document
+			s.addRevision(ctx, document.ID, &document)
+			s.notify(ctx, document.ID, &document, outcome.Existed)
+			for j := i + 1; j < len(operations); j++ {
+				if operations[j].Document.ID == document.ID {
+					acls[j] = document.ACL
+				}
+			}
+		}
+	}
+	return results, err
+}
+
+// Validate checks the document against the validation rules of the configuration
+func (s *DocumentServiceImpl) Validate(document models.Document) error {
+	return s.validator.Validate(document)
+}
+
+// GetRevisions returns the kept revisions of a document, the oldest first, none if the actor cannot read the document
+func (s *DocumentServiceImpl) GetRevisions(ctx context.Context, id string) ([]models.DocumentRevision, error) {
+	revisions, err := s.repo(ctx).GetRevisions(id)
+	if err != nil || readableRevisions(ctx, revisions) {
+		return revisions, err
+	}
+	return []models.DocumentRevision{}, nil
+}
+
+// GetRevision returns a revision of a document, if the actor can read the document
+func (s *DocumentServiceImpl) GetRevision(ctx context.Context, id string, revision int64) (models.DocumentRevision, bool, error) {
+	revisions, err := s.GetRevisions(ctx, id)
+	for _, stored := range revisions {
+		if stored.Revision == revision {
+			return stored, true, nil
+		}
+	}
+	return models.DocumentRevision{}, false, err
+}
+
+// RestoreRevision writes back the document as it was in the revision, that makes a new revision
+func (s *DocumentServiceImpl) RestoreRevision(ctx context.Context, id string, revision int64, precondition repodocuments.Precondition) (models.Document, bool, error) {
+	stored, found, err := s.GetRevision(ctx, id, revision)
+	if err != nil {
+		return models.Document{}, false, err
+	}
+	if !found {
+		return models.Document{}, false, repodocuments.ErrNotFound
+	}
+	if stored.Deleted || stored.Document == nil {
+		return models.Document{}, false, ErrRevisionDeleted
+	}
+
+	document := *stored.Document
+	document.Version = 0
+	return s.CreateOrUpdate(ctx, document, precondition)
+}
+
+// WatchChanges streams the changes of the documents whose id starts with prefix
+func (s *DocumentServiceImpl) WatchChanges(ctx context.Context, lastEventID string, prefix string) (<-chan models.DocumentChange, error) {
+	changes, err := s.feed(ctx).subscribe(ctx, lastEventID)
+	if err != nil {
+		return changes, err
+	}
+
+	//the changes of the documents the actor cannot read are not sent, a deletion has no document but keeps the ACL of the deleted one
+	principals := PrincipalsFrom(ctx)
+	filtered := make(chan models.DocumentChange)
+	go func() {
+		defer close(filtered)
+		for change := range changes {
+			if !strings.HasPrefix(change.DocumentID, prefix) {
+				continue
+			}
+			if !repodocuments.CanRead(change.ACL, principals) {
+				continue
+			}
+			select {
+			case filtered <- change:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return filtered, nil
+}
+
+//auditTime is the time of a write, at the precision of the times stored by mongo
+func auditTime() time.Time {
+	return time.Now().UTC().Truncate(time.Millisecond)
+}
+
+//stampAudit replaces the audit fields sent by the client, the repository keeps the creation of a stored document
+func stampAudit(document *models.Document, actor string, now time.Time) {
+	document.CreatedAt = now
+	document.CreatedBy = actor
+	document.UpdatedAt = now
+	document.UpdatedBy = actor
 }
 
-// Get returns the document with ID.
-func (s *DocumentServiceImpl) Get(id string) (models.Document, error) {
-	return s.documentRepo.GetById(id)
+//stampOwner replaces the ACL sent by the client, the repository keeps the ACL of a stored document
+func stampOwner(document *models.Document, actor string) {
+	document.ACL = &models.DocumentACL{Owner: actor, Readers: []string{}, Writers: []string{}}
 }
 
-// GetAll return all documents sorted by ID
-func (s *DocumentServiceImpl) GetAll() ([]models.Document, error) {
-	return s.documentRepo.GetAll()
+//notify publishes the change made by a write to the feed of its tenant
+func (s *DocumentServiceImpl) notify(ctx context.Context, id string, document *models.Document, existed bool) {
+	change := models.DocumentChange{
+		Type:       models.ChangeCreated,
+		DocumentID: id,
+		Document:   document,
+		Timestamp:  time.Now().UTC(),
+		ACL:        document.ACL,
+	}
+	if existed {
+		change.Type = models.ChangeUpdated
+	}
+	s.feed(ctx).publish(change)
 }
 
-// CreateOrUpdate creates or update given document
-func (s *DocumentServiceImpl) CreateOrUpdate(documentToCreate models.Document) (bool, error) {
-	return s.documentRepo.CreateOrUpdate(documentToCreate)
+//notifyDeletion publishes the deletion of a document to the feed of its tenant, with the ACL the document had
+func (s *DocumentServiceImpl) notifyDeletion(ctx context.Context, id string, acl *models.DocumentACL) {
+	s.feed(ctx).publish(models.DocumentChange{
+		Type:       models.ChangeDeleted,
+		DocumentID: id,
+		Timestamp:  time.Now().UTC(),
+		ACL:        acl,
+	})
 }
 
-// Delete delete document id
-func (s *DocumentServiceImpl) Delete(idToDelete string) (bool, error) {
-	return s.documentRepo.Delete(idToDelete)
+//addRevision keeps the state of the document after a write, a nil document is a deletion.
+//The write is already done, so a failure is only logged.
+func (s *DocumentServiceImpl) addRevision(ctx context.Context, id string, document *models.Document) {
+	revision := models.DocumentRevision{
+		DocumentID: id,
+		Timestamp:  time.Now().UTC(),
+		Deleted:    document == nil,
+		Document:   document,
+	}
+	if _, err := s.repo(ctx).AddRevision(revision, s.maxRevisions); err != nil {
+		LoggerFrom(ctx).Errorf("Cannot keep the revision of document %s [err=%s]", id, err)
+	}
 }
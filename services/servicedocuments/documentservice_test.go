@@ -1,9 +1,15 @@
 package servicedocuments
 
 import (
+	"context"
+	"goapi/config"
 	"goapi/models"
 	"goapi/repositories/repodocuments"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -12,8 +18,9 @@ func TestDocumentServiceImpl_CreateOrUpdateWithCreation(t *testing.T) {
 	repo := repodocuments.InMemoryDocumentRepo{}
 	documentServiceImpl := NewDocumentServiceImpl(&repo)
 
-	updated, err := documentServiceImpl.CreateOrUpdate(models.Document{ID: "toto", Description: "descToto", Name: "nameToto"})
+	doc, updated, err := documentServiceImpl.CreateOrUpdate(context.Background(), models.Document{ID: "toto", Description: "descToto", Name: "nameToto"}, repodocuments.Precondition{})
 	assert.Nil(t, err)
+	assert.Equal(t, int64(1), doc.Version)
 	length := 0
 	repo.DocumentsById.Range(func(_, _ interface{}) bool {
 		length++
@@ -23,16 +30,33 @@ func TestDocumentServiceImpl_CreateOrUpdateWithCreation(t *testing.T) {
 	assert.False(t, updated)
 }
 
+//withoutAudit clears the audit fields and the ACL of a document, their values depend on the time and the actor of the test
+func withoutAudit(document models.Document) models.Document {
+	document.CreatedAt, document.UpdatedAt = time.Time{}, time.Time{}
+	document.CreatedBy, document.UpdatedBy = "", ""
+	document.ACL = nil
+	return document
+}
+
+func resultsWithoutAudit(results []repodocuments.OperationResult) []repodocuments.OperationResult {
+	for i := range results {
+		results[i].Document = withoutAudit(results[i].Document)
+	}
+	return results
+}
+
 func TestDocumentServiceImpl_CreateOrUpdateWithUpdate(t *testing.T) {
 	repo := repodocuments.InMemoryDocumentRepo{}
 	documentServiceImpl := NewDocumentServiceImpl(&repo)
 
-	doc := models.Document{ID: "toto", Description: "descToto", Name: "nameToto"}
+	doc := models.Document{ID: "toto", Description: "descToto", Name: "nameToto", Version: 1}
 	repo.DocumentsById.Store("toto", doc)
 
 	docUpdate := models.Document{ID: doc.ID, Description: "descUpdateToto", Name: "nameUpdateToto"}
-	updated, err := documentServiceImpl.CreateOrUpdate(docUpdate)
+	docUpdated, updated, err := documentServiceImpl.CreateOrUpdate(context.Background(), docUpdate, repodocuments.Precondition{})
 	assert.Nil(t, err)
+	docUpdate.Version = 2
+	assert.Equal(t, docUpdate, withoutAudit(docUpdated))
 	length := 0
 	repo.DocumentsById.Range(func(_, _ interface{}) bool {
 		length++
@@ -41,7 +65,7 @@ func TestDocumentServiceImpl_CreateOrUpdateWithUpdate(t *testing.T) {
 	assert.Equal(t, 1, length)
 	assert.True(t, updated)
 	docFound, _ := repo.DocumentsById.Load(doc.ID)
-	assert.Equal(t, docFound.(models.Document), docUpdate)
+	assert.Equal(t, withoutAudit(docFound.(models.Document)), docUpdate)
 }
 
 func TestDocumentServiceImpl_DeleteExisting(t *testing.T) {
@@ -51,22 +75,28 @@ func TestDocumentServiceImpl_DeleteExisting(t *testing.T) {
 	doc := models.Document{ID: "toto", Description: "descToto", Name: "nameToto"}
 	repo.DocumentsById.Store("toto", doc)
 
-	found, err := documentServiceImpl.Delete(doc.ID)
+	found, err := documentServiceImpl.Delete(context.Background(), doc.ID, repodocuments.Precondition{})
 	assert.Nil(t, err)
 	assert.True(t, found)
-	length := 0
-	repo.DocumentsById.Range(func(_, _ interface{}) bool {
-		length++
-		return true
-	})
-	assert.Equal(t, 0, length)
+
+	//the document is moved to the trash
+	docFound, err := documentServiceImpl.Get(context.Background(), doc.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, models.Document{}, docFound)
+	trashed, _ := repo.DocumentsById.Load("toto")
+	assert.True(t, trashed.(models.Document).Trashed())
+
+	//and cannot be deleted twice
+	found, err = documentServiceImpl.Delete(context.Background(), doc.ID, repodocuments.Precondition{})
+	assert.Nil(t, err)
+	assert.False(t, found)
 }
 
 func TestDocumentServiceImpl_DeleteNonExisting(t *testing.T) {
 	repo := repodocuments.InMemoryDocumentRepo{}
 	documentServiceImpl := NewDocumentServiceImpl(&repo)
 
-	found, err := documentServiceImpl.Delete("toto")
+	found, err := documentServiceImpl.Delete(context.Background(), "toto", repodocuments.Precondition{})
 	assert.Nil(t, err)
 	assert.False(t, found)
 	length := 0
@@ -77,13 +107,429 @@ func TestDocumentServiceImpl_DeleteNonExisting(t *testing.T) {
 	assert.Equal(t, 0, length)
 }
 
+func TestDocumentServiceImpl_Trash(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	documentServiceImpl := NewDocumentServiceImpl(&repo)
+
+	_, _, _ = documentServiceImpl.CreateOrUpdate(context.Background(), models.Document{ID: "toto", Name: "nameToto"}, repodocuments.Precondition{})
+	_, _, _ = documentServiceImpl.CreateOrUpdate(context.Background(), models.Document{ID: "titi", Name: "nameTiti"}, repodocuments.Precondition{})
+	_, _ = documentServiceImpl.Delete(context.Background(), "toto", repodocuments.Precondition{})
+
+	//the trashed document is only listed in the trash
+	page, err := documentServiceImpl.List(context.Background(), repodocuments.DocumentQuery{})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(page.Documents))
+	assert.Equal(t, "titi", page.Documents[0].ID)
+	all, _ := documentServiceImpl.GetAll(context.Background())
+	assert.Equal(t, 1, len(all))
+	search, _ := documentServiceImpl.Search(context.Background(), repodocuments.SearchQuery{Text: "nameToto"})
+	assert.Equal(t, 0, len(search.Hits))
+
+	trash, err := documentServiceImpl.List(context.Background(), repodocuments.DocumentQuery{Trashed: true})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(trash.Documents))
+	assert.Equal(t, "toto", trash.Documents[0].ID)
+	assert.Equal(t, int64(2), trash.Documents[0].Version)
+	assert.NotNil(t, trash.Documents[0].DeletedAt)
+
+	//only a trashed document can be restored
+	_, err = documentServiceImpl.Restore(context.Background(), "titi")
+	assert.Equal(t, repodocuments.ErrNotFound, err)
+
+	restored, err := documentServiceImpl.Restore(context.Background(), "toto")
+	assert.Nil(t, err)
+	assert.Equal(t, models.Document{ID: "toto", Name: "nameToto", Version: 3}, withoutAudit(restored))
+	docFound, _ := documentServiceImpl.Get(context.Background(), "toto")
+	assert.Equal(t, restored, docFound)
+	search, _ = documentServiceImpl.Search(context.Background(), repodocuments.SearchQuery{Text: "nameToto"})
+	assert.Equal(t, 1, len(search.Hits))
+
+	//the restoration is a new revision
+	revisions, _ := documentServiceImpl.GetRevisions(context.Background(), "toto")
+	assert.Equal(t, 3, len(revisions))
+	assert.Equal(t, &restored, revisions[2].Document)
+}
+
+func TestDocumentServiceImpl_CreateOverTrashed(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	documentServiceImpl := NewDocumentServiceImpl(&repo)
+
+	_, _, _ = documentServiceImpl.CreateOrUpdate(context.Background(), models.Document{ID: "toto", Name: "old"}, repodocuments.Precondition{})
+	_, _ = documentServiceImpl.Delete(context.Background(), "toto", repodocuments.Precondition{})
+
+	//a trashed document does not exist anymore for the writes
+	_, _, err := documentServiceImpl.CreateOrUpdate(context.Background(), models.Document{ID: "toto"}, repodocuments.Precondition{MustExist: true})
+	assert.Equal(t, repodocuments.ErrPreconditionFailed, err)
+
+	doc, updated, err := documentServiceImpl.CreateOrUpdate(context.Background(), models.Document{ID: "toto", Name: "new"}, repodocuments.Precondition{MustNotExist: true})
+	assert.Nil(t, err)
+	assert.False(t, updated)
+	assert.Equal(t, models.Document{ID: "toto", Name: "new", Version: 3}, withoutAudit(doc))
+
+	trash, _ := documentServiceImpl.List(context.Background(), repodocuments.DocumentQuery{Trashed: true})
+	assert.Equal(t, 0, len(trash.Documents))
+}
+
+func TestDocumentServiceImpl_PurgeTrash(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	documentServiceImpl, _ := NewDocumentServiceImplWithConfig(&repo, &config.DocumentsConfig{TrashRetentionHours: 24})
+
+	old := time.Now().UTC().Add(-25 * time.Hour)
+	recent := time.Now().UTC().Add(-23 * time.Hour)
+	repo.DocumentsById.Store("old", models.Document{ID: "old", DeletedAt: &old})
+	repo.DocumentsById.Store("recent", models.Document{ID: "recent", DeletedAt: &recent})
+	repo.DocumentsById.Store("alive", models.Document{ID: "alive"})
+
+	//only the documents trashed for longer than the retention are purged
+	purged, err := documentServiceImpl.PurgeTrash()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), purged)
+	_, found := repo.DocumentsById.Load("old")
+	assert.False(t, found)
+	_, found = repo.DocumentsById.Load("recent")
+	assert.True(t, found)
+	_, found = repo.DocumentsById.Load("alive")
+	assert.True(t, found)
+
+	//the trash is kept forever without retention
+	documentServiceImpl = NewDocumentServiceImpl(&repo)
+	purged, err = documentServiceImpl.PurgeTrash()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), purged)
+}
+
+func TestDocumentServiceImpl_PurgeTrashRemovesRevisions(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	documentServiceImpl, _ := NewDocumentServiceImplWithConfig(&repo, &config.DocumentsConfig{TrashRetentionHours: 24})
+
+	_, _, _ = documentServiceImpl.CreateOrUpdate(context.Background(), models.Document{ID: "toto", Name: "first"}, repodocuments.Precondition{})
+	_, _ = documentServiceImpl.Delete(context.Background(), "toto", repodocuments.Precondition{})
+	trashed, _ := repo.DocumentsById.Load("toto")
+	document := trashed.(models.Document)
+	old := time.Now().UTC().Add(-25 * time.Hour)
+	document.DeletedAt = &old
+	repo.DocumentsById.Store("toto", document)
+
+	purged, err := documentServiceImpl.PurgeTrash()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), purged)
+
+	//a purged document cannot come back from its revisions
+	revisions, err := documentServiceImpl.GetRevisions(context.Background(), "toto")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(revisions))
+	_, _, err = documentServiceImpl.RestoreRevision(context.Background(), "toto", 1, repodocuments.Precondition{})
+	assert.Equal(t, repodocuments.ErrNotFound, err)
+}
+
+func TestDocumentServiceImpl_CreateOrUpdateIfMatch(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	documentServiceImpl := NewDocumentServiceImpl(&repo)
+
+	doc := models.Document{ID: "toto", Description: "descToto", Name: "nameToto", Version: 2}
+	repo.DocumentsById.Store("toto", doc)
+
+	//stale version is rejected and the document is untouched
+	_, updated, err := documentServiceImpl.CreateOrUpdate(context.Background(), models.Document{ID: "toto", Name: "stale"}, repodocuments.Precondition{IfMatch: 1})
+	assert.Equal(t, repodocuments.ErrPreconditionFailed, err)
+	assert.True(t, updated)
+	docFound, _ := repo.DocumentsById.Load(doc.ID)
+	assert.Equal(t, doc, docFound.(models.Document))
+
+	//current version is accepted
+	docUpdated, updated, err := documentServiceImpl.CreateOrUpdate(context.Background(), models.Document{ID: "toto", Name: "current"}, repodocuments.Precondition{IfMatch: 2})
+	assert.Nil(t, err)
+	assert.True(t, updated)
+	assert.Equal(t, models.Document{ID: "toto", Name: "current", Version: 3}, withoutAudit(docUpdated))
+
+	//a missing document cannot match
+	_, _, err = documentServiceImpl.CreateOrUpdate(context.Background(), models.Document{ID: "titi"}, repodocuments.Precondition{IfMatch: 1})
+	assert.Equal(t, repodocuments.ErrPreconditionFailed, err)
+}
+
+func TestDocumentServiceImpl_CreateOnly(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	documentServiceImpl := NewDocumentServiceImpl(&repo)
+
+	_, updated, err := documentServiceImpl.CreateOrUpdate(context.Background(), models.Document{ID: "toto"}, repodocuments.Precondition{MustNotExist: true})
+	assert.Nil(t, err)
+	assert.False(t, updated)
+
+	_, updated, err = documentServiceImpl.CreateOrUpdate(context.Background(), models.Document{ID: "toto"}, repodocuments.Precondition{MustNotExist: true})
+	assert.Equal(t, repodocuments.ErrPreconditionFailed, err)
+	assert.True(t, updated)
+}
+
+func TestDocumentServiceImpl_DeleteIfMatch(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	documentServiceImpl := NewDocumentServiceImpl(&repo)
+
+	repo.DocumentsById.Store("toto", models.Document{ID: "toto", Version: 2})
+
+	_, err := documentServiceImpl.Delete(context.Background(), "toto", repodocuments.Precondition{IfMatch: 1})
+	assert.Equal(t, repodocuments.ErrPreconditionFailed, err)
+	_, found := repo.DocumentsById.Load("toto")
+	assert.True(t, found)
+
+	found, err = documentServiceImpl.Delete(context.Background(), "toto", repodocuments.Precondition{IfMatch: 2})
+	assert.Nil(t, err)
+	assert.True(t, found)
+}
+
+func TestDocumentServiceImpl_ConcurrentUpdatesIfMatch(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	documentServiceImpl := NewDocumentServiceImpl(&repo)
+
+	repo.DocumentsById.Store("toto", models.Document{ID: "toto", Version: 1})
+
+	//only one of the writers based on the same version wins
+	var wg sync.WaitGroup
+	var succeeded int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := documentServiceImpl.CreateOrUpdate(context.Background(), models.Document{ID: "toto"}, repodocuments.Precondition{IfMatch: 1}); err == nil {
+				atomic.AddInt32(&succeeded, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), succeeded)
+}
+
+func TestDocumentServiceImpl_ApplyBatch(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	documentServiceImpl := NewDocumentServiceImpl(&repo)
+
+	repo.DocumentsById.Store("tata", models.Document{ID: "tata", Version: 1})
+
+	results, err := documentServiceImpl.ApplyBatch(context.Background(), []repodocuments.Operation{
+		{Document: models.Document{ID: "toto", Name: "nameToto"}},
+		{Delete: true, Document: models.Document{ID: "tata"}},
+		{Delete: true, Document: models.Document{ID: "titi"}},
+	}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []repodocuments.OperationResult{
+		{Document: models.Document{ID: "toto", Name: "nameToto", Version: 1}},
+		{Existed: true},
+		{Err: repodocuments.ErrNotFound},
+	}, resultsWithoutAudit(results))
+
+	//the failed operation doesn't prevent the others
+	_, found := repo.DocumentsById.Load("toto")
+	assert.True(t, found)
+	docFound, _ := repo.DocumentsById.Load("tata")
+	assert.True(t, docFound.(models.Document).Trashed())
+}
+
+func TestDocumentServiceImpl_ApplyBatchAtomicRollback(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	documentServiceImpl := NewDocumentServiceImpl(&repo)
+
+	tata := models.Document{ID: "tata", Version: 2}
+	repo.DocumentsById.Store("tata", tata)
+
+	results, err := documentServiceImpl.ApplyBatch(context.Background(), []repodocuments.Operation{
+		{Document: models.Document{ID: "toto", Name: "nameToto"}},
+		{Delete: true, Document: models.Document{ID: "tata"}},
+		{Document: models.Document{ID: "tata"}, Precondition: repodocuments.Precondition{MustExist: true}},
+	}, true)
+	assert.Nil(t, err)
+	assert.Equal(t, []repodocuments.OperationResult{
+		{Err: repodocuments.ErrRolledBack},
+		{Err: repodocuments.ErrRolledBack},
+		{Err: repodocuments.ErrPreconditionFailed},
+	}, results)
+
+	//nothing was applied
+	_, found := repo.DocumentsById.Load("toto")
+	assert.False(t, found)
+	docFound, _ := repo.DocumentsById.Load("tata")
+	assert.Equal(t, tata, docFound.(models.Document))
+}
+
+func TestDocumentServiceImpl_ApplyBatchAtomic(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	documentServiceImpl := NewDocumentServiceImpl(&repo)
+
+	repo.DocumentsById.Store("tata", models.Document{ID: "tata", Version: 2})
+
+	//the operations see the changes of the previous ones
+	results, err := documentServiceImpl.ApplyBatch(context.Background(), []repodocuments.Operation{
+		{Document: models.Document{ID: "toto", Name: "nameToto"}},
+		{Document: models.Document{ID: "toto", Name: "nameToto2"}, Precondition: repodocuments.Precondition{IfMatch: 1}},
+		{Delete: true, Document: models.Document{ID: "tata"}, Precondition: repodocuments.Precondition{IfMatch: 2}},
+	}, true)
+	assert.Nil(t, err)
+	assert.Equal(t, []repodocuments.OperationResult{
+		{Document: models.Document{ID: "toto", Name: "nameToto", Version: 1}},
+		{Document: models.Document{ID: "toto", Name: "nameToto2", Version: 2}, Existed: true},
+		{Existed: true},
+	}, resultsWithoutAudit(results))
+
+	docFound, _ := repo.DocumentsById.Load("toto")
+	assert.Equal(t, models.Document{ID: "toto", Name: "nameToto2", Version: 2}, withoutAudit(docFound.(models.Document)))
+	docFound, _ = repo.DocumentsById.Load("tata")
+	assert.True(t, docFound.(models.Document).Trashed())
+}
+
+func TestDocumentServiceImpl_Revisions(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	documentServiceImpl := NewDocumentServiceImpl(&repo)
+
+	_, _, err := documentServiceImpl.CreateOrUpdate(context.Background(), models.Document{ID: "toto", Name: "first"}, repodocuments.Precondition{})
+	assert.Nil(t, err)
+	_, _, err = documentServiceImpl.CreateOrUpdate(context.Background(), models.Document{ID: "toto", Name: "second"}, repodocuments.Precondition{})
+	assert.Nil(t, err)
+	_, err = documentServiceImpl.Delete(context.Background(), "toto", repodocuments.Precondition{})
+	assert.Nil(t, err)
+
+	revisions, err := documentServiceImpl.GetRevisions(context.Background(), "toto")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(revisions))
+	assert.Equal(t, int64(1), revisions[0].Revision)
+	assert.Equal(t, models.Document{ID: "toto", Name: "first", Version: 1}, withoutAudit(*revisions[0].Document))
+	assert.Equal(t, int64(2), revisions[1].Revision)
+	assert.Equal(t, models.Document{ID: "toto", Name: "second", Version: 2}, withoutAudit(*revisions[1].Document))
+	assert.Equal(t, int64(3), revisions[2].Revision)
+	assert.True(t, revisions[2].Deleted)
+	assert.Nil(t, revisions[2].Document)
+	assert.False(t, revisions[2].Timestamp.IsZero())
+
+	revision, found, err := documentServiceImpl.GetRevision(context.Background(), "toto", 2)
+	assert.Nil(t, err)
+	assert.True(t, found)
+	assert.Equal(t, revisions[1], revision)
+
+	_, found, err = documentServiceImpl.GetRevision(context.Background(), "toto", 4)
+	assert.Nil(t, err)
+	assert.False(t, found)
+}
+
+func TestDocumentServiceImpl_RevisionsAreCapped(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	documentServiceImpl, _ := NewDocumentServiceImplWithConfig(&repo, &config.DocumentsConfig{MaxRevisions: 2})
+
+	for i := 0; i < 5; i++ {
+		_, _, err := documentServiceImpl.CreateOrUpdate(context.Background(), models.Document{ID: "toto"}, repodocuments.Precondition{})
+		assert.Nil(t, err)
+	}
+
+	//only the last revisions are kept
+	revisions, err := documentServiceImpl.GetRevisions(context.Background(), "toto")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(revisions))
+	assert.Equal(t, int64(4), revisions[0].Revision)
+	assert.Equal(t, int64(5), revisions[1].Revision)
+}
+
+func TestDocumentServiceImpl_RestoreRevision(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	documentServiceImpl := NewDocumentServiceImpl(&repo)
+
+	_, _, _ = documentServiceImpl.CreateOrUpdate(context.Background(), models.Document{ID: "toto", Name: "first"}, repodocuments.Precondition{})
+	_, _, _ = documentServiceImpl.CreateOrUpdate(context.Background(), models.Document{ID: "toto", Name: "second"}, repodocuments.Precondition{})
+	_, _ = documentServiceImpl.Delete(context.Background(), "toto", repodocuments.Precondition{})
+
+	//a deletion cannot be restored
+	_, _, err := documentServiceImpl.RestoreRevision(context.Background(), "toto", 3, repodocuments.Precondition{})
+	assert.Equal(t, ErrRevisionDeleted, err)
+
+	_, _, err = documentServiceImpl.RestoreRevision(context.Background(), "toto", 10, repodocuments.Precondition{})
+	assert.Equal(t, repodocuments.ErrNotFound, err)
+
+	//the deleted document comes back as it was in the revision, with a new version
+	restored, updated, err := documentServiceImpl.RestoreRevision(context.Background(), "toto", 1, repodocuments.Precondition{})
+	assert.Nil(t, err)
+	assert.False(t, updated)
+	assert.Equal(t, models.Document{ID: "toto", Name: "first", Version: 4}, withoutAudit(restored))
+
+	//and the restoration is a new revision
+	revisions, _ := documentServiceImpl.GetRevisions(context.Background(), "toto")
+	assert.Equal(t, 4, len(revisions))
+	assert.Equal(t, &restored, revisions[3].Document)
+}
+
+func TestDocumentServiceImpl_Search(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	documentServiceImpl := NewDocumentServiceImpl(&repo)
+
+	_, _, _ = documentServiceImpl.CreateOrUpdate(context.Background(), models.Document{ID: "a", Name: "Annual report", Description: "the report of the year"}, repodocuments.Precondition{})
+	_, _, _ = documentServiceImpl.CreateOrUpdate(context.Background(), models.Document{ID: "b", Name: "Invoice", Description: "see the report"}, repodocuments.Precondition{})
+	_, _, _ = documentServiceImpl.CreateOrUpdate(context.Background(), models.Document{ID: "c", Name: "Invoice", Description: "nothing"}, repodocuments.Precondition{})
+	_, _, _ = documentServiceImpl.CreateOrUpdate(context.Background(), models.Document{ID: "d", Name: "Report <draft>", Description: "old"}, repodocuments.Precondition{})
+	_, _ = documentServiceImpl.Delete(context.Background(), "d", repodocuments.Precondition{})
+
+	page, err := documentServiceImpl.Search(context.Background(), repodocuments.SearchQuery{Text: "REPORT"})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(page.Hits))
+	assert.Empty(t, page.NextCursor)
+
+	//the name weights more than the description
+	assert.Equal(t, "a", page.Hits[0].Document.ID)
+	assert.Equal(t, "b", page.Hits[1].Document.ID)
+	assert.Greater(t, page.Hits[0].Score, page.Hits[1].Score)
+	assert.Equal(t, models.Highlights{
+		"name":        "Annual <em>report</em>",
+		"description": "the <em>report</em> of the year",
+	}, page.Hits[0].Highlights)
+	assert.Equal(t, models.Highlights{"description": "see the <em>report</em>"}, page.Hits[1].Highlights)
+}
+
+func TestDocumentServiceImpl_SearchPages(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	documentServiceImpl := NewDocumentServiceImpl(&repo)
+
+	for _, id := range []string{"c", "a", "b"} {
+		_, _, _ = documentServiceImpl.CreateOrUpdate(context.Background(), models.Document{ID: id, Name: "same name"}, repodocuments.Precondition{})
+	}
+
+	//same scores are sorted by id
+	page, err := documentServiceImpl.Search(context.Background(), repodocuments.SearchQuery{Text: "name", Limit: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(page.Hits))
+	assert.Equal(t, "a", page.Hits[0].Document.ID)
+	assert.Equal(t, "b", page.Hits[1].Document.ID)
+
+	cursor := page.NextCursor
+	page, err = documentServiceImpl.Search(context.Background(), repodocuments.SearchQuery{Text: "name", Limit: 2, Cursor: cursor})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(page.Hits))
+	assert.Equal(t, "c", page.Hits[0].Document.ID)
+	assert.Empty(t, page.NextCursor)
+
+	//a cursor cannot be used with another text
+	_, err = documentServiceImpl.Search(context.Background(), repodocuments.SearchQuery{Text: "same", Cursor: cursor})
+	assert.Equal(t, repodocuments.ErrInvalidCursor, err)
+}
+
+func TestHighlight(t *testing.T) {
+	terms := map[string]bool{"report": true}
+
+	snippet, found := highlight("<b>Report</b> & reports", terms)
+	assert.True(t, found)
+	assert.Equal(t, "&lt;b&gt;<em>Report</em>&lt;/b&gt; &amp; reports", snippet)
+
+	_, found = highlight("nothing", terms)
+	assert.False(t, found)
+
+	//a long text is cut around the first match
+	long := strings.Repeat("a ", 50) + "report" + strings.Repeat(" b", 100)
+	snippet, found = highlight(long, terms)
+	assert.True(t, found)
+	assert.True(t, strings.HasPrefix(snippet, "…"))
+	assert.True(t, strings.HasSuffix(snippet, "…"))
+	assert.Contains(t, snippet, "<em>report</em>")
+}
+
 func TestDocumentServiceImpl_GetFound(t *testing.T) {
 	repo := repodocuments.InMemoryDocumentRepo{}
 	documentServiceImpl := NewDocumentServiceImpl(&repo)
 
 	doc := models.Document{ID: "toto", Description: "descToto", Name: "nameToto"}
 	repo.DocumentsById.Store("toto", doc)
-	res, err := documentServiceImpl.Get("toto")
+	res, err := documentServiceImpl.Get(context.Background(), "toto")
 	assert.Nil(t, err)
 	assert.Equal(t, doc, res)
 }
@@ -92,8 +538,8 @@ func TestDocumentServiceImpl_GetNotFound(t *testing.T) {
 	repo := repodocuments.InMemoryDocumentRepo{}
 	documentServiceImpl := NewDocumentServiceImpl(&repo)
 
-	res, err := documentServiceImpl.Get("toto")
-	assert.Nil(t, err)
+	res, err := documentServiceImpl.Get(context.Background(), "toto")
+	assert.ErrorIs(t, err, ErrNotFound)
 	assert.Equal(t, models.Document{}, res)
 }
 
@@ -107,13 +553,229 @@ func TestDocumentServiceImpl_GetAll(t *testing.T) {
 	docTata := models.Document{ID: "tata", Description: "descTata", Name: "nameTata"}
 	repo.DocumentsById.Store(docTata.ID, docTata)
 
-	res, err := documentServiceImpl.GetAll()
+	res, err := documentServiceImpl.GetAll(context.Background())
 	assert.Nil(t, err)
 	assert.Equal(t, 2, len(res))
 	assert.Equal(t, docTata, res[0]) //values should be sorted by ID
 	assert.Equal(t, docToto, res[1])
 }
 
+func TestDocumentServiceImpl_ListPages(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	documentServiceImpl := NewDocumentServiceImpl(&repo)
+
+	for _, id := range []string{"c", "a", "d", "b", "e"} {
+		repo.DocumentsById.Store(id, models.Document{ID: id, Name: "name" + id})
+	}
+
+	//first page
+	page, err := documentServiceImpl.List(context.Background(), repodocuments.DocumentQuery{Limit: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, []models.Document{{ID: "a", Name: "namea"}, {ID: "b", Name: "nameb"}}, page.Documents)
+	assert.NotEmpty(t, page.NextCursor)
+
+	//second page
+	page, err = documentServiceImpl.List(context.Background(), repodocuments.DocumentQuery{Limit: 2, Cursor: page.NextCursor})
+	assert.Nil(t, err)
+	assert.Equal(t, []models.Document{{ID: "c", Name: "namec"}, {ID: "d", Name: "named"}}, page.Documents)
+	assert.NotEmpty(t, page.NextCursor)
+
+	//last page has no next cursor
+	page, err = documentServiceImpl.List(context.Background(), repodocuments.DocumentQuery{Limit: 2, Cursor: page.NextCursor})
+	assert.Nil(t, err)
+	assert.Equal(t, []models.Document{{ID: "e", Name: "namee"}}, page.Documents)
+	assert.Empty(t, page.NextCursor)
+}
+
+func TestDocumentServiceImpl_ListSortedByNameDescending(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	documentServiceImpl := NewDocumentServiceImpl(&repo)
+
+	repo.DocumentsById.Store("a", models.Document{ID: "a", Name: "tata"})
+	repo.DocumentsById.Store("b", models.Document{ID: "b", Name: "toto"})
+	repo.DocumentsById.Store("c", models.Document{ID: "c", Name: "tata"})
+
+	sort, err := repodocuments.ParseDocumentSort("-name")
+	assert.Nil(t, err)
+
+	page, err := documentServiceImpl.List(context.Background(), repodocuments.DocumentQuery{Limit: 2, Sort: sort})
+	assert.Nil(t, err)
+	assert.Equal(t, []models.Document{{ID: "b", Name: "toto"}, {ID: "a", Name: "tata"}}, page.Documents)
+
+	//same names are sorted by id
+	page, err = documentServiceImpl.List(context.Background(), repodocuments.DocumentQuery{Limit: 2, Sort: sort, Cursor: page.NextCursor})
+	assert.Nil(t, err)
+	assert.Equal(t, []models.Document{{ID: "c", Name: "tata"}}, page.Documents)
+	assert.Empty(t, page.NextCursor)
+}
+
+func TestDocumentServiceImpl_AuditFields(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	documentServiceImpl := NewDocumentServiceImpl(&repo)
+
+	//the audit fields sent by the client are ignored
+	forged := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	created, _, err := documentServiceImpl.CreateOrUpdate(WithActor(context.Background(), "alice"),
+		models.Document{ID: "toto", CreatedAt: forged, CreatedBy: "mallory", UpdatedAt: forged, UpdatedBy: "mallory"}, repodocuments.Precondition{})
+	assert.Nil(t, err)
+	assert.Equal(t, "alice", created.CreatedBy)
+	assert.Equal(t, "alice", created.UpdatedBy)
+	assert.True(t, created.CreatedAt.After(forged))
+	assert.Equal(t, created.CreatedAt, created.UpdatedAt)
+	_, err = documentServiceImpl.SetACL(WithActor(context.Background(), "alice"), "toto",
+		models.DocumentACL{Owner: "alice", Writers: []string{"user:bob", "user:" + AnonymousActor}}, repodocuments.Precondition{})
+	assert.Nil(t, err)
+
+	//an update keeps the creation
+	time.Sleep(2 * time.Millisecond)
+	updated, _, err := documentServiceImpl.CreateOrUpdate(WithActor(context.Background(), "bob"), models.Document{ID: "toto", Name: "new"}, repodocuments.Precondition{})
+	assert.Nil(t, err)
+	assert.Equal(t, created.CreatedAt, updated.CreatedAt)
+	assert.Equal(t, "alice", updated.CreatedBy)
+	assert.True(t, updated.UpdatedAt.After(created.UpdatedAt))
+	assert.Equal(t, "bob", updated.UpdatedBy)
+	docFound, _ := documentServiceImpl.Get(context.Background(), "toto")
+	assert.Equal(t, updated, docFound)
+
+	//so does a batch, and the writes without actor are anonymous
+	results, err := documentServiceImpl.ApplyBatch(context.Background(), []repodocuments.Operation{{Document: models.Document{ID: "toto"}}}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, "alice", results[0].Document.CreatedBy)
+	assert.Equal(t, AnonymousActor, results[0].Document.UpdatedBy)
+}
+
+func TestDocumentServiceImpl_ListAudited(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	documentServiceImpl := NewDocumentServiceImpl(&repo)
+
+	now := time.Date(2021, 10, 1, 12, 0, 0, 0, time.UTC)
+	repo.DocumentsById.Store("a", models.Document{ID: "a", CreatedBy: "alice", CreatedAt: now, UpdatedBy: "bob", UpdatedAt: now.Add(2 * time.Hour)})
+	repo.DocumentsById.Store("b", models.Document{ID: "b", CreatedBy: "bob", CreatedAt: now.Add(time.Hour), UpdatedBy: "bob", UpdatedAt: now.Add(time.Hour)})
+	repo.DocumentsById.Store("c", models.Document{ID: "c", CreatedBy: "alice", CreatedAt: now.Add(time.Hour), UpdatedBy: "alice", UpdatedAt: now.Add(3 * time.Hour)})
+
+	ids := func(page models.DocumentPage) []string {
+		result := make([]string, 0, len(page.Documents))
+		for _, document := range page.Documents {
+			result = append(result, document.ID)
+		}
+		return result
+	}
+
+	page, err := documentServiceImpl.List(context.Background(), repodocuments.DocumentQuery{Filter: repodocuments.DocumentFilter{CreatedBy: "alice"}})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "c"}, ids(page))
+
+	//the bounds are excluded
+	page, err = documentServiceImpl.List(context.Background(), repodocuments.DocumentQuery{Filter: repodocuments.DocumentFilter{CreatedAfter: now, UpdatedBefore: now.Add(3 * time.Hour)}})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"b"}, ids(page))
+
+	//same times are sorted by id, through the pages
+	sort, err := repodocuments.ParseDocumentSort("-createdAt")
+	assert.Nil(t, err)
+	page, err = documentServiceImpl.List(context.Background(), repodocuments.DocumentQuery{Limit: 2, Sort: sort})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"b", "c"}, ids(page))
+	page, err = documentServiceImpl.List(context.Background(), repodocuments.DocumentQuery{Limit: 2, Sort: sort, Cursor: page.NextCursor})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a"}, ids(page))
+
+	sort, _ = repodocuments.ParseDocumentSort("updatedBy")
+	page, err = documentServiceImpl.List(context.Background(), repodocuments.DocumentQuery{Sort: sort})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"c", "a", "b"}, ids(page))
+}
+
+func TestDocumentServiceImpl_ListSelector(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	documentServiceImpl := NewDocumentServiceImpl(&repo)
+
+	for _, document := range []models.Document{
+		{ID: "a", Labels: map[string]string{"team": "payments", "env": "prod"}},
+		{ID: "b", Labels: map[string]string{"team": "payments", "env": "dev", "deprecated": "true"}},
+		{ID: "c", Labels: map[string]string{"team": "search", "env": "staging"}},
+		{ID: "d"},
+	} {
+		_, _, err := documentServiceImpl.CreateOrUpdate(context.Background(), document, repodocuments.Precondition{})
+		assert.Nil(t, err)
+	}
+
+	ids := func(page models.DocumentPage) []string {
+		result := make([]string, 0, len(page.Documents))
+		for _, document := range page.Documents {
+			result = append(result, document.ID)
+		}
+		return result
+	}
+	for _, test := range []struct {
+		selector    string
+		expectedIDs []string
+	}{
+		{"team=payments", []string{"a", "b"}},
+		{"team==payments,env!=prod", []string{"b"}},
+		//a document without the label matches != and notin
+		{"env!=prod", []string{"b", "c", "d"}},
+		{"env in (dev, staging)", []string{"b", "c"}},
+		{"env notin (dev,staging)", []string{"a", "d"}},
+		{"team,!deprecated", []string{"a", "c"}},
+		{"team in (payments,search),env", []string{"a", "b", "c"}},
+		{"owner", []string{}},
+	} {
+		selector, err := repodocuments.ParseLabelSelector(test.selector)
+		assert.Nil(t, err, test.selector)
+		page, err := documentServiceImpl.List(context.Background(), repodocuments.DocumentQuery{Filter: repodocuments.DocumentFilter{Selector: selector}})
+		assert.Nil(t, err)
+		assert.Equal(t, test.expectedIDs, ids(page), test.selector)
+	}
+
+	//the index follows the changes of the labels
+	_, _, err := documentServiceImpl.CreateOrUpdate(context.Background(), models.Document{ID: "d", Labels: map[string]string{"team": "payments"}}, repodocuments.Precondition{})
+	assert.Nil(t, err)
+	_, err = documentServiceImpl.Delete(context.Background(), "a", repodocuments.Precondition{})
+	assert.Nil(t, err)
+	selector, _ := repodocuments.ParseLabelSelector("team=payments")
+	page, err := documentServiceImpl.List(context.Background(), repodocuments.DocumentQuery{Filter: repodocuments.DocumentFilter{Selector: selector}})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"b", "d"}, ids(page))
+	page, err = documentServiceImpl.List(context.Background(), repodocuments.DocumentQuery{Filter: repodocuments.DocumentFilter{Selector: selector}, Trashed: true})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a"}, ids(page))
+}
+
+func TestParseLabelSelector_Invalid(t *testing.T) {
+	for _, selector := range []string{"=payments", "team=-payments", "env in (dev,-prod)", "team=pay ments", "a,,b"} {
+		_, err := repodocuments.ParseLabelSelector(selector)
+		assert.ErrorIs(t, err, repodocuments.ErrInvalidSelector, selector)
+	}
+}
+
+func TestDocumentServiceImpl_InvalidLabels(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	documentServiceImpl := NewDocumentServiceImpl(&repo)
+
+	err := documentServiceImpl.Validate(models.Document{ID: "toto", Labels: map[string]string{"team": "pay ments", "-env": "prod"}})
+	assert.EqualError(t, err, `labels key "-env" must be alphanumeric with -, _, . or /, and at most 253 characters, `+
+		"labels value of team must be alphanumeric with -, _ or ., and at most 63 characters")
+}
+
+func TestDocumentServiceImpl_ListWrongCursor(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	documentServiceImpl := NewDocumentServiceImpl(&repo)
+
+	repo.DocumentsById.Store("a", models.Document{ID: "a", Name: "namea"})
+	repo.DocumentsById.Store("b", models.Document{ID: "b", Name: "nameb"})
+	page, err := documentServiceImpl.List(context.Background(), repodocuments.DocumentQuery{Limit: 1})
+	assert.Nil(t, err)
+
+	//a cursor cannot be used with another sort
+	sort, _ := repodocuments.ParseDocumentSort("name")
+	_, err = documentServiceImpl.List(context.Background(), repodocuments.DocumentQuery{Limit: 1, Sort: sort, Cursor: page.NextCursor})
+	assert.Equal(t, repodocuments.ErrInvalidCursor, err)
+
+	_, err = documentServiceImpl.List(context.Background(), repodocuments.DocumentQuery{Cursor: "wrong"})
+	assert.Equal(t, repodocuments.ErrInvalidCursor, err)
+}
+
 func TestNewDocumentServiceImpl(t *testing.T) {
 	repo := repodocuments.InMemoryDocumentRepo{}
 	documentServiceImpl := NewDocumentServiceImpl(&repo)
@@ -0,0 +1,17 @@
+package servicedocuments
+
+import "goapi/repositories/repodocuments"
+
+// The kinds of the errors of the services, they are the ones of the repositories so that their errors go through unchanged
+var (
+	ErrNotFound    = repodocuments.ErrNotFound
+	ErrConflict    = repodocuments.ErrConflict
+	ErrValidation  = repodocuments.ErrValidation
+	ErrUnavailable = repodocuments.ErrUnavailable
+	ErrForbidden   = repodocuments.ErrForbidden
+)
+
+// NewError returns an error of the kind
+func NewError(kind error, format string, args ...interface{}) error {
+	return repodocuments.NewError(kind, format, args...)
+}
@@ -0,0 +1,104 @@
+package servicedocuments
+
+import (
+	"goapi/models"
+	"goapi/repositories/repodocuments"
+	"html"
+	"strings"
+	"unicode"
+)
+
+//the maximum length of a snippet, and the length of the text kept before the first match
+const (
+	snippetLength = 160
+	snippetBefore = 40
+)
+
+//span is the position of a matching term in a text, in runes
+type span struct {
+	start, end int
+}
+
+//matchingSpans returns the positions of the words of the text that are search terms
+func matchingSpans(text []rune, terms map[string]bool) []span {
+	spans := make([]span, 0)
+	isWord := func(r rune) bool { return unicode.IsLetter(r) || unicode.IsNumber(r) }
+	for i := 0; i < len(text); {
+		if !isWord(text[i]) {
+			i++
+			continue
+		}
+		start := i
+		for i < len(text) && isWord(text[i]) {
+			i++
+		}
+		if terms[strings.ToLower(string(text[start:i]))] {
+			spans = append(spans, span{start, i})
+		}
+	}
+	return spans
+}
+
+//highlight returns the snippet of the text around the search terms, with the terms in <em> tags.
+//The text is escaped, and false is returned if no term is in the text.
+func highlight(value string, terms map[string]bool) (string, bool) {
+	text := []rune(value)
+	spans := matchingSpans(text, terms)
+	if len(spans) == 0 {
+		return "", false
+	}
+
+	//the window of the text shown around the first match
+	windowStart := 0
+	if spans[0].start > snippetBefore {
+		windowStart = spans[0].start - snippetBefore
+	}
+	windowEnd := windowStart + snippetLength
+	if windowEnd > len(text) {
+		windowEnd = len(text)
+	}
+
+	var snippet strings.Builder
+	if windowStart > 0 {
+		snippet.WriteString("…")
+	}
+	position := windowStart
+	for _, match := range spans {
+		if match.start >= windowEnd {
+			break
+		}
+		end := match.end
+		if end > windowEnd {
+			end = windowEnd
+		}
+		snippet.WriteString(html.EscapeString(string(text[position:match.start])))
+		snippet.WriteString("<em>" + html.EscapeString(string(text[match.start:end])) + "</em>")
+		position = end
+	}
+	snippet.WriteString(html.EscapeString(string(text[position:windowEnd])))
+	if windowEnd < len(text) {
+		snippet.WriteString("…")
+	}
+	return snippet.String(), true
+}
+
+//addHighlights sets the snippets of the name and description of each hit
+func addHighlights(text string, page *models.SearchPage) {
+	terms := make(map[string]bool)
+	for _, term := range repodocuments.SearchTerms(text) {
+		terms[term] = true
+	}
+
+	for i := range page.Hits {
+		highlights := make(map[string]string)
+		if snippet, found := highlight(page.Hits[i].Document.Name, terms); found {
+			highlights["name"] = snippet
+		}
+		if snippet, found := highlight(page.Hits[i].Document.Description, terms); found {
+			highlights["description"] = snippet
+		}
+		if len(highlights) > 0 {
+			page.Hits[i].Highlights = highlights
+		}
+	}
+}
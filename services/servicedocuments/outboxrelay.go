@@ -0,0 +1,150 @@
+package servicedocuments
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"expvar"
+	"goapi/config"
+	"goapi/models"
+	"goapi/repositories/repodocuments"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-co-op/gocron"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// OutboxBatchSize is the maximum number of events published at once
+	OutboxBatchSize = 100
+	// DefaultRelayIntervalMs is how often the outbox is published when there is no configuration
+	DefaultRelayIntervalMs = 500
+	// RelayLeaseDuration is how long a relay keeps the outbox without extending its lease,
+	// another instance takes it over once it is over
+	RelayLeaseDuration = 30 * time.Second
+)
+
+//the metrics of the publication of the document events, served with the other expvar variables
+var (
+	outboxMetrics = expvar.NewMap("documentEvents")
+	//publishLagMs is the age of the oldest event not published yet, 0 when the outbox is empty.
+	//It is told by the instance holding the lease of the relay.
+	publishLagMs  = new(expvar.Int)
+	published     = new(expvar.Int)
+	publishErrors = new(expvar.Int)
+)
+
+func init() {
+	outboxMetrics.Set("publishLagMs", publishLagMs)
+	outboxMetrics.Set("published", published)
+	outboxMetrics.Set("publishErrors", publishErrors)
+}
+
+// EventPublisher publishes the document events in order
+type EventPublisher interface {
+	PublishEvents(events []models.DocumentEvent) error
+}
+
+// OutboxRelay publishes the events of the outbox. An event is removed from the outbox only once published,
+// so each event is published at least once, and again if the relay stops in between.
+// The relays of all the instances share the outbox, only the one holding its lease publishes so that the events stay in order.
+type OutboxRelay struct {
+	outbox    repodocuments.Outbox
+	publisher EventPublisher
+	interval  int
+	scheduler *gocron.Scheduler
+	//holder names the relay in the lease
+	holder string
+	//the lease is held by the relay, not by a call, so the calls of the relay publish one at a time
+	lock sync.Mutex
+}
+
+//newRelayHolder returns a name of the relay unique across the instances
+func newRelayHolder() string {
+	hostname, _ := os.Hostname()
+	random := make([]byte, 8)
+	_, _ = rand.Read(random)
+	return hostname + "-" + hex.EncodeToString(random)
+}
+
+func NewOutboxRelay(outbox repodocuments.Outbox, publisher EventPublisher, configuration *config.DocumentsConfig) *OutboxRelay {
+	interval := configuration.RelayIntervalMs
+	if interval <= 0 {
+		interval = DefaultRelayIntervalMs
+	}
+	outbox.EnableOutbox()
+	return &OutboxRelay{outbox: outbox, publisher: publisher, interval: interval, holder: newRelayHolder()}
+}
+
+// Relay publishes the pending events until the outbox is empty, and returns how many were published.
+// It publishes nothing while the relay of another instance holds the lease.
+func (r *OutboxRelay) Relay() (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	total := 0
+	for {
+		//the lease is extended before each batch, so it is not lost while the outbox is long
+		held, err := r.outbox.AcquireRelayLease(r.holder, RelayLeaseDuration)
+		if err != nil || !held {
+			return total, err
+		}
+		events, err := r.outbox.PendingEvents(OutboxBatchSize)
+		if err != nil {
+			return total, err
+		}
+		if len(events) == 0 {
+			publishLagMs.Set(0)
+			return total, nil
+		}
+		//the lag keeps growing while the events cannot be published
+		publishLagMs.Set(time.Since(events[0].OccurredAt).Milliseconds())
+		if err := r.publisher.PublishEvents(events); err != nil {
+			publishErrors.Add(1)
+			return total, err
+		}
+		published.Add(int64(len(events)))
+		total += len(events)
+
+		ids := make([]string, 0, len(events))
+		for _, event := range events {
+			ids = append(ids, event.ID)
+		}
+		//if the removal fails, the events are published again
+		if err := r.outbox.RemoveEvents(ids); err != nil {
+			return total, err
+		}
+	}
+}
+
+// Start schedules the publication of the outbox
+func (r *OutboxRelay) Start() {
+	if r.scheduler != nil {
+		return
+	}
+	r.scheduler = gocron.NewScheduler(time.UTC)
+	_, err := r.scheduler.Every(r.interval).Milliseconds().SingletonMode().Do(func() {
+		if _, err := r.Relay(); err != nil {
+			log.Errorf("Cannot publish the document events [err=%s]", err)
+		}
+	})
+	if err != nil {
+		log.Errorf("Cannot schedule the publication of the document events [err=%s]", err)
+		return
+	}
+	r.scheduler.StartAsync()
+}
+
+// Stop stops the publication of the outbox, and gives the lease back to the relays of the other instances
+func (r *OutboxRelay) Stop() {
+	if r.scheduler != nil {
+		r.scheduler.Stop()
+		r.scheduler = nil
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if err := r.outbox.ReleaseRelayLease(r.holder); err != nil {
+		log.Errorf("Cannot release the lease of the outbox [err=%s]", err)
+	}
+}
@@ -0,0 +1,142 @@
+package servicedocuments
+
+import (
+	"context"
+	"errors"
+	"goapi/config"
+	"goapi/models"
+	"goapi/repositories/repodocuments"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type publisherMock struct {
+	published []models.DocumentEvent
+	err       error
+}
+
+func (p *publisherMock) PublishEvents(events []models.DocumentEvent) error {
+	if p.err != nil {
+		return p.err
+	}
+	p.published = append(p.published, events...)
+	return nil
+}
+
+func TestOutboxRelay_Relay(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	documentServiceImpl := NewDocumentServiceImpl(&repo)
+	publisher := &publisherMock{}
+	relay := NewOutboxRelay(&repo, publisher, &config.DocumentsConfig{})
+
+	_, _, _ = documentServiceImpl.CreateOrUpdate(context.Background(), models.Document{ID: "toto"}, repodocuments.Precondition{})
+	_, _, _ = documentServiceImpl.CreateOrUpdate(context.Background(), models.Document{ID: "toto", Name: "nameToto"}, repodocuments.Precondition{})
+	_, _ = documentServiceImpl.Delete(context.Background(), "toto", repodocuments.Precondition{})
+	//a failed write has no event
+	_, _, _ = documentServiceImpl.CreateOrUpdate(context.Background(), models.Document{ID: "titi"}, repodocuments.Precondition{MustExist: true})
+
+	n, err := relay.Relay()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, 3, len(publisher.published))
+
+	//the events are published in the order of the writes
+	assert.Equal(t, models.EventDocumentCreated, publisher.published[0].Type)
+	assert.Equal(t, models.Document{ID: "toto", Version: 1}, withoutAudit(*publisher.published[0].Document))
+	assert.Equal(t, models.EventDocumentUpdated, publisher.published[1].Type)
+	assert.Equal(t, int64(2), publisher.published[1].Version)
+	assert.Equal(t, models.EventDocumentDeleted, publisher.published[2].Type)
+	assert.Equal(t, "toto", publisher.published[2].DocumentID)
+	assert.Equal(t, int64(3), publisher.published[2].Version)
+	assert.Nil(t, publisher.published[2].Document)
+
+	//the outbox is empty once published
+	pending, _ := repo.PendingEvents(OutboxBatchSize)
+	assert.Equal(t, 0, len(pending))
+}
+
+func TestOutboxRelay_RelayAfterFailure(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	documentServiceImpl := NewDocumentServiceImpl(&repo)
+	publisher := &publisherMock{err: errors.New("kafka down")}
+	relay := NewOutboxRelay(&repo, publisher, &config.DocumentsConfig{})
+
+	for i := 0; i < OutboxBatchSize+1; i++ {
+		_, _, _ = documentServiceImpl.CreateOrUpdate(context.Background(), models.Document{ID: "toto"}, repodocuments.Precondition{})
+	}
+
+	//the events are kept until they are published
+	_, err := relay.Relay()
+	assert.Equal(t, publisher.err, err)
+	pending, _ := repo.PendingEvents(OutboxBatchSize)
+	assert.Equal(t, OutboxBatchSize, len(pending))
+
+	publisher.err = nil
+	n, err := relay.Relay()
+	assert.Nil(t, err)
+	assert.Equal(t, OutboxBatchSize+1, n)
+	for i, event := range publisher.published {
+		assert.Equal(t, int64(i+1), event.Version)
+	}
+}
+
+func TestOutboxRelay_Lease(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	documentServiceImpl := NewDocumentServiceImpl(&repo)
+	publisher, other := &publisherMock{}, &publisherMock{}
+	relay := NewOutboxRelay(&repo, publisher, &config.DocumentsConfig{})
+	otherRelay := NewOutboxRelay(&repo, other, &config.DocumentsConfig{})
+
+	//the relay holding the lease publishes, the other one waits for it
+	_, _, _ = documentServiceImpl.CreateOrUpdate(context.Background(), models.Document{ID: "toto"}, repodocuments.Precondition{})
+	n, err := relay.Relay()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	_, _, _ = documentServiceImpl.CreateOrUpdate(context.Background(), models.Document{ID: "titi"}, repodocuments.Precondition{})
+	n, err = otherRelay.Relay()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+
+	//once the relay is stopped, the other one takes the lease over
+	relay.Stop()
+	n, err = otherRelay.Relay()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, "titi", other.published[0].DocumentID)
+	n, _ = relay.Relay()
+	assert.Equal(t, 0, n)
+}
+
+func TestOutboxRelay_AtomicBatch(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	documentServiceImpl := NewDocumentServiceImpl(&repo)
+	publisher := &publisherMock{}
+	relay := NewOutboxRelay(&repo, publisher, &config.DocumentsConfig{})
+
+	//a rolled back batch has no event
+	_, _ = documentServiceImpl.ApplyBatch(context.Background(), []repodocuments.Operation{
+		{Document: models.Document{ID: "toto"}},
+		{Delete: true, Document: models.Document{ID: "titi"}},
+	}, true)
+	pending, _ := repo.PendingEvents(OutboxBatchSize)
+	assert.Equal(t, 0, len(pending))
+
+	_, _ = documentServiceImpl.ApplyBatch(context.Background(), []repodocuments.Operation{
+		{Document: models.Document{ID: "toto"}},
+		{Document: models.Document{ID: "titi"}},
+	}, true)
+	n, err := relay.Relay()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+}
+
+func TestOutboxRelay_Disabled(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	documentServiceImpl := NewDocumentServiceImpl(&repo)
+
+	//without relay, the outbox is not enabled and keeps nothing
+	_, _, _ = documentServiceImpl.CreateOrUpdate(context.Background(), models.Document{ID: "toto"}, repodocuments.Precondition{})
+	pending, _ := repo.PendingEvents(OutboxBatchSize)
+	assert.Equal(t, 0, len(pending))
+}
@@ -0,0 +1,97 @@
+package servicedocuments
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"goapi/models"
+	"goapi/repositories/repodocuments"
+
+	jsonpatch "github.com/evanphx/json-patch"
+)
+
+// PatchType is the media type of a patch
+type PatchType string
+
+const (
+	// MergePatch is a JSON Merge Patch (RFC 7396)
+	MergePatch PatchType = "application/merge-patch+json"
+	// JSONPatch is a JSON Patch (RFC 6902)
+	JSONPatch PatchType = "application/json-patch+json"
+)
+
+//the number of times a patch is applied again when the document is modified in between
+const patchAttempts = 10
+
+var ErrInvalidPatch = NewError(ErrValidation, "invalid patch")
+var ErrUnsupportedPatch = NewError(ErrValidation, "unsupported patch type")
+
+// ErrPatchConflict is returned when the document kept being modified while a patch without precondition was applied
+var ErrPatchConflict = NewError(ErrConflict, "the document kept being modified during the patch")
+
+//applyPatch returns the document modified by the patch. The fields managed by the server are kept.
+func applyPatch(document models.Document, patchType PatchType, patch []byte) (models.Document, error) {
+	original, err := json.Marshal(document)
+	if err != nil {
+		return models.Document{}, err
+	}
+
+	var patched []byte
+	switch patchType {
+	case MergePatch:
+		patched, err = jsonpatch.MergePatch(original, patch)
+	case JSONPatch:
+		var operations jsonpatch.Patch
+		if operations, err = jsonpatch.DecodePatch(patch); err == nil {
+			patched, err = operations.Apply(original)
+		}
+	default:
+		return models.Document{}, ErrUnsupportedPatch
+	}
+	if err != nil {
+		return models.Document{}, fmt.Errorf("%w: %s", ErrInvalidPatch, err)
+	}
+
+	var result models.Document
+	if err := json.Unmarshal(patched, &result); err != nil {
+		return models.Document{}, fmt.Errorf("%w: %s", ErrInvalidPatch, err)
+	}
+	if result.ID != document.ID {
+		return models.Document{}, fmt.Errorf("%w: the id cannot be modified", ErrInvalidPatch)
+	}
+	result.Version = document.Version
+	result.DeletedAt = document.DeletedAt
+	result.CreatedAt, result.CreatedBy = document.CreatedAt, document.CreatedBy
+	result.UpdatedAt, result.UpdatedBy = document.UpdatedAt, document.UpdatedBy
+	result.ACL = document.ACL
+	return result, nil
+}
+
+// Patch modifies the document with the patch if the precondition holds, ErrNotFound if the document does not exist.
+// The patch is applied on the current version of the document, and applied again if it was modified in between.
+func (s *DocumentServiceImpl) Patch(ctx context.Context, id string, patchType PatchType, patch []byte, precondition repodocuments.Precondition) (models.Document, error) {
+	for attempt := 0; attempt < patchAttempts; attempt++ {
+		current, err := s.Get(ctx, id)
+		if err != nil {
+			return models.Document{}, err
+		}
+		if precondition.MustNotExist || (precondition.IfMatch > 0 && precondition.IfMatch != current.Version) {
+			return models.Document{}, repodocuments.ErrPreconditionFailed
+		}
+
+		patched, err := applyPatch(current, patchType, patch)
+		if err != nil {
+			return models.Document{}, err
+		}
+
+		//the write only succeeds if nobody modified the document since it was read
+		document, _, err := s.CreateOrUpdate(ctx, patched, repodocuments.Precondition{IfMatch: current.Version})
+		if errors.Is(err, repodocuments.ErrPreconditionFailed) && precondition.IfMatch == 0 {
+			continue
+		}
+		return document, err
+	}
+	//without precondition the caller asked for no version, the concurrent writes are a conflict
+	return models.Document{}, ErrPatchConflict
+}
@@ -0,0 +1,143 @@
+package servicedocuments
+
+import (
+	"context"
+	"goapi/models"
+	"goapi/repositories/repodocuments"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDocumentServiceImpl_PatchMerge(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	documentServiceImpl := NewDocumentServiceImpl(&repo)
+
+	//a document written before the ACLs, anyone can patch it
+	_, _, _ = repo.CreateOrUpdate(models.Document{ID: "toto", Name: "nameToto", Description: "descToto", CreatedBy: "alice"}, repodocuments.Precondition{})
+
+	//the fields missing from the patch are kept, and the fields managed by the server cannot be patched
+	patch := `{"description":"newDesc","version":10,"createdBy":"mallory"}`
+	doc, err := documentServiceImpl.Patch(WithActor(context.Background(), "bob"), "toto", MergePatch, []byte(patch), repodocuments.Precondition{})
+	assert.Nil(t, err)
+	assert.Equal(t, models.Document{ID: "toto", Name: "nameToto", Description: "newDesc", Version: 2}, withoutAudit(doc))
+	assert.Equal(t, "alice", doc.CreatedBy)
+	assert.Equal(t, "bob", doc.UpdatedBy)
+
+	//null removes a field
+	doc, err = documentServiceImpl.Patch(context.Background(), "toto", MergePatch, []byte(`{"name":null}`), repodocuments.Precondition{IfMatch: 2})
+	assert.Nil(t, err)
+	assert.Equal(t, models.Document{ID: "toto", Description: "newDesc", Version: 3}, withoutAudit(doc))
+	docFound, _ := documentServiceImpl.Get(context.Background(), "toto")
+	assert.Equal(t, doc, docFound)
+}
+
+func TestDocumentServiceImpl_PatchJSON(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	documentServiceImpl := NewDocumentServiceImpl(&repo)
+
+	_, _, _ = documentServiceImpl.CreateOrUpdate(context.Background(), models.Document{ID: "toto", Name: "nameToto", Description: "descToto"}, repodocuments.Precondition{})
+
+	patch := `[{"op":"test","path":"/name","value":"nameToto"},{"op":"copy","from":"/name","path":"/description"}]`
+	doc, err := documentServiceImpl.Patch(context.Background(), "toto", JSONPatch, []byte(patch), repodocuments.Precondition{})
+	assert.Nil(t, err)
+	assert.Equal(t, models.Document{ID: "toto", Name: "nameToto", Description: "nameToto", Version: 2}, withoutAudit(doc))
+
+	//a failed test is an invalid patch, and nothing is modified
+	patch = `[{"op":"replace","path":"/description","value":"other"},{"op":"test","path":"/name","value":"other"}]`
+	_, err = documentServiceImpl.Patch(context.Background(), "toto", JSONPatch, []byte(patch), repodocuments.Precondition{})
+	assert.ErrorIs(t, err, ErrInvalidPatch)
+	docFound, _ := documentServiceImpl.Get(context.Background(), "toto")
+	assert.Equal(t, doc, docFound)
+}
+
+func TestDocumentServiceImpl_PatchInvalid(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	documentServiceImpl := NewDocumentServiceImpl(&repo)
+
+	_, err := documentServiceImpl.Patch(context.Background(), "toto", MergePatch, []byte(`{}`), repodocuments.Precondition{})
+	assert.Equal(t, repodocuments.ErrNotFound, err)
+
+	_, _, _ = documentServiceImpl.CreateOrUpdate(context.Background(), models.Document{ID: "toto"}, repodocuments.Precondition{})
+
+	for _, patch := range []string{
+		`[{"op":"remove","path":"/unknown"}]`,
+		`[{"op":"move","path":"/name"}]`,
+		`[{"op":"replace","path":"/id","value":"titi"}]`,
+		`[{"op":"replace","path":"/name","value":5}]`,
+		`{"op":"replace"}`,
+	} {
+		_, err = documentServiceImpl.Patch(context.Background(), "toto", JSONPatch, []byte(patch), repodocuments.Precondition{})
+		assert.ErrorIs(t, err, ErrInvalidPatch, patch)
+	}
+
+	_, err = documentServiceImpl.Patch(context.Background(), "toto", MergePatch, []byte(`{"id":"titi"}`), repodocuments.Precondition{})
+	assert.ErrorIs(t, err, ErrInvalidPatch)
+
+	_, err = documentServiceImpl.Patch(context.Background(), "toto", "application/json", []byte(`{}`), repodocuments.Precondition{})
+	assert.Equal(t, ErrUnsupportedPatch, err)
+
+	//a stale version is rejected
+	_, err = documentServiceImpl.Patch(context.Background(), "toto", MergePatch, []byte(`{}`), repodocuments.Precondition{IfMatch: 5})
+	assert.Equal(t, repodocuments.ErrPreconditionFailed, err)
+}
+
+func TestDocumentServiceImpl_ConcurrentPatches(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	documentServiceImpl := NewDocumentServiceImpl(&repo)
+
+	_, _, _ = documentServiceImpl.CreateOrUpdate(context.Background(), models.Document{ID: "toto"}, repodocuments.Precondition{})
+
+	//each patch only modifies its own field, a lost change would reset the field of the other one
+	const patches = 50
+	var wg sync.WaitGroup
+	for _, field := range []string{"name", "description"} {
+		wg.Add(1)
+		go func(field string) {
+			defer wg.Done()
+			for i := 1; i <= patches; i++ {
+				patch := `{"` + field + `":"` + strconv.Itoa(i) + `"}`
+				_, err := documentServiceImpl.Patch(context.Background(), "toto", MergePatch, []byte(patch), repodocuments.Precondition{})
+				assert.Nil(t, err)
+			}
+		}(field)
+	}
+	wg.Wait()
+
+	docFound, _ := documentServiceImpl.Get(context.Background(), "toto")
+	assert.Equal(t, models.Document{ID: "toto", Name: strconv.Itoa(patches), Description: strconv.Itoa(patches), Version: 1 + 2*patches}, withoutAudit(docFound))
+}
+
+//modifiedRepo is a repository whose documents are always modified between the read and the write of a patch
+type modifiedRepo struct {
+	*repodocuments.InMemoryDocumentRepo
+}
+
+func (r modifiedRepo) ForTenant(string) repodocuments.DocumentRepository {
+	return r
+}
+
+func (r modifiedRepo) CreateOrUpdate(document models.Document, precondition repodocuments.Precondition) (models.Document, bool, error) {
+	if precondition.IfMatch > 0 {
+		return models.Document{}, true, repodocuments.ErrPreconditionFailed
+	}
+	return r.InMemoryDocumentRepo.CreateOrUpdate(document, precondition)
+}
+
+func TestDocumentServiceImpl_PatchConflict(t *testing.T) {
+	repo := modifiedRepo{&repodocuments.InMemoryDocumentRepo{}}
+	documentServiceImpl := NewDocumentServiceImpl(repo)
+
+	_, _, _ = documentServiceImpl.CreateOrUpdate(context.Background(), models.Document{ID: "toto"}, repodocuments.Precondition{})
+
+	//without If-Match the attempts run out on a conflict, not on a failed precondition
+	_, err := documentServiceImpl.Patch(context.Background(), "toto", MergePatch, []byte(`{"name":"titi"}`), repodocuments.Precondition{})
+	assert.Equal(t, ErrPatchConflict, err)
+	assert.ErrorIs(t, err, ErrConflict)
+	assert.NotErrorIs(t, err, repodocuments.ErrPreconditionFailed)
+
+	_, err = documentServiceImpl.Patch(context.Background(), "toto", MergePatch, []byte(`{"name":"titi"}`), repodocuments.Precondition{IfMatch: 1})
+	assert.Equal(t, repodocuments.ErrPreconditionFailed, err)
+}
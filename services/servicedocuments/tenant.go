@@ -0,0 +1,31 @@
+package servicedocuments
+
+import (
+	"context"
+	"goapi/repositories/repodocuments"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultTenant is the tenant of the requests telling no tenant
+const DefaultTenant = repodocuments.DefaultTenant
+
+type tenantKey struct{}
+
+// WithTenant returns a context whose reads and writes only see the documents of the tenant
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFrom returns the tenant of the context, DefaultTenant if none is known
+func TenantFrom(ctx context.Context) string {
+	if tenant, ok := ctx.Value(tenantKey{}).(string); ok && len(tenant) > 0 {
+		return tenant
+	}
+	return DefaultTenant
+}
+
+// LoggerFrom returns a logger adding the tenant of the context to each line
+func LoggerFrom(ctx context.Context) *log.Entry {
+	return log.WithField("tenant", TenantFrom(ctx))
+}
@@ -0,0 +1,142 @@
+package servicedocuments
+
+import (
+	"context"
+	"goapi/config"
+	"goapi/models"
+	"goapi/repositories/repocontents"
+	"goapi/repositories/repodocuments"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTenantFrom(t *testing.T) {
+	assert.Equal(t, DefaultTenant, TenantFrom(context.Background()))
+	assert.Equal(t, DefaultTenant, TenantFrom(WithTenant(context.Background(), "")))
+	assert.Equal(t, "payments", TenantFrom(WithTenant(context.Background(), "payments")))
+}
+
+func TestDocumentServiceImpl_TenantIsolation(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	documentServiceImpl := NewDocumentServiceImpl(&repo)
+	payments := WithTenant(context.Background(), "payments")
+	billing := WithTenant(context.Background(), "billing")
+
+	//the same id in each tenant
+	_, _, err := documentServiceImpl.CreateOrUpdate(payments, models.Document{ID: "toto", Name: "payments report"}, repodocuments.Precondition{})
+	assert.Nil(t, err)
+	_, updated, err := documentServiceImpl.CreateOrUpdate(billing, models.Document{ID: "toto", Name: "billing report"}, repodocuments.Precondition{MustNotExist: true})
+	assert.Nil(t, err)
+	assert.False(t, updated)
+
+	//each tenant only sees its documents
+	docFound, err := documentServiceImpl.Get(payments, "toto")
+	assert.Nil(t, err)
+	assert.Equal(t, "payments report", docFound.Name)
+	docFound, err = documentServiceImpl.Get(billing, "toto")
+	assert.Nil(t, err)
+	assert.Equal(t, "billing report", docFound.Name)
+	_, err = documentServiceImpl.Get(context.Background(), "toto")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	all, _ := documentServiceImpl.GetAll(billing)
+	assert.Equal(t, 1, len(all))
+	page, _ := documentServiceImpl.List(context.Background(), repodocuments.DocumentQuery{})
+	assert.Equal(t, 0, len(page.Documents))
+	search, _ := documentServiceImpl.Search(payments, repodocuments.SearchQuery{Text: "billing"})
+	assert.Equal(t, 0, len(search.Hits))
+	revisions, _ := documentServiceImpl.GetRevisions(payments, "toto")
+	assert.Equal(t, 1, len(revisions))
+	assert.Equal(t, "payments report", revisions[0].Document.Name)
+
+	//a deletion only trashes the document of the tenant
+	found, err := documentServiceImpl.Delete(payments, "toto", repodocuments.Precondition{})
+	assert.Nil(t, err)
+	assert.True(t, found)
+	_, err = documentServiceImpl.Get(billing, "toto")
+	assert.Nil(t, err)
+	_, err = documentServiceImpl.Restore(billing, "toto")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	tenants, _ := repo.Tenants()
+	assert.Equal(t, []string{"billing", DefaultTenant, "payments"}, tenants)
+}
+
+type purgeObserverMock struct {
+	purged map[string][]string
+}
+
+func (o *purgeObserverMock) DocumentsPurged(ctx context.Context, ids []string) {
+	o.purged[TenantFrom(ctx)] = append(o.purged[TenantFrom(ctx)], ids...)
+}
+
+func TestDocumentServiceImpl_PurgeTrashOfAllTenants(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	documentServiceImpl, _ := NewDocumentServiceImplWithConfig(&repo, &config.DocumentsConfig{TrashRetentionHours: 1})
+	observer := &purgeObserverMock{purged: make(map[string][]string)}
+	documentServiceImpl.RegisterPurgeObserver(observer)
+
+	//a document trashed long ago in each tenant
+	deletedAt := time.Now().UTC().Add(-2 * time.Hour)
+	repo.DocumentsById.Store("toto", models.Document{ID: "toto", DeletedAt: &deletedAt})
+	repo.ForTenant("payments").(*repodocuments.InMemoryDocumentRepo).DocumentsById.Store("titi", models.Document{ID: "titi", DeletedAt: &deletedAt})
+
+	purged, err := documentServiceImpl.PurgeTrash()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), purged)
+	//the observers know the tenant of the purged documents
+	assert.Equal(t, map[string][]string{DefaultTenant: {"toto"}, "payments": {"titi"}}, observer.purged)
+}
+
+func TestContentServiceImpl_TenantIsolation(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	contentServiceImpl := NewContentServiceImpl(&repo, &repocontents.InMemoryContentRepo{})
+	payments := WithTenant(context.Background(), "payments")
+	repo.DocumentsById.Store("toto", models.Document{ID: "toto"})
+	repo.ForTenant("payments").(*repodocuments.InMemoryDocumentRepo).DocumentsById.Store("toto", models.Document{ID: "toto"})
+
+	_, err := contentServiceImpl.PutContent(payments, "toto", "text/plain", strings.NewReader("hello"))
+	assert.Nil(t, err)
+
+	//the document of the default tenant has no content
+	_, _, err = contentServiceImpl.GetContent(context.Background(), "toto")
+	assert.Equal(t, ErrNoContent, err)
+	_, content, err := contentServiceImpl.GetContent(payments, "toto")
+	assert.Nil(t, err)
+	defer content.Close()
+
+	//nor can another tenant use it
+	_, _, err = contentServiceImpl.GetContent(WithTenant(context.Background(), "billing"), "toto")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestDocumentServiceImpl_TenantChangesAndEvents(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	documentServiceImpl := NewDocumentServiceImpl(&repo)
+	publisher := &publisherMock{}
+	relay := NewOutboxRelay(&repo, publisher, &config.DocumentsConfig{})
+	payments := WithTenant(context.Background(), "payments")
+
+	ctx, cancel := context.WithCancel(payments)
+	defer cancel()
+	changes, err := documentServiceImpl.WatchChanges(ctx, "", "")
+	assert.Nil(t, err)
+
+	_, _, _ = documentServiceImpl.CreateOrUpdate(context.Background(), models.Document{ID: "titi"}, repodocuments.Precondition{})
+	_, _, _ = documentServiceImpl.CreateOrUpdate(payments, models.Document{ID: "toto"}, repodocuments.Precondition{})
+
+	//the feed of a tenant only has its changes
+	received := receive(t, changes, 1)
+	assert.Equal(t, "toto", received[0].DocumentID)
+	assert.Equal(t, "1", received[0].EventID)
+
+	//the events of all the tenants are published, with their tenant
+	_, err = relay.Relay()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(publisher.published))
+	assert.Equal(t, DefaultTenant, publisher.published[0].Tenant)
+	assert.Equal(t, "payments", publisher.published[1].Tenant)
+}
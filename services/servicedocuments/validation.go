@@ -0,0 +1,118 @@
+package servicedocuments
+
+import (
+	"fmt"
+	"goapi/config"
+	"goapi/models"
+	"goapi/repositories/repodocuments"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// ValidationError lists all the fields of a document breaking the validation rules
+type ValidationError struct {
+	Fields []models.FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		messages[i] = field.Message
+	}
+	return strings.Join(messages, ", ")
+}
+
+func (e *ValidationError) Unwrap() error {
+	return ErrValidation
+}
+
+//the fields of a document that can be required, with how to read them
+var requirableFields = map[string]func(models.Document) string{
+	"name":        func(d models.Document) string { return d.Name },
+	"description": func(d models.Document) string { return d.Description },
+}
+
+// Validator checks the documents against the validation rules of the configuration.
+// It is used by all the writes of the service, and can be called before writing from any other path.
+type Validator struct {
+	rules          config.ValidationConfig
+	idCharacters   *regexp.Regexp
+	requiredFields []string
+}
+
+// NewValidator returns the validator of the rules, an error if the rules cannot be applied
+func NewValidator(rules *config.ValidationConfig) (*Validator, error) {
+	validator := &Validator{rules: *rules}
+	if len(rules.IDCharacters) > 0 {
+		idCharacters, err := regexp.Compile("^[" + rules.IDCharacters + "]*$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid idCharacters %s [err=%s]", rules.IDCharacters, err)
+		}
+		validator.idCharacters = idCharacters
+	}
+	for _, field := range rules.RequiredFields {
+		if _, found := requirableFields[field]; !found {
+			return nil, fmt.Errorf("invalid required field %s, it must be name or description", field)
+		}
+		validator.requiredFields = append(validator.requiredFields, field)
+	}
+	return validator, nil
+}
+
+//sortedKeys returns the keys of the labels sorted, so that the errors are always in the same order
+func sortedKeys(labels map[string]string) []string {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Validate returns a ValidationError listing every field of the document breaking a rule, nil if the document is valid
+func (v *Validator) Validate(document models.Document) error {
+	var fields []models.FieldError
+	invalid := func(field string, format string, args ...interface{}) {
+		fields = append(fields, models.FieldError{Field: field, Message: field + " " + fmt.Sprintf(format, args...)})
+	}
+
+	idLength := utf8.RuneCountInString(document.ID)
+	switch {
+	case idLength == 0:
+		invalid("id", "must be defined")
+	case v.idCharacters != nil && !v.idCharacters.MatchString(document.ID):
+		invalid("id", "must only contain the characters %s", v.rules.IDCharacters)
+	case idLength < v.rules.MinIDLength:
+		invalid("id", "must be at least %d characters", v.rules.MinIDLength)
+	case v.rules.MaxIDLength > 0 && idLength > v.rules.MaxIDLength:
+		invalid("id", "must be at most %d characters", v.rules.MaxIDLength)
+	}
+
+	for _, field := range v.requiredFields {
+		if len(strings.TrimSpace(requirableFields[field](document))) == 0 {
+			invalid(field, "must be defined")
+		}
+	}
+	if v.rules.MaxNameLength > 0 && utf8.RuneCountInString(document.Name) > v.rules.MaxNameLength {
+		invalid("name", "must be at most %d characters", v.rules.MaxNameLength)
+	}
+	if v.rules.MaxDescriptionLength > 0 && utf8.RuneCountInString(document.Description) > v.rules.MaxDescriptionLength {
+		invalid("description", "must be at most %d characters", v.rules.MaxDescriptionLength)
+	}
+
+	//the labels must be selectable
+	for _, key := range sortedKeys(document.Labels) {
+		if !repodocuments.ValidLabelKey(key) {
+			invalid("labels", "key %q must be alphanumeric with -, _, . or /, and at most 253 characters", key)
+		} else if !repodocuments.ValidLabelValue(document.Labels[key]) {
+			invalid("labels", "value of %s must be alphanumeric with -, _ or ., and at most 63 characters", key)
+		}
+	}
+
+	if len(fields) > 0 {
+		return &ValidationError{Fields: fields}
+	}
+	return nil
+}
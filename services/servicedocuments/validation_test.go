@@ -0,0 +1,109 @@
+package servicedocuments
+
+import (
+	"context"
+	"goapi/config"
+	"goapi/models"
+	"goapi/repositories/repodocuments"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var validationRules = config.ValidationConfig{
+	IDCharacters:         "a-z0-9-",
+	MinIDLength:          3,
+	MaxIDLength:          8,
+	MaxNameLength:        10,
+	MaxDescriptionLength: 20,
+	RequiredFields:       []string{"name"},
+}
+
+func TestValidator_Validate(t *testing.T) {
+	validator, err := NewValidator(&validationRules)
+	assert.Nil(t, err)
+
+	for _, test := range []struct {
+		document       models.Document
+		expectedFields []models.FieldError
+	}{
+		{models.Document{ID: "toto", Name: "nameToto"}, nil},
+		{models.Document{ID: "tété", Name: "nameToto"}, []models.FieldError{{Field: "id", Message: "id must only contain the characters a-z0-9-"}}},
+		{models.Document{ID: "to", Name: "nameToto"}, []models.FieldError{{Field: "id", Message: "id must be at least 3 characters"}}},
+		{models.Document{ID: "toto-titi", Name: "nameToto"}, []models.FieldError{{Field: "id", Message: "id must be at most 8 characters"}}},
+		//all the invalid fields are listed
+		{models.Document{Name: " ", Description: strings.Repeat("d", 21)}, []models.FieldError{
+			{Field: "id", Message: "id must be defined"},
+			{Field: "name", Message: "name must be defined"},
+			{Field: "description", Message: "description must be at most 20 characters"},
+		}},
+		{models.Document{ID: "toto", Name: "nameOfTotoTiti"}, []models.FieldError{{Field: "name", Message: "name must be at most 10 characters"}}},
+	} {
+		err := validator.Validate(test.document)
+		if test.expectedFields == nil {
+			assert.Nil(t, err)
+			continue
+		}
+		assert.ErrorIs(t, err, ErrValidation)
+		assert.Equal(t, &ValidationError{Fields: test.expectedFields}, err)
+	}
+}
+
+func TestNewValidator_WrongRules(t *testing.T) {
+	_, err := NewValidator(&config.ValidationConfig{IDCharacters: "z-a"})
+	assert.NotNil(t, err)
+
+	_, err = NewValidator(&config.ValidationConfig{RequiredFields: []string{"version"}})
+	assert.EqualError(t, err, "invalid required field version, it must be name or description")
+
+	//the service is not created with them
+	_, err = NewDocumentServiceImplWithConfig(&repodocuments.InMemoryDocumentRepo{}, &config.DocumentsConfig{Validation: config.ValidationConfig{IDCharacters: "z-a"}})
+	assert.NotNil(t, err)
+}
+
+func TestDocumentServiceImpl_CreateOrUpdateInvalid(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	documentServiceImpl, _ := NewDocumentServiceImplWithConfig(&repo, &config.DocumentsConfig{Validation: validationRules})
+
+	_, _, err := documentServiceImpl.CreateOrUpdate(context.Background(), models.Document{ID: "TOTO"}, repodocuments.Precondition{})
+	assert.EqualError(t, err, "id must only contain the characters a-z0-9-, name must be defined")
+
+	//nothing was written
+	_, found := repo.DocumentsById.Load("TOTO")
+	assert.False(t, found)
+}
+
+func TestDocumentServiceImpl_ApplyBatchInvalid(t *testing.T) {
+	repo := repodocuments.InMemoryDocumentRepo{}
+	documentServiceImpl, _ := NewDocumentServiceImplWithConfig(&repo, &config.DocumentsConfig{Validation: validationRules})
+
+	operations := []repodocuments.Operation{
+		{Document: models.Document{ID: "toto", Name: "nameToto"}},
+		{Document: models.Document{ID: "titi"}},
+		{Delete: true, Document: models.Document{ID: "TATA"}},
+	}
+	invalid := &ValidationError{Fields: []models.FieldError{{Field: "name", Message: "name must be defined"}}}
+
+	//with an atomic batch nothing is written
+	results, err := documentServiceImpl.ApplyBatch(context.Background(), operations, true)
+	assert.Nil(t, err)
+	assert.Equal(t, []repodocuments.OperationResult{
+		{Err: repodocuments.ErrRolledBack},
+		{Err: invalid},
+		{Err: repodocuments.ErrRolledBack},
+	}, results)
+	_, found := repo.DocumentsById.Load("toto")
+	assert.False(t, found)
+
+	//otherwise the valid operations are applied, the deletions are not validated
+	results, err = documentServiceImpl.ApplyBatch(context.Background(), operations, false)
+	assert.Nil(t, err)
+	assert.Equal(t, []repodocuments.OperationResult{
+		{Document: models.Document{ID: "toto", Name: "nameToto", Version: 1}},
+		{Err: invalid},
+		{Err: repodocuments.ErrNotFound},
+	}, resultsWithoutAudit(results))
+	_, found = repo.DocumentsById.Load("toto")
+	assert.True(t, found)
+}